@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -47,29 +48,30 @@ func (p *configProvider) GetConfigs() ([]*asynq.PeriodicTaskConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(users) == 0 {
-		// No users - no tasks to schedule
-		return nil, nil
-	}
-	var output []*asynq.PeriodicTaskConfig
+	// the blobs of deleted attachments are cleaned up regularly, and deliveries whose task got lost are enqueued again
+	output := []*asynq.PeriodicTaskConfig{{Cronspec: "@hourly", Task: tasks.NewDeleteBlobs()}, {Cronspec: "@hourly", Task: tasks.NewRequeueDeliveries()}}
 	for _, user := range users {
 
 		// if the user has no cron, skip them so asynq doesn't get mad at me
 		if user.Cron == "" {
 			continue
 		}
-		if user.SentEmails > user.MaxSentEmails+1 {
+		// users in their grace period get a final warning instead of the regular emails
+		if user.Status != db.UserStatusAlive {
 			continue
 		}
-		if user.SentEmails == user.MaxSentEmails+1 {
-			deathTask, err := tasks.NewUserDeath(user.ID, user.Name, sonic.Marshal)
+		if user.SentEmails > user.MaxSentEmails {
+			pendingReleaseTask, err := tasks.NewUserPendingRelease(user.ID, user.Name, user.Email, sonic.Marshal)
 			if err != nil {
 				return nil, err
 			}
-			p.EnqueueTask(deathTask)
+			// the task ID conflicts while the previous sync's task hasn't been processed yet
+			if _, err := p.EnqueueTask(pendingReleaseTask); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+				log.Printf("failed to enqueue pending release task for user %d: %v", user.ID, err)
+			}
 			continue
 		}
-		task, err := tasks.NewRecurringEmailTask(user.ID, user.Name, user.Email, 24*time.Hour)
+		task, err := tasks.NewRecurringEmailTask(user.ID, user.Name, user.Email, user.Locale, 24*time.Hour)
 		if err != nil {
 			return nil, err
 		}
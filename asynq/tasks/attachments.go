@@ -0,0 +1,109 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gragorther/epigo/blob"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+)
+
+const TypeDeleteBlobs = "blobs:delete"
+
+// how many blobs a single run of the delete blobs task deletes
+const deleteBlobsBatchSize = 100
+
+// a periodic task, see the scheduler
+func NewDeleteBlobs() *asynq.Task {
+	return asynq.NewTask(TypeDeleteBlobs, nil)
+}
+
+// deletes the blobs of deleted attachments from the blob store
+func HandleDeleteBlobs(db interface {
+	DeletedBlobKeys(ctx context.Context, limit uint) (keys []string, err error)
+	ForgetDeletedBlobs(ctx context.Context, keys []string) error
+}, blobs blob.BlobStore,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		for {
+			keys, err := db.DeletedBlobKeys(ctx, deleteBlobsBatchSize)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				return nil
+			}
+			for _, key := range keys {
+				if err := blobs.Delete(ctx, key); err != nil {
+					return fmt.Errorf("failed to delete blob %s: %w", key, err)
+				}
+			}
+			if err := db.ForgetDeletedBlobs(ctx, keys); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type attachmentDB interface {
+	AttachmentsByLastMessageID(ctx context.Context, lastMessageID uint) (attachments []dbHandler.Attachment, err error)
+	OpenAttachment(ctx context.Context, lastMessageID uint, blobKey string, ciphertext []byte) (content []byte, err error)
+}
+
+// reads an attachment from the blob store and decrypts it
+func readAttachment(ctx context.Context, db attachmentDB, blobs blob.BlobStore, attachment dbHandler.Attachment) ([]byte, error) {
+	r, err := blobs.Get(ctx, attachment.BlobKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the blob of attachment %d: %w", attachment.ID, err)
+	}
+	defer r.Close()
+	ciphertext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the blob of attachment %d: %w", attachment.ID, err)
+	}
+	return db.OpenAttachment(ctx, attachment.LastMessageID, attachment.BlobKey, ciphertext)
+}
+
+// adds the attachments of the delivery's message to the death email. They're attached if they take up at most
+// mailAttachmentLimit bytes together, otherwise the recipient gets download links that expire after linkExpiry.
+func addDeathEmailAttachments(ctx context.Context, deathEmail *email.UserDeathEmail, db attachmentDB, blobs blob.BlobStore, deliveryID uint, lastMessageID uint,
+	createAttachmentAccess tokens.CreateAttachmentAccessFunc, attachmentDownloadURL string, mailAttachmentLimit int64, linkExpiry time.Duration,
+) error {
+	attachments, err := db.AttachmentsByLastMessageID(ctx, lastMessageID)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, attachment := range attachments {
+		total += attachment.Size
+	}
+
+	if total <= mailAttachmentLimit {
+		for _, attachment := range attachments {
+			content, err := readAttachment(ctx, db, blobs, attachment)
+			if err != nil {
+				return err
+			}
+			deathEmail.Attachments = append(deathEmail.Attachments, email.Attachment{Filename: attachment.Filename, ContentType: attachment.ContentType, Content: content})
+		}
+		return nil
+	}
+
+	deathEmail.AttachmentLinksExpireAt = time.Now().Add(linkExpiry)
+	for _, attachment := range attachments {
+		token, err := createAttachmentAccess(deliveryID, attachment.ID, deathEmail.AttachmentLinksExpireAt)
+		if err != nil {
+			return fmt.Errorf("failed to create attachment access token: %w", err)
+		}
+		deathEmail.AttachmentLinks = append(deathEmail.AttachmentLinks, email.AttachmentLink{
+			Filename: attachment.Filename,
+			URL:      fmt.Sprintf("%s?token=%s", attachmentDownloadURL, token),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/notify"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/wneessen/go-mail"
+)
+
+const (
+	TypeCreateNotificationChannel = "createNotificationChannel"
+	TypeDeleteNotificationChannel = "deleteNotificationChannel"
+	TypeChannelNotification       = "notify:channel"
+	TypeChannelOptIn              = "email:channelOptIn"
+)
+
+func (q *queue) CreateNotificationChannel(channel dbHandler.CreateNotificationChannel) error {
+	return q.createAndEnqueueTask(channel, TypeCreateNotificationChannel, asynq.Queue(queues.QueueHigh))
+}
+
+// email channels are sent a confirmation email, they don't get anything before their address confirmed it
+func HandleCreateNotificationChannel(db interface {
+	CreateNotificationChannel(ctx context.Context, channel dbHandler.CreateNotificationChannel) (id uint, err error)
+	ClaimNotificationChannelOptIn(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error)
+	UserByID(ctx context.Context, ID uint) (user dbHandler.User, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, unmarshal UnmarshalFunc, minDurationBetweenEmails time.Duration,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p dbHandler.CreateNotificationChannel
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		id, err := db.CreateNotificationChannel(ctx, p)
+		if err != nil || p.Kind != string(notify.KindEmail) {
+			return err
+		}
+
+		// retrying would create the channel twice. The user can have the confirmation email sent again by testing the channel.
+		if err := sendChannelOptIn(ctx, db, enqueueTask, marshal, id, p.UserID, p.Target, minDurationBetweenEmails); err != nil {
+			return fmt.Errorf("%w: %w", asynq.SkipRetry, err)
+		}
+		return nil
+	}
+}
+
+func (q *queue) DeleteNotificationChannelByID(id uint) error {
+	return q.createAndEnqueueTask(id, TypeDeleteNotificationChannel, asynq.Queue(queues.QueueLow))
+}
+
+func HandleDeleteNotificationChannelByID(db interface {
+	DeleteNotificationChannelByID(ctx context.Context, id uint) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var id uint
+		if err := unmarshal(t.Payload(), &id); err != nil {
+			return err
+		}
+		return db.DeleteNotificationChannelByID(ctx, id)
+	}
+}
+
+type channelOptInPayload struct {
+	ChannelID uint
+	Email     string
+	// the name of the user who added the channel
+	UserName string
+}
+
+func (q *queue) SendChannelOptIn(channelID uint, email string, userName string) error {
+	return q.createAndEnqueueTask(channelOptInPayload{ChannelID: channelID, Email: email, UserName: userName}, TypeChannelOptIn, asynq.Queue(queues.QueueLow))
+}
+
+func sendChannelOptIn(ctx context.Context, db interface {
+	ClaimNotificationChannelOptIn(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error)
+	UserByID(ctx context.Context, ID uint) (user dbHandler.User, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, channelID uint, userID uint, email string, minDurationBetweenEmails time.Duration,
+) error {
+	claimed, err := db.ClaimNotificationChannelOptIn(ctx, channelID, minDurationBetweenEmails)
+	if err != nil || !claimed {
+		return err
+	}
+	user, err := db.UserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	payload, err := marshal(channelOptInPayload{ChannelID: channelID, Email: email, UserName: user.Name.ValueOr(user.Username)})
+	if err != nil {
+		return err
+	}
+	_, err = enqueueTask(asynq.NewTask(TypeChannelOptIn, payload), asynq.Queue(queues.QueueLow))
+	return err
+}
+
+// optInURL takes a token parameter and has /confirm and /unsubscribe subroutes, e.g. https://afterwill.life/channels
+func HandleChannelOptIn(emailService interface {
+	SendChannelOptInEmail(ctx context.Context, to string, userName string, confirmURL string, unsubscribeURL string) error
+}, unmarshal UnmarshalFunc, createChannelOptInToken tokens.CreateChannelOptInFunc, optInURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p channelOptInPayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		token, err := createChannelOptInToken(p.ChannelID, p.Email)
+		if err != nil {
+			return err
+		}
+		err = emailService.SendChannelOptInEmail(ctx, p.Email, p.UserName, fmt.Sprintf("%s/confirm?token=%s", optInURL, token), fmt.Sprintf("%s/unsubscribe?token=%s", optInURL, token))
+		var sendErr *mail.SendError
+		// the mail server permanently rejected the address, retrying won't help
+		if errors.As(err, &sendErr) && !sendErr.IsTemp() {
+			return fmt.Errorf("%w: %w", asynq.SkipRetry, err)
+		}
+		return err
+	}
+}
+
+type channelNotificationPayload struct {
+	ChannelID    uint
+	Notification notify.Notification
+}
+
+func (q *queue) NotifyChannel(channelID uint, notification notify.Notification) error {
+	return q.createAndEnqueueTask(channelNotificationPayload{ChannelID: channelID, Notification: notification}, TypeChannelNotification, asynq.Queue(queues.QueueHigh))
+}
+
+// sends one notification to each channel. When the enqueueing task is retried, the ID of the task
+// (taken from ctx) keeps channels from getting the same notification twice.
+func notifyChannels(ctx context.Context, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, channelIDs []uint, notifications ...notify.Notification) error {
+	parentTaskID, _ := asynq.GetTaskID(ctx)
+	for _, channelID := range channelIDs {
+		for i, notification := range notifications {
+			payload, err := marshal(channelNotificationPayload{ChannelID: channelID, Notification: notification})
+			if err != nil {
+				return err
+			}
+			opts := []asynq.Option{asynq.Queue(queues.QueueCritical)}
+			if parentTaskID != "" {
+				opts = append(opts, asynq.TaskID(fmt.Sprintf("%s:%s:%d:%d", TypeChannelNotification, parentTaskID, channelID, i)))
+			}
+			if _, err := enqueueTask(asynq.NewTask(TypeChannelNotification, payload), opts...); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+				return fmt.Errorf("failed to enqueue notification for channel %d: %w", channelID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// email channels get an unsubscribe link with every notification, see HandleChannelOptIn for optInURL
+func HandleChannelNotification(db interface {
+	NotificationChannelByID(ctx context.Context, id uint) (channel dbHandler.NotificationChannel, err error)
+}, emailSender notify.EmailSender, client *http.Client, unmarshal UnmarshalFunc, createChannelOptInToken tokens.CreateChannelOptInFunc, optInURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p channelNotificationPayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		channel, err := db.NotificationChannelByID(ctx, p.ChannelID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// the channel was deleted in the meantime
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the address of the email channel hasn't confirmed it yet or it unsubscribed in the meantime
+		if !channel.Active() {
+			return nil
+		}
+		var unsubscribeURL string
+		if notify.Kind(channel.Kind) == notify.KindEmail {
+			token, err := createChannelOptInToken(channel.ID, channel.Target)
+			if err != nil {
+				return err
+			}
+			unsubscribeURL = fmt.Sprintf("%s/unsubscribe?token=%s", optInURL, token)
+		}
+		notifier, err := notify.New(notify.Kind(channel.Kind), channel.Target, channel.Secret.String, unsubscribeURL, emailSender, client)
+		if err != nil {
+			return fmt.Errorf("%w: %w", asynq.SkipRetry, err)
+		}
+
+		err = notifier.Notify(ctx, p.Notification)
+		var statusErr *notify.StatusError
+		if errors.As(err, &statusErr) && statusErr.Permanent() {
+			return fmt.Errorf("%w: %w", asynq.SkipRetry, err)
+		}
+		return err
+	}
+}
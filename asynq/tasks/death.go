@@ -2,9 +2,14 @@ package tasks
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/gragorther/epigo/asynq/queues"
 	dbHandler "github.com/gragorther/epigo/database/db"
 	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/notify"
 	"github.com/hibiken/asynq"
 	"github.com/samber/lo"
 )
@@ -14,6 +19,8 @@ const TypeUserDeath = "userDeath"
 type userDeathPayload struct {
 	UserID uint
 	Name   string
+	// true for the task that releases the last messages after the user's trusted contacts confirmed their death or didn't respond in time
+	AfterConfirmation bool
 }
 
 func NewUserDeath(userID uint, name string, marshal MarshalFunc) (task *asynq.Task, err error) {
@@ -22,35 +29,267 @@ func NewUserDeath(userID uint, name string, marshal MarshalFunc) (task *asynq.Ta
 		return nil, err
 	}
 
-	return asynq.NewTask(TypeUserDeath, payload), nil
+	return asynq.NewTask(TypeUserDeath, payload, asynq.TaskID(UserDeathTaskID(userID)), asynq.Queue(queues.QueueCritical)), nil
 }
 
+func newUserDeathAfterConfirmation(userID uint, name string, marshal MarshalFunc) (task *asynq.Task, err error) {
+	payload, err := marshal(userDeathPayload{UserID: userID, Name: name, AfterConfirmation: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(TypeUserDeath, payload, asynq.TaskID(UserDeathConfirmationTaskID(userID)), asynq.Queue(queues.QueueCritical)), nil
+}
+
+// the death task of a user always has the same ID, so that it can't be enqueued twice and so that it can be cancelled
+func UserDeathTaskID(userID uint) string {
+	return fmt.Sprintf("%s:%d", TypeUserDeath, userID)
+}
+
+// the ID of the death task that waits for the user's trusted contacts
+func UserDeathConfirmationTaskID(userID uint) string {
+	return fmt.Sprintf("%s:%d:confirmation", TypeUserDeath, userID)
+}
+
+type TaskDeleter interface {
+	DeleteTask(queue, id string) error
+}
+
+type CancelUserDeathFunc func(userID uint) error
+
+// deletes the user's death tasks if they haven't been processed yet. It's not an error if there are no such tasks.
+func CancelUserDeath(deleter TaskDeleter) CancelUserDeathFunc {
+	return func(userID uint) error {
+		for _, id := range []string{UserDeathTaskID(userID), UserDeathConfirmationTaskID(userID)} {
+			err := deleter.DeleteTask(queues.QueueCritical, id)
+			if err != nil && !errors.Is(err, asynq.ErrTaskNotFound) && !errors.Is(err, asynq.ErrQueueNotFound) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// schedules the death task of a user whose account deletion was cancelled again, since it was cancelled when the
+// deletion was scheduled. Users who are neither pending release nor awaiting confirmation don't have one.
+func (q *queue) RearmUserDeath(user dbHandler.KeptUser) error {
+	var task *asynq.Task
+	var err error
+	switch user.Status {
+	case dbHandler.UserStatusPendingRelease:
+		task, err = NewUserDeath(user.ID, user.Name, q.marshal)
+	case dbHandler.UserStatusAwaitingConfirmation:
+		// the trusted contacts were already asked, only the timeout is left
+		task, err = newUserDeathAfterConfirmation(user.ID, user.Name, q.marshal)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// the task ID conflicts if the death task wasn't processed or cancelled yet
+	if _, err := q.enqueueTask(task, asynq.ProcessAt(user.ReleaseAt.Time)); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to schedule user death task: %w", err)
+	}
+	return nil
+}
+
+type TaskRunner interface {
+	RunTask(queue, id string) error
+	GetTaskInfo(queue, id string) (*asynq.TaskInfo, error)
+}
+
+type RunUserDeathFunc func(userID uint) error
+
+// the death task that waits for the trusted contacts isn't scheduled, e.g. because it's already done
+var ErrNoUserDeathTask = errors.New("there is no user death task waiting for confirmation")
+
+// releases the user's last messages right away instead of waiting for their trusted contacts' confirmation to time out.
+// Returns ErrNoUserDeathTask if there's no such task to run. It's not an error if the task was already started, e.g. by
+// another trusted contact's confirmation.
+func RunUserDeath(runner TaskRunner) RunUserDeathFunc {
+	return func(userID uint) error {
+		id := UserDeathConfirmationTaskID(userID)
+		err := runner.RunTask(queues.QueueCritical, id)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			return fmt.Errorf("%w: %w", ErrNoUserDeathTask, err)
+		}
+		if err != nil {
+			// asynq refuses to run tasks that are pending or running already
+			info, infoErr := runner.GetTaskInfo(queues.QueueCritical, id)
+			if infoErr == nil && (info.State == asynq.TaskStatePending || info.State == asynq.TaskStateActive) {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// runs when the user's grace period is over. If the user has trusted contacts, they're asked to confirm the death first,
+// otherwise the last messages are released right away.
 func HandleUserDeath(db interface {
-	LastMessagesAndRecipients(ctx context.Context, userID uint) (lastMessages []dbHandler.LastMessageAndRecipients, err error)
-}, emailService interface {
-	SendUserDeathEmails(ctx context.Context, name string, emails []email.UserDeathEmailAndRecipients) error
-}, unmarshal UnmarshalFunc,
+	CreateDeliveries(ctx context.Context, userID uint) error
+	ShareLastMessages(ctx context.Context, userID uint) error
+	QueuedDeliveriesByUserID(ctx context.Context, userID uint) (deliveries []dbHandler.QueuedDelivery, err error)
+	UserStatusByID(ctx context.Context, userID uint) (status dbHandler.UserStatus, err error)
+	MarkUserReleased(ctx context.Context, userID uint) error
+	TrustedContactsByUserID(ctx context.Context, userID uint) (contacts []dbHandler.TrustedContact, err error)
+	StartUserAwaitingConfirmation(ctx context.Context, userID uint) (deadline time.Time, awaiting bool, err error)
+	LastMessageChannelNotifications(ctx context.Context, userID uint) (notifications []dbHandler.ChannelLastMessage, err error)
+	LastMessagesByUserID(ctx context.Context, userID uint) (lastMessages []dbHandler.LastMessage, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, unmarshal UnmarshalFunc, translate email.TranslateFunc,
 ) asynq.HandlerFunc {
 	return func(ctx context.Context, task *asynq.Task) error {
 		var payload userDeathPayload
 		if err := unmarshal(task.Payload(), &payload); err != nil {
 			return err
 		}
-		lastMessages, err := db.LastMessagesAndRecipients(ctx, payload.UserID)
+		status, err := db.UserStatusByID(ctx, payload.UserID)
 		if err != nil {
 			return err
 		}
 
-		emailsAndRecipients := lo.Map(lastMessages, func(item dbHandler.LastMessageAndRecipients, _ int) email.UserDeathEmailAndRecipients {
-			return email.UserDeathEmailAndRecipients{
-				Title:           item.LastMessage.Title,
-				Content:         item.LastMessage.Content.String,
-				RecipientEmails: dbHandler.RecipientsToStringArray(item.Recipients),
+		switch {
+		case status == dbHandler.UserStatusPendingRelease || (status == dbHandler.UserStatusAwaitingConfirmation && !payload.AfterConfirmation):
+			contacts, err := db.TrustedContactsByUserID(ctx, payload.UserID)
+			if err != nil {
+				return err
+			}
+			if len(contacts) == 0 {
+				return releaseLastMessages(ctx, db, enqueueTask, marshal, translate, payload)
 			}
-		})
-		if err := emailService.SendUserDeathEmails(ctx, payload.Name, emailsAndRecipients); err != nil {
+			return awaitTrustedContacts(ctx, db, enqueueTask, marshal, payload, contacts)
+		case status == dbHandler.UserStatusAwaitingConfirmation && payload.AfterConfirmation:
+			return releaseLastMessages(ctx, db, enqueueTask, marshal, translate, payload)
+		default:
+			// the user checked in or a trusted contact vetoed the release
+			return nil
+		}
+	}
+}
+
+func awaitTrustedContacts(ctx context.Context, db interface {
+	StartUserAwaitingConfirmation(ctx context.Context, userID uint) (deadline time.Time, awaiting bool, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, payload userDeathPayload, contacts []dbHandler.TrustedContact,
+) error {
+	deadline, awaiting, err := db.StartUserAwaitingConfirmation(ctx, payload.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to start awaiting confirmation: %w", err)
+	}
+	if !awaiting {
+		return nil
+	}
+
+	timeoutTask, err := newUserDeathAfterConfirmation(payload.UserID, payload.Name, marshal)
+	if err != nil {
+		return err
+	}
+	// the task ID conflicts if this task is retried after the timeout was already scheduled
+	if _, err := enqueueTask(timeoutTask, asynq.ProcessIn(time.Until(deadline))); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to schedule trusted contact confirmation timeout: %w", err)
+	}
+
+	for _, contact := range contacts {
+		emailTask, err := newTrustedContactEmail(trustedContactEmailPayload{
+			ContactID: contact.ID,
+			UserID:    payload.UserID,
+			UserName:  payload.Name,
+			Name:      contact.Name.String,
+			Email:     contact.Email,
+			Deadline:  deadline,
+		}, marshal)
+		if err != nil {
+			return err
+		}
+		if _, err := enqueueTask(emailTask); err != nil {
+			return fmt.Errorf("failed to enqueue trusted contact email: %w", err)
+		}
+	}
+	return nil
+}
+
+// creates a delivery for every last message and recipient and sends each of them in its own task, so that one bad address
+// doesn't fail the whole release and retries don't send the same message twice. Deliveries of messages with a later
+// release rule are scheduled for when they're due.
+func releaseLastMessages(ctx context.Context, db interface {
+	CreateDeliveries(ctx context.Context, userID uint) error
+	ShareLastMessages(ctx context.Context, userID uint) error
+	QueuedDeliveriesByUserID(ctx context.Context, userID uint) (deliveries []dbHandler.QueuedDelivery, err error)
+	MarkUserReleased(ctx context.Context, userID uint) error
+	LastMessageChannelNotifications(ctx context.Context, userID uint) (notifications []dbHandler.ChannelLastMessage, err error)
+	LastMessagesByUserID(ctx context.Context, userID uint) (lastMessages []dbHandler.LastMessage, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, translate email.TranslateFunc, payload userDeathPayload,
+) error {
+	if err := db.CreateDeliveries(ctx, payload.UserID); err != nil {
+		return fmt.Errorf("failed to create deliveries: %w", err)
+	}
+	// the shares have to exist before the deliveries that send them are enqueued
+	if err := db.ShareLastMessages(ctx, payload.UserID); err != nil {
+		return fmt.Errorf("failed to share last message keys: %w", err)
+	}
+	deliveries, err := db.QueuedDeliveriesByUserID(ctx, payload.UserID)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if err := enqueueDelivery(enqueueTask, marshal, delivery); err != nil {
 			return err
 		}
+	}
+
+	if err := notifyLastMessageChannels(ctx, db, enqueueTask, marshal, translate, payload); err != nil {
+		return err
+	}
+	return db.MarkUserReleased(ctx, payload.UserID)
+}
+
+// sends each channel the released messages of its groups, in the locale of the group. Messages that go out later
+// aren't given away early.
+func notifyLastMessageChannels(ctx context.Context, db interface {
+	LastMessageChannelNotifications(ctx context.Context, userID uint) (notifications []dbHandler.ChannelLastMessage, err error)
+	LastMessagesByUserID(ctx context.Context, userID uint) (lastMessages []dbHandler.LastMessage, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, translate email.TranslateFunc, payload userDeathPayload,
+) error {
+	channelMessages, err := db.LastMessageChannelNotifications(ctx, payload.UserID)
+	if err != nil {
+		return err
+	}
+	if len(channelMessages) == 0 {
 		return nil
 	}
+	lastMessages, err := db.LastMessagesByUserID(ctx, payload.UserID)
+	if err != nil {
+		return err
+	}
+	lastMessagesByID := lo.KeyBy(lastMessages, func(m dbHandler.LastMessage) uint { return m.ID })
+
+	// the notifications of each channel, which are ordered by channel
+	for _, channel := range lo.PartitionBy(channelMessages, func(m dbHandler.ChannelLastMessage) uint { return m.ChannelID }) {
+		notifications := make([]notify.Notification, 0, len(channel))
+		for _, channelMessage := range channel {
+			message, ok := lastMessagesByID[channelMessage.LastMessageID]
+			if !ok {
+				// deleted in the meantime
+				continue
+			}
+			locale := email.Locale(channelMessage.Locale)
+			notification := notify.Notification{
+				Event:   notify.EventLastMessage,
+				Title:   translate(locale, "death.subject", payload.Name, message.Title),
+				Message: message.Content.String,
+			}
+			// the ciphertext is useless without the key, which only the recipients' emails link to
+			if message.EncryptedContent != nil {
+				notification.Message = translate(locale, "channel.lastmessage.encrypted")
+			}
+			if message.ReleaseMode == dbHandler.ReleaseModeShamir {
+				notification.Message = translate(locale, "channel.lastmessage.shared")
+			}
+			notifications = append(notifications, notification)
+		}
+		if err := notifyChannels(ctx, enqueueTask, marshal, []uint{channel[0].ChannelID}, notifications...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
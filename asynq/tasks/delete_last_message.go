@@ -10,7 +10,7 @@ import (
 const TypeDeleteLastMessage = "deleteLastMessage"
 
 func (q *queue) DeleteLastMessageByID(id uint) error {
-	return q.createAndEnqueueTask(id, TypeDeleteGroup, asynq.Queue(queues.QueueLow))
+	return q.createAndEnqueueTask(id, TypeDeleteLastMessage, asynq.Queue(queues.QueueLow))
 }
 
 func HandleDeleteLastMessageByID(db interface {
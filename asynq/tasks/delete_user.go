@@ -0,0 +1,32 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	"github.com/hibiken/asynq"
+)
+
+const TypeDeleteUser = "user:delete"
+
+// deletes the account once the cooling-off period is over. If the deletion is cancelled in the meantime, the task does nothing.
+func (q *queue) DeleteUserAt(userID uint, deleteAt time.Time) error {
+	return q.createAndEnqueueTask(userID, TypeDeleteUser, asynq.Queue(queues.QueueLow), asynq.ProcessAt(deleteAt),
+		asynq.TaskID(fmt.Sprintf("%s:%d:%d", TypeDeleteUser, userID, deleteAt.Unix())))
+}
+
+func HandleDeleteUser(db interface {
+	DeleteUserIfDue(ctx context.Context, userID uint) (deleted bool, err error)
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var userID uint
+		if err := unmarshal(t.Payload(), &userID); err != nil {
+			return err
+		}
+		_, err := db.DeleteUserIfDue(ctx, userID)
+		return err
+	}
+}
@@ -0,0 +1,283 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	"github.com/gragorther/epigo/blob"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/shamir"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+	"github.com/wneessen/go-mail"
+)
+
+const TypeDeliverLastMessage = "email:deliverLastMessage"
+
+type deliverLastMessagePayload struct {
+	DeliveryID uint
+}
+
+func NewDeliverLastMessage(deliveryID uint, marshal MarshalFunc) (*asynq.Task, error) {
+	payload, err := marshal(deliverLastMessagePayload{DeliveryID: deliveryID})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeDeliverLastMessage, payload, asynq.TaskID(fmt.Sprintf("%s:%d", TypeDeliverLastMessage, deliveryID)), asynq.Queue(queues.QueueCritical)), nil
+}
+
+// enqueues the delivery to be sent at its SendAt
+func enqueueDelivery(enqueueTask TaskEnqueueFunc, marshal MarshalFunc, delivery dbHandler.QueuedDelivery) error {
+	task, err := NewDeliverLastMessage(delivery.ID, marshal)
+	if err != nil {
+		return err
+	}
+	// the task ID conflicts if the delivery was already enqueued, e.g. by an earlier try of the task enqueueing it
+	if _, err := enqueueTask(task, asynq.ProcessAt(delivery.SendAt)); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to enqueue delivery %d: %w", delivery.ID, err)
+	}
+	return nil
+}
+
+const TypeRequeueDeliveries = "deliveries:requeue"
+
+// how many deliveries a single query of the requeue deliveries task loads
+const requeueDeliveriesBatchSize = 100
+
+// a periodic task, see the scheduler
+func NewRequeueDeliveries() *asynq.Task {
+	return asynq.NewTask(TypeRequeueDeliveries, nil)
+}
+
+// deliveries can wait in Redis for years before they're due. If their task got lost in the meantime, e.g. because Redis
+// was flushed or failed over, they would never be sent, so queued deliveries that are overdue are enqueued again.
+// Deliveries whose task still exists keep it, since the task ID conflicts.
+func HandleRequeueDeliveries(db interface {
+	OverdueQueuedDeliveries(ctx context.Context, afterID uint, limit uint) (deliveries []dbHandler.QueuedDelivery, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var afterID uint
+		for {
+			deliveries, err := db.OverdueQueuedDeliveries(ctx, afterID, requeueDeliveriesBatchSize)
+			if err != nil {
+				return err
+			}
+			if len(deliveries) == 0 {
+				return nil
+			}
+			for _, delivery := range deliveries {
+				afterID = delivery.ID
+				if err := enqueueDelivery(enqueueTask, marshal, delivery); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+const TypeRecordDeliveryFailure = "email:recordDeliveryFailure"
+
+type recordDeliveryFailurePayload struct {
+	DeliveryID uint
+	LastError  string
+	Final      bool
+	Yearly     bool
+}
+
+// a delivery is sending while its email goes out. If sending failed, but recording that did too, only the task that
+// sent it knows the email didn't go out, so it hands the failure over to this task instead of leaving the delivery sending.
+func newRecordDeliveryFailure(p recordDeliveryFailurePayload, marshal MarshalFunc) (*asynq.Task, error) {
+	payload, err := marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeRecordDeliveryFailure, payload, asynq.Queue(queues.QueueCritical)), nil
+}
+
+// records the failed attempt, which puts the delivery back in queued unless the failure was final. The task that sent
+// it is still being retried then and sends it again.
+func HandleRecordDeliveryFailure(db interface {
+	MarkDeliveryAttemptFailed(ctx context.Context, id uint, lastError string, final bool) error
+	NextYearlyDelivery(ctx context.Context, deliveryID uint) (next dbHandler.QueuedDelivery, queued bool, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p recordDeliveryFailurePayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		if err := db.MarkDeliveryAttemptFailed(ctx, p.DeliveryID, p.LastError, p.Final); err != nil {
+			return err
+		}
+		if p.Final && p.Yearly {
+			return scheduleNextYearlyDelivery(ctx, db, enqueueTask, marshal, p.DeliveryID)
+		}
+		return nil
+	}
+}
+
+// the delivery's email is going out or went out, but it wasn't recorded yet
+var ErrDeliverySending = errors.New("the delivery is still sending")
+
+// once a delivery of a yearly message is done, whether it was sent or failed, the one for the next year is scheduled
+func scheduleNextYearlyDelivery(ctx context.Context, db interface {
+	NextYearlyDelivery(ctx context.Context, deliveryID uint) (next dbHandler.QueuedDelivery, queued bool, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, deliveryID uint,
+) error {
+	next, queued, err := db.NextYearlyDelivery(ctx, deliveryID)
+	if err != nil {
+		return fmt.Errorf("failed to create the next yearly delivery: %w", err)
+	}
+	if !queued {
+		return nil
+	}
+	return enqueueDelivery(enqueueTask, marshal, next)
+}
+
+// sends one last message to one recipient and records the outcome on the delivery.
+//
+// Client side encrypted messages aren't put in the email. The recipient gets a link to messageViewURL instead, with a token
+// to fetch the ciphertext and the passphrase-wrapped key fragment after the #, so it isn't sent back to the server. For messages whose key was
+// split, the recipient gets their share and a link to sharedMessageURL, where the shares are put together.
+//
+// Attachments go along with the email, or as links to attachmentDownloadURL when they're larger than
+// mailAttachmentLimit together. Shamir messages are sent without their attachments, since those aren't protected by
+// the shares.
+//
+// The delivery is moved to sending right before the email is sent, and the email is never sent again once the mail
+// server took it, even if recording that fails. While a delivery is sending, tries of the task fail with
+// ErrDeliverySending, so a delivery whose failure is recorded late by the record delivery failure task is still sent.
+// Yearly messages get their delivery for the next year scheduled once this one is done.
+func HandleDeliverLastMessage(db interface {
+	DeliveryMessageByID(ctx context.Context, id uint) (delivery dbHandler.DeliveryMessage, err error)
+	ClaimDeliveryForSending(ctx context.Context, id uint) (claimed bool, err error)
+	MarkDeliverySent(ctx context.Context, id uint, smtpMessageID string) error
+	MarkDeliveryAttemptFailed(ctx context.Context, id uint, lastError string, final bool) error
+	NextYearlyDelivery(ctx context.Context, deliveryID uint) (next dbHandler.QueuedDelivery, queued bool, err error)
+	attachmentDB
+}, emailService interface {
+	SendUserDeathEmail(ctx context.Context, name string, email email.UserDeathEmail) (messageID string, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, unmarshal UnmarshalFunc, createMessageAccess tokens.CreateMessageAccessFunc, messageViewURL string, sharedMessageURL string,
+	blobs blob.BlobStore, createAttachmentAccess tokens.CreateAttachmentAccessFunc, attachmentDownloadURL string, mailAttachmentLimit int64, attachmentLinkExpiry time.Duration,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p deliverLastMessagePayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		delivery, err := db.DeliveryMessageByID(ctx, p.DeliveryID)
+		if err != nil {
+			return err
+		}
+		if delivery.Status == dbHandler.DeliveryStatusSending {
+			return ErrDeliverySending
+		}
+		if delivery.Status != dbHandler.DeliveryStatusQueued {
+			// an earlier try may have failed after the delivery was done, but before the next one was scheduled
+			if delivery.ReleaseRule == dbHandler.ReleaseRuleYearly {
+				return scheduleNextYearlyDelivery(ctx, db, enqueueTask, marshal, p.DeliveryID)
+			}
+			return nil
+		}
+
+		deathEmail := email.UserDeathEmail{
+			Title:          delivery.Title,
+			Content:        delivery.Content.String,
+			RecipientEmail: delivery.RecipientEmail,
+			Locale:         email.Locale(delivery.Locale),
+		}
+		switch {
+		case delivery.ReleaseMode == dbHandler.ReleaseModeShamir:
+			if delivery.KeyShare == nil {
+				// the key was split before this recipient's delivery existed. Shamir messages aren't yearly.
+				return db.MarkDeliveryAttemptFailed(ctx, p.DeliveryID, "there is no key share for this recipient", true)
+			}
+			token, err := createMessageAccess(p.DeliveryID)
+			if err != nil {
+				return fmt.Errorf("failed to create message access token: %w", err)
+			}
+			deathEmail.KeyShare = shamir.EncodeShare(delivery.KeyShare)
+			deathEmail.ShareThreshold = uint(delivery.ShareThreshold.Int32)
+			deathEmail.ShareCount = uint(delivery.ShareCount.Int32)
+			deathEmail.ViewURL = fmt.Sprintf("%s?token=%s#%s", sharedMessageURL, token, deathEmail.KeyShare)
+		case delivery.EncryptedContent != nil:
+			token, err := createMessageAccess(p.DeliveryID)
+			if err != nil {
+				return fmt.Errorf("failed to create message access token: %w", err)
+			}
+			deathEmail.ViewURL = fmt.Sprintf("%s?token=%s", messageViewURL, token)
+			if delivery.EncryptedContent.KeyFragment.Valid {
+				deathEmail.ViewURL += "#" + delivery.EncryptedContent.KeyFragment.String
+			}
+		}
+		if delivery.ReleaseMode != dbHandler.ReleaseModeShamir {
+			if err := addDeathEmailAttachments(ctx, &deathEmail, db, blobs, p.DeliveryID, delivery.LastMessageID,
+				createAttachmentAccess, attachmentDownloadURL, mailAttachmentLimit, attachmentLinkExpiry); err != nil {
+				return err
+			}
+		}
+
+		claimed, err := db.ClaimDeliveryForSending(ctx, p.DeliveryID)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			// another try got to it first
+			return nil
+		}
+		messageID, sendErr := emailService.SendUserDeathEmail(ctx, delivery.UserName, deathEmail)
+		if sendErr == nil {
+			var markErr error
+			if err := db.MarkDeliverySent(ctx, p.DeliveryID, messageID); err != nil {
+				// the email went out, retrying would send it twice. The delivery stays sending.
+				markErr = fmt.Errorf("%w: failed to mark delivery sent: %w", asynq.SkipRetry, err)
+			}
+			if delivery.ReleaseRule == dbHandler.ReleaseRuleYearly {
+				// retrying is safe, the delivery isn't queued anymore
+				if err := scheduleNextYearlyDelivery(ctx, db, enqueueTask, marshal, p.DeliveryID); err != nil {
+					return err
+				}
+			}
+			return markErr
+		}
+
+		var smtpErr *mail.SendError
+		permanent := errors.As(sendErr, &smtpErr) && !smtpErr.IsTemp()
+		retryCount, _ := asynq.GetRetryCount(ctx)
+		maxRetry, _ := asynq.GetMaxRetry(ctx)
+		final := permanent || retryCount >= maxRetry
+		if err := db.MarkDeliveryAttemptFailed(ctx, p.DeliveryID, sendErr.Error(), final); err != nil {
+			task, taskErr := newRecordDeliveryFailure(recordDeliveryFailurePayload{
+				DeliveryID: p.DeliveryID,
+				LastError:  sendErr.Error(),
+				Final:      final,
+				Yearly:     delivery.ReleaseRule == dbHandler.ReleaseRuleYearly,
+			}, marshal)
+			if taskErr != nil {
+				return errors.Join(err, taskErr)
+			}
+			if _, taskErr := enqueueTask(task); taskErr != nil {
+				return errors.Join(err, taskErr)
+			}
+			if final {
+				// the record delivery failure task schedules the next yearly delivery
+				return fmt.Errorf("%w: failed to mark delivery attempt failed: %w", asynq.SkipRetry, err)
+			}
+			return fmt.Errorf("failed to mark delivery attempt failed: %w", err)
+		}
+		if final && delivery.ReleaseRule == dbHandler.ReleaseRuleYearly {
+			if err := scheduleNextYearlyDelivery(ctx, db, enqueueTask, marshal, p.DeliveryID); err != nil {
+				return err
+			}
+		}
+		if permanent {
+			return fmt.Errorf("%w: %w", asynq.SkipRetry, sendErr)
+		}
+		return sendErr
+	}
+}
@@ -0,0 +1,89 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TypeEmailChange       = "email:emailChange"
+	TypeEmailChangeNotice = "email:emailChangeNotice"
+)
+
+type emailChangePayload struct {
+	UserID   uint
+	NewEmail string
+}
+
+// sends the confirmation link to the new address
+func (q *queue) SendEmailChange(userID uint, newEmail string) error {
+	return q.createAndEnqueueTask(emailChangePayload{UserID: userID, NewEmail: newEmail}, TypeEmailChange, asynq.Queue(queues.QueueCritical))
+}
+
+// warns the current address about the change
+func (q *queue) SendEmailChangeNotice(userID uint, newEmail string) error {
+	return q.createAndEnqueueTask(emailChangePayload{UserID: userID, NewEmail: newEmail}, TypeEmailChangeNotice, asynq.Queue(queues.QueueCritical))
+}
+
+type emailChangeUserDB interface {
+	UserByID(ctx context.Context, ID uint) (user dbHandler.User, err error)
+}
+
+func emailChangeUser(ctx context.Context, db emailChangeUserDB, userID uint) (email.LifeStatusUser, error) {
+	user, err := db.UserByID(ctx, userID)
+	if err != nil {
+		return email.LifeStatusUser{}, fmt.Errorf("failed to get user by ID: %w", err)
+	}
+	return email.LifeStatusUser{Name: user.Name.ValueOr(user.Username), Email: user.Email, Locale: email.Locale(user.Locale)}, nil
+}
+
+// confirmURL takes a token query parameter, e.g. https://afterwill.life/user/email/confirm
+func HandleEmailChange(emailService interface {
+	SendEmailChangeEmail(ctx context.Context, user email.LifeStatusUser, confirmURL string) error
+}, db emailChangeUserDB, unmarshal UnmarshalFunc, createEmailChange tokens.CreateEmailChangeFunc, confirmURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p emailChangePayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		user, err := emailChangeUser(ctx, db, p.UserID)
+		if err != nil {
+			return err
+		}
+		token, err := createEmailChange(p.UserID, user.Email, p.NewEmail)
+		if err != nil {
+			return err
+		}
+		user.Email = p.NewEmail
+		return emailService.SendEmailChangeEmail(ctx, user, fmt.Sprintf("%s?token=%s", confirmURL, token))
+	}
+}
+
+// cancelURL takes a token query parameter, e.g. https://afterwill.life/user/email/cancel
+func HandleEmailChangeNotice(emailService interface {
+	SendEmailChangeNoticeEmail(ctx context.Context, user email.LifeStatusUser, newEmail string, cancelURL string) error
+}, db emailChangeUserDB, unmarshal UnmarshalFunc, createEmailChangeCancel tokens.CreateEmailChangeCancelFunc, cancelURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p emailChangePayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		user, err := emailChangeUser(ctx, db, p.UserID)
+		if err != nil {
+			return err
+		}
+		token, err := createEmailChangeCancel(p.UserID)
+		if err != nil {
+			return err
+		}
+		return emailService.SendEmailChangeNoticeEmail(ctx, user, p.NewEmail, fmt.Sprintf("%s?token=%s", cancelURL, token))
+	}
+}
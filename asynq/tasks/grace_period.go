@@ -0,0 +1,32 @@
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+const TypeSetUserGracePeriod = "setUserGracePeriod"
+
+type setUserGracePeriodPayload struct {
+	UserID      uint
+	GracePeriod time.Duration
+}
+
+func (q *queue) SetUserGracePeriod(userID uint, gracePeriod time.Duration) error {
+	return q.createAndEnqueueTask(setUserGracePeriodPayload{UserID: userID, GracePeriod: gracePeriod}, TypeSetUserGracePeriod)
+}
+
+func HandleSetUserGracePeriod(db interface {
+	SetUserGracePeriod(ctx context.Context, userID uint, gracePeriod time.Duration) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var payload setUserGracePeriodPayload
+		if err := unmarshal(t.Payload(), &payload); err != nil {
+			return err
+		}
+		return db.SetUserGracePeriod(ctx, payload.UserID, payload.GracePeriod)
+	}
+}
@@ -0,0 +1,31 @@
+package tasks
+
+import (
+	"context"
+
+	"github.com/hibiken/asynq"
+)
+
+const TypeSetUserLocale = "setUserLocale"
+
+type setUserLocalePayload struct {
+	UserID uint
+	Locale string
+}
+
+func (q *queue) SetUserLocale(userID uint, locale string) error {
+	return q.createAndEnqueueTask(setUserLocalePayload{UserID: userID, Locale: locale}, TypeSetUserLocale)
+}
+
+func HandleSetUserLocale(db interface {
+	SetUserLocale(ctx context.Context, userID uint, locale string) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var payload setUserLocalePayload
+		if err := unmarshal(t.Payload(), &payload); err != nil {
+			return err
+		}
+		return db.SetUserLocale(ctx, payload.UserID, payload.Locale)
+	}
+}
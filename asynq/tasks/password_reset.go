@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+)
+
+const TypePasswordResetEmail = "email:passwordReset"
+
+func (q *queue) SendPasswordResetEmail(email string) error {
+	return q.createAndEnqueueTask(email, TypePasswordResetEmail, asynq.Queue(queues.QueueCritical))
+}
+
+// resetURL takes a token query parameter, e.g. https://afterwill.life/user/password/reset
+func HandlePasswordResetEmail(emailService interface {
+	SendPasswordResetEmail(ctx context.Context, user email.LifeStatusUser, resetURL string) error
+}, db interface {
+	PasswordResetUserByEmail(ctx context.Context, email string) (user dbHandler.PasswordResetUser, err error)
+}, unmarshal UnmarshalFunc, createPasswordReset tokens.CreatePasswordResetFunc, resetURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var userEmail string
+		if err := unmarshal(t.Payload(), &userEmail); err != nil {
+			return err
+		}
+		user, err := db.PasswordResetUserByEmail(ctx, userEmail)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// nobody is registered with this address; the handler doesn't tell the requester so
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		token, err := createPasswordReset(user.ID, user.PasswordHash)
+		if err != nil {
+			return err
+		}
+		return emailService.SendPasswordResetEmail(ctx, email.LifeStatusUser{Name: user.Name, Email: user.Email, Locale: email.Locale(user.Locale)}, fmt.Sprintf("%s?token=%s", resetURL, token))
+	}
+}
@@ -0,0 +1,86 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/notify"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+)
+
+const TypeUserPendingRelease = "userPendingRelease"
+
+type userPendingReleasePayload struct {
+	UserID uint
+	Name   string
+	Email  string
+}
+
+// the task ID is fixed per user so the scheduler can't start the same user's grace period twice
+func NewUserPendingRelease(userID uint, name string, userEmail string, marshal MarshalFunc) (*asynq.Task, error) {
+	payload, err := marshal(userPendingReleasePayload{UserID: userID, Name: name, Email: userEmail})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeUserPendingRelease, payload, asynq.TaskID(fmt.Sprintf("%s:%d", TypeUserPendingRelease, userID)), asynq.Queue(queues.QueueCritical)), nil
+}
+
+// starts the user's grace period: the death task is scheduled for the end of it and the user gets a final warning.
+// Checking in during the grace period cancels the death task.
+func HandleUserPendingRelease(db interface {
+	StartUserPendingRelease(ctx context.Context, userID uint) (releaseAt time.Time, pending bool, err error)
+	LifeStatusChannelIDsByUserID(ctx context.Context, userID uint) (ids []uint, err error)
+}, emailService interface {
+	SendUserFinalWarningEmail(ctx context.Context, user email.LifeStatusUser, verificationURL string, releaseAt time.Time) error
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, unmarshal UnmarshalFunc, createUserLifeStatusToken tokens.CreateUserLifeStatusFunc, verificationURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p userPendingReleasePayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+
+		releaseAt, pending, err := db.StartUserPendingRelease(ctx, p.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to start user pending release: %w", err)
+		}
+		if !pending {
+			// the user has already been released
+			return nil
+		}
+
+		deathTask, err := NewUserDeath(p.UserID, p.Name, marshal)
+		if err != nil {
+			return err
+		}
+		// the task ID conflicts if this task is retried after the death task was already scheduled
+		if _, err := enqueueTask(deathTask, asynq.ProcessIn(time.Until(releaseAt))); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			return fmt.Errorf("failed to schedule user death task: %w", err)
+		}
+
+		token, err := createUserLifeStatusToken(p.UserID, releaseAt)
+		if err != nil {
+			return err
+		}
+		link := fmt.Sprintf("%s?token=%s", verificationURL, token)
+
+		channelIDs, err := db.LifeStatusChannelIDsByUserID(ctx, p.UserID)
+		if err != nil {
+			return err
+		}
+		if err := notifyChannels(ctx, enqueueTask, marshal, channelIDs, notify.Notification{
+			Event:   notify.EventLifeStatus,
+			Title:   "FINAL WARNING: your last messages are about to be sent",
+			Message: fmt.Sprintf("%s, you have missed all of your life status emails. Unless you open the link below, your last messages will be sent out on %s.", p.Name, releaseAt.UTC().Format(time.RFC1123)),
+			URL:     link,
+		}); err != nil {
+			return err
+		}
+		return emailService.SendUserFinalWarningEmail(ctx, email.LifeStatusUser{Name: p.Name, Email: p.Email}, link, releaseAt)
+	}
+}
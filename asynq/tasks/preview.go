@@ -0,0 +1,153 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	"github.com/gragorther/epigo/blob"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/hibiken/asynq"
+)
+
+const TypeSendLastMessagePreview = "email:lastMessagePreview"
+
+// stands in for the tokens and key shares of previews. There's no delivery the links could give access to yet, and
+// the key of a shamir message is only split when it's released.
+const previewPlaceholder = "PREVIEW"
+
+type previewDB interface {
+	LastMessagePreview(ctx context.Context, lastMessageID uint) (preview dbHandler.LastMessagePreview, err error)
+	attachmentDB
+}
+
+// the death email the recipient would get, as HandleDeliverLastMessage builds it. Attachments are left without their
+// contents.
+func previewDeathEmail(preview dbHandler.LastMessagePreview, attachments []dbHandler.Attachment, recipientEmail string, locale string,
+	messageViewURL string, sharedMessageURL string, attachmentDownloadURL string, mailAttachmentLimit int64, attachmentLinkExpiry time.Duration,
+) email.UserDeathEmail {
+	message := preview.LastMessage
+	deathEmail := email.UserDeathEmail{
+		Title:          message.Title,
+		Content:        message.Content.String,
+		RecipientEmail: recipientEmail,
+		Locale:         email.Locale(locale),
+	}
+	switch {
+	case message.ReleaseMode == dbHandler.ReleaseModeShamir:
+		deathEmail.KeyShare = previewPlaceholder
+		deathEmail.ShareCount = uint(len(preview.Recipients))
+		deathEmail.ShareThreshold = dbHandler.EffectiveShareThreshold(uint(message.ShareThreshold.Int32), deathEmail.ShareCount)
+		deathEmail.ViewURL = fmt.Sprintf("%s?token=%s#%s", sharedMessageURL, previewPlaceholder, previewPlaceholder)
+		// shamir messages are sent without their attachments
+		return deathEmail
+	case message.EncryptedContent != nil:
+		deathEmail.ViewURL = fmt.Sprintf("%s?token=%s", messageViewURL, previewPlaceholder)
+		if message.EncryptedContent.KeyFragment.Valid {
+			deathEmail.ViewURL += "#" + message.EncryptedContent.KeyFragment.String
+		}
+	}
+
+	var total int64
+	for _, attachment := range attachments {
+		total += attachment.Size
+	}
+	if total <= mailAttachmentLimit {
+		for _, attachment := range attachments {
+			deathEmail.Attachments = append(deathEmail.Attachments, email.Attachment{Filename: attachment.Filename, ContentType: attachment.ContentType})
+		}
+		return deathEmail
+	}
+	deathEmail.AttachmentLinksExpireAt = time.Now().Add(attachmentLinkExpiry)
+	for _, attachment := range attachments {
+		deathEmail.AttachmentLinks = append(deathEmail.AttachmentLinks, email.AttachmentLink{
+			Filename: attachment.Filename,
+			URL:      fmt.Sprintf("%s?token=%s", attachmentDownloadURL, previewPlaceholder),
+		})
+	}
+	return deathEmail
+}
+
+type LastMessagePreview struct {
+	RecipientEmail string       `json:"recipientEmail"`
+	Locale         email.Locale `json:"locale"`
+	// in the shamir release mode, how many recipients would have to put their shares together
+	ShareThreshold uint `json:"shareThreshold,omitempty"`
+	// whether the message has fewer recipients than its threshold, which is lowered to their number then. The owner
+	// can't be told when it happens, since they're dead by then.
+	ShareThresholdLowered bool `json:"shareThresholdLowered,omitempty"`
+	email.RenderedEmail
+}
+
+// renders the death emails a last message would be sent as, one per recipient
+type PreviewLastMessageFunc func(ctx context.Context, lastMessageID uint) (previews []LastMessagePreview, err error)
+
+func PreviewLastMessage(db previewDB, emailService interface {
+	RenderUserDeathEmail(name string, email email.UserDeathEmail) (rendered email.RenderedEmail, err error)
+}, messageViewURL string, sharedMessageURL string, attachmentDownloadURL string, mailAttachmentLimit int64, attachmentLinkExpiry time.Duration,
+) PreviewLastMessageFunc {
+	return func(ctx context.Context, lastMessageID uint) (previews []LastMessagePreview, err error) {
+		preview, err := db.LastMessagePreview(ctx, lastMessageID)
+		if err != nil {
+			return nil, err
+		}
+		attachments, err := db.AttachmentsByLastMessageID(ctx, lastMessageID)
+		if err != nil {
+			return nil, err
+		}
+		previews = make([]LastMessagePreview, 0, len(preview.Recipients))
+		for _, recipient := range preview.Recipients {
+			deathEmail := previewDeathEmail(preview, attachments, recipient.Email, recipient.Locale,
+				messageViewURL, sharedMessageURL, attachmentDownloadURL, mailAttachmentLimit, attachmentLinkExpiry)
+			rendered, err := emailService.RenderUserDeathEmail(preview.UserName, deathEmail)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render the death email for %s: %w", recipient.Email, err)
+			}
+			previews = append(previews, LastMessagePreview{RecipientEmail: recipient.Email, Locale: deathEmail.Locale, RenderedEmail: rendered,
+				ShareThreshold:        deathEmail.ShareThreshold,
+				ShareThresholdLowered: deathEmail.ShareThreshold < uint(preview.LastMessage.ShareThreshold.Int32),
+			})
+		}
+		return previews, nil
+	}
+}
+
+func (q *queue) SendLastMessagePreview(lastMessageID uint) error {
+	return q.createAndEnqueueTask(lastMessageID, TypeSendLastMessagePreview, asynq.Queue(queues.QueueLow))
+}
+
+// sends the owner of a last message a test copy of its death email, in their own locale and with the attachments
+// the recipients would get
+func HandleSendLastMessagePreview(db previewDB, emailService interface {
+	SendUserDeathEmail(ctx context.Context, name string, email email.UserDeathEmail) (messageID string, err error)
+}, unmarshal UnmarshalFunc, blobs blob.BlobStore,
+	messageViewURL string, sharedMessageURL string, attachmentDownloadURL string, mailAttachmentLimit int64, attachmentLinkExpiry time.Duration,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var lastMessageID uint
+		if err := unmarshal(t.Payload(), &lastMessageID); err != nil {
+			return err
+		}
+		preview, err := db.LastMessagePreview(ctx, lastMessageID)
+		if err != nil {
+			return err
+		}
+		attachments, err := db.AttachmentsByLastMessageID(ctx, lastMessageID)
+		if err != nil {
+			return err
+		}
+		deathEmail := previewDeathEmail(preview, attachments, preview.UserEmail, preview.UserLocale,
+			messageViewURL, sharedMessageURL, attachmentDownloadURL, mailAttachmentLimit, attachmentLinkExpiry)
+		deathEmail.Test = true
+		// when they're attached, it's in the order of attachments
+		for i := range deathEmail.Attachments {
+			if deathEmail.Attachments[i].Content, err = readAttachment(ctx, db, blobs, attachments[i]); err != nil {
+				return err
+			}
+		}
+		_, err = emailService.SendUserDeathEmail(ctx, preview.UserName, deathEmail)
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+	"github.com/wneessen/go-mail"
+)
+
+const TypeRecipientNotice = "email:recipientNotice"
+
+type recipientNoticePayload struct {
+	RecipientID uint
+	Email       string
+	// the name of the user who named the recipient
+	UserName string
+}
+
+func (q *queue) SendRecipientNotice(recipientID uint, email string, userName string) error {
+	return q.createAndEnqueueTask(recipientNoticePayload{RecipientID: recipientID, Email: email, UserName: userName}, TypeRecipientNotice, asynq.Queue(queues.QueueLow))
+}
+
+// optInURL takes a token parameter and has /confirm and /unsubscribe subroutes, e.g. https://afterwill.life/recipients
+func HandleRecipientNotice(emailService interface {
+	SendRecipientNoticeEmail(ctx context.Context, recipientEmail string, userName string, confirmURL string, unsubscribeURL string) error
+}, db interface {
+	SetRecipientStatusByEmail(ctx context.Context, id uint, email string, status dbHandler.RecipientStatus) (updated bool, err error)
+}, unmarshal UnmarshalFunc, createRecipientOptInToken tokens.CreateRecipientOptInFunc, optInURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p recipientNoticePayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		token, err := createRecipientOptInToken(p.RecipientID, p.Email)
+		if err != nil {
+			return err
+		}
+
+		err = emailService.SendRecipientNoticeEmail(ctx, p.Email, p.UserName, fmt.Sprintf("%s/confirm?token=%s", optInURL, token), fmt.Sprintf("%s/unsubscribe?token=%s", optInURL, token))
+		var sendErr *mail.SendError
+		// the mail server permanently rejected the address, retrying won't help
+		if errors.As(err, &sendErr) && !sendErr.IsTemp() {
+			// the recipient's email may have been changed since the notice was enqueued
+			_, err := db.SetRecipientStatusByEmail(ctx, p.RecipientID, p.Email, dbHandler.RecipientStatusBounced)
+			return err
+		}
+		return err
+	}
+}
@@ -0,0 +1,75 @@
+package tasks
+
+import (
+	"context"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TypeCreateRecipients     = "createRecipients"
+	TypeUpdateRecipientEmail = "updateRecipientEmail"
+	TypeDeleteRecipient      = "deleteRecipient"
+)
+
+type createRecipientsPayload struct {
+	GroupID uint
+	Emails  []string
+}
+
+func (q *queue) CreateRecipients(groupID uint, emails []string) error {
+	return q.createAndEnqueueTask(createRecipientsPayload{GroupID: groupID, Emails: emails}, TypeCreateRecipients, asynq.Queue(queues.QueueHigh))
+}
+
+func HandleCreateRecipients(db interface {
+	CreateRecipients(ctx context.Context, groupID uint, emails []string) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p createRecipientsPayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		return db.CreateRecipients(ctx, p.GroupID, p.Emails)
+	}
+}
+
+type updateRecipientEmailPayload struct {
+	ID    uint
+	Email string
+}
+
+func (q *queue) UpdateRecipientEmail(id uint, email string) error {
+	return q.createAndEnqueueTask(updateRecipientEmailPayload{ID: id, Email: email}, TypeUpdateRecipientEmail, asynq.Queue(queues.QueueDefault))
+}
+
+func HandleUpdateRecipientEmail(db interface {
+	UpdateRecipientEmail(ctx context.Context, id uint, email string) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p updateRecipientEmailPayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		return db.UpdateRecipientEmail(ctx, p.ID, p.Email)
+	}
+}
+
+func (q *queue) DeleteRecipientByID(id uint) error {
+	return q.createAndEnqueueTask(id, TypeDeleteRecipient, asynq.Queue(queues.QueueLow))
+}
+
+func HandleDeleteRecipientByID(db interface {
+	DeleteRecipientByID(ctx context.Context, id uint) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var id uint
+		if err := unmarshal(t.Payload(), &id); err != nil {
+			return err
+		}
+		return db.DeleteRecipientByID(ctx, id)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/bytedance/sonic"
 	"github.com/gragorther/epigo/database/db"
 	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/notify"
 	"github.com/gragorther/epigo/tokens"
 	"github.com/hibiken/asynq"
 )
@@ -55,14 +56,15 @@ type recurringEmailTaskPayload struct {
 	UserID       uint
 	Name         string
 	Email        string
+	Locale       string
 	ExpiresAfter time.Duration
 }
 type verificationEmailSender interface {
 	SendVerificationEmail(ctx context.Context, user email.User, registrationLink string) error
 }
 
-func NewRecurringEmailTask(userID uint, name string, email string, expiresAfter time.Duration) (*asynq.Task, error) {
-	payload, err := sonic.Marshal(recurringEmailTaskPayload{Name: name, Email: email, ExpiresAfter: expiresAfter, UserID: userID})
+func NewRecurringEmailTask(userID uint, name string, email string, locale string, expiresAfter time.Duration) (*asynq.Task, error) {
+	payload, err := sonic.Marshal(recurringEmailTaskPayload{Name: name, Email: email, Locale: locale, ExpiresAfter: expiresAfter, UserID: userID})
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +77,8 @@ func HandleRecurringEmail(emailService interface {
 }, db interface {
 	IncrementUserSentEmailsCount(ctx context.Context, userID uint) error
 	GetUserSentEmails(context.Context, uint) (db.UserSentEmails, error)
-}, unmarshal UnmarshalFunc, createUserLifeStatusToken tokens.CreateUserLifeStatusFunc, verificationURL string,
+	LifeStatusChannelIDsByUserID(ctx context.Context, userID uint) (ids []uint, err error)
+}, enqueueTask TaskEnqueueFunc, marshal MarshalFunc, unmarshal UnmarshalFunc, createUserLifeStatusToken tokens.CreateUserLifeStatusFunc, verificationURL string,
 ) asynq.HandlerFunc {
 	return func(ctx context.Context, t *asynq.Task) error {
 		var p recurringEmailTaskPayload
@@ -88,7 +91,23 @@ func HandleRecurringEmail(emailService interface {
 			return err
 		}
 
-		if err := emailService.SendUserLifeStatusEmail(ctx, email.LifeStatusUser{Name: p.Name, Email: p.Email}, fmt.Sprintf("%s?%s", verificationURL, token)); err != nil {
+		link := fmt.Sprintf("%s?token=%s", verificationURL, token)
+
+		// the user's other channels are notified first, so they still get the ping if the email fails
+		channelIDs, err := db.LifeStatusChannelIDsByUserID(ctx, p.UserID)
+		if err != nil {
+			return err
+		}
+		if err := notifyChannels(ctx, enqueueTask, marshal, channelIDs, notify.Notification{
+			Event:   notify.EventLifeStatus,
+			Title:   "Still alive?",
+			Message: fmt.Sprintf("Still alive, %s? Open the link below to let us know.", p.Name),
+			URL:     link,
+		}); err != nil {
+			return err
+		}
+
+		if err := emailService.SendUserLifeStatusEmail(ctx, email.LifeStatusUser{Name: p.Name, Email: p.Email, Locale: email.Locale(p.Locale)}, link); err != nil {
 			return err
 		}
 
@@ -0,0 +1,114 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gragorther/epigo/asynq/queues"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TypeCreateTrustedContact          = "createTrustedContact"
+	TypeDeleteTrustedContact          = "deleteTrustedContact"
+	TypeSetUserTrustedContactSettings = "setUserTrustedContactSettings"
+	TypeTrustedContactEmail           = "email:trustedContact"
+)
+
+func (q *queue) CreateTrustedContact(contact dbHandler.CreateTrustedContact) error {
+	return q.createAndEnqueueTask(contact, TypeCreateTrustedContact, asynq.Queue(queues.QueueHigh))
+}
+
+func HandleCreateTrustedContact(db interface {
+	CreateTrustedContact(ctx context.Context, contact dbHandler.CreateTrustedContact) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p dbHandler.CreateTrustedContact
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		return db.CreateTrustedContact(ctx, p)
+	}
+}
+
+func (q *queue) DeleteTrustedContactByID(id uint) error {
+	return q.createAndEnqueueTask(id, TypeDeleteTrustedContact, asynq.Queue(queues.QueueLow))
+}
+
+func HandleDeleteTrustedContactByID(db interface {
+	DeleteTrustedContactByID(ctx context.Context, id uint) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var id uint
+		if err := unmarshal(t.Payload(), &id); err != nil {
+			return err
+		}
+		return db.DeleteTrustedContactByID(ctx, id)
+	}
+}
+
+type setUserTrustedContactSettingsPayload struct {
+	UserID   uint
+	Settings dbHandler.TrustedContactSettings
+}
+
+func (q *queue) SetUserTrustedContactSettings(userID uint, settings dbHandler.TrustedContactSettings) error {
+	return q.createAndEnqueueTask(setUserTrustedContactSettingsPayload{UserID: userID, Settings: settings}, TypeSetUserTrustedContactSettings)
+}
+
+func HandleSetUserTrustedContactSettings(db interface {
+	SetUserTrustedContactSettings(ctx context.Context, userID uint, settings dbHandler.TrustedContactSettings) error
+}, unmarshal UnmarshalFunc,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p setUserTrustedContactSettingsPayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		return db.SetUserTrustedContactSettings(ctx, p.UserID, p.Settings)
+	}
+}
+
+type trustedContactEmailPayload struct {
+	ContactID uint
+	UserID    uint
+	// the name of the user whose death is being confirmed
+	UserName string
+	Name     string
+	Email    string
+	Deadline time.Time
+}
+
+func newTrustedContactEmail(payload trustedContactEmailPayload, marshal MarshalFunc) (*asynq.Task, error) {
+	marshaledPayload, err := marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeTrustedContactEmail, marshaledPayload, asynq.Queue(queues.QueueCritical)), nil
+}
+
+// decisionURL takes a token parameter and has /confirm and /veto subroutes, e.g. https://afterwill.life/trusted-contacts
+func HandleTrustedContactEmail(emailService interface {
+	SendTrustedContactEmail(ctx context.Context, contact email.TrustedContact, userName string, confirmURL string, vetoURL string, deadline time.Time) error
+}, unmarshal UnmarshalFunc, createTrustedContactDecisionToken tokens.CreateTrustedContactDecisionFunc, decisionURL string,
+) asynq.HandlerFunc {
+	return func(ctx context.Context, t *asynq.Task) error {
+		var p trustedContactEmailPayload
+		if err := unmarshal(t.Payload(), &p); err != nil {
+			return err
+		}
+		token, err := createTrustedContactDecisionToken(p.ContactID, p.UserID, p.Deadline)
+		if err != nil {
+			return err
+		}
+
+		return emailService.SendTrustedContactEmail(ctx, email.TrustedContact{Name: p.Name, Email: p.Email}, p.UserName,
+			fmt.Sprintf("%s/confirm?token=%s", decisionURL, token), fmt.Sprintf("%s/veto?token=%s", decisionURL, token), p.Deadline)
+	}
+}
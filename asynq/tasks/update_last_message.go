@@ -15,7 +15,7 @@ type updateLastMessagePayload struct {
 }
 
 func (q *queue) UpdateLastMessage(id uint, m dbHandler.UpdateLastMessage) error {
-	return q.createAndEnqueueTask(m, TypeUpdateLastMessage)
+	return q.createAndEnqueueTask(updateLastMessagePayload{LastMessage: m, ID: id}, TypeUpdateLastMessage)
 }
 
 func HandleUpdateLastMessage(
@@ -13,11 +13,12 @@ import (
 const TypeVerificationEmail = "email:verification"
 
 type verificationEmailPayload struct {
-	Email string `json:"email"`
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
 }
 
-func (q *queue) SendVerificationEmail(email string) error {
-	payload, err := sonic.Marshal(verificationEmailPayload{Email: email})
+func (q *queue) SendVerificationEmail(email string, locale string) error {
+	payload, err := sonic.Marshal(verificationEmailPayload{Email: email, Locale: locale})
 	if err != nil {
 		return err
 	}
@@ -39,6 +40,6 @@ func HandleVerificationEmailTask(createEmailVerification tokens.CreateEmailVerif
 		if err != nil {
 			return err
 		}
-		return emailService.SendVerificationEmail(ctx, email.User{Email: p.Email}, fmt.Sprintf("%v?token=%v", registrationRoute, token))
+		return emailService.SendVerificationEmail(ctx, email.User{Email: p.Email, Locale: email.Locale(p.Locale)}, fmt.Sprintf("%v?token=%v", registrationRoute, token))
 	}
 }
@@ -3,12 +3,15 @@ package workers
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/gragorther/epigo/asynq/queues"
 	"github.com/gragorther/epigo/asynq/tasks"
+	"github.com/gragorther/epigo/blob"
 	"github.com/gragorther/epigo/database/db"
 	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/notify"
 	"github.com/gragorther/epigo/tokens"
 	"github.com/hibiken/asynq"
 )
@@ -26,12 +29,65 @@ func Run(ctx context.Context, redisClientOpt asynq.RedisClientOpt, db interface
 	UpdateUserInterval(ctx context.Context, userID uint, cron string) error
 	IncrementUserSentEmailsCount(ctx context.Context, userID uint) error
 	GetUserSentEmails(context.Context, uint) (db.UserSentEmails, error)
-	LastMessagesAndRecipients(ctx context.Context, userID uint) (lastMessages []db.LastMessageAndRecipients, err error)
-}, jwtSecret []byte, emailService interface {
+	CreateDeliveries(ctx context.Context, userID uint) error
+	ShareLastMessages(ctx context.Context, userID uint) error
+	QueuedDeliveriesByUserID(ctx context.Context, userID uint) (deliveries []db.QueuedDelivery, err error)
+	NextYearlyDelivery(ctx context.Context, deliveryID uint) (next db.QueuedDelivery, queued bool, err error)
+	DeliveryMessageByID(ctx context.Context, id uint) (delivery db.DeliveryMessage, err error)
+	ClaimDeliveryForSending(ctx context.Context, id uint) (claimed bool, err error)
+	MarkDeliverySent(ctx context.Context, id uint, smtpMessageID string) error
+	MarkDeliveryAttemptFailed(ctx context.Context, id uint, lastError string, final bool) error
+	UserStatusByID(ctx context.Context, userID uint) (status db.UserStatus, err error)
+	MarkUserReleased(ctx context.Context, userID uint) error
+	StartUserPendingRelease(ctx context.Context, userID uint) (releaseAt time.Time, pending bool, err error)
+	SetUserGracePeriod(ctx context.Context, userID uint, gracePeriod time.Duration) error
+	SetUserLocale(ctx context.Context, userID uint, locale string) error
+	TrustedContactsByUserID(ctx context.Context, userID uint) (contacts []db.TrustedContact, err error)
+	StartUserAwaitingConfirmation(ctx context.Context, userID uint) (deadline time.Time, awaiting bool, err error)
+	CreateTrustedContact(ctx context.Context, contact db.CreateTrustedContact) error
+	DeleteTrustedContactByID(ctx context.Context, id uint) error
+	SetUserTrustedContactSettings(ctx context.Context, userID uint, settings db.TrustedContactSettings) error
+	CreateRecipients(ctx context.Context, groupID uint, emails []string) error
+	UpdateRecipientEmail(ctx context.Context, id uint, email string) error
+	DeleteRecipientByID(ctx context.Context, id uint) error
+	SetRecipientStatusByEmail(ctx context.Context, id uint, email string, status db.RecipientStatus) (updated bool, err error)
+	LastMessagesByUserID(ctx context.Context, userID uint) (lastMessages []db.LastMessage, err error)
+	CreateNotificationChannel(ctx context.Context, channel db.CreateNotificationChannel) (id uint, err error)
+	ClaimNotificationChannelOptIn(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error)
+	DeleteNotificationChannelByID(ctx context.Context, id uint) error
+	NotificationChannelByID(ctx context.Context, id uint) (channel db.NotificationChannel, err error)
+	LifeStatusChannelIDsByUserID(ctx context.Context, userID uint) (ids []uint, err error)
+	LastMessageChannelNotifications(ctx context.Context, userID uint) (notifications []db.ChannelLastMessage, err error)
+	PasswordResetUserByEmail(ctx context.Context, email string) (user db.PasswordResetUser, err error)
+	UserByID(ctx context.Context, ID uint) (user db.User, err error)
+	DeleteUserIfDue(ctx context.Context, userID uint) (deleted bool, err error)
+	AttachmentsByLastMessageID(ctx context.Context, lastMessageID uint) (attachments []db.Attachment, err error)
+	OpenAttachment(ctx context.Context, lastMessageID uint, blobKey string, ciphertext []byte) (content []byte, err error)
+	DeletedBlobKeys(ctx context.Context, limit uint) (keys []string, err error)
+	OverdueQueuedDeliveries(ctx context.Context, afterID uint, limit uint) (deliveries []db.QueuedDelivery, err error)
+	ForgetDeletedBlobs(ctx context.Context, keys []string) error
+	LastMessagePreview(ctx context.Context, lastMessageID uint) (preview db.LastMessagePreview, err error)
+}, emailService interface {
 	SendUserLifeStatusEmail(ctx context.Context, user email.LifeStatusUser, verificationURL string) error
-	SendUserDeathEmails(ctx context.Context, name string, emails []email.UserDeathEmailAndRecipients) error
+	SendUserDeathEmail(ctx context.Context, name string, email email.UserDeathEmail) (messageID string, err error)
 	SendVerificationEmail(ctx context.Context, user email.User, registrationLink string) error
+	SendUserFinalWarningEmail(ctx context.Context, user email.LifeStatusUser, verificationURL string, releaseAt time.Time) error
+	SendTrustedContactEmail(ctx context.Context, contact email.TrustedContact, userName string, confirmURL string, vetoURL string, deadline time.Time) error
+	SendRecipientNoticeEmail(ctx context.Context, recipientEmail string, userName string, confirmURL string, unsubscribeURL string) error
+	SendNotificationEmail(ctx context.Context, to string, subject string, message string, url string, unsubscribeURL string) error
+	SendChannelOptInEmail(ctx context.Context, to string, userName string, confirmURL string, unsubscribeURL string) error
+	SendPasswordResetEmail(ctx context.Context, user email.LifeStatusUser, resetURL string) error
+	SendEmailChangeEmail(ctx context.Context, user email.LifeStatusUser, confirmURL string) error
+	SendEmailChangeNoticeEmail(ctx context.Context, user email.LifeStatusUser, newEmail string, cancelURL string) error
+	Translate(locale email.Locale, key string, args ...any) string
 }, registrationRoute string, createVerificationEmailToken tokens.CreateEmailVerificationFunc, createUserLifeStatus tokens.CreateUserLifeStatusFunc, lifeVerificationURL string,
+	createTrustedContactDecision tokens.CreateTrustedContactDecisionFunc, trustedContactDecisionURL string,
+	createRecipientOptIn tokens.CreateRecipientOptInFunc, recipientOptInURL string,
+	createChannelOptIn tokens.CreateChannelOptInFunc, channelOptInURL string, minDurationBetweenEmails time.Duration,
+	createPasswordReset tokens.CreatePasswordResetFunc, passwordResetURL string,
+	createEmailChange tokens.CreateEmailChangeFunc, emailChangeURL string, createEmailChangeCancel tokens.CreateEmailChangeCancelFunc, emailChangeCancelURL string,
+	createMessageAccess tokens.CreateMessageAccessFunc, messageViewURL string, sharedMessageURL string,
+	blobs blob.BlobStore, createAttachmentAccess tokens.CreateAttachmentAccessFunc, attachmentDownloadURL string, mailAttachmentLimit int64, attachmentLinkExpiry time.Duration,
 ) {
 	srv := asynq.NewServer(
 		redisClientOpt,
@@ -53,6 +109,11 @@ func Run(ctx context.Context, redisClientOpt asynq.RedisClientOpt, db interface
 	mux := asynq.NewServeMux()
 
 	unmarshal := sonic.Unmarshal
+	marshal := sonic.Marshal
+	client := asynq.NewClient(redisClientOpt)
+	defer client.Close()
+	// used for webhooks and other HTTP notification channels
+	httpClient := notify.NewHTTPClient(15 * time.Second)
 
 	handlerTypes := map[string]asynq.HandlerFunc{
 		tasks.TypeCreateGroup:          tasks.HandleCreateGroup(db, unmarshal),
@@ -60,13 +121,39 @@ func Run(ctx context.Context, redisClientOpt asynq.RedisClientOpt, db interface
 		tasks.TypeUpdateUserInterval:   tasks.HandleUpdateUserInterval(db, unmarshal),
 		tasks.TypeUpdateGroup:          tasks.HandleUpdateGroup(db, unmarshal),
 		tasks.TypeSetUserMaxSentEmails: tasks.HandleSetUserMaxSentEmails(db, unmarshal),
-		tasks.TypeRecurringEmail:       tasks.HandleRecurringEmail(emailService, db, unmarshal, createUserLifeStatus, lifeVerificationURL),
+		tasks.TypeRecurringEmail:       tasks.HandleRecurringEmail(emailService, db, tasks.EnqueueTask(client), marshal, unmarshal, createUserLifeStatus, lifeVerificationURL),
 		tasks.TypeVerificationEmail:    tasks.HandleVerificationEmailTask(createVerificationEmailToken, unmarshal, emailService, registrationRoute),
-		tasks.TypeUserDeath:            tasks.HandleUserDeath(db, emailService, unmarshal),
-		tasks.TypeDeleteLastMessage:    tasks.HandleDeleteLastMessageByID(db, unmarshal),
-		tasks.TypeCreateUser:           tasks.HandleCreateUser(db, unmarshal),
-		tasks.TypeDeleteGroup:          tasks.HandleDeleteGroupByID(db, unmarshal),
-		tasks.TypeUpdateLastMessage:    tasks.HandleUpdateLastMessage(db, unmarshal),
+		tasks.TypeUserDeath:            tasks.HandleUserDeath(db, tasks.EnqueueTask(client), marshal, unmarshal, emailService.Translate),
+		tasks.TypeDeliverLastMessage: tasks.HandleDeliverLastMessage(db, emailService, tasks.EnqueueTask(client), marshal, unmarshal, createMessageAccess, messageViewURL, sharedMessageURL,
+			blobs, createAttachmentAccess, attachmentDownloadURL, mailAttachmentLimit, attachmentLinkExpiry),
+		tasks.TypeDeleteLastMessage:             tasks.HandleDeleteLastMessageByID(db, unmarshal),
+		tasks.TypeCreateUser:                    tasks.HandleCreateUser(db, unmarshal),
+		tasks.TypeDeleteGroup:                   tasks.HandleDeleteGroupByID(db, unmarshal),
+		tasks.TypeUpdateLastMessage:             tasks.HandleUpdateLastMessage(db, unmarshal),
+		tasks.TypeUserPendingRelease:            tasks.HandleUserPendingRelease(db, emailService, tasks.EnqueueTask(client), marshal, unmarshal, createUserLifeStatus, lifeVerificationURL),
+		tasks.TypeSetUserGracePeriod:            tasks.HandleSetUserGracePeriod(db, unmarshal),
+		tasks.TypeSetUserLocale:                 tasks.HandleSetUserLocale(db, unmarshal),
+		tasks.TypeCreateTrustedContact:          tasks.HandleCreateTrustedContact(db, unmarshal),
+		tasks.TypeDeleteTrustedContact:          tasks.HandleDeleteTrustedContactByID(db, unmarshal),
+		tasks.TypeSetUserTrustedContactSettings: tasks.HandleSetUserTrustedContactSettings(db, unmarshal),
+		tasks.TypeCreateRecipients:              tasks.HandleCreateRecipients(db, unmarshal),
+		tasks.TypeUpdateRecipientEmail:          tasks.HandleUpdateRecipientEmail(db, unmarshal),
+		tasks.TypeDeleteRecipient:               tasks.HandleDeleteRecipientByID(db, unmarshal),
+		tasks.TypeRecipientNotice:               tasks.HandleRecipientNotice(emailService, db, unmarshal, createRecipientOptIn, recipientOptInURL),
+		tasks.TypeTrustedContactEmail:           tasks.HandleTrustedContactEmail(emailService, unmarshal, createTrustedContactDecision, trustedContactDecisionURL),
+		tasks.TypeCreateNotificationChannel:     tasks.HandleCreateNotificationChannel(db, tasks.EnqueueTask(client), marshal, unmarshal, minDurationBetweenEmails),
+		tasks.TypeDeleteNotificationChannel:     tasks.HandleDeleteNotificationChannelByID(db, unmarshal),
+		tasks.TypeChannelNotification:           tasks.HandleChannelNotification(db, emailService, httpClient, unmarshal, createChannelOptIn, channelOptInURL),
+		tasks.TypeChannelOptIn:                  tasks.HandleChannelOptIn(emailService, unmarshal, createChannelOptIn, channelOptInURL),
+		tasks.TypePasswordResetEmail:            tasks.HandlePasswordResetEmail(emailService, db, unmarshal, createPasswordReset, passwordResetURL),
+		tasks.TypeEmailChange:                   tasks.HandleEmailChange(emailService, db, unmarshal, createEmailChange, emailChangeURL),
+		tasks.TypeEmailChangeNotice:             tasks.HandleEmailChangeNotice(emailService, db, unmarshal, createEmailChangeCancel, emailChangeCancelURL),
+		tasks.TypeDeleteUser:                    tasks.HandleDeleteUser(db, unmarshal),
+		tasks.TypeDeleteBlobs:                   tasks.HandleDeleteBlobs(db, blobs),
+		tasks.TypeRequeueDeliveries:             tasks.HandleRequeueDeliveries(db, tasks.EnqueueTask(client), marshal),
+		tasks.TypeRecordDeliveryFailure:         tasks.HandleRecordDeliveryFailure(db, tasks.EnqueueTask(client), marshal, unmarshal),
+		tasks.TypeSendLastMessagePreview: tasks.HandleSendLastMessagePreview(db, emailService, unmarshal, blobs, messageViewURL, sharedMessageURL,
+			attachmentDownloadURL, mailAttachmentLimit, attachmentLinkExpiry),
 	}
 
 	for typename, handlerFunc := range handlerTypes {
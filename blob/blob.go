@@ -0,0 +1,43 @@
+// Package blob stores files, like the attachments of last messages, outside the database. The stores only see opaque
+// keys and bytes; encrypting the bytes is up to the caller.
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path/filepath"
+)
+
+var (
+	ErrNotFound   = errors.New("blob: not found")
+	ErrInvalidKey = errors.New("blob: invalid key")
+)
+
+type BlobStore interface {
+	// stores size bytes from r under key, replacing whatever was stored there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// fails with ErrNotFound when nothing is stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// deleting a key that doesn't exist isn't an error, so deletes can be retried
+	Delete(ctx context.Context, key string) error
+}
+
+// a random key for a new blob
+func NewKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// keys are used as file names and in URLs, so they can't point outside the store
+func validateKey(key string) error {
+	if key == "" || !filepath.IsLocal(key) || filepath.Base(key) != key {
+		return ErrInvalidKey
+	}
+	return nil
+}
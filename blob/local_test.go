@@ -0,0 +1,49 @@
+package blob_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gragorther/epigo/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := blob.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	ctx := t.Context()
+
+	key, err := blob.NewKey()
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, key, strings.NewReader("letter"), 6, "application/pdf"))
+
+	r, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, r.Close())
+	require.NoError(t, err)
+	assert.Equal(t, "letter", string(content))
+
+	assert.Error(t, store.Put(ctx, key, strings.NewReader("short"), 6, ""), "a short upload should fail")
+	r, err = store.Get(ctx, key)
+	require.NoError(t, err)
+	content, _ = io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "letter", string(content), "a failed upload shouldn't replace the blob")
+
+	require.NoError(t, store.Delete(ctx, key))
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, blob.ErrNotFound)
+	assert.NoError(t, store.Delete(ctx, key), "deleting twice shouldn't fail")
+}
+
+func TestLocalStoreInvalidKey(t *testing.T) {
+	store, err := blob.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	for _, key := range []string{"", "../escape", "a/b", "/etc/passwd"} {
+		_, err := store.Get(t.Context(), key)
+		assert.ErrorIs(t, err, blob.ErrInvalidKey, "key %q", key)
+	}
+}
@@ -0,0 +1,54 @@
+// Command reencrypt wraps the data keys of all last messages with the newest master key in MESSAGE_ENCRYPTION_KEYS,
+// and encrypts messages stored before encryption was added. It also removes the unwrapped key fragments of end-to-end
+// encrypted messages, which were accepted before only wrapped ones were.
+//
+// To rotate the master key, add a key with a higher version to MESSAGE_ENCRYPTION_KEYS and restart the app, so new
+// messages use it. Then run this and remove the old key once it's done.
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/gragorther/epigo/config"
+	"github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/database/initializers"
+	"github.com/gragorther/epigo/envelope"
+)
+
+func main() {
+	config, err := config.Get()
+	if err != nil {
+		log.Fatalf("failed to read config: %v", err)
+	}
+	keys, err := envelope.ParseKeys(config.MessageEncryptionKeys)
+	if err != nil {
+		log.Fatalf("failed to load message encryption keys: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	dbconn, err := initializers.ConnectDB(ctx, config.DatabaseURL)
+	if err != nil {
+		log.Fatalf("DB connection error: %v", err)
+	}
+	defer dbconn.Close()
+	if err := initializers.Migrate(ctx, dbconn); err != nil {
+		log.Fatalf("failed to migrate db: %v", err)
+	}
+
+	repo := db.NewDB(dbconn, keys)
+	reencrypted, err := repo.ReencryptLastMessages(ctx)
+	log.Printf("re-encrypted %d last messages with master key version %d", reencrypted, keys.CurrentVersion())
+	if err != nil {
+		log.Fatalf("failed to re-encrypt last messages: %v", err)
+	}
+	removed, err := repo.RemoveRawKeyFragments(ctx)
+	log.Printf("removed %d unwrapped key fragments", removed)
+	if err != nil {
+		log.Fatalf("failed to remove unwrapped key fragments: %v", err)
+	}
+}
@@ -7,16 +7,24 @@ import (
 )
 
 type Config struct {
-	Production               bool   `env:"PROD" env-description:"whether the server is in prod mode"`
-	AdminUsername            string `env:"ADMIN_USERNAME"`
-	AdminPassword            string `env:"ADMIN_PASSWORD"`
-	JWTSecret                string `env:"JWT_SECRET"`
-	DatabaseURL              string `env:"DATABASE_URL"`
+	Production              bool     `env:"PROD" env-description:"whether the server is in prod mode"`
+	AdminUsername           string   `env:"ADMIN_USERNAME"`
+	AdminPassword           string   `env:"ADMIN_PASSWORD"`
+	JWTSecret               string   `env:"JWT_SECRET" env-description:"the HMAC secret tokens are signed with if there's no JWT_SIGNING_KEY_FILE. Keep it after switching to a key file, so the tokens it signed stay valid"`
+	JWTSigningKeyFile       string   `env:"JWT_SIGNING_KEY_FILE" env-description:"a PEM file with the Ed25519 or P-256 ECDSA private key new tokens are signed with"`
+	JWTVerificationKeyFiles []string `env:"JWT_VERIFICATION_KEY_FILES" env-separator:"," env-description:"PEM files with previous signing keys, whose tokens are still accepted"`
+	DatabaseURL             string   `env:"DATABASE_URL"`
+	// rotate by adding a key with a higher version, then running cmd/reencrypt and removing the old key
+	MessageEncryptionKeys    []string `env:"MESSAGE_ENCRYPTION_KEYS" env-separator:"," env-description:"the master keys last messages are encrypted with, as <version>:<base64 32 byte key>. The highest version encrypts new messages"`
 	Email                    EmailConfig
 	Redis                    RedisConfig
+	Blob                     BlobConfig
+	Attachments              AttachmentConfig
 	BaseURL                  string        `env:"BASE_URL" env-description:"the base url of the app, e.g. https://afterwill.life"`
 	GinMode                  string        `env:"GIN_MODE"`
 	MinDurationBetweenEmails time.Duration `env:"MIN_DURATION_BETWEEN_EMAILS"`
+	// 0 deletes accounts right away
+	AccountDeletionCoolingOff time.Duration `env:"ACCOUNT_DELETION_COOLING_OFF" env-description:"how long deleted accounts are kept, so the deletion can be cancelled"`
 }
 
 func Get() (Config, error) {
@@ -32,6 +40,28 @@ type EmailConfig struct {
 	Port       int    `env:"EMAIL_PORT"`
 	Password   string `env:"EMAIL_PASSWORD"`
 	Username   string `env:"EMAIL_USERNAME" env-description:"the username used for authenticating with the mail server"`
+	// templates in this directory override the embedded ones. Send SIGHUP to reload them.
+	TemplateDir string `env:"EMAIL_TEMPLATE_DIR" env-description:"an optional directory with email templates overriding the default ones"`
+}
+
+type BlobConfig struct {
+	Store    string `env:"BLOB_STORE" env-default:"local" env-description:"where attachments are stored, local or s3"`
+	LocalDir string `env:"BLOB_LOCAL_DIR" env-default:"data/blobs" env-description:"the directory the local blob store keeps files in"`
+	// works with any S3 compatible store, like MinIO
+	S3Endpoint        string `env:"S3_ENDPOINT" env-description:"e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000"`
+	S3Region          string `env:"S3_REGION" env-default:"us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET"`
+	S3AccessKeyID     string `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY"`
+	S3PathStyle       bool   `env:"S3_PATH_STYLE" env-description:"put the bucket in the path instead of the host name, which MinIO needs"`
+}
+
+type AttachmentConfig struct {
+	MaxSize int64 `env:"ATTACHMENT_MAX_SIZE" env-default:"26214400" env-description:"the largest file that can be attached to a last message, in bytes"`
+	Quota   int64 `env:"ATTACHMENT_QUOTA" env-default:"104857600" env-description:"how many bytes of attachments each user can store"`
+	// most mail servers reject emails larger than 25 MB, and attachments grow by a third when they're encoded
+	MailLimit  int64         `env:"MAIL_ATTACHMENT_LIMIT" env-default:"10485760" env-description:"the attachments of a last message are sent as download links instead when they're larger than this together, in bytes"`
+	LinkExpiry time.Duration `env:"ATTACHMENT_LINK_EXPIRY" env-default:"720h" env-description:"how long attachment download links in death emails work"`
 }
 
 type RedisConfig struct {
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrAttachmentQuotaExceeded = errors.New("the attachment would exceed the user's storage quota")
+
+type Attachment struct {
+	ID            uint   `json:"id"`
+	LastMessageID uint   `json:"lastMessageID"`
+	Filename      string `json:"filename"`
+	ContentType   string `json:"contentType"`
+	// in bytes
+	Size      int64     `json:"size"`
+	BlobKey   string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const attachmentColumns = "id, last_message_id, filename, content_type, size, blob_key, created_at"
+
+type CreateAttachment struct {
+	UserID        uint
+	LastMessageID uint
+	Filename      string
+	ContentType   string
+	Size          int64
+	// where the file is in the blob store
+	BlobKey string
+}
+
+// records an attachment and adds its size to the user's storage. Fails with ErrAttachmentQuotaExceeded if the user's
+// attachments would take up more than quota bytes.
+//
+// Create the attachment before uploading the file, so the space is reserved, and delete it if the upload fails.
+func (d *DB) CreateAttachment(ctx context.Context, attachment CreateAttachment, quota int64) (id uint, err error) {
+	err = d.db.QueryRow(ctx, `WITH u AS (UPDATE users SET attachments_size = attachments_size + $5 WHERE id = $1 AND attachments_size + $5 <= $7 RETURNING id)
+		INSERT INTO attachments (user_id, last_message_id, filename, content_type, size, blob_key) SELECT u.id, $2, $3, $4, $5, $6 FROM u RETURNING id`,
+		attachment.UserID, attachment.LastMessageID, attachment.Filename, attachment.ContentType, attachment.Size, attachment.BlobKey, quota).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrAttachmentQuotaExceeded
+	}
+	return id, err
+}
+
+func (d *DB) AttachmentsByLastMessageID(ctx context.Context, lastMessageID uint) (attachments []Attachment, err error) {
+	if err := pgxscan.Select(ctx, d.db, &attachments, "SELECT "+attachmentColumns+" FROM attachments WHERE last_message_id = $1 ORDER BY id", lastMessageID); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (d *DB) AttachmentsByUserID(ctx context.Context, userID uint) (attachments []Attachment, err error) {
+	if err := pgxscan.Select(ctx, d.db, &attachments, "SELECT "+attachmentColumns+" FROM attachments WHERE user_id = $1 ORDER BY id", userID); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// checks that the attachment belongs to the last message and the last message to the user
+func (d *DB) UserAuthorizationForAttachment(ctx context.Context, attachmentID uint, lastMessageID uint, userID uint) (authorized bool, err error) {
+	err = d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM attachments WHERE id = $1 AND last_message_id = $2 AND user_id = $3)", attachmentID, lastMessageID, userID).Scan(&authorized)
+	return authorized, err
+}
+
+// the blob of the attachment is deleted later, see DeletedBlobKeys
+func (d *DB) DeleteAttachmentByID(ctx context.Context, id uint) error {
+	_, err := d.db.Exec(ctx, "DELETE FROM attachments WHERE id = $1", id)
+	return err
+}
+
+// an attachment of the message of a sent delivery, for the recipient to download
+func (d *DB) DeliveryAttachment(ctx context.Context, deliveryID uint, attachmentID uint) (attachment Attachment, err error) {
+	err = pgxscan.Get(ctx, d.db, &attachment, `SELECT attachments.id, attachments.last_message_id, attachments.filename, attachments.content_type,
+		attachments.size, attachments.blob_key, attachments.created_at FROM attachments JOIN deliveries ON deliveries.last_message_id = attachments.last_message_id
+		WHERE deliveries.id = $1 AND attachments.id = $2 AND deliveries.status IN ($3, $4)`, deliveryID, attachmentID, DeliveryStatusSent, DeliveryStatusSending)
+	return attachment, err
+}
+
+// the blobs of deleted attachments that are still in the blob store, oldest first
+func (d *DB) DeletedBlobKeys(ctx context.Context, limit uint) (keys []string, err error) {
+	if err := pgxscan.Select(ctx, d.db, &keys, "SELECT blob_key FROM deleted_blobs ORDER BY deleted_at LIMIT $1", limit); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// call it once the blobs were deleted from the blob store
+func (d *DB) ForgetDeletedBlobs(ctx context.Context, keys []string) error {
+	_, err := d.db.Exec(ctx, "DELETE FROM deleted_blobs WHERE blob_key = ANY($1)", keys)
+	return err
+}
@@ -0,0 +1,72 @@
+package db_test
+
+import (
+	"errors"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Suite) TestAttachments() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+		UserID:          userID,
+		Name:            "family",
+		RecipientEmails: []string{"recipient@google.com"},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "photos", Content: null.StringFrom("see attached"), GroupIDs: []uint{groupID}}))
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	messageID := messages[0].ID
+
+	const quota = 100
+	id, err := s.Repo.CreateAttachment(s.Ctx, db.CreateAttachment{UserID: userID, LastMessageID: messageID, Filename: "beach.jpg", ContentType: "image/jpeg", Size: 60, BlobKey: "first"}, quota)
+	s.Require().NoError(err)
+	_, err = s.Repo.CreateAttachment(s.Ctx, db.CreateAttachment{UserID: userID, LastMessageID: messageID, Filename: "letter.pdf", ContentType: "application/pdf", Size: 50, BlobKey: "second"}, quota)
+	s.ErrorIs(err, db.ErrAttachmentQuotaExceeded)
+
+	attachments, err := s.Repo.AttachmentsByLastMessageID(s.Ctx, messageID)
+	s.Require().NoError(err)
+	s.Require().Len(attachments, 1)
+	s.Equal("beach.jpg", attachments[0].Filename)
+	authorized, err := s.Repo.UserAuthorizationForAttachment(s.Ctx, id, messageID, userID)
+	s.Require().NoError(err)
+	s.True(authorized)
+
+	ciphertext, err := s.Repo.SealAttachment(s.Ctx, messageID, "first", []byte("jpeg"))
+	s.Require().NoError(err)
+	content, err := s.Repo.OpenAttachment(s.Ctx, messageID, "first", ciphertext)
+	s.Require().NoError(err)
+	s.Equal("jpeg", string(content))
+	_, err = s.Repo.OpenAttachment(s.Ctx, messageID, "second", ciphertext)
+	s.Error(err, "the ciphertext should be bound to its blob key")
+
+	s.Require().NoError(s.Repo.CreateDeliveries(s.Ctx, userID))
+	deliveryIDs, err := s.Repo.QueuedDeliveryIDsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	_, err = s.Repo.DeliveryAttachment(s.Ctx, deliveryIDs[0], id)
+	s.True(errors.Is(err, pgx.ErrNoRows), "attachments of unsent deliveries shouldn't be downloadable")
+	s.Require().NoError(s.Repo.MarkDeliverySent(s.Ctx, deliveryIDs[0], "<id@example.com>"))
+	attachment, err := s.Repo.DeliveryAttachment(s.Ctx, deliveryIDs[0], id)
+	s.Require().NoError(err)
+	s.Equal("first", attachment.BlobKey)
+
+	s.Require().NoError(s.Repo.DeleteAttachmentByID(s.Ctx, id))
+	keys, err := s.Repo.DeletedBlobKeys(s.Ctx, 10)
+	s.Require().NoError(err)
+	s.Equal([]string{"first"}, keys)
+	s.Require().NoError(s.Repo.ForgetDeletedBlobs(s.Ctx, keys))
+	_, err = s.Repo.CreateAttachment(s.Ctx, db.CreateAttachment{UserID: userID, LastMessageID: messageID, Filename: "letter.pdf", ContentType: "application/pdf", Size: 50, BlobKey: "second"}, quota)
+	s.Require().NoError(err, "deleting an attachment should free up its space")
+
+	s.Require().NoError(s.Repo.DeleteLastMessageByID(s.Ctx, messageID))
+	keys, err = s.Repo.DeletedBlobKeys(s.Ctx, 10)
+	s.Require().NoError(err)
+	s.Equal([]string{"second"}, keys, "deleting the message should delete its attachments' blobs too")
+}
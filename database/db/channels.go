@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	_ "embed"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/guregu/null/v6"
+)
+
+type CreateNotificationChannel struct {
+	UserID uint
+	Kind   string
+	Target string
+	Secret null.String
+	// whether the channel gets life status pings
+	LifeStatus bool
+	// whether the channel gets the user's last messages once they're released
+	LastMessages bool
+	// the groups whose last messages the channel gets
+	GroupIDs []uint
+}
+
+// email channels have to be confirmed from their address before they get anything, the other kinds are confirmed right away
+func (d *DB) CreateNotificationChannel(ctx context.Context, channel CreateNotificationChannel) (id uint, err error) {
+	err = d.db.QueryRow(ctx, `WITH c AS (
+		INSERT INTO notification_channels (user_id, kind, target, secret, life_status, last_messages, confirmed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $2 = 'email' THEN NULL ELSE now() END) RETURNING id
+	), g AS (
+		INSERT INTO notification_channel_groups (notification_channel_id, group_id) SELECT c.id, UNNEST($7::int[]) FROM c
+	)
+	SELECT id FROM c`,
+		channel.UserID, channel.Kind, channel.Target, channel.Secret, channel.LifeStatus, channel.LastMessages, channel.GroupIDs).Scan(&id)
+	return id, err
+}
+
+type NotificationChannel struct {
+	ID           uint        `json:"id"`
+	Kind         string      `json:"kind"`
+	Target       string      `json:"target"`
+	Secret       null.String `json:"-"`
+	LifeStatus   bool        `json:"lifeStatus"`
+	LastMessages bool        `json:"lastMessages"`
+	GroupIDs     []uint      `json:"groupIDs"`
+	// null until the address of an email channel confirmed that it wants the notifications
+	ConfirmedAt    null.Time `json:"confirmedAt"`
+	UnsubscribedAt null.Time `json:"unsubscribedAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// whether the channel may be sent notifications
+func (c NotificationChannel) Active() bool {
+	return c.ConfirmedAt.Valid && !c.UnsubscribedAt.Valid
+}
+
+const notificationChannelColumns = `id, kind, target, secret, life_status, last_messages, confirmed_at, unsubscribed_at, created_at,
+	ARRAY(SELECT group_id FROM notification_channel_groups WHERE notification_channel_id = notification_channels.id ORDER BY group_id) AS group_ids`
+
+func (d *DB) NotificationChannelsByUserID(ctx context.Context, userID uint) (channels []NotificationChannel, err error) {
+	if err := pgxscan.Select(ctx, d.db, &channels, "SELECT "+notificationChannelColumns+" FROM notification_channels WHERE user_id = $1 ORDER BY id", userID); err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+func (d *DB) NotificationChannelByID(ctx context.Context, id uint) (channel NotificationChannel, err error) {
+	err = pgxscan.Get(ctx, d.db, &channel, "SELECT "+notificationChannelColumns+" FROM notification_channels WHERE id = $1", id)
+	return channel, err
+}
+
+func (d *DB) LifeStatusChannelIDsByUserID(ctx context.Context, userID uint) (ids []uint, err error) {
+	if err := pgxscan.Select(ctx, d.db, &ids, `SELECT id FROM notification_channels WHERE user_id = $1 AND life_status
+		AND confirmed_at IS NOT NULL AND unsubscribed_at IS NULL ORDER BY id`, userID); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// a released last message that goes to a channel
+type ChannelLastMessage struct {
+	ChannelID     uint
+	LastMessageID uint
+	Locale        string
+}
+
+//go:embed queries/last_message_channel_notifications.sql
+var lastMessageChannelNotificationsQuery string
+
+// which of the user's released last messages go to which of their channels: the on_death messages of the channel's
+// groups that went out to at least one recipient of the group. Ordered by channel and message.
+func (d *DB) LastMessageChannelNotifications(ctx context.Context, userID uint) (notifications []ChannelLastMessage, err error) {
+	if err := pgxscan.Select(ctx, d.db, &notifications, lastMessageChannelNotificationsQuery, userID); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (d *DB) UserAuthorizationForNotificationChannel(ctx context.Context, channelID uint, userID uint) (authorized bool, err error) {
+	err = d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM notification_channels WHERE id = $1 AND user_id = $2)", channelID, userID).Scan(&authorized)
+	return authorized, err
+}
+
+func (d *DB) DeleteNotificationChannelByID(ctx context.Context, id uint) error {
+	_, err := d.db.Exec(ctx, "DELETE FROM notification_channels WHERE id = $1", id)
+	return err
+}
+
+// records that a confirmation email is sent to the address of the email channel now. claimed is false if the channel
+// is confirmed already, if the address unsubscribed from any of the user's channels, or if one of the user's channels
+// with the address was sent a confirmation email in the last minInterval.
+func (d *DB) ClaimNotificationChannelOptIn(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error) {
+	tag, err := d.db.Exec(ctx, `UPDATE notification_channels SET opt_in_sent_at = now()
+		WHERE id = $1 AND kind = 'email' AND confirmed_at IS NULL AND unsubscribed_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM notification_channels other WHERE other.user_id = notification_channels.user_id
+			AND other.target = notification_channels.target
+			AND (other.unsubscribed_at IS NOT NULL OR other.opt_in_sent_at > now() - make_interval(secs => $2)))`, id, minInterval.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// confirmed is false if the channel doesn't exist anymore, its target isn't email anymore or it unsubscribed
+func (d *DB) ConfirmNotificationChannel(ctx context.Context, id uint, email string) (confirmed bool, err error) {
+	tag, err := d.db.Exec(ctx, `UPDATE notification_channels SET confirmed_at = COALESCE(confirmed_at, now())
+		WHERE id = $1 AND kind = 'email' AND target = $2 AND unsubscribed_at IS NULL`, id, email)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// the channel is kept, so its address doesn't get another confirmation email when the user tests it or adds it again.
+// unsubscribed is false if the channel doesn't exist anymore or its target isn't email anymore.
+func (d *DB) UnsubscribeNotificationChannel(ctx context.Context, id uint, email string) (unsubscribed bool, err error) {
+	tag, err := d.db.Exec(ctx, `UPDATE notification_channels SET unsubscribed_at = COALESCE(unsubscribed_at, now())
+		WHERE id = $1 AND kind = 'email' AND target = $2`, id, email)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// records that the channel is sent a test notification now, unless it was already sent one in the last minInterval.
+// claimed is false in that case.
+func (d *DB) ClaimNotificationChannelTest(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error) {
+	tag, err := d.db.Exec(ctx, `UPDATE notification_channels SET last_tested_at = now() WHERE id = $1
+		AND (last_tested_at IS NULL OR last_tested_at <= now() - make_interval(secs => $2))`, id, minInterval.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,119 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Suite) TestNotificationChannels() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+
+	_, err = s.Repo.CreateNotificationChannel(s.Ctx, db.CreateNotificationChannel{
+		UserID: userID, Kind: "webhook", Target: "https://example.com/hook", Secret: null.StringFrom("secret"), LifeStatus: true,
+	})
+	s.Require().NoError(err)
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{UserID: userID, Name: "family"})
+	s.Require().NoError(err)
+	_, err = s.Repo.CreateNotificationChannel(s.Ctx, db.CreateNotificationChannel{
+		UserID: userID, Kind: "email", Target: "backup@google.com", LifeStatus: true, LastMessages: true, GroupIDs: []uint{groupID},
+	})
+	s.Require().NoError(err)
+
+	channels, err := s.Repo.NotificationChannelsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(channels, 2)
+
+	lifeStatusIDs, err := s.Repo.LifeStatusChannelIDsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal([]uint{channels[0].ID}, lifeStatusIDs, "email channels shouldn't get anything before they're confirmed")
+	s.Equal([]uint{groupID}, channels[1].GroupIDs)
+	s.True(channels[0].Active())
+	s.False(channels[1].Active())
+
+	emailChannelID := channels[1].ID
+	claimed, err := s.Repo.ClaimNotificationChannelOptIn(s.Ctx, emailChannelID, time.Hour)
+	s.Require().NoError(err)
+	s.True(claimed)
+	claimed, err = s.Repo.ClaimNotificationChannelOptIn(s.Ctx, emailChannelID, time.Hour)
+	s.Require().NoError(err)
+	s.False(claimed, "a second confirmation email shouldn't be sent within the interval")
+	confirmed, err := s.Repo.ConfirmNotificationChannel(s.Ctx, emailChannelID, "other@google.com")
+	s.Require().NoError(err)
+	s.False(confirmed, "only the channel's own address can confirm it")
+	confirmed, err = s.Repo.ConfirmNotificationChannel(s.Ctx, emailChannelID, "backup@google.com")
+	s.Require().NoError(err)
+	s.True(confirmed)
+	lifeStatusIDs, err = s.Repo.LifeStatusChannelIDsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal([]uint{channels[0].ID, emailChannelID}, lifeStatusIDs)
+
+	unsubscribed, err := s.Repo.UnsubscribeNotificationChannel(s.Ctx, emailChannelID, "backup@google.com")
+	s.Require().NoError(err)
+	s.True(unsubscribed)
+	lifeStatusIDs, err = s.Repo.LifeStatusChannelIDsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal([]uint{channels[0].ID}, lifeStatusIDs, "unsubscribed channels shouldn't get anything")
+	againID, err := s.Repo.CreateNotificationChannel(s.Ctx, db.CreateNotificationChannel{UserID: userID, Kind: "email", Target: "backup@google.com", LifeStatus: true})
+	s.Require().NoError(err)
+	claimed, err = s.Repo.ClaimNotificationChannelOptIn(s.Ctx, againID, 0)
+	s.Require().NoError(err)
+	s.False(claimed, "an address that unsubscribed shouldn't get another confirmation email")
+
+	claimed, err = s.Repo.ClaimNotificationChannelTest(s.Ctx, channels[0].ID, time.Hour)
+	s.Require().NoError(err)
+	s.True(claimed)
+	claimed, err = s.Repo.ClaimNotificationChannelTest(s.Ctx, channels[0].ID, time.Hour)
+	s.Require().NoError(err)
+	s.False(claimed, "a second test notification shouldn't be sent within the interval")
+
+	channel, err := s.Repo.NotificationChannelByID(s.Ctx, channels[0].ID)
+	s.Require().NoError(err)
+	s.Equal(null.StringFrom("secret"), channel.Secret)
+
+	authorized, err := s.Repo.UserAuthorizationForNotificationChannel(s.Ctx, channel.ID, userID+1)
+	s.Require().NoError(err)
+	s.False(authorized, "other users shouldn't be authorized for the channel")
+
+	s.Require().NoError(s.Repo.DeleteNotificationChannelByID(s.Ctx, channel.ID))
+	_, err = s.Repo.NotificationChannelByID(s.Ctx, channel.ID)
+	s.ErrorIs(err, pgx.ErrNoRows)
+}
+
+func (s *Suite) TestLastMessageChannelNotifications() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	familyID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{UserID: userID, Name: "family", RecipientEmails: []string{"family@google.com"}, Locale: null.StringFrom("de")})
+	s.Require().NoError(err)
+	friendsID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{UserID: userID, Name: "friends", RecipientEmails: []string{"friend@google.com"}})
+	s.Require().NoError(err)
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "family", Content: null.StringFrom("hi"), GroupIDs: []uint{familyID}}))
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "friends", Content: null.StringFrom("hi"), GroupIDs: []uint{friendsID}}))
+	_, err = s.Repo.CreateNotificationChannel(s.Ctx, db.CreateNotificationChannel{
+		UserID: userID, Kind: "webhook", Target: "https://example.com/hook", LastMessages: true, GroupIDs: []uint{familyID},
+	})
+	s.Require().NoError(err)
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	channels, err := s.Repo.NotificationChannelsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+
+	notifications, err := s.Repo.LastMessageChannelNotifications(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Empty(notifications, "messages that weren't released shouldn't go to channels")
+
+	s.Require().NoError(s.Repo.CreateDeliveries(s.Ctx, userID))
+	notifications, err = s.Repo.LastMessageChannelNotifications(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal([]db.ChannelLastMessage{{ChannelID: channels[0].ID, LastMessageID: messages[0].ID, Locale: "de"}}, notifications,
+		"channels should only get the messages of their groups, in the group's locale")
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	_ "embed"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+type CheckInSource string
+
+// the ways a user can let us know they're still alive
+const (
+	CheckInSourceEmail  CheckInSource = "email"
+	CheckInSourceApp    CheckInSource = "app"
+	CheckInSourceAPIKey CheckInSource = "apiKey"
+)
+
+//go:embed queries/check_in_user.sql
+var checkInUserQuery string
+
+// resets the user's sent emails counter, records when they last confirmed they're alive and adds the check-in to their history
+func (d *DB) CheckInUser(ctx context.Context, userID uint, source CheckInSource) (checkedInAt time.Time, err error) {
+	err = d.db.QueryRow(ctx, checkInUserQuery, userID, source).Scan(&checkedInAt)
+	return checkedInAt, err
+}
+
+type CheckIn struct {
+	ID        uint          `json:"id"`
+	Source    CheckInSource `json:"source"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// newest check-ins first
+func (d *DB) CheckInsByUserID(ctx context.Context, userID uint, limit uint, offset uint) (checkIns []CheckIn, err error) {
+	if err := pgxscan.Select(ctx, d.db, &checkIns, "SELECT id, source, created_at FROM check_ins WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3", userID, limit, offset); err != nil {
+		return nil, err
+	}
+	return checkIns, nil
+}
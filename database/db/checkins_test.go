@@ -0,0 +1,37 @@
+package db_test
+
+import (
+	"github.com/gragorther/epigo/database/db"
+)
+
+func (s *Suite) TestCheckInUser() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	s.Require().NoError(s.Repo.IncrementUserSentEmailsCount(s.Ctx, userID))
+	s.Require().NoError(s.Repo.IncrementUserSentEmailsCount(s.Ctx, userID))
+
+	checkedInAt, err := s.Repo.CheckInUser(s.Ctx, userID, db.CheckInSourceApp)
+	s.Require().NoError(err)
+	s.False(checkedInAt.IsZero(), "check-in timestamp should be set")
+
+	sentEmails, err := s.Repo.GetUserSentEmails(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Zero(sentEmails.SentEmails, "sent emails should be reset")
+
+	_, err = s.Repo.CheckInUser(s.Ctx, userID, db.CheckInSourceEmail)
+	s.Require().NoError(err)
+
+	checkIns, err := s.Repo.CheckInsByUserID(s.Ctx, userID, 10, 0)
+	s.Require().NoError(err)
+	s.Require().Len(checkIns, 2)
+	s.Equal(db.CheckInSourceEmail, checkIns[0].Source, "newest check-in should come first")
+	s.Equal(db.CheckInSourceApp, checkIns[1].Source)
+
+	checkIns, err = s.Repo.CheckInsByUserID(s.Ctx, userID, 1, 1)
+	s.Require().NoError(err)
+	s.Require().Len(checkIns, 1)
+	s.Equal(db.CheckInSourceApp, checkIns[0].Source)
+}
@@ -1,15 +1,19 @@
 package db
 
 import (
+	"github.com/gragorther/epigo/envelope"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type DB struct {
 	db *pgxpool.Pool
+	// the master keys last messages are encrypted with
+	keys *envelope.Keys
 }
 
-func NewDB(db *pgxpool.Pool) *DB {
+func NewDB(db *pgxpool.Pool, keys *envelope.Keys) *DB {
 	return &DB{
-		db: db,
+		db:   db,
+		keys: keys,
 	}
 }
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+// marks the account for deletion once the cooling-off period is over at deleteAt
+func (d *DB) ScheduleUserDeletion(ctx context.Context, userID uint, deleteAt time.Time) error {
+	_, err := d.db.Exec(ctx, "UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2", deleteAt, userID)
+	return err
+}
+
+// what's needed to schedule the death task of a user again once their deletion is cancelled
+type KeptUser struct {
+	ID     uint
+	Name   string
+	Status UserStatus
+	// when the last messages get released if the user is pending release or awaiting confirmation
+	ReleaseAt null.Time
+}
+
+// cancelled is false if no deletion was scheduled
+func (d *DB) CancelUserDeletion(ctx context.Context, userID uint) (user KeptUser, cancelled bool, err error) {
+	err = d.db.QueryRow(ctx, "UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL RETURNING id, COALESCE(name, ''), status, release_at", userID).
+		Scan(&user.ID, &user.Name, &user.Status, &user.ReleaseAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return KeptUser{}, false, nil
+	}
+	return user, err == nil, err
+}
+
+// deletes the user if their deletion was scheduled and is due. deleted is false if it was cancelled or moved to later.
+func (d *DB) DeleteUserIfDue(ctx context.Context, userID uint) (deleted bool, err error) {
+	tag, err := d.db.Exec(ctx, "DELETE FROM users WHERE id = $1 AND deletion_scheduled_at <= now()", userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
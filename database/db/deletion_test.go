@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Suite) TestUserDeletionAndExport() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username:     "testusername",
+		Email:        "testemail@google.com",
+		PasswordHash: "hash",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "title", Content: null.StringFrom("content")}))
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	_, err = s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{Name: "group", UserID: userID, LastMessageIDs: []uint{messages[0].ID}, RecipientEmails: []string{"recipient@google.com"}})
+	s.Require().NoError(err)
+	_, err = s.Repo.CheckInUser(s.Ctx, userID, db.CheckInSourceApp)
+	s.Require().NoError(err)
+
+	export, err := s.Repo.ExportUserData(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal("testemail@google.com", export.Profile.Email)
+	s.Require().Len(export.LastMessages, 1)
+	s.Len(export.LastMessages[0].GroupIDs, 1)
+	s.Require().Len(export.Groups, 1)
+	s.Len(export.Groups[0].Recipients, 1)
+	s.Len(export.CheckIns, 1)
+
+	s.Require().NoError(s.Repo.ScheduleUserDeletion(s.Ctx, userID, time.Now().Add(time.Hour)))
+	deleted, err := s.Repo.DeleteUserIfDue(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.False(deleted, "the user shouldn't be deleted before the cooling-off period is over")
+	kept, cancelled, err := s.Repo.CancelUserDeletion(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.True(cancelled)
+	s.Equal(userID, kept.ID)
+	s.Equal(db.UserStatusAlive, kept.Status)
+	s.False(kept.ReleaseAt.Valid)
+	_, cancelled, err = s.Repo.CancelUserDeletion(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.False(cancelled, "there's no deletion left to cancel")
+
+	s.Require().NoError(s.Repo.ScheduleUserDeletion(s.Ctx, userID, time.Now().Add(time.Hour)))
+	releaseAt, pending, err := s.Repo.StartUserPendingRelease(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().True(pending)
+	kept, cancelled, err = s.Repo.CancelUserDeletion(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.True(cancelled)
+	s.Equal(db.UserStatusPendingRelease, kept.Status)
+	s.WithinDuration(releaseAt, kept.ReleaseAt.Time, time.Second)
+
+	s.Require().NoError(s.Repo.ScheduleUserDeletion(s.Ctx, userID, time.Now().Add(-time.Second)))
+	deleted, err = s.Repo.DeleteUserIfDue(s.Ctx, userID)
+	s.Require().NoError(err, "deleting a user with messages, groups and recipients should cascade")
+	s.True(deleted)
+	_, err = s.Repo.UserByID(s.Ctx, userID)
+	s.True(errors.Is(err, pgx.ErrNoRows))
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	_ "embed"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/guregu/null/v6"
+)
+
+type DeliveryStatus string
+
+// a delivery is one last message sent to one recipient
+const (
+	DeliveryStatusQueued DeliveryStatus = "queued"
+	// the email is being handed to the mail server. A delivery that stays in it was interrupted after the email may
+	// have gone out, so it isn't sent again.
+	DeliveryStatusSending DeliveryStatus = "sending"
+	DeliveryStatusSent    DeliveryStatus = "sent"
+	// the delivery won't be retried anymore
+	DeliveryStatusFailed DeliveryStatus = "failed"
+)
+
+// whether the recipient may have gotten the email, so the links in it have to work
+func (s DeliveryStatus) MaybeSent() bool {
+	return s == DeliveryStatusSent || s == DeliveryStatusSending
+}
+
+//go:embed queries/create_deliveries.sql
+var createDeliveriesQuery string
+
+// creates a queued delivery for every last message and recipient of the user. Existing deliveries are left alone, so this can be retried.
+func (d *DB) CreateDeliveries(ctx context.Context, userID uint) error {
+	_, err := d.db.Exec(ctx, createDeliveriesQuery, userID)
+	return err
+}
+
+func (d *DB) QueuedDeliveryIDsByUserID(ctx context.Context, userID uint) (ids []uint, err error) {
+	if err := pgxscan.Select(ctx, d.db, &ids, "SELECT id FROM deliveries WHERE user_id = $1 AND status = $2 ORDER BY id", userID, DeliveryStatusQueued); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+//go:embed queries/delivery_by_id.sql
+var deliveryByIDQuery string
+
+// everything needed to send a delivery
+type DeliveryMessage struct {
+	Status         DeliveryStatus
+	LastMessageID  uint
+	RecipientEmail string
+	Locale         string
+	Title          string
+	Content        null.String
+	// set for client side encrypted messages, in which case Content is empty
+	EncryptedContent *EncryptedContent
+	// the name of the user who left the message
+	UserName    string
+	ReleaseMode ReleaseMode
+	ReleaseRule ReleaseRule
+	// this recipient's share of the key of a shamir message. It's removed once the delivery was sent.
+	KeyShare []byte
+	// the content of a shamir message, encrypted with the key that was split
+	SharedCiphertext []byte
+	ShareThreshold   null.Int32
+	ShareCount       null.Int32
+}
+
+func (d *DB) DeliveryMessageByID(ctx context.Context, id uint) (delivery DeliveryMessage, err error) {
+	var m sealedLastMessage
+	if err := d.db.QueryRow(ctx, deliveryByIDQuery, id).Scan(append([]any{&delivery.Status, &delivery.RecipientEmail, &delivery.Locale, &delivery.UserName, &delivery.ReleaseMode,
+		&delivery.ReleaseRule, &delivery.KeyShare, &delivery.SharedCiphertext, &delivery.ShareThreshold, &delivery.ShareCount, &delivery.LastMessageID}, m.scanTargets()...)...); err != nil {
+		return DeliveryMessage{}, err
+	}
+	lastMessage, err := d.openLastMessage(delivery.LastMessageID, m)
+	if err != nil {
+		return DeliveryMessage{}, err
+	}
+	delivery.Title = lastMessage.Title
+	delivery.Content = lastMessage.Content
+	delivery.EncryptedContent = lastMessage.EncryptedContent
+	return delivery, nil
+}
+
+// moves the delivery from queued to sending right before its email is sent, so it's sent at most once. claimed is
+// false if the delivery isn't queued anymore.
+func (d *DB) ClaimDeliveryForSending(ctx context.Context, id uint) (claimed bool, err error) {
+	tag, err := d.db.Exec(ctx, "UPDATE deliveries SET status = $1 WHERE id = $2 AND status = $3", DeliveryStatusSending, id, DeliveryStatusQueued)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// the key share of the delivery is removed, since the recipient has it now
+func (d *DB) MarkDeliverySent(ctx context.Context, id uint, smtpMessageID string) error {
+	_, err := d.db.Exec(ctx, "UPDATE deliveries SET status = $1, smtp_message_id = $2, attempts = attempts + 1, last_error = NULL, key_share = NULL WHERE id = $3", DeliveryStatusSent, smtpMessageID, id)
+	return err
+}
+
+// records a failed attempt. If final is false, the delivery stays queued so it gets retried. If it's final, the key
+// share of the delivery is dropped, since it won't be sent anymore.
+func (d *DB) MarkDeliveryAttemptFailed(ctx context.Context, id uint, lastError string, final bool) error {
+	status := DeliveryStatusQueued
+	if final {
+		status = DeliveryStatusFailed
+	}
+	_, err := d.db.Exec(ctx, "UPDATE deliveries SET status = $1, last_error = $2, attempts = attempts + 1, key_share = CASE WHEN $4 THEN NULL ELSE key_share END WHERE id = $3",
+		status, lastError, id, final)
+	return err
+}
+
+type Delivery struct {
+	ID             uint           `json:"id"`
+	LastMessageID  uint           `json:"lastMessageID"`
+	RecipientEmail string         `json:"recipientEmail"`
+	Locale         string         `json:"locale"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       uint           `json:"attempts"`
+	LastError      null.String    `json:"lastError"`
+	SMTPMessageID  null.String    `json:"smtpMessageID" db:"smtp_message_id"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+	// when the delivery is sent, which depends on the release rule of the message
+	SendAt time.Time `json:"sendAt"`
+	// counts the years of yearly messages, starting at 0
+	Occurrence uint `json:"occurrence"`
+}
+
+func (d *DB) DeliveriesByUserID(ctx context.Context, userID uint) (deliveries []Delivery, err error) {
+	if err := pgxscan.Select(ctx, d.db, &deliveries, `SELECT id, last_message_id, recipient_email, locale, status, attempts, occurrence, send_at, last_error, smtp_message_id, created_at, updated_at
+		FROM deliveries WHERE user_id = $1 ORDER BY id`, userID); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
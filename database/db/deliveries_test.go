@@ -0,0 +1,60 @@
+package db_test
+
+import (
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+)
+
+func (s *Suite) TestDeliveries() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+		UserID:          userID,
+		Name:            "testname",
+		RecipientEmails: []string{"first@google.com", "second@google.com"},
+		Locale:          null.StringFrom("de"),
+	})
+	s.Require().NoError(err, "creating test group shouldn't fail")
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{
+		UserID:   userID,
+		Title:    "title",
+		Content:  null.StringFrom("content"),
+		GroupIDs: []uint{groupID},
+	}))
+
+	s.Require().NoError(s.Repo.CreateDeliveries(s.Ctx, userID))
+	s.Require().NoError(s.Repo.CreateDeliveries(s.Ctx, userID), "creating deliveries again shouldn't fail")
+	ids, err := s.Repo.QueuedDeliveryIDsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(ids, 2, "there should be exactly one delivery per recipient")
+
+	delivery, err := s.Repo.DeliveryMessageByID(s.Ctx, ids[0])
+	s.Require().NoError(err)
+	s.Equal("title", delivery.Title)
+	s.Equal("testusername", delivery.UserName, "the username should be used when the user has no name")
+	s.Equal("de", delivery.Locale, "the group's locale should be used over the user's")
+
+	claimed, err := s.Repo.ClaimDeliveryForSending(s.Ctx, ids[0])
+	s.Require().NoError(err)
+	s.True(claimed)
+	claimed, err = s.Repo.ClaimDeliveryForSending(s.Ctx, ids[0])
+	s.Require().NoError(err)
+	s.False(claimed, "a delivery should only be sent once")
+	s.Require().NoError(s.Repo.MarkDeliverySent(s.Ctx, ids[0], "<id@example.com>"))
+	s.Require().NoError(s.Repo.MarkDeliveryAttemptFailed(s.Ctx, ids[1], "mailbox full", false))
+	ids, err = s.Repo.QueuedDeliveryIDsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Len(ids, 1, "a non-final failure should stay queued")
+
+	s.Require().NoError(s.Repo.MarkDeliveryAttemptFailed(s.Ctx, ids[0], "no such user", true))
+	deliveries, err := s.Repo.DeliveriesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(deliveries, 2)
+	s.Equal(db.DeliveryStatusSent, deliveries[0].Status)
+	s.Equal(db.DeliveryStatusFailed, deliveries[1].Status)
+	s.Equal(uint(2), deliveries[1].Attempts)
+	s.Equal(null.StringFrom("no such user"), deliveries[1].LastError)
+}
@@ -0,0 +1,342 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gragorther/epigo/envelope"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+// the title and content of last messages are encrypted with a data key per message, wrapped by the master key (see the
+// envelope package), so a leaked database dump doesn't expose them.
+//
+// the additional data binds each ciphertext to its column, so they can't be swapped around
+const (
+	adLastMessageTitle            = "last_messages.title"
+	adLastMessageContent          = "last_messages.content"
+	adLastMessageEncryptedContent = "last_messages.encrypted_content"
+)
+
+// the columns of a last message as it's stored, in the order sealedLastMessage.scanTargets expects them
+const sealedLastMessageColumns = `last_messages.title, last_messages.content, last_messages.encrypted_content,
+	last_messages.title_ciphertext, last_messages.content_ciphertext, last_messages.encrypted_content_ciphertext,
+	last_messages.data_key, last_messages.key_version`
+
+// a last message as it's stored. Messages from before encryption have a plaintext title and content and no key version.
+type sealedLastMessage struct {
+	Title                      null.String
+	Content                    null.String
+	EncryptedContent           *EncryptedContent
+	TitleCiphertext            []byte
+	ContentCiphertext          []byte
+	EncryptedContentCiphertext []byte
+	DataKey                    []byte
+	KeyVersion                 null.Int32
+}
+
+func (m *sealedLastMessage) scanTargets() []any {
+	return []any{&m.Title, &m.Content, &m.EncryptedContent, &m.TitleCiphertext, &m.ContentCiphertext, &m.EncryptedContentCiphertext, &m.DataKey, &m.KeyVersion}
+}
+
+func (d *DB) openLastMessage(id uint, m sealedLastMessage) (LastMessage, error) {
+	if !m.KeyVersion.Valid {
+		m.EncryptedContent.dropRawKeyFragment()
+		return LastMessage{ID: id, Title: m.Title.String, Content: m.Content, EncryptedContent: m.EncryptedContent}, nil
+	}
+	dataKey, err := d.keys.UnwrapDataKey(m.DataKey, uint(m.KeyVersion.Int32))
+	if err != nil {
+		return LastMessage{}, fmt.Errorf("failed to unwrap data key of last message %d: %w", id, err)
+	}
+	return openLastMessageFields(id, dataKey, sealedFields{
+		TitleCiphertext:            m.TitleCiphertext,
+		ContentCiphertext:          m.ContentCiphertext,
+		EncryptedContentCiphertext: m.EncryptedContentCiphertext,
+	})
+}
+
+// decrypts the title and content of a last message, or of one of its revisions
+func openLastMessageFields(id uint, dataKey envelope.DataKey, fields sealedFields) (LastMessage, error) {
+	title, err := dataKey.Open(fields.TitleCiphertext, []byte(adLastMessageTitle))
+	if err != nil {
+		return LastMessage{}, fmt.Errorf("failed to decrypt title of last message %d: %w", id, err)
+	}
+	lastMessage := LastMessage{ID: id, Title: string(title)}
+	if fields.ContentCiphertext != nil {
+		content, err := dataKey.Open(fields.ContentCiphertext, []byte(adLastMessageContent))
+		if err != nil {
+			return LastMessage{}, fmt.Errorf("failed to decrypt content of last message %d: %w", id, err)
+		}
+		lastMessage.Content = null.StringFrom(string(content))
+	}
+	if fields.EncryptedContentCiphertext != nil {
+		encryptedContent, err := dataKey.Open(fields.EncryptedContentCiphertext, []byte(adLastMessageEncryptedContent))
+		if err != nil {
+			return LastMessage{}, fmt.Errorf("failed to decrypt encrypted content of last message %d: %w", id, err)
+		}
+		if err := json.Unmarshal(encryptedContent, &lastMessage.EncryptedContent); err != nil {
+			return LastMessage{}, err
+		}
+		lastMessage.EncryptedContent.dropRawKeyFragment()
+	}
+	return lastMessage, nil
+}
+
+// the ciphertexts of the fields that were set; the others stay nil
+type sealedFields struct {
+	TitleCiphertext            []byte
+	ContentCiphertext          []byte
+	EncryptedContentCiphertext []byte
+}
+
+func sealLastMessageFields(dataKey envelope.DataKey, title null.String, content null.String, encryptedContent *EncryptedContent) (fields sealedFields, err error) {
+	if title.Valid {
+		if fields.TitleCiphertext, err = dataKey.Seal([]byte(title.String), []byte(adLastMessageTitle)); err != nil {
+			return sealedFields{}, err
+		}
+	}
+	if content.Valid {
+		if fields.ContentCiphertext, err = dataKey.Seal([]byte(content.String), []byte(adLastMessageContent)); err != nil {
+			return sealedFields{}, err
+		}
+	}
+	if encryptedContent != nil {
+		stored := *encryptedContent
+		stored.dropRawKeyFragment()
+		plaintext, err := json.Marshal(stored)
+		if err != nil {
+			return sealedFields{}, err
+		}
+		if fields.EncryptedContentCiphertext, err = dataKey.Seal(plaintext, []byte(adLastMessageEncryptedContent)); err != nil {
+			return sealedFields{}, err
+		}
+	}
+	return fields, nil
+}
+
+// the data key of an existing last message. Messages from before encryption get encrypted first, so they have one.
+func (d *DB) lastMessageDataKey(ctx context.Context, id uint) (envelope.DataKey, error) {
+	var key wrappedDataKey
+	if err := pgxscan.Get(ctx, d.db, &key, "SELECT id, data_key, key_version FROM last_messages WHERE id = $1", id); err != nil {
+		return envelope.DataKey{}, err
+	}
+	if !key.KeyVersion.Valid {
+		if err := d.encryptPlaintextLastMessage(ctx, id); err != nil {
+			return envelope.DataKey{}, err
+		}
+		return d.lastMessageDataKey(ctx, id)
+	}
+	return d.keys.UnwrapDataKey(key.DataKey, uint(key.KeyVersion.Int32))
+}
+
+// encrypts a last message stored before encryption was added and removes its plaintext
+func (d *DB) encryptPlaintextLastMessage(ctx context.Context, id uint) error {
+	var m sealedLastMessage
+	err := d.db.QueryRow(ctx, "SELECT "+sealedLastMessageColumns+" FROM last_messages WHERE id = $1 AND key_version IS NULL", id).Scan(m.scanTargets()...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// someone else encrypted it in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dataKey, wrapped, version, err := d.keys.GenerateDataKey()
+	if err != nil {
+		return err
+	}
+	fields, err := sealLastMessageFields(dataKey, null.StringFrom(m.Title.String), m.Content, m.EncryptedContent)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(ctx, `UPDATE last_messages SET title = NULL, content = NULL, encrypted_content = NULL,
+		title_ciphertext = $1, content_ciphertext = $2, encrypted_content_ciphertext = $3, data_key = $4, key_version = $5
+		WHERE id = $6 AND key_version IS NULL`, fields.TitleCiphertext, fields.ContentCiphertext, fields.EncryptedContentCiphertext, wrapped, version, id)
+	return err
+}
+
+// attachments are encrypted with the data key of their last message, bound to their blob key
+func attachmentAD(blobKey string) []byte {
+	return []byte("attachments:" + blobKey)
+}
+
+// encrypts the file of an attachment before it goes into the blob store
+func (d *DB) SealAttachment(ctx context.Context, lastMessageID uint, blobKey string, content []byte) (ciphertext []byte, err error) {
+	dataKey, err := d.lastMessageDataKey(ctx, lastMessageID)
+	if err != nil {
+		return nil, err
+	}
+	return dataKey.Seal(content, attachmentAD(blobKey))
+}
+
+func (d *DB) OpenAttachment(ctx context.Context, lastMessageID uint, blobKey string, ciphertext []byte) (content []byte, err error) {
+	dataKey, err := d.lastMessageDataKey(ctx, lastMessageID)
+	if err != nil {
+		return nil, err
+	}
+	content, err = dataKey.Open(ciphertext, attachmentAD(blobKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt attachment %s of last message %d: %w", blobKey, lastMessageID, err)
+	}
+	return content, nil
+}
+
+// the cursor only works for the user it was made for
+func cursorAD(userID uint) []byte {
+	return []byte("cursor:" + strconv.FormatUint(uint64(userID), 10))
+}
+
+// encrypts a sort value, e.g. a title, so it can go into a cursor
+func (d *DB) sealCursorValue(userID uint, value string) (*sealedCursorValue, error) {
+	dataKey, wrapped, version, err := d.keys.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := dataKey.Seal([]byte(value), cursorAD(userID))
+	if err != nil {
+		return nil, err
+	}
+	return &sealedCursorValue{WrappedKey: wrapped, KeyVersion: version, Ciphertext: ciphertext}, nil
+}
+
+// returns ErrInvalidCursor if the value wasn't sealed for the user, or with a master key that was dropped since
+func (d *DB) openCursorValue(userID uint, sealed *sealedCursorValue) (string, error) {
+	dataKey, err := d.keys.UnwrapDataKey(sealed.WrappedKey, sealed.KeyVersion)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	value, err := dataKey.Open(sealed.Ciphertext, cursorAD(userID))
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	return string(value), nil
+}
+
+type wrappedDataKey struct {
+	ID         uint
+	DataKey    []byte
+	KeyVersion null.Int32
+}
+
+// how many rows ReencryptLastMessages loads at once
+const reencryptBatchSize = 100
+
+// wraps the data keys of all last messages with the current master key, so older master keys can be removed afterwards,
+// and encrypts messages stored before encryption was added. It can run while the app is running.
+func (d *DB) ReencryptLastMessages(ctx context.Context) (reencrypted uint, err error) {
+	current := d.keys.CurrentVersion()
+	var lastID uint
+	for {
+		var keys []wrappedDataKey
+		if err := pgxscan.Select(ctx, d.db, &keys, "SELECT id, data_key, key_version FROM last_messages WHERE key_version IS DISTINCT FROM $1 AND id > $2 ORDER BY id LIMIT $3",
+			current, lastID, reencryptBatchSize); err != nil {
+			return reencrypted, err
+		}
+		if len(keys) == 0 {
+			return reencrypted, nil
+		}
+		for _, key := range keys {
+			lastID = key.ID
+			if !key.KeyVersion.Valid {
+				if err := d.encryptPlaintextLastMessage(ctx, key.ID); err != nil {
+					return reencrypted, fmt.Errorf("failed to encrypt last message %d: %w", key.ID, err)
+				}
+				reencrypted++
+				continue
+			}
+			rewrapped, version, err := d.keys.RewrapDataKey(key.DataKey, uint(key.KeyVersion.Int32))
+			if err != nil {
+				return reencrypted, fmt.Errorf("failed to rewrap data key of last message %d: %w", key.ID, err)
+			}
+			if _, err := d.db.Exec(ctx, "UPDATE last_messages SET data_key = $1, key_version = $2 WHERE id = $3 AND key_version = $4",
+				rewrapped, version, key.ID, key.KeyVersion); err != nil {
+				return reencrypted, err
+			}
+			reencrypted++
+		}
+	}
+}
+
+// removes key fragments that aren't wrapped, i.e. the keys of end-to-end encrypted messages, from encrypted messages and
+// their revisions. Those were accepted before only wrapped keys were. The migration removes them from messages stored
+// before encryption was added, but it can't decrypt the others, so the reencrypt command does. Until then they're
+// dropped whenever a message is read.
+func (d *DB) RemoveRawKeyFragments(ctx context.Context) (removed uint, err error) {
+	var lastID uint
+	for {
+		var keys []wrappedDataKey
+		if err := pgxscan.Select(ctx, d.db, &keys, `SELECT id, data_key, key_version FROM last_messages
+			WHERE encrypted_content_ciphertext IS NOT NULL AND key_version IS NOT NULL AND id > $1 ORDER BY id LIMIT $2`, lastID, reencryptBatchSize); err != nil {
+			return removed, err
+		}
+		if len(keys) == 0 {
+			return removed, nil
+		}
+		for _, key := range keys {
+			lastID = key.ID
+			dataKey, err := d.keys.UnwrapDataKey(key.DataKey, uint(key.KeyVersion.Int32))
+			if err != nil {
+				return removed, fmt.Errorf("failed to unwrap data key of last message %d: %w", key.ID, err)
+			}
+			n, err := d.removeRawKeyFragment(ctx, key.ID, dataKey)
+			if err != nil {
+				return removed, fmt.Errorf("failed to remove the key fragment of last message %d: %w", key.ID, err)
+			}
+			removed += n
+		}
+	}
+}
+
+// removes the raw key fragment from the message, which records a revision without it, and deletes the revisions that
+// still have it
+func (d *DB) removeRawKeyFragment(ctx context.Context, id uint, dataKey envelope.DataKey) (removed uint, err error) {
+	rows, err := d.db.Query(ctx, `SELECT 0, encrypted_content_ciphertext FROM last_messages WHERE id = $1 AND encrypted_content_ciphertext IS NOT NULL
+		UNION ALL SELECT id, encrypted_content_ciphertext FROM last_message_revisions WHERE last_message_id = $1 AND encrypted_content_ciphertext IS NOT NULL`, id)
+	if err != nil {
+		return 0, err
+	}
+	type sealedEncryptedContent struct {
+		RevisionID uint
+		Ciphertext []byte
+	}
+	sealed, err := pgx.CollectRows(rows, pgx.RowToStructByPos[sealedEncryptedContent])
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range sealed {
+		plaintext, err := dataKey.Open(s.Ciphertext, []byte(adLastMessageEncryptedContent))
+		if err != nil {
+			return removed, err
+		}
+		var encryptedContent EncryptedContent
+		if err := json.Unmarshal(plaintext, &encryptedContent); err != nil {
+			return removed, err
+		}
+		if !encryptedContent.dropRawKeyFragment() {
+			continue
+		}
+		if s.RevisionID != 0 {
+			// revisions can't be changed, only deleted
+			if _, err := d.db.Exec(ctx, "DELETE FROM last_message_revisions WHERE id = $1", s.RevisionID); err != nil {
+				return removed, err
+			}
+			removed++
+			continue
+		}
+		fields, err := sealLastMessageFields(dataKey, null.String{}, null.String{}, &encryptedContent)
+		if err != nil {
+			return removed, err
+		}
+		// only if it wasn't changed in the meantime
+		if _, err := d.db.Exec(ctx, "UPDATE last_messages SET encrypted_content_ciphertext = $1 WHERE id = $2 AND encrypted_content_ciphertext = $3",
+			fields.EncryptedContentCiphertext, id, s.Ciphertext); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+type ExportProfile struct {
+	Username                     string      `json:"username"`
+	Name                         null.String `json:"name"`
+	Email                        string      `json:"email"`
+	Locale                       string      `json:"locale"`
+	Cron                         string      `json:"cron"`
+	MaxSentEmails                uint        `json:"maxSentEmails"`
+	Status                       UserStatus  `json:"status"`
+	GracePeriodSeconds           uint        `json:"gracePeriodSeconds"`
+	TrustedContactQuorum         uint        `json:"trustedContactQuorum"`
+	TrustedContactTimeoutSeconds uint        `json:"trustedContactTimeoutSeconds"`
+	TOTPEnabled                  bool        `json:"totpEnabled" db:"totp_enabled"`
+	LastConfirmedAt              null.Time   `json:"lastConfirmedAt"`
+	DeletionScheduledAt          null.Time   `json:"deletionScheduledAt"`
+	CreatedAt                    null.Time   `json:"createdAt"`
+}
+
+type ExportLastMessage struct {
+	ID      uint        `json:"id"`
+	Title   string      `json:"title"`
+	Content null.String `json:"content"`
+	// client side encrypted messages are exported as they are stored, the key never reaches the server
+	EncryptedContent *EncryptedContent `json:"encryptedContent"`
+	GroupIDs         []int64           `json:"groupIDs"`
+	// earlier versions of the title and content, newest first
+	Revisions []LastMessageRevision `json:"revisions"`
+}
+
+type ExportGroup struct {
+	ID                    uint             `json:"id"`
+	Name                  string           `json:"name"`
+	Description           null.String      `json:"description"`
+	Locale                null.String      `json:"locale"`
+	RequireRecipientOptIn bool             `json:"requireRecipientOptIn"`
+	Recipients            []GroupRecipient `json:"recipients"`
+}
+
+// everything stored about a user, for GDPR data exports. Secrets like the password hash and channel secrets are left out.
+type UserExport struct {
+	Profile              ExportProfile         `json:"profile"`
+	LastMessages         []ExportLastMessage   `json:"lastMessages"`
+	Groups               []ExportGroup         `json:"groups"`
+	CheckIns             []CheckIn             `json:"checkIns"`
+	Deliveries           []Delivery            `json:"deliveries"`
+	TrustedContacts      []TrustedContact      `json:"trustedContacts"`
+	NotificationChannels []NotificationChannel `json:"notificationChannels"`
+	Sessions             []Session             `json:"sessions"`
+	// only what's known about the files, they're downloaded one by one
+	Attachments []Attachment `json:"attachments"`
+}
+
+func (d *DB) ExportUserData(ctx context.Context, userID uint) (export UserExport, err error) {
+	if err := pgxscan.Get(ctx, d.db, &export.Profile, `SELECT username, name, email, locale, cron, max_sent_emails, status, grace_period_seconds,
+	trusted_contact_quorum, trusted_contact_timeout_seconds, totp_enabled, last_confirmed_at, deletion_scheduled_at, created_at FROM users WHERE id = $1`, userID); err != nil {
+		return UserExport{}, err
+	}
+	rows, err := d.db.Query(ctx, `SELECT id, ARRAY(SELECT group_id FROM group_last_messages WHERE last_message_id = last_messages.id ORDER BY group_id),
+	`+sealedLastMessageColumns+` FROM last_messages WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return UserExport{}, err
+	}
+	if export.LastMessages, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (ExportLastMessage, error) {
+		var exported ExportLastMessage
+		var m sealedLastMessage
+		if err := row.Scan(append([]any{&exported.ID, &exported.GroupIDs}, m.scanTargets()...)...); err != nil {
+			return ExportLastMessage{}, err
+		}
+		lastMessage, err := d.openLastMessage(exported.ID, m)
+		if err != nil {
+			return ExportLastMessage{}, err
+		}
+		exported.Title = lastMessage.Title
+		exported.Content = lastMessage.Content
+		exported.EncryptedContent = lastMessage.EncryptedContent
+		return exported, nil
+	}); err != nil {
+		return UserExport{}, err
+	}
+	for i := range export.LastMessages {
+		if export.LastMessages[i].Revisions, err = d.LastMessageRevisions(ctx, export.LastMessages[i].ID); err != nil {
+			return UserExport{}, err
+		}
+	}
+	if export.Attachments, err = d.AttachmentsByUserID(ctx, userID); err != nil {
+		return UserExport{}, err
+	}
+	if err := pgxscan.Select(ctx, d.db, &export.Groups, "SELECT id, name, description, locale, require_recipient_opt_in FROM groups WHERE user_id = $1 ORDER BY id", userID); err != nil {
+		return UserExport{}, err
+	}
+	for i := range export.Groups {
+		if export.Groups[i].Recipients, err = d.RecipientsByGroupID(ctx, export.Groups[i].ID); err != nil {
+			return UserExport{}, err
+		}
+	}
+	if err := pgxscan.Select(ctx, d.db, &export.CheckIns, "SELECT id, source, created_at FROM check_ins WHERE user_id = $1 ORDER BY created_at DESC, id DESC", userID); err != nil {
+		return UserExport{}, err
+	}
+	if export.Deliveries, err = d.DeliveriesByUserID(ctx, userID); err != nil {
+		return UserExport{}, err
+	}
+	if export.TrustedContacts, err = d.TrustedContactsByUserID(ctx, userID); err != nil {
+		return UserExport{}, err
+	}
+	if export.NotificationChannels, err = d.NotificationChannelsByUserID(ctx, userID); err != nil {
+		return UserExport{}, err
+	}
+	if export.Sessions, err = d.SessionsByUserID(ctx, userID); err != nil {
+		return UserExport{}, err
+	}
+	return export, nil
+}
@@ -26,30 +26,76 @@ func (d *DB) DeleteGroupByID(ctx context.Context, id uint) error {
 }
 
 type Group struct {
-	Name        string
-	Description null.String
-	ID          uint
+	Name                  string
+	Description           null.String
+	ID                    uint
+	RequireRecipientOptIn bool
+	// the locale recipients get their emails in. If it's null, the user's locale is used.
+	Locale null.String
 }
 
 func (d *DB) GroupsByUserID(ctx context.Context, userID uint) (groups []Group, err error) {
-	if err := pgxscan.Select(ctx, d.db, &groups, "SELECT name, description, id FROM groups WHERE user_id = $1", userID); err != nil {
+	if err := pgxscan.Select(ctx, d.db, &groups, "SELECT name, description, id, require_recipient_opt_in, locale FROM groups WHERE user_id = $1", userID); err != nil {
 		return nil, err
 	}
 	return groups, err
 }
 
+// a page of the user's groups, which can be searched by name and sorted by "id" or "name"
+func (d *DB) GroupsPageByUserID(ctx context.Context, userID uint, opts ListOptions) (groups []Group, nextCursor string, err error) {
+	field, desc, err := opts.sortField("name")
+	if err != nil {
+		return nil, "", err
+	}
+	after, err := opts.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+	query := "SELECT name, description, id, require_recipient_opt_in, locale FROM groups WHERE user_id = $1 AND name ILIKE '%' || $2 || '%'"
+	args := []any{userID, likeEscaper.Replace(opts.Search)}
+	if after != nil {
+		if field == "id" {
+			query += fmt.Sprintf(" AND id %s $3", comparison)
+			args = append(args, after.ID)
+		} else {
+			query += fmt.Sprintf(" AND (name, id) %s ($3, $4)", comparison)
+			args = append(args, after.Value, after.ID)
+		}
+	}
+	if field == "id" {
+		query += fmt.Sprintf(" ORDER BY id %s", direction)
+	} else {
+		query += fmt.Sprintf(" ORDER BY name %[1]s, id %[1]s", direction)
+	}
+	query += fmt.Sprintf(" LIMIT %d", opts.limit()+1)
+
+	if err := pgxscan.Select(ctx, d.db, &groups, query, args...); err != nil {
+		return nil, "", err
+	}
+	groups, nextCursor = page(groups, opts.limit(), opts.Sort, func(group Group) (string, uint) {
+		return group.Name, group.ID
+	})
+	return groups, nextCursor, nil
+}
+
 type CreateGroup struct {
-	Name           string
-	Description    null.String
-	UserID         uint
-	LastMessageIDs []uint
+	Name            string
+	Description     null.String
+	UserID          uint
+	LastMessageIDs  []uint
+	RecipientEmails []string
+	Locale          null.String
 }
 
 //go:embed queries/create_group.sql
 var createGroupQuery string
 
 func (d *DB) CreateGroup(ctx context.Context, group CreateGroup) error {
-	_, err := d.db.Exec(ctx, createGroupQuery, group.Name, group.Description, group.UserID, group.LastMessageIDs)
+	_, err := d.db.Exec(ctx, createGroupQuery, group.Name, group.Description, group.UserID, group.LastMessageIDs, group.RecipientEmails, group.Locale)
 	return err
 }
 
@@ -113,7 +159,7 @@ JOIN data ON ins_ord.ord = data.ord;
 var createGroupReturningIDQuery string
 
 func (d *DB) CreateGroupReturningID(ctx context.Context, group CreateGroup) (groupID uint, err error) {
-	err = d.db.QueryRow(ctx, createGroupReturningIDQuery, group.Name, group.Description, group.UserID, group.LastMessageIDs).Scan(&groupID)
+	err = d.db.QueryRow(ctx, createGroupReturningIDQuery, group.Name, group.Description, group.UserID, group.LastMessageIDs, group.RecipientEmails, group.Locale).Scan(&groupID)
 	return
 }
 
@@ -128,14 +174,17 @@ func (d *DB) UpdateGroupName(ctx context.Context, id uint, newName string) error
 }
 
 type UpdateGroup struct {
-	Name        null.String
-	Description null.String
+	Name                  null.String
+	Description           null.String
+	RequireRecipientOptIn null.Bool
+	Locale                null.String
 }
 
 var ErrAllFieldsEmpty = errors.New("all the fields in the struct are empty")
 
 func (d *DB) UpdateGroup(ctx context.Context, id uint, group UpdateGroup) error {
-	_, err := d.db.Exec(ctx, "UPDATE groups SET name = COALESCE($1, name), description = COALESCE($2, description) WHERE id = $3", group.Name, group.Description, id)
+	_, err := d.db.Exec(ctx, "UPDATE groups SET name = COALESCE($1, name), description = COALESCE($2, description), require_recipient_opt_in = COALESCE($3, require_recipient_opt_in), locale = COALESCE($4, locale) WHERE id = $5",
+		group.Name, group.Description, group.RequireRecipientOptIn, group.Locale, id)
 	return err
 }
 
@@ -143,15 +192,26 @@ func (d *DB) UpdateGroup(ctx context.Context, id uint, group UpdateGroup) error
 var groupByIDQuery string
 
 type GroupByID struct {
-	Name           string
-	Description    null.String
-	UserID         uint
-	LastMessageIDs []uint
+	ID                    uint
+	Name                  string
+	Description           null.String
+	UserID                uint
+	RequireRecipientOptIn bool
+	Locale                null.String
+	LastMessageIDs        []uint
+	Recipients            []GroupRecipient
 }
 
+// the group with the IDs of its last messages and its recipients. Returns pgx.ErrNoRows if there's no such group.
 func (d *DB) GroupByID(ctx context.Context, id uint) (group GroupByID, err error) {
-	err = d.db.QueryRow(ctx, groupByIDQuery, id).Scan(&group.Name, &group.Description, &group.UserID, &group.LastMessageIDs)
-	return
+	if err := d.db.QueryRow(ctx, groupByIDQuery, id).Scan(&group.ID, &group.Name, &group.Description, &group.UserID, &group.RequireRecipientOptIn,
+		&group.Locale, &group.LastMessageIDs); err != nil {
+		return GroupByID{}, err
+	}
+	if group.Recipients, err = d.RecipientsByGroupID(ctx, id); err != nil {
+		return GroupByID{}, err
+	}
+	return group, nil
 }
 
 func (d *DB) GroupExistsByID(ctx context.Context, groupID uint) (exists bool, err error) {
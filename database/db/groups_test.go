@@ -3,6 +3,7 @@ package db_test
 import (
 	"github.com/gragorther/epigo/database/db"
 	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
 )
 
 func (s *Suite) TestCreateGroupsForUser() {
@@ -91,5 +92,30 @@ func (s *Suite) TestGroupByID() {
 	}
 
 	s.Run("last messages", func() {
+		userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+			Username: "othername",
+			Email:    "otheremail@google.com",
+		})
+		s.Require().NoError(err)
+		s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "goodbye"}))
+		messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+		s.Require().NoError(err)
+		id, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+			UserID:          userID,
+			Name:            "family",
+			LastMessageIDs:  []uint{messages[0].ID},
+			RecipientEmails: []string{"recipient@google.com"},
+		})
+		s.Require().NoError(err)
+
+		got, err := s.Repo.GroupByID(s.Ctx, id)
+		s.Require().NoError(err)
+		s.Equal(id, got.ID)
+		s.Equal([]uint{messages[0].ID}, got.LastMessageIDs)
+		s.Require().Len(got.Recipients, 1)
+		s.Equal("recipient@google.com", got.Recipients[0].Email)
+
+		_, err = s.Repo.GroupByID(s.Ctx, id+1)
+		s.ErrorIs(err, pgx.ErrNoRows)
 	})
 }
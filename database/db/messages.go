@@ -1,11 +1,15 @@
 package db
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
 
 	_ "embed"
 
-	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/guregu/null/v6"
 	"github.com/jackc/pgx/v5"
 	"github.com/samber/lo"
@@ -19,30 +23,179 @@ func (d *DB) CanUserEditLastmessage(ctx context.Context, userID uint, messageID
 	return
 }
 
+// a message body that was encrypted by the client before it was sent to us. The server only stores and hands it out again,
+// it never sees the key.
+type EncryptedContent struct {
+	// base64 encoded
+	Ciphertext string `json:"ciphertext"`
+	Algorithm  string `json:"algorithm"`
+	// whatever the client needs to decrypt the ciphertext, like the IV or how the key is wrapped. Opaque to the server.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// put into the fragment of the link in the death email, so recipients don't have to type it in. It's always a key
+	// wrapped with a passphrase only the sender and the recipients know (see KeyWrapped), never the key itself, since
+	// it's stored here; when it's empty, recipients get the key some other way.
+	KeyFragment null.String `json:"keyFragment"`
+}
+
+// whether the metadata says how to unwrap the key fragment with a passphrase, as {"wrap": {...}}
+func (c *EncryptedContent) KeyWrapped() bool {
+	var metadata struct {
+		Wrap map[string]json.RawMessage `json:"wrap"`
+	}
+	return json.Unmarshal(c.Metadata, &metadata) == nil && metadata.Wrap != nil
+}
+
+// key fragments that aren't wrapped are the key itself, which must not leave the server, so they're dropped.
+// Messages stored before those were rejected may still have them.
+func (c *EncryptedContent) dropRawKeyFragment() (dropped bool) {
+	if c == nil || !c.KeyFragment.Valid || c.KeyWrapped() {
+		return false
+	}
+	c.KeyFragment = null.String{}
+	return true
+}
+
 type CreateLastMessage struct {
 	UserID   uint
 	Title    string
 	Content  null.String
 	GroupIDs []uint
+	// set instead of Content for client side encrypted messages
+	EncryptedContent *EncryptedContent
+	// defaults to ReleaseModeStandard
+	ReleaseMode    ReleaseMode
+	ShareThreshold null.Int32
+	// defaults to ReleaseRuleOnDeath. ReleaseDelayDays goes with ReleaseRuleAfterDeath, ReleaseAt with
+	// ReleaseRuleOnDate and ReleaseRuleYearly.
+	ReleaseRule      ReleaseRule
+	ReleaseDelayDays null.Int32
+	ReleaseAt        null.Time
 }
 
 func (d *DB) CreateLastMessage(ctx context.Context, message CreateLastMessage) error {
-	_, err := d.db.Exec(ctx, `WITH m AS (INSERT INTO last_messages (title, content, user_id) VALUES ($1, $2, $3) RETURNING id)
-		INSERT INTO group_last_messages (last_message_id, group_id) SELECT m.id, UNNEST($4::int[]) FROM m`, message.Title, message.Content, message.UserID, message.GroupIDs)
+	dataKey, wrapped, version, err := d.keys.GenerateDataKey()
+	if err != nil {
+		return err
+	}
+	fields, err := sealLastMessageFields(dataKey, null.StringFrom(message.Title), message.Content, message.EncryptedContent)
+	if err != nil {
+		return err
+	}
+	if message.ReleaseMode == "" {
+		message.ReleaseMode = ReleaseModeStandard
+	}
+	if message.ReleaseRule == "" {
+		message.ReleaseRule = ReleaseRuleOnDeath
+	}
+	_, err = d.db.Exec(ctx, `WITH m AS (INSERT INTO last_messages (title_ciphertext, content_ciphertext, encrypted_content_ciphertext, data_key, key_version, user_id,
+		release_mode, share_threshold, release_rule, release_delay_days, release_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $10, $11, $12) RETURNING id)
+		INSERT INTO group_last_messages (last_message_id, group_id) SELECT m.id, UNNEST($9::int[]) FROM m`,
+		fields.TitleCiphertext, fields.ContentCiphertext, fields.EncryptedContentCiphertext, wrapped, version, message.UserID,
+		message.ReleaseMode, message.ShareThreshold, message.GroupIDs, message.ReleaseRule, message.ReleaseDelayDays, message.ReleaseAt)
 	return err
 }
 
 type LastMessage struct {
-	Title   string
-	Content null.String
-	ID      uint
+	Title            string
+	Content          null.String
+	EncryptedContent *EncryptedContent
+	ID               uint
+	ReleaseMode      ReleaseMode
+	// how many recipients have to put their key shares together, in the shamir release mode
+	ShareThreshold   null.Int32
+	ReleaseRule      ReleaseRule
+	ReleaseDelayDays null.Int32
+	ReleaseAt        null.Time
 }
 
 func (d *DB) LastMessagesByUserID(ctx context.Context, userID uint) (lastMessages []LastMessage, err error) {
-	if err := pgxscan.Select(ctx, d.db, &lastMessages, "SELECT title, content, id FROM last_messages WHERE user_id = $1", userID); err != nil {
+	rows, err := d.db.Query(ctx, "SELECT id, release_mode, share_threshold, release_rule, release_delay_days, release_at, "+sealedLastMessageColumns+" FROM last_messages WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
 		return nil, err
 	}
-	return lastMessages, err
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (LastMessage, error) {
+		var id uint
+		var settings LastMessage
+		var m sealedLastMessage
+		if err := row.Scan(append([]any{&id, &settings.ReleaseMode, &settings.ShareThreshold, &settings.ReleaseRule, &settings.ReleaseDelayDays, &settings.ReleaseAt},
+			m.scanTargets()...)...); err != nil {
+			return LastMessage{}, err
+		}
+		lastMessage, err := d.openLastMessage(id, m)
+		lastMessage.ReleaseMode = settings.ReleaseMode
+		lastMessage.ShareThreshold = settings.ShareThreshold
+		lastMessage.ReleaseRule = settings.ReleaseRule
+		lastMessage.ReleaseDelayDays = settings.ReleaseDelayDays
+		lastMessage.ReleaseAt = settings.ReleaseAt
+		return lastMessage, err
+	})
+}
+
+// a page of the user's last messages, which can be searched by title and sorted by "id" or "title". Titles are
+// encrypted, so searching and sorting happen after all the user's messages were decrypted. Every page decrypts all of
+// them again, so a page costs as much as the user has messages, not as much as the page has.
+//
+// sorted by title, the cursor has the title of the last message of the previous page, encrypted so titles don't end
+// up in URLs. It keeps working if that message is deleted.
+func (d *DB) LastMessagesPageByUserID(ctx context.Context, userID uint, opts ListOptions) (lastMessages []LastMessage, nextCursor string, err error) {
+	field, desc, err := opts.sortField("title")
+	if err != nil {
+		return nil, "", err
+	}
+	after, err := opts.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+	all, err := d.LastMessagesByUserID(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// titles are compared ignoring case, with the ID breaking ties
+	compare := func(aTitle string, aID uint, bTitle string, bID uint) int {
+		c := 0
+		if field == "title" {
+			c = strings.Compare(strings.ToLower(aTitle), strings.ToLower(bTitle))
+		}
+		if c == 0 {
+			c = cmp.Compare(aID, bID)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	}
+	var afterTitle string
+	if after != nil && field == "title" {
+		if after.Sealed == nil {
+			return nil, "", ErrInvalidCursor
+		}
+		if afterTitle, err = d.openCursorValue(userID, after.Sealed); err != nil {
+			return nil, "", err
+		}
+	}
+	search := strings.ToLower(opts.Search)
+	lastMessages = slices.DeleteFunc(all, func(m LastMessage) bool {
+		if !strings.Contains(strings.ToLower(m.Title), search) {
+			return true
+		}
+		return after != nil && compare(m.Title, m.ID, afterTitle, after.ID) <= 0
+	})
+	slices.SortFunc(lastMessages, func(a, b LastMessage) int {
+		return compare(a.Title, a.ID, b.Title, b.ID)
+	})
+	lastMessages, nextCursor = page(lastMessages, opts.limit(), opts.Sort, func(m LastMessage) (string, uint) {
+		return "", m.ID
+	})
+	if nextCursor != "" && field == "title" {
+		last := lastMessages[len(lastMessages)-1]
+		sealed, err := d.sealCursorValue(userID, last.Title)
+		if err != nil {
+			return nil, "", err
+		}
+		nextCursor = cursor{Sort: opts.Sort, Sealed: sealed, ID: last.ID}.encode()
+	}
+	return lastMessages, nextCursor, nil
 }
 
 type LastMessageAndRecipients struct {
@@ -61,8 +214,13 @@ func (d *DB) LastMessagesAndRecipients(ctx context.Context, userID uint) (lastMe
 
 	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (LastMessageAndRecipients, error) {
 		var lm LastMessageAndRecipients
+		var id uint
+		var m sealedLastMessage
 		var recipientEmails []string
-		if err := row.Scan(&lm.LastMessage.Title, &lm.LastMessage.Content, &recipientEmails); err != nil {
+		if err := row.Scan(append(append([]any{&id}, m.scanTargets()...), &recipientEmails)...); err != nil {
+			return LastMessageAndRecipients{}, err
+		}
+		if lm.LastMessage, err = d.openLastMessage(id, m); err != nil {
 			return LastMessageAndRecipients{}, err
 		}
 		lm.Recipients = lo.Map(recipientEmails, func(item string, _ int) (recipient Recipient) {
@@ -74,18 +232,46 @@ func (d *DB) LastMessagesAndRecipients(ctx context.Context, userID uint) (lastMe
 }
 
 func (d *DB) UpdateLastMessageTitle(ctx context.Context, id uint, title string) error {
-	_, err := d.db.Exec(ctx, "UPDATE last_messages SET title = $1 WHERE id = $2", title, id)
-	return err
+	return d.UpdateLastMessage(ctx, id, UpdateLastMessage{Title: null.StringFrom(title)})
 }
 
 type UpdateLastMessage struct {
-	Title    null.String
-	Content  null.String
-	GroupIDs []uint
+	Title            null.String
+	Content          null.String
+	EncryptedContent *EncryptedContent
+	GroupIDs         []uint
+	// left alone when empty
+	ReleaseMode    ReleaseMode
+	ShareThreshold null.Int32
+	// left alone when empty. Otherwise ReleaseDelayDays and ReleaseAt are replaced along with it.
+	ReleaseRule      ReleaseRule
+	ReleaseDelayDays null.Int32
+	ReleaseAt        null.Time
 }
 
+// fields that aren't set are left alone. Setting the content removes the encrypted content and the other way around.
 func (d *DB) UpdateLastMessage(ctx context.Context, id uint, m UpdateLastMessage) error {
-	_, err := d.db.Exec(ctx, "UPDATE last_messages SET title = COALESCE($1, name), content = COALESCE($2, description) WHERE id = $3", m.Title, m.Content, id)
+	dataKey, err := d.lastMessageDataKey(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the message was deleted in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fields, err := sealLastMessageFields(dataKey, m.Title, m.Content, m.EncryptedContent)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(ctx, `UPDATE last_messages SET title_ciphertext = COALESCE($1, title_ciphertext),
+		content_ciphertext = CASE WHEN $3::bytea IS NULL THEN COALESCE($2, content_ciphertext) END,
+		encrypted_content_ciphertext = CASE WHEN $2::bytea IS NULL THEN COALESCE($3, encrypted_content_ciphertext) END,
+		release_mode = COALESCE(NULLIF($4::text, ''), release_mode), share_threshold = COALESCE($5, share_threshold),
+		release_rule = COALESCE(NULLIF($7::text, ''), release_rule),
+		release_delay_days = CASE WHEN $7::text = '' THEN release_delay_days ELSE $8 END,
+		release_at = CASE WHEN $7::text = '' THEN release_at ELSE $9 END
+		WHERE id = $6`, fields.TitleCiphertext, fields.ContentCiphertext, fields.EncryptedContentCiphertext, m.ReleaseMode, m.ShareThreshold, id,
+		m.ReleaseRule, m.ReleaseDelayDays, m.ReleaseAt)
 	return err
 }
 
@@ -94,17 +280,61 @@ func (g *DB) DeleteLastMessageByID(ctx context.Context, id uint) error {
 	return err
 }
 
+// what of a last message decides which edits are allowed, without decrypting it
+type LastMessageSettings struct {
+	ReleaseMode ReleaseMode
+	ReleaseRule ReleaseRule
+	// whether the message is end-to-end encrypted
+	Encrypted bool
+	// whether files are attached to the message
+	HasAttachments bool
+}
+
+// returns pgx.ErrNoRows if there's no such message
+func (d *DB) LastMessageSettingsByID(ctx context.Context, id uint) (settings LastMessageSettings, err error) {
+	err = d.db.QueryRow(ctx, `SELECT release_mode, release_rule, (encrypted_content IS NOT NULL OR encrypted_content_ciphertext IS NOT NULL),
+		EXISTS(SELECT 1 FROM attachments WHERE last_message_id = last_messages.id)
+		FROM last_messages WHERE id = $1`, id).Scan(&settings.ReleaseMode, &settings.ReleaseRule, &settings.Encrypted, &settings.HasAttachments)
+	return settings, err
+}
+
 func (d *DB) LastMessageExistsByID(ctx context.Context, id uint) (exists bool, err error) {
 	err = d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM last_messages WHERE id = $1)", id).Scan(&exists)
 	return exists, err
 }
 
-type lastMessage struct {
-	Title       string
-	Description null.String
+type LastMessageByID struct {
+	LastMessage
+	UserID   uint
+	GroupIDs []uint
+	// the recipients of the message's groups, each once
+	Recipients []Recipient
 }
 
-func (d *DB) LastMessageByID(ctx context.Context, id uint) (lastMessage lastMessage, err error) {
-	err = d.db.QueryRow(ctx, "SELECT title, description FROM last_messages WHERE id = ?", id).Scan(&lastMessage.Title, &lastMessage.Description)
-	return lastMessage, err
+// the message with the IDs of its groups and its recipients. Returns pgx.ErrNoRows if there's no such message.
+func (d *DB) LastMessageByID(ctx context.Context, id uint) (lastMessage LastMessageByID, err error) {
+	var settings LastMessage
+	var m sealedLastMessage
+	var recipientEmails []string
+	if err := d.db.QueryRow(ctx, `SELECT user_id, release_mode, share_threshold, release_rule, release_delay_days, release_at,
+		ARRAY(SELECT group_id FROM group_last_messages WHERE last_message_id = last_messages.id ORDER BY group_id),
+		ARRAY(SELECT DISTINCT recipients.email FROM recipients INNER JOIN group_last_messages ON group_last_messages.group_id = recipients.group_id
+		WHERE group_last_messages.last_message_id = last_messages.id ORDER BY recipients.email),
+		`+sealedLastMessageColumns+` FROM last_messages WHERE id = $1`, id).Scan(append([]any{&lastMessage.UserID, &settings.ReleaseMode, &settings.ShareThreshold,
+		&settings.ReleaseRule, &settings.ReleaseDelayDays, &settings.ReleaseAt, &lastMessage.GroupIDs, &recipientEmails}, m.scanTargets()...)...); err != nil {
+		return LastMessageByID{}, err
+	}
+	if lastMessage.LastMessage, err = d.openLastMessage(id, m); err != nil {
+		return LastMessageByID{}, err
+	}
+	lastMessage.ReleaseMode = settings.ReleaseMode
+	lastMessage.ShareThreshold = settings.ShareThreshold
+	lastMessage.ReleaseRule = settings.ReleaseRule
+	lastMessage.ReleaseDelayDays = settings.ReleaseDelayDays
+	lastMessage.ReleaseAt = settings.ReleaseAt
+	lastMessage.Recipients = lo.Map(recipientEmails, func(item string, _ int) (recipient Recipient) {
+		recipient.Email = item
+		return
+	})
+	return lastMessage, nil
 }
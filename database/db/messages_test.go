@@ -0,0 +1,173 @@
+package db_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/envelope"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Suite) TestEncryptedLastMessage() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	encrypted := &db.EncryptedContent{
+		Ciphertext:  "Y2lwaGVydGV4dA==",
+		Algorithm:   "A256GCM",
+		Metadata:    json.RawMessage(`{"iv":"aXY"}`),
+		KeyFragment: null.StringFrom("a2V5"),
+	}
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "title", EncryptedContent: encrypted}))
+
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(messages, 1)
+	s.False(messages[0].Content.Valid)
+	s.Require().NotNil(messages[0].EncryptedContent)
+	s.Equal(encrypted.Ciphertext, messages[0].EncryptedContent.Ciphertext)
+	s.JSONEq(`{"iv":"aXY"}`, string(messages[0].EncryptedContent.Metadata))
+	s.False(messages[0].EncryptedContent.KeyFragment.Valid, "a key fragment that isn't wrapped is the key itself and shouldn't be stored")
+
+	s.Require().NoError(s.Repo.UpdateLastMessage(s.Ctx, messages[0].ID, db.UpdateLastMessage{Title: null.StringFrom("new title")}))
+	messages, err = s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal("new title", messages[0].Title)
+	s.NotNil(messages[0].EncryptedContent, "updating the title shouldn't touch the encrypted content")
+
+	s.Require().NoError(s.Repo.UpdateLastMessage(s.Ctx, messages[0].ID, db.UpdateLastMessage{Content: null.StringFrom("plain")}))
+	messages, err = s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal(null.StringFrom("plain"), messages[0].Content)
+	s.Nil(messages[0].EncryptedContent, "setting the content should remove the encrypted content")
+}
+
+// the test suite's master key has version 1 and is masterKey(1)
+func masterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, envelope.KeySize)
+}
+
+func (s *Suite) TestLastMessageEncryptionAtRest() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "secret title", Content: null.StringFrom("last words")}))
+	// a message stored before encryption was added
+	_, err = s.DB.Exec(s.Ctx, "INSERT INTO last_messages (user_id, title, content) VALUES ($1, 'old title', 'old words')", userID)
+	s.Require().NoError(err)
+
+	var plaintextRows int
+	s.Require().NoError(s.DB.QueryRow(s.Ctx, "SELECT COUNT(*) FROM last_messages WHERE title IS NOT NULL OR content IS NOT NULL").Scan(&plaintextRows))
+	s.Equal(1, plaintextRows, "new messages shouldn't be stored in plaintext")
+
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(messages, 2)
+	s.ElementsMatch([]string{"secret title", "old title"}, []string{messages[0].Title, messages[1].Title})
+
+	rotatedKeys, err := envelope.NewKeys(map[uint][]byte{1: masterKey(1), 2: masterKey(2)})
+	s.Require().NoError(err)
+	reencrypted, err := db.NewDB(s.DB, rotatedKeys).ReencryptLastMessages(s.Ctx)
+	s.Require().NoError(err)
+	s.Equal(uint(2), reencrypted)
+
+	s.Require().NoError(s.DB.QueryRow(s.Ctx, "SELECT COUNT(*) FROM last_messages WHERE title IS NOT NULL OR content IS NOT NULL").Scan(&plaintextRows))
+	s.Zero(plaintextRows, "re-encrypting should encrypt old messages")
+
+	newKeys, err := envelope.NewKeys(map[uint][]byte{2: masterKey(2)})
+	s.Require().NoError(err)
+	messages, err = db.NewDB(s.DB, newKeys).LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err, "the old master key shouldn't be needed after re-encrypting")
+	s.Require().Len(messages, 2)
+	s.ElementsMatch([]string{"last words", "old words"}, []string{messages[0].Content.String, messages[1].Content.String})
+}
+
+func (s *Suite) TestLastMessageByID() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	var groupIDs []uint
+	for _, emails := range [][]string{{"a@google.com", "b@google.com"}, {"b@google.com"}} {
+		groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{UserID: userID, Name: "group", RecipientEmails: emails})
+		s.Require().NoError(err)
+		groupIDs = append(groupIDs, groupID)
+	}
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "goodbye", Content: null.StringFrom("hello"), GroupIDs: groupIDs,
+		ReleaseRule: db.ReleaseRuleAfterDeath, ReleaseDelayDays: null.Int32From(3)}))
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+
+	got, err := s.Repo.LastMessageByID(s.Ctx, messages[0].ID)
+	s.Require().NoError(err)
+	s.Equal(userID, got.UserID)
+	s.Equal("goodbye", got.Title)
+	s.Equal("hello", got.Content.String)
+	s.Equal(db.ReleaseRuleAfterDeath, got.ReleaseRule)
+	s.Equal(groupIDs, got.GroupIDs)
+	s.Equal([]db.Recipient{{Email: "a@google.com"}, {Email: "b@google.com"}}, got.Recipients, "recipients in several groups should be listed once")
+
+	_, err = s.Repo.LastMessageByID(s.Ctx, messages[0].ID+1)
+	s.ErrorIs(err, pgx.ErrNoRows)
+}
+
+func (s *Suite) TestRemoveRawKeyFragments() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	wrapped := &db.EncryptedContent{
+		Ciphertext:  "Y2lwaGVydGV4dA==",
+		Algorithm:   "A256GCM",
+		Metadata:    json.RawMessage(`{"iv":"aXY","wrap":{"kdf":"PBKDF2","salt":"c2FsdA","iterations":600000}}`),
+		KeyFragment: null.StringFrom("d3JhcHBlZA"),
+	}
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "wrapped", EncryptedContent: wrapped}))
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(messages, 1)
+	s.Equal(wrapped.KeyFragment, messages[0].EncryptedContent.KeyFragment, "wrapped key fragments should be kept")
+
+	removed, err := s.Repo.RemoveRawKeyFragments(s.Ctx)
+	s.Require().NoError(err)
+	s.Zero(removed, "wrapped key fragments shouldn't be removed")
+}
+
+func (s *Suite) TestLastMessageSettingsByID() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "encrypted",
+		EncryptedContent: &db.EncryptedContent{Ciphertext: "Y2lwaGVydGV4dA==", Algorithm: "A256GCM"}}))
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "shared", Content: null.StringFrom("hi"),
+		ReleaseMode: db.ReleaseModeShamir, ShareThreshold: null.Int32From(2)}))
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+
+	settings, err := s.Repo.LastMessageSettingsByID(s.Ctx, messages[0].ID)
+	s.Require().NoError(err)
+	s.Equal(db.LastMessageSettings{ReleaseMode: db.ReleaseModeStandard, ReleaseRule: db.ReleaseRuleOnDeath, Encrypted: true}, settings)
+	settings, err = s.Repo.LastMessageSettingsByID(s.Ctx, messages[1].ID)
+	s.Require().NoError(err)
+	s.Equal(db.LastMessageSettings{ReleaseMode: db.ReleaseModeShamir, ReleaseRule: db.ReleaseRuleOnDeath}, settings)
+
+	_, err = s.Repo.CreateAttachment(s.Ctx, db.CreateAttachment{UserID: userID, LastMessageID: messages[0].ID, Filename: "a.txt",
+		ContentType: "text/plain", Size: 1, BlobKey: "settingskey"}, 1<<20)
+	s.Require().NoError(err)
+	settings, err = s.Repo.LastMessageSettingsByID(s.Ctx, messages[0].ID)
+	s.Require().NoError(err)
+	s.True(settings.HasAttachments)
+
+	_, err = s.Repo.LastMessageSettingsByID(s.Ctx, messages[1].ID+1)
+	s.ErrorIs(err, pgx.ErrNoRows)
+}
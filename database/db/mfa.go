@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/guregu/null/v6"
+)
+
+type TOTP struct {
+	Secret   null.String
+	Enabled  bool
+	LastStep int64
+	// codes aren't accepted until then, because too many wrong ones were tried
+	LockedUntil null.Time
+}
+
+func (d *DB) TOTPByUserID(ctx context.Context, userID uint) (totp TOTP, err error) {
+	err = pgxscan.Get(ctx, d.db, &totp, "SELECT totp_secret AS secret, totp_enabled AS enabled, totp_last_step AS last_step, totp_locked_until AS locked_until FROM users WHERE id = $1", userID)
+	return totp, err
+}
+
+// stores the secret of an enrolment that hasn't been verified yet. set is false if TOTP is already enabled.
+func (d *DB) SetPendingTOTPSecret(ctx context.Context, userID uint, secret string) (set bool, err error) {
+	tag, err := d.db.Exec(ctx, "UPDATE users SET totp_secret = $2 WHERE id = $1 AND NOT totp_enabled", userID, secret)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// enables TOTP once the first code was verified and replaces the user's recovery codes.
+// step is the time step of the verified code. enabled is false if TOTP was already enabled.
+func (d *DB) EnableTOTP(ctx context.Context, userID uint, step int64, recoveryCodeHashes []string) (enabled bool, err error) {
+	err = d.db.QueryRow(ctx, `WITH enabled AS (
+		UPDATE users SET totp_enabled = TRUE, totp_last_step = $2, totp_failed_attempts = 0 WHERE id = $1 AND NOT totp_enabled AND totp_secret IS NOT NULL RETURNING id
+	), deleted AS (
+		DELETE FROM recovery_codes WHERE user_id IN (SELECT id FROM enabled)
+	), inserted AS (
+		INSERT INTO recovery_codes (user_id, code_hash) SELECT enabled.id, code_hash FROM enabled, unnest($3::text[]) AS code_hash
+	)
+	SELECT EXISTS(SELECT 1 FROM enabled)`, userID, step, recoveryCodeHashes).Scan(&enabled)
+	return enabled, err
+}
+
+func (d *DB) DisableTOTP(ctx context.Context, userID uint) error {
+	_, err := d.db.Exec(ctx, `WITH deleted AS (DELETE FROM recovery_codes WHERE user_id = $1)
+	UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0, totp_failed_attempts = 0, totp_locked_until = NULL WHERE id = $1`, userID)
+	return err
+}
+
+// marks the time step of a valid code as used. used is false if a code of that step or a later one was already used,
+// so the code is being replayed.
+func (d *DB) UseTOTPStep(ctx context.Context, userID uint, step int64) (used bool, err error) {
+	tag, err := d.db.Exec(ctx, "UPDATE users SET totp_last_step = $2, totp_failed_attempts = 0 WHERE id = $1 AND totp_last_step < $2", userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// counts a wrong code. Once maxAttempts wrong codes were tried in a row, codes are refused for lockout.
+func (d *DB) RecordTOTPFailure(ctx context.Context, userID uint, maxAttempts uint, lockout time.Duration) error {
+	_, err := d.db.Exec(ctx, `UPDATE users SET
+	totp_failed_attempts = CASE WHEN totp_failed_attempts + 1 >= $2 THEN 0 ELSE totp_failed_attempts + 1 END,
+	totp_locked_until = CASE WHEN totp_failed_attempts + 1 >= $2 THEN now() + make_interval(secs => $3) ELSE totp_locked_until END
+	WHERE id = $1`, userID, maxAttempts, lockout.Seconds())
+	return err
+}
+
+// used is false if the code doesn't exist or was already used
+func (d *DB) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (used bool, err error) {
+	tag, err := d.db.Exec(ctx, "UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (d *DB) UnusedRecoveryCodeCount(ctx context.Context, userID uint) (count uint, err error) {
+	err = d.db.QueryRow(ctx, "SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
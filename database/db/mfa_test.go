@@ -0,0 +1,67 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+)
+
+func (s *Suite) TestTOTP() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username:     "testusername",
+		Email:        "testemail@google.com",
+		PasswordHash: "hash",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+
+	enabled, err := s.Repo.EnableTOTP(s.Ctx, userID, 1, []string{"a"})
+	s.Require().NoError(err)
+	s.False(enabled, "TOTP can't be enabled without a secret")
+
+	set, err := s.Repo.SetPendingTOTPSecret(s.Ctx, userID, "SECRET")
+	s.Require().NoError(err)
+	s.True(set)
+	enabled, err = s.Repo.EnableTOTP(s.Ctx, userID, 10, []string{"a", "b"})
+	s.Require().NoError(err)
+	s.True(enabled)
+	set, err = s.Repo.SetPendingTOTPSecret(s.Ctx, userID, "OTHER")
+	s.Require().NoError(err)
+	s.False(set, "the secret shouldn't be replaced once TOTP is enabled")
+
+	totp, err := s.Repo.TOTPByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal(db.TOTP{Secret: totp.Secret, Enabled: true, LastStep: 10}, totp)
+	s.Equal("SECRET", totp.Secret.String)
+
+	used, err := s.Repo.UseTOTPStep(s.Ctx, userID, 10)
+	s.Require().NoError(err)
+	s.False(used, "a code of the step used to enable TOTP shouldn't be accepted again")
+	used, err = s.Repo.UseTOTPStep(s.Ctx, userID, 11)
+	s.Require().NoError(err)
+	s.True(used)
+
+	used, err = s.Repo.UseRecoveryCode(s.Ctx, userID, "a")
+	s.Require().NoError(err)
+	s.True(used)
+	used, err = s.Repo.UseRecoveryCode(s.Ctx, userID, "a")
+	s.Require().NoError(err)
+	s.False(used, "recovery codes should only work once")
+	count, err := s.Repo.UnusedRecoveryCodeCount(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal(uint(1), count)
+
+	for range 3 {
+		s.Require().NoError(s.Repo.RecordTOTPFailure(s.Ctx, userID, 3, time.Hour))
+	}
+	totp, err = s.Repo.TOTPByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.True(totp.LockedUntil.Valid && totp.LockedUntil.Time.After(time.Now()), "too many wrong codes should lock TOTP")
+
+	s.Require().NoError(s.Repo.DisableTOTP(s.Ctx, userID))
+	totp, err = s.Repo.TOTPByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal(db.TOTP{}, totp)
+	count, err = s.Repo.UnusedRecoveryCodeCount(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Zero(count)
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor = errors.New("the cursor is invalid or belongs to another sort order")
+	ErrInvalidSort   = errors.New("unknown sort order")
+)
+
+// how many rows a page has when ListOptions.Limit isn't set
+const DefaultPageLimit = 50
+
+// how a page of a list is picked. The zero value is the first page, oldest first.
+type ListOptions struct {
+	Limit uint
+	// from the previous page, empty for the first one
+	Cursor string
+	// only rows whose name or title contains it, ignoring case
+	Search string
+	// the field to sort by, e.g. "name", with a - in front for descending order. Ties are broken by ID, which is the
+	// default sort order.
+	Sort string
+}
+
+func (o ListOptions) limit() uint {
+	if o.Limit == 0 {
+		return DefaultPageLimit
+	}
+	return o.Limit
+}
+
+// the field to sort by and whether it's descending. field is one of fields, or "id".
+func (o ListOptions) sortField(fields ...string) (field string, desc bool, err error) {
+	field, desc = strings.CutPrefix(o.Sort, "-")
+	if field == "" {
+		field = "id"
+	}
+	if field != "id" && !slices.Contains(fields, field) {
+		return "", false, ErrInvalidSort
+	}
+	return field, desc, nil
+}
+
+// where the previous page ended: the sort value and the ID of its last row
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	// the sort value, if it can't be in URLs as it is, e.g. because it's an encrypted title
+	Sealed *sealedCursorValue `json:"e,omitempty"`
+	ID     uint               `json:"i"`
+}
+
+// a sort value encrypted with a data key of its own, see DB.sealCursorValue
+type sealedCursorValue struct {
+	WrappedKey []byte `json:"k"`
+	KeyVersion uint   `json:"v"`
+	Ciphertext []byte `json:"c"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// the cursor of the options, or nil for the first page
+func (o ListOptions) cursor() (*cursor, error) {
+	if o.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(o.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != o.Sort {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// cuts rows, which has up to limit+1 rows, down to a page. nextCursor is empty on the last page.
+func page[T any](rows []T, limit uint, sort string, cursorOf func(row T) (value string, id uint)) (page []T, nextCursor string) {
+	if uint(len(rows)) <= limit {
+		return rows, ""
+	}
+	rows = rows[:limit]
+	value, id := cursorOf(rows[len(rows)-1])
+	return rows, cursor{Sort: sort, Value: value, ID: id}.encode()
+}
+
+// escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
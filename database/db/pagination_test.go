@@ -0,0 +1,88 @@
+package db_test
+
+import (
+	"github.com/gragorther/epigo/database/db"
+	"github.com/samber/lo"
+)
+
+func (s *Suite) TestGroupsPage() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	for _, name := range []string{"friends", "Family", "work", "100%"} {
+		_, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{UserID: userID, Name: name})
+		s.Require().NoError(err)
+	}
+	names := func(groups []db.Group) []string {
+		return lo.Map(groups, func(group db.Group, _ int) string { return group.Name })
+	}
+
+	groups, cursor, err := s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 3})
+	s.Require().NoError(err)
+	s.Equal([]string{"friends", "Family", "work"}, names(groups))
+	s.Require().NotEmpty(cursor)
+	groups, cursor, err = s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 3, Cursor: cursor})
+	s.Require().NoError(err)
+	s.Equal([]string{"100%"}, names(groups))
+	s.Empty(cursor, "the last page shouldn't have a next cursor")
+
+	groups, _, err = s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Search: "f"})
+	s.Require().NoError(err)
+	s.Equal([]string{"friends", "Family"}, names(groups), "search should ignore case")
+	groups, _, err = s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Search: "%"})
+	s.Require().NoError(err)
+	s.Equal([]string{"100%"}, names(groups), "wildcards should be matched literally")
+
+	groups, cursor, err = s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 2, Sort: "-id"})
+	s.Require().NoError(err)
+	s.Equal([]string{"100%", "work"}, names(groups))
+	_, _, err = s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 2, Cursor: cursor, Sort: "name"})
+	s.ErrorIs(err, db.ErrInvalidCursor, "cursors should only work with their sort order")
+	_, _, err = s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Sort: "description"})
+	s.ErrorIs(err, db.ErrInvalidSort)
+
+	groups, cursor, err = s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 2, Sort: "-name"})
+	s.Require().NoError(err)
+	s.Equal("work", groups[0].Name)
+	rest, _, err := s.Repo.GroupsPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 2, Cursor: cursor, Sort: "-name"})
+	s.Require().NoError(err)
+	s.Len(append(groups, rest...), 4)
+}
+
+func (s *Suite) TestLastMessagesPage() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	for _, title := range []string{"to Mum", "birthday", "Apology", "to Dad"} {
+		s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: title}))
+	}
+	titles := func(messages []db.LastMessage) []string {
+		return lo.Map(messages, func(m db.LastMessage, _ int) string { return m.Title })
+	}
+
+	messages, cursor, err := s.Repo.LastMessagesPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 2, Sort: "title"})
+	s.Require().NoError(err)
+	s.Equal([]string{"Apology", "birthday"}, titles(messages), "titles should be sorted ignoring case")
+	messages, cursor, err = s.Repo.LastMessagesPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 2, Cursor: cursor, Sort: "title"})
+	s.Require().NoError(err)
+	s.Equal([]string{"to Dad", "to Mum"}, titles(messages))
+	s.Empty(cursor)
+
+	messages, _, err = s.Repo.LastMessagesPageByUserID(s.Ctx, userID, db.ListOptions{Search: "TO ", Sort: "-id"})
+	s.Require().NoError(err)
+	s.Equal([]string{"to Dad", "to Mum"}, titles(messages))
+
+	messages, cursor, err = s.Repo.LastMessagesPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 1, Sort: "title"})
+	s.Require().NoError(err)
+	s.Require().NoError(s.Repo.DeleteLastMessageByID(s.Ctx, messages[0].ID))
+	messages, _, err = s.Repo.LastMessagesPageByUserID(s.Ctx, userID, db.ListOptions{Limit: 1, Cursor: cursor, Sort: "title"})
+	s.Require().NoError(err, "title cursors should keep working when their message is deleted")
+	s.Equal([]string{"birthday"}, titles(messages))
+
+	_, _, err = s.Repo.LastMessagesPageByUserID(s.Ctx, userID+1, db.ListOptions{Limit: 1, Cursor: cursor, Sort: "title"})
+	s.ErrorIs(err, db.ErrInvalidCursor, "title cursors should only work for their user")
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type PasswordResetUser struct {
+	ID           uint
+	Name         string
+	Email        string
+	PasswordHash string
+	Locale       string
+}
+
+// addresses are compared case-insensitively, since accounts from before emails were normalized can have upper case in theirs
+func (d *DB) PasswordResetUserByEmail(ctx context.Context, email string) (user PasswordResetUser, err error) {
+	err = d.db.QueryRow(ctx, "SELECT id, COALESCE(name, username), email, password_hash, locale FROM users WHERE lower(email) = lower($1)", email).
+		Scan(&user.ID, &user.Name, &user.Email, &user.PasswordHash, &user.Locale)
+	return user, err
+}
+
+// records that a password reset email is sent to the address now, unless one was already sent to it in the last
+// minInterval. claimed is false in that case, or if nobody is registered with the address.
+func (d *DB) ClaimPasswordResetEmail(ctx context.Context, email string, minInterval time.Duration) (claimed bool, err error) {
+	tag, err := d.db.Exec(ctx, `UPDATE users SET last_password_reset_email_at = now() WHERE lower(email) = lower($1)
+		AND (last_password_reset_email_at IS NULL OR last_password_reset_email_at <= now() - make_interval(secs => $2))`, email, minInterval.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (d *DB) PasswordHashByUserID(ctx context.Context, userID uint) (passwordHash string, err error) {
+	err = d.db.QueryRow(ctx, "SELECT password_hash FROM users WHERE id = $1", userID).Scan(&passwordHash)
+	return passwordHash, err
+}
+
+// replaces the password hash and logs the user out everywhere. reset is false if the password hash
+// isn't oldPasswordHash anymore, which means the reset token was already used.
+func (d *DB) ResetUserPassword(ctx context.Context, userID uint, oldPasswordHash string, newPasswordHash string) (reset bool, err error) {
+	err = d.db.QueryRow(ctx, `WITH updated AS (
+		UPDATE users SET password_hash = $1, auth_valid_after = now() WHERE id = $2 AND password_hash = $3 RETURNING id
+	), revoked AS (
+		UPDATE sessions SET revoked_at = now() WHERE user_id IN (SELECT id FROM updated) AND revoked_at IS NULL
+	)
+	SELECT EXISTS(SELECT 1 FROM updated)`, newPasswordHash, userID, oldPasswordHash).Scan(&reset)
+	return reset, err
+}
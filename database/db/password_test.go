@@ -0,0 +1,38 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+)
+
+func (s *Suite) TestResetUserPassword() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username:     "testusername",
+		Email:        "testemail@google.com",
+		PasswordHash: "old",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+
+	sessionID, err := s.Repo.CreateSession(s.Ctx, db.CreateSession{UserID: userID, RefreshTokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)})
+	s.Require().NoError(err)
+
+	user, err := s.Repo.PasswordResetUserByEmail(s.Ctx, "testemail@google.com")
+	s.Require().NoError(err)
+	s.Equal(userID, user.ID)
+	s.Equal("testusername", user.Name, "the username should be used when the user has no name")
+
+	reset, err := s.Repo.ResetUserPassword(s.Ctx, userID, "old", "new")
+	s.Require().NoError(err)
+	s.True(reset)
+	reset, err = s.Repo.ResetUserPassword(s.Ctx, userID, "old", "newer")
+	s.Require().NoError(err)
+	s.False(reset, "the password shouldn't be reset twice with the same token")
+
+	passwordHash, err := s.Repo.PasswordHashByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal("new", passwordHash)
+	active, err := s.Repo.SessionActive(s.Ctx, sessionID, userID, time.Now())
+	s.Require().NoError(err)
+	s.False(active, "resetting the password should log the user out everywhere")
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+
+	_ "embed"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+type PreviewRecipient struct {
+	Email  string
+	Locale string
+}
+
+// a last message with everything needed to show its owner the death emails it would be sent as
+type LastMessagePreview struct {
+	LastMessage LastMessage
+	// the name the emails are signed with
+	UserName   string
+	UserEmail  string
+	UserLocale string
+	// who would get the message if the user died now
+	Recipients []PreviewRecipient
+}
+
+//go:embed queries/preview_recipients.sql
+var previewRecipientsQuery string
+
+func (d *DB) LastMessagePreview(ctx context.Context, lastMessageID uint) (preview LastMessagePreview, err error) {
+	var m sealedLastMessage
+	var lastMessage LastMessage
+	if err := d.db.QueryRow(ctx, `SELECT COALESCE(users.name, users.username), users.email, users.locale, last_messages.release_mode,
+		last_messages.share_threshold, `+sealedLastMessageColumns+` FROM last_messages INNER JOIN users ON users.id = last_messages.user_id
+		WHERE last_messages.id = $1`, lastMessageID).Scan(append([]any{&preview.UserName, &preview.UserEmail, &preview.UserLocale,
+		&lastMessage.ReleaseMode, &lastMessage.ShareThreshold}, m.scanTargets()...)...); err != nil {
+		return LastMessagePreview{}, err
+	}
+	if preview.LastMessage, err = d.openLastMessage(lastMessageID, m); err != nil {
+		return LastMessagePreview{}, err
+	}
+	preview.LastMessage.ReleaseMode = lastMessage.ReleaseMode
+	preview.LastMessage.ShareThreshold = lastMessage.ShareThreshold
+	if err := pgxscan.Select(ctx, d.db, &preview.Recipients, previewRecipientsQuery, lastMessageID); err != nil {
+		return LastMessagePreview{}, err
+	}
+	return preview, nil
+}
@@ -0,0 +1,39 @@
+package db_test
+
+import (
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+)
+
+func (s *Suite) TestLastMessagePreview() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+		UserID:          userID,
+		Name:            "family",
+		RecipientEmails: []string{"recipient@google.com", "declined@google.com"},
+		Locale:          null.StringFrom("de"),
+	})
+	s.Require().NoError(err)
+	recipients, err := s.Repo.RecipientsByGroupID(s.Ctx, groupID)
+	s.Require().NoError(err)
+	for _, recipient := range recipients {
+		if recipient.Email == "declined@google.com" {
+			s.Require().NoError(s.Repo.SetRecipientStatus(s.Ctx, recipient.ID, db.RecipientStatusDeclined))
+		}
+	}
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "goodbye", Content: null.StringFrom("hello"), GroupIDs: []uint{groupID}}))
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+
+	preview, err := s.Repo.LastMessagePreview(s.Ctx, messages[0].ID)
+	s.Require().NoError(err)
+	s.Equal("testusername", preview.UserName, "users without a name should sign with their username")
+	s.Equal("testemail@google.com", preview.UserEmail)
+	s.Equal("goodbye", preview.LastMessage.Title)
+	s.Equal("hello", preview.LastMessage.Content.String)
+	s.Equal([]db.PreviewRecipient{{Email: "recipient@google.com", Locale: "de"}}, preview.Recipients, "declined recipients don't get the message")
+}
@@ -0,0 +1,13 @@
+WITH u AS (
+  UPDATE users
+  SET sent_emails = 0,
+    last_confirmed_at = now(),
+    status = CASE WHEN status = 'released' THEN status ELSE 'alive' END,
+    release_at = CASE WHEN status = 'released' THEN release_at ELSE NULL END
+  WHERE id = $1
+  RETURNING id, last_confirmed_at
+)
+INSERT INTO check_ins (user_id, source, created_at)
+SELECT u.id, $2, u.last_confirmed_at
+FROM u
+RETURNING created_at;
@@ -0,0 +1,21 @@
+INSERT INTO deliveries (user_id, last_message_id, recipient_email, locale, send_at)
+SELECT DISTINCT ON (last_messages.id, recipients.email)
+  last_messages.user_id, last_messages.id, recipients.email, COALESCE(groups.locale, users.locale),
+  CASE last_messages.release_rule
+    WHEN 'after_death' THEN now() + make_interval(days => last_messages.release_delay_days)
+    -- dates that passed before the user died go out right away
+    WHEN 'on_date' THEN GREATEST(now(), last_messages.release_at)
+    WHEN 'yearly' THEN next_anniversary(last_messages.release_at, now())
+    ELSE now()
+  END
+FROM last_messages
+INNER JOIN users ON users.id = last_messages.user_id
+INNER JOIN group_last_messages ON group_last_messages.last_message_id = last_messages.id
+INNER JOIN groups ON groups.id = group_last_messages.group_id
+INNER JOIN recipients ON recipients.group_id = group_last_messages.group_id
+WHERE last_messages.user_id = $1
+  AND recipients.status NOT IN ('declined', 'bounced')
+  AND (NOT groups.require_recipient_opt_in OR recipients.status = 'confirmed')
+-- when a recipient is in several groups with the message, the oldest group's locale wins
+ORDER BY last_messages.id, recipients.email, groups.id
+ON CONFLICT (last_message_id, recipient_email, occurrence) DO NOTHING
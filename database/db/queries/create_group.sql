@@ -1,2 +1,4 @@
-WITH g AS (INSERT INTO groups (name, description, user_id) VALUES ($1, $2, $3) RETURNING id)
-		INSERT INTO group_last_messages (group_id, last_message_id) SELECT g.id, UNNEST($4::int[]) FROM g
+WITH g AS (INSERT INTO groups (name, description, user_id, locale) VALUES ($1, $2, $3, $6) RETURNING id),
+m AS (INSERT INTO group_last_messages (group_id, last_message_id) SELECT g.id, UNNEST($4::int[]) FROM g)
+INSERT INTO recipients (group_id, email) SELECT g.id, UNNEST($5::text[]) FROM g
+ON CONFLICT (group_id, email) DO NOTHING
@@ -1,6 +1,6 @@
 WITH g AS (
-  INSERT INTO groups (name, description, user_id)
-    VALUES ($1, $2, $3)
+  INSERT INTO groups (name, description, user_id, locale)
+    VALUES ($1, $2, $3, $6)
   RETURNING id
 ),
 ins AS (
@@ -8,6 +8,12 @@ ins AS (
   SELECT g.id, UNNEST($4::int[])
   FROM g
   RETURNING group_id
+),
+r AS (
+  INSERT INTO recipients (group_id, email)
+  SELECT g.id, UNNEST($5::text[])
+  FROM g
+  ON CONFLICT (group_id, email) DO NOTHING
 )
 SELECT id AS group_id
 FROM g;
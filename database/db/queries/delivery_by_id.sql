@@ -0,0 +1,24 @@
+-- the last message columns are the ones sealedLastMessage.scanTargets expects
+SELECT deliveries.status,
+deliveries.recipient_email,
+deliveries.locale,
+COALESCE(users.name, users.username),
+last_messages.release_mode,
+last_messages.release_rule,
+deliveries.key_share,
+last_messages.shared_ciphertext,
+last_messages.share_threshold,
+last_messages.share_count,
+last_messages.id,
+last_messages.title,
+last_messages.content,
+last_messages.encrypted_content,
+last_messages.title_ciphertext,
+last_messages.content_ciphertext,
+last_messages.encrypted_content_ciphertext,
+last_messages.data_key,
+last_messages.key_version
+FROM deliveries
+INNER JOIN last_messages ON last_messages.id = deliveries.last_message_id
+INNER JOIN users ON users.id = deliveries.user_id
+WHERE deliveries.id = $1
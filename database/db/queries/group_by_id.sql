@@ -1,2 +1,3 @@
-SELECT groups.name, groups.description, groups.user_id, ARRAY_AGG(group_last_messages.last_message_id) FILTER (WHERE group_last_messages.last_message_id IS NOT NULL) FROM groups LEFT JOIN
+SELECT groups.id, groups.name, groups.description, groups.user_id, groups.require_recipient_opt_in, groups.locale,
+ARRAY_AGG(group_last_messages.last_message_id ORDER BY group_last_messages.last_message_id) FILTER (WHERE group_last_messages.last_message_id IS NOT NULL) FROM groups LEFT JOIN
 group_last_messages ON groups.id = group_last_messages.group_id WHERE groups.id = $1 GROUP BY groups.id
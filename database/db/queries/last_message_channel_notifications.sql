@@ -0,0 +1,16 @@
+-- every on_death last message of the user with every channel of one of its groups, in the locale of the oldest such
+-- group. Like the emails, channels only get messages that went out to at least one recipient of their group, so
+-- recipients that didn't opt in or declined don't count. Email channels have to be confirmed and not unsubscribed.
+SELECT DISTINCT ON (notification_channels.id, last_messages.id)
+  notification_channels.id AS channel_id, last_messages.id AS last_message_id, COALESCE(groups.locale, users.locale) AS locale
+FROM notification_channels
+INNER JOIN users ON users.id = notification_channels.user_id
+INNER JOIN notification_channel_groups ON notification_channel_groups.notification_channel_id = notification_channels.id
+INNER JOIN groups ON groups.id = notification_channel_groups.group_id
+INNER JOIN group_last_messages ON group_last_messages.group_id = groups.id
+INNER JOIN last_messages ON last_messages.id = group_last_messages.last_message_id
+WHERE notification_channels.user_id = $1 AND notification_channels.last_messages AND last_messages.release_rule = 'on_death'
+  AND notification_channels.confirmed_at IS NOT NULL AND notification_channels.unsubscribed_at IS NULL
+  AND EXISTS (SELECT 1 FROM deliveries INNER JOIN recipients ON recipients.email = deliveries.recipient_email AND recipients.group_id = groups.id
+    WHERE deliveries.last_message_id = last_messages.id)
+ORDER BY notification_channels.id, last_messages.id, groups.id
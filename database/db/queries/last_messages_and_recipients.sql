@@ -1,8 +1,19 @@
-SELECT last_messages.title,
+-- the last message columns are the ones sealedLastMessage.scanTargets expects
+SELECT last_messages.id,
+last_messages.title,
 last_messages.content,
+last_messages.encrypted_content,
+last_messages.title_ciphertext,
+last_messages.content_ciphertext,
+last_messages.encrypted_content_ciphertext,
+last_messages.data_key,
+last_messages.key_version,
 ARRAY_AGG(DISTINCT recipients.email)
 FROM last_messages
 INNER JOIN group_last_messages ON group_last_messages.last_message_id = last_messages.id
+INNER JOIN groups ON groups.id = group_last_messages.group_id
 INNER JOIN recipients ON recipients.group_id = group_last_messages.group_id
 WHERE last_messages.user_id = $1
+  AND recipients.status NOT IN ('declined', 'bounced')
+  AND (NOT groups.require_recipient_opt_in OR recipients.status = 'confirmed')
 GROUP BY last_messages.id
@@ -0,0 +1,16 @@
+-- the delivery of a yearly message for the next year, as long as the recipient still gets the message. If it already
+-- exists, it's returned as it is, so this can be retried.
+INSERT INTO deliveries (user_id, last_message_id, recipient_email, locale, occurrence, send_at)
+SELECT previous.user_id, previous.last_message_id, previous.recipient_email, previous.locale, previous.occurrence + 1,
+  next_anniversary(last_messages.release_at, previous.send_at + INTERVAL '1 day')
+FROM deliveries AS previous
+INNER JOIN last_messages ON last_messages.id = previous.last_message_id
+WHERE previous.id = $1
+  AND last_messages.release_rule = 'yearly'
+  AND EXISTS (SELECT 1 FROM group_last_messages
+    INNER JOIN recipients ON recipients.group_id = group_last_messages.group_id
+    WHERE group_last_messages.last_message_id = previous.last_message_id
+      AND recipients.email = previous.recipient_email
+      AND recipients.status NOT IN ('declined', 'bounced'))
+ON CONFLICT (last_message_id, recipient_email, occurrence) DO UPDATE SET send_at = deliveries.send_at
+RETURNING id, send_at, status
@@ -0,0 +1,10 @@
+-- the recipients create_deliveries.sql would send the message to if the user died now, with the same locales
+SELECT DISTINCT ON (recipients.email) recipients.email, COALESCE(groups.locale, users.locale) AS locale
+FROM group_last_messages
+INNER JOIN groups ON groups.id = group_last_messages.group_id
+INNER JOIN users ON users.id = groups.user_id
+INNER JOIN recipients ON recipients.group_id = group_last_messages.group_id
+WHERE group_last_messages.last_message_id = $1
+  AND recipients.status NOT IN ('declined', 'bounced')
+  AND (NOT groups.require_recipient_opt_in OR recipients.status = 'confirmed')
+ORDER BY recipients.email, groups.id
@@ -0,0 +1,8 @@
+UPDATE trusted_contacts
+SET decision = $3, decided_at = now()
+FROM users
+WHERE trusted_contacts.id = $1
+  AND trusted_contacts.user_id = $2
+  AND users.id = trusted_contacts.user_id
+  AND users.status = 'awaiting_confirmation'
+RETURNING trusted_contacts.id;
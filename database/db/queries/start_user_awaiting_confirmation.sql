@@ -0,0 +1,18 @@
+WITH u AS (
+  UPDATE users
+  SET status = 'awaiting_confirmation',
+    release_at = CASE
+      WHEN old.status = 'awaiting_confirmation' THEN old.release_at
+      ELSE now() + old.trusted_contact_timeout_seconds * INTERVAL '1 second'
+    END
+  FROM users AS old
+  WHERE users.id = $1 AND old.id = users.id AND old.status IN ('pending_release', 'awaiting_confirmation')
+  RETURNING users.id, users.release_at, old.status AS old_status
+),
+reset AS (
+  UPDATE trusted_contacts
+  SET decision = NULL, decided_at = NULL
+  FROM u
+  WHERE trusted_contacts.user_id = u.id AND u.old_status = 'pending_release'
+)
+SELECT release_at FROM u;
@@ -0,0 +1,8 @@
+UPDATE users
+SET status = 'pending_release',
+  release_at = CASE
+    WHEN status = 'pending_release' THEN release_at
+    ELSE now() + grace_period_seconds * INTERVAL '1 second'
+  END
+WHERE id = $1 AND status IN ('alive', 'pending_release')
+RETURNING release_at;
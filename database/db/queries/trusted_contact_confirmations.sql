@@ -0,0 +1,8 @@
+SELECT
+  COUNT(trusted_contacts.id) FILTER (WHERE trusted_contacts.decision = 'confirmed'),
+  COUNT(trusted_contacts.id),
+  users.trusted_contact_quorum
+FROM users
+LEFT JOIN trusted_contacts ON trusted_contacts.user_id = users.id
+WHERE users.id = $1
+GROUP BY users.id;
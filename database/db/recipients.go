@@ -2,7 +2,10 @@ package db
 
 import (
 	"context"
+	"time"
 
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/guregu/null/v6"
 	"github.com/samber/lo"
 )
 
@@ -20,3 +23,80 @@ func (d *DB) RecipientExistsByID(ctx context.Context, id uint) (exists bool, err
 	err = d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM recipients WHERE id = $1)", id).Scan(&exists)
 	return exists, err
 }
+
+type RecipientStatus string
+
+const (
+	// the recipient hasn't responded to the notice that they were named, or hasn't been sent one
+	RecipientStatusPending   RecipientStatus = "pending"
+	RecipientStatusConfirmed RecipientStatus = "confirmed"
+	// the recipient unsubscribed, they never get any last messages
+	RecipientStatusDeclined RecipientStatus = "declined"
+	RecipientStatusBounced  RecipientStatus = "bounced"
+)
+
+type GroupRecipient struct {
+	ID     uint            `json:"id"`
+	Email  string          `json:"email"`
+	Status RecipientStatus `json:"status"`
+	// when the recipient was last sent a notice
+	NotifiedAt null.Time `json:"notifiedAt"`
+}
+
+// emails that are already recipients of the group are skipped
+func (d *DB) CreateRecipients(ctx context.Context, groupID uint, emails []string) error {
+	_, err := d.db.Exec(ctx, "INSERT INTO recipients (group_id, email) SELECT $1, UNNEST($2::text[]) ON CONFLICT (group_id, email) DO NOTHING", groupID, emails)
+	return err
+}
+
+func (d *DB) RecipientsByGroupID(ctx context.Context, groupID uint) (recipients []GroupRecipient, err error) {
+	if err := pgxscan.Select(ctx, d.db, &recipients, "SELECT id, email, status, notified_at FROM recipients WHERE group_id = $1 ORDER BY id", groupID); err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// records that the pending recipients of the group are sent a notice now and returns them. Recipients who were already
+// sent one in the last minInterval are left out.
+func (d *DB) ClaimRecipientNotices(ctx context.Context, groupID uint, minInterval time.Duration) (recipients []GroupRecipient, err error) {
+	if err := pgxscan.Select(ctx, d.db, &recipients, `UPDATE recipients SET notified_at = now() WHERE group_id = $1 AND status = $2
+		AND (notified_at IS NULL OR notified_at <= now() - make_interval(secs => $3)) RETURNING id, email, status, notified_at`,
+		groupID, RecipientStatusPending, minInterval.Seconds()); err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// does nothing if the new email is already a recipient of the same group. The recipient has to opt in again.
+func (d *DB) UpdateRecipientEmail(ctx context.Context, id uint, email string) error {
+	_, err := d.db.Exec(ctx, `UPDATE recipients SET email = $1, status = 'pending' WHERE id = $2
+		AND NOT EXISTS(SELECT 1 FROM recipients r WHERE r.group_id = recipients.group_id AND r.email = $1)`, email, id)
+	return err
+}
+
+func (d *DB) DeleteRecipientByID(ctx context.Context, id uint) error {
+	_, err := d.db.Exec(ctx, "DELETE FROM recipients WHERE id = $1", id)
+	return err
+}
+
+// checks that the recipient belongs to the group and that the group belongs to the user
+func (d *DB) UserAuthorizationForRecipient(ctx context.Context, recipientID uint, groupID uint, userID uint) (authorized bool, err error) {
+	err = d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM recipients INNER JOIN groups ON groups.id = recipients.group_id WHERE recipients.id = $1 AND groups.id = $2 AND groups.user_id = $3)",
+		recipientID, groupID, userID).Scan(&authorized)
+	return authorized, err
+}
+
+func (d *DB) SetRecipientStatus(ctx context.Context, id uint, status RecipientStatus) error {
+	_, err := d.db.Exec(ctx, "UPDATE recipients SET status = $1 WHERE id = $2", status, id)
+	return err
+}
+
+// only sets the status if the recipient still has the given email, so a response to a notice sent to an old address
+// doesn't count for the new one. updated is false if the recipient is gone or their email changed.
+func (d *DB) SetRecipientStatusByEmail(ctx context.Context, id uint, email string, status RecipientStatus) (updated bool, err error) {
+	tag, err := d.db.Exec(ctx, "UPDATE recipients SET status = $1 WHERE id = $2 AND email = $3", status, id, email)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
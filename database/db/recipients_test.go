@@ -0,0 +1,95 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+)
+
+func (s *Suite) TestRecipients() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+		UserID:          userID,
+		Name:            "testname",
+		RecipientEmails: []string{"first@google.com"},
+	})
+	s.Require().NoError(err, "creating test group shouldn't fail")
+
+	s.Require().NoError(s.Repo.CreateRecipients(s.Ctx, groupID, []string{"first@google.com", "second@google.com"}))
+	recipients, err := s.Repo.RecipientsByGroupID(s.Ctx, groupID)
+	s.Require().NoError(err)
+	s.Require().Len(recipients, 2, "duplicate recipients shouldn't be added")
+	s.Equal("first@google.com", recipients[0].Email)
+	s.Equal("second@google.com", recipients[1].Email)
+
+	authorized, err := s.Repo.UserAuthorizationForRecipient(s.Ctx, recipients[0].ID, groupID, userID)
+	s.Require().NoError(err)
+	s.True(authorized)
+	authorized, err = s.Repo.UserAuthorizationForRecipient(s.Ctx, recipients[0].ID, groupID, userID+1)
+	s.Require().NoError(err)
+	s.False(authorized, "other users shouldn't be authorized for the recipient")
+
+	s.Require().NoError(s.Repo.SetRecipientStatus(s.Ctx, recipients[1].ID, db.RecipientStatusConfirmed))
+	notified, err := s.Repo.ClaimRecipientNotices(s.Ctx, groupID, time.Hour)
+	s.Require().NoError(err)
+	s.Require().Len(notified, 1, "only pending recipients should be notified")
+	s.Equal(recipients[0].ID, notified[0].ID)
+	s.True(notified[0].NotifiedAt.Valid)
+	notified, err = s.Repo.ClaimRecipientNotices(s.Ctx, groupID, time.Hour)
+	s.Require().NoError(err)
+	s.Empty(notified, "recipients shouldn't be notified again within the interval")
+
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{
+		UserID:   userID,
+		Title:    "title",
+		Content:  null.StringFrom("content"),
+		GroupIDs: []uint{groupID},
+	}))
+	lastMessages, err := s.Repo.LastMessagesAndRecipients(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(lastMessages, 1)
+	s.ElementsMatch([]string{"first@google.com", "second@google.com"}, db.RecipientsToStringArray(lastMessages[0].Recipients))
+
+	s.Require().NoError(s.Repo.DeleteRecipientByID(s.Ctx, recipients[1].ID))
+	recipients, err = s.Repo.RecipientsByGroupID(s.Ctx, groupID)
+	s.Require().NoError(err)
+	s.Len(recipients, 1)
+}
+
+func (s *Suite) TestLastMessagesAndRecipientsSkipsDeclined() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+		UserID:          userID,
+		Name:            "testname",
+		RecipientEmails: []string{"declined@google.com", "pending@google.com"},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "title", GroupIDs: []uint{groupID}}))
+
+	recipients, err := s.Repo.RecipientsByGroupID(s.Ctx, groupID)
+	s.Require().NoError(err)
+	s.Require().Len(recipients, 2)
+	s.Require().NoError(s.Repo.SetRecipientStatus(s.Ctx, recipients[0].ID, db.RecipientStatusDeclined))
+	updated, err := s.Repo.SetRecipientStatusByEmail(s.Ctx, recipients[1].ID, "old@google.com", db.RecipientStatusConfirmed)
+	s.Require().NoError(err)
+	s.False(updated, "a response for another address shouldn't change the recipient's status")
+
+	lastMessages, err := s.Repo.LastMessagesAndRecipients(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(lastMessages, 1)
+	s.Equal([]string{"pending@google.com"}, db.RecipientsToStringArray(lastMessages[0].Recipients))
+
+	s.Require().NoError(s.Repo.UpdateGroup(s.Ctx, groupID, db.UpdateGroup{RequireRecipientOptIn: null.BoolFrom(true)}))
+	lastMessages, err = s.Repo.LastMessagesAndRecipients(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Empty(lastMessages, "only confirmed recipients should get messages from groups that require opt-in")
+}
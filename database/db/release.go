@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	_ "embed"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+// when a last message is sent once the user died
+type ReleaseRule string
+
+const (
+	// right away
+	ReleaseRuleOnDeath ReleaseRule = "on_death"
+	// ReleaseDelayDays after the death
+	ReleaseRuleAfterDeath ReleaseRule = "after_death"
+	// at ReleaseAt, or right away if that was before the death
+	ReleaseRuleOnDate ReleaseRule = "on_date"
+	// every year on the anniversary of ReleaseAt, starting with the first one after the death
+	ReleaseRuleYearly ReleaseRule = "yearly"
+)
+
+// a delivery waiting to be sent at SendAt
+type QueuedDelivery struct {
+	ID     uint
+	SendAt time.Time
+}
+
+// queued deliveries of any user that should have been sent already, with IDs above afterID, ordered by ID
+func (d *DB) OverdueQueuedDeliveries(ctx context.Context, afterID uint, limit uint) (deliveries []QueuedDelivery, err error) {
+	if err := pgxscan.Select(ctx, d.db, &deliveries, "SELECT id, send_at FROM deliveries WHERE status = $1 AND send_at <= now() AND id > $2 ORDER BY id LIMIT $3",
+		DeliveryStatusQueued, afterID, limit); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (d *DB) QueuedDeliveriesByUserID(ctx context.Context, userID uint) (deliveries []QueuedDelivery, err error) {
+	if err := pgxscan.Select(ctx, d.db, &deliveries, "SELECT id, send_at FROM deliveries WHERE user_id = $1 AND status = $2 ORDER BY id", userID, DeliveryStatusQueued); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+//go:embed queries/next_yearly_delivery.sql
+var nextYearlyDeliveryQuery string
+
+// creates the delivery for the next year after a delivery of a yearly message went out or failed for good. queued is
+// false when there's nothing to send: the message isn't yearly, the recipient no longer gets it, or the next delivery
+// was already sent.
+func (d *DB) NextYearlyDelivery(ctx context.Context, deliveryID uint) (next QueuedDelivery, queued bool, err error) {
+	var status DeliveryStatus
+	err = d.db.QueryRow(ctx, nextYearlyDeliveryQuery, deliveryID).Scan(&next.ID, &next.SendAt, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueuedDelivery{}, false, nil
+	}
+	if err != nil {
+		return QueuedDelivery{}, false, err
+	}
+	return next, status == DeliveryStatusQueued, nil
+}
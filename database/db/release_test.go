@@ -0,0 +1,72 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+)
+
+func (s *Suite) TestReleaseRules() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+		UserID:          userID,
+		Name:            "family",
+		RecipientEmails: []string{"recipient@google.com"},
+	})
+	s.Require().NoError(err)
+
+	birthday := time.Date(1990, time.March, 14, 9, 0, 0, 0, time.UTC)
+	future := time.Now().Add(30 * 24 * time.Hour)
+	for _, message := range []db.CreateLastMessage{
+		{Title: "goodbye"},
+		{Title: "a week later", ReleaseRule: db.ReleaseRuleAfterDeath, ReleaseDelayDays: null.Int32From(7)},
+		{Title: "graduation", ReleaseRule: db.ReleaseRuleOnDate, ReleaseAt: null.TimeFrom(future)},
+		{Title: "happy birthday", ReleaseRule: db.ReleaseRuleYearly, ReleaseAt: null.TimeFrom(birthday)},
+	} {
+		message.UserID = userID
+		message.GroupIDs = []uint{groupID}
+		s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, message))
+	}
+	s.Error(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "no delay", ReleaseRule: db.ReleaseRuleAfterDeath}),
+		"the after_death rule should need a delay")
+
+	s.Require().NoError(s.Repo.CreateDeliveries(s.Ctx, userID))
+	deliveries, err := s.Repo.QueuedDeliveriesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(deliveries, 4)
+	now := time.Now()
+	s.WithinDuration(now, deliveries[0].SendAt, time.Minute)
+	s.WithinDuration(now.Add(7*24*time.Hour), deliveries[1].SendAt, time.Minute)
+	s.WithinDuration(future, deliveries[2].SendAt, time.Second)
+	yearly := deliveries[3]
+	s.True(yearly.SendAt.After(now))
+	s.Equal(birthday.Month(), yearly.SendAt.UTC().Month())
+	s.Equal(birthday.Day(), yearly.SendAt.UTC().Day())
+
+	overdue, err := s.Repo.OverdueQueuedDeliveries(s.Ctx, 0, 10)
+	s.Require().NoError(err)
+	s.Require().Len(overdue, 1, "only the on_death delivery should be due already")
+	s.Equal(deliveries[0].ID, overdue[0].ID)
+	overdue, err = s.Repo.OverdueQueuedDeliveries(s.Ctx, deliveries[0].ID, 10)
+	s.Require().NoError(err)
+	s.Empty(overdue)
+
+	_, queued, err := s.Repo.NextYearlyDelivery(s.Ctx, deliveries[0].ID)
+	s.Require().NoError(err)
+	s.False(queued, "one-off messages shouldn't be sent again")
+
+	s.Require().NoError(s.Repo.MarkDeliverySent(s.Ctx, yearly.ID, "<id@example.com>"))
+	next, queued, err := s.Repo.NextYearlyDelivery(s.Ctx, yearly.ID)
+	s.Require().NoError(err)
+	s.Require().True(queued)
+	s.Equal(yearly.SendAt.UTC().AddDate(1, 0, 0), next.SendAt.UTC())
+	again, queued, err := s.Repo.NextYearlyDelivery(s.Ctx, yearly.ID)
+	s.Require().NoError(err)
+	s.True(queued)
+	s.Equal(next.ID, again.ID, "scheduling the next year twice should reuse the delivery")
+}
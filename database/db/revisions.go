@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrRevisionNotRestorable = errors.New("end-to-end encrypted revisions can't be restored to messages in the shamir release mode")
+
+// a version of the title and content of a last message. Revisions are recorded by a trigger whenever a message is
+// created or its title or content change.
+type LastMessageRevision struct {
+	ID               uint              `json:"id"`
+	Title            string            `json:"title"`
+	Content          null.String       `json:"content"`
+	EncryptedContent *EncryptedContent `json:"encryptedContent"`
+	CreatedAt        time.Time         `json:"createdAt"`
+}
+
+// newest first
+func (d *DB) LastMessageRevisions(ctx context.Context, lastMessageID uint) (revisions []LastMessageRevision, err error) {
+	dataKey, err := d.lastMessageDataKey(ctx, lastMessageID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query(ctx, `SELECT id, title_ciphertext, content_ciphertext, encrypted_content_ciphertext, created_at
+		FROM last_message_revisions WHERE last_message_id = $1 ORDER BY id DESC`, lastMessageID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (LastMessageRevision, error) {
+		var revision LastMessageRevision
+		var fields sealedFields
+		if err := row.Scan(&revision.ID, &fields.TitleCiphertext, &fields.ContentCiphertext, &fields.EncryptedContentCiphertext, &revision.CreatedAt); err != nil {
+			return LastMessageRevision{}, err
+		}
+		lastMessage, err := openLastMessageFields(lastMessageID, dataKey, fields)
+		if err != nil {
+			return LastMessageRevision{}, err
+		}
+		revision.Title = lastMessage.Title
+		revision.Content = lastMessage.Content
+		revision.EncryptedContent = lastMessage.EncryptedContent
+		return revision, nil
+	})
+}
+
+// sets the title and content of the message back to those of the revision, which records a new revision. Returns
+// pgx.ErrNoRows if the message has no such revision.
+func (d *DB) RestoreLastMessageRevision(ctx context.Context, lastMessageID uint, revisionID uint) error {
+	// the revisions are encrypted with the message's data key, so the ciphertexts can be copied over as they are
+	tag, err := d.db.Exec(ctx, `UPDATE last_messages SET title_ciphertext = r.title_ciphertext, content_ciphertext = r.content_ciphertext,
+		encrypted_content_ciphertext = r.encrypted_content_ciphertext FROM last_message_revisions AS r
+		WHERE last_messages.id = $1 AND r.id = $2 AND r.last_message_id = last_messages.id
+		AND (last_messages.release_mode = $3 OR r.encrypted_content_ciphertext IS NULL)`, lastMessageID, revisionID, ReleaseModeStandard)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+	var exists bool
+	if err := d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM last_message_revisions WHERE id = $1 AND last_message_id = $2)", revisionID, lastMessageID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return pgx.ErrNoRows
+	}
+	return ErrRevisionNotRestorable
+}
@@ -0,0 +1,41 @@
+package db_test
+
+import (
+	"errors"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Suite) TestLastMessageRevisions() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{UserID: userID, Title: "draft", Content: null.StringFrom("first draft")}))
+	messages, err := s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	messageID := messages[0].ID
+
+	s.Require().NoError(s.Repo.UpdateLastMessage(s.Ctx, messageID, db.UpdateLastMessage{Content: null.StringFrom("oops")}))
+	s.Require().NoError(s.Repo.UpdateLastMessage(s.Ctx, messageID, db.UpdateLastMessage{ReleaseRule: db.ReleaseRuleAfterDeath, ReleaseDelayDays: null.Int32From(3)}))
+	revisions, err := s.Repo.LastMessageRevisions(s.Ctx, messageID)
+	s.Require().NoError(err)
+	s.Require().Len(revisions, 2, "only changes to the title and content should be recorded")
+	s.Equal("oops", revisions[0].Content.String)
+	s.Equal("draft", revisions[1].Title)
+	s.Equal("first draft", revisions[1].Content.String)
+
+	s.Require().NoError(s.Repo.RestoreLastMessageRevision(s.Ctx, messageID, revisions[1].ID))
+	messages, err = s.Repo.LastMessagesByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal("first draft", messages[0].Content.String)
+	revisions, err = s.Repo.LastMessageRevisions(s.Ctx, messageID)
+	s.Require().NoError(err)
+	s.Len(revisions, 3, "restoring should record a revision")
+
+	err = s.Repo.RestoreLastMessageRevision(s.Ctx, messageID+1, revisions[0].ID)
+	s.True(errors.Is(err, pgx.ErrNoRows), "revisions of other messages shouldn't be restorable")
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+// a refresh token that was already replaced was used again, so someone else has a copy of it.
+// The session is revoked when this happens.
+var ErrRefreshTokenReused = errors.New("refresh token was reused")
+
+type CreateSession struct {
+	UserID           uint
+	RefreshTokenHash string
+	UserAgent        string
+	IPAddress        string
+	ExpiresAt        time.Time
+}
+
+func (d *DB) CreateSession(ctx context.Context, session CreateSession) (id uint, err error) {
+	err = d.db.QueryRow(ctx, "INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip_address, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		session.UserID, session.RefreshTokenHash, session.UserAgent, session.IPAddress, session.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+type SessionUser struct {
+	ID     uint
+	UserID uint
+}
+
+// replaces the refresh token of the session it belongs to and extends the session until expiresAt.
+// Returns pgx.ErrNoRows if the token doesn't belong to an active session, and ErrRefreshTokenReused
+// if it was already replaced.
+func (d *DB) RotateSessionRefreshToken(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, expiresAt time.Time) (session SessionUser, err error) {
+	err = d.db.QueryRow(ctx, `UPDATE sessions SET previous_refresh_token_hash = refresh_token_hash, refresh_token_hash = $2, last_used_at = now(), expires_at = $3
+	WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > now() RETURNING id, user_id`, refreshTokenHash, newRefreshTokenHash, expiresAt).
+		Scan(&session.ID, &session.UserID)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return session, err
+	}
+
+	tag, err := d.db.Exec(ctx, "UPDATE sessions SET revoked_at = now() WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL", refreshTokenHash)
+	if err != nil {
+		return SessionUser{}, err
+	}
+	if tag.RowsAffected() > 0 {
+		return SessionUser{}, ErrRefreshTokenReused
+	}
+	return SessionUser{}, pgx.ErrNoRows
+}
+
+type Session struct {
+	ID         uint      `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// returns the sessions that haven't been revoked or expired
+func (d *DB) SessionsByUserID(ctx context.Context, userID uint) (sessions []Session, err error) {
+	if err := pgxscan.Select(ctx, d.db, &sessions, `SELECT id, user_agent, ip_address, created_at, last_used_at, expires_at FROM sessions
+	WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now() ORDER BY last_used_at DESC`, userID); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// whether an access token of the session that was issued at issuedAt is still valid. It isn't if the session
+// was revoked or has expired, or if the user's auth tokens were invalidated after it was issued.
+func (d *DB) SessionActive(ctx context.Context, sessionID uint, userID uint, issuedAt time.Time) (active bool, err error) {
+	// the issued at claim of tokens only has a precision of seconds
+	err = d.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM sessions JOIN users ON users.id = sessions.user_id
+	WHERE sessions.id = $1 AND sessions.user_id = $2 AND sessions.revoked_at IS NULL AND sessions.expires_at > now()
+	AND (users.auth_valid_after IS NULL OR date_trunc('second', users.auth_valid_after) <= $3))`, sessionID, userID, issuedAt).Scan(&active)
+	return active, err
+}
+
+func (d *DB) UserAuthorizationForSession(ctx context.Context, sessionID uint, userID uint) (authorized bool, err error) {
+	err = d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND user_id = $2)", sessionID, userID).Scan(&authorized)
+	return authorized, err
+}
+
+func (d *DB) RevokeSessionByID(ctx context.Context, id uint) error {
+	_, err := d.db.Exec(ctx, "UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	return err
+}
+
+// logs the user out everywhere
+func (d *DB) RevokeSessionsByUserID(ctx context.Context, userID uint) error {
+	_, err := d.db.Exec(ctx, "UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
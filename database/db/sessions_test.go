@@ -0,0 +1,53 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Suite) TestSessions() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username:     "testusername",
+		Email:        "testemail@google.com",
+		PasswordHash: "hash",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+
+	sessionID, err := s.Repo.CreateSession(s.Ctx, db.CreateSession{UserID: userID, RefreshTokenHash: "first", UserAgent: "curl", ExpiresAt: time.Now().Add(time.Hour)})
+	s.Require().NoError(err)
+	active, err := s.Repo.SessionActive(s.Ctx, sessionID, userID, time.Now())
+	s.Require().NoError(err)
+	s.True(active)
+
+	session, err := s.Repo.RotateSessionRefreshToken(s.Ctx, "first", "second", time.Now().Add(time.Hour))
+	s.Require().NoError(err)
+	s.Equal(db.SessionUser{ID: sessionID, UserID: userID}, session)
+
+	_, err = s.Repo.RotateSessionRefreshToken(s.Ctx, "unknown", "third", time.Now().Add(time.Hour))
+	s.ErrorIs(err, pgx.ErrNoRows)
+
+	sessions, err := s.Repo.SessionsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(sessions, 1)
+	s.Equal("curl", sessions[0].UserAgent)
+
+	_, err = s.Repo.RotateSessionRefreshToken(s.Ctx, "first", "third", time.Now().Add(time.Hour))
+	s.ErrorIs(err, db.ErrRefreshTokenReused, "using a replaced refresh token again should be detected")
+	active, err = s.Repo.SessionActive(s.Ctx, sessionID, userID, time.Now())
+	s.Require().NoError(err)
+	s.False(active, "the session should be revoked when its refresh token is reused")
+	_, err = s.Repo.RotateSessionRefreshToken(s.Ctx, "second", "third", time.Now().Add(time.Hour))
+	s.ErrorIs(err, pgx.ErrNoRows, "refresh tokens of revoked sessions shouldn't work")
+
+	otherSessionID, err := s.Repo.CreateSession(s.Ctx, db.CreateSession{UserID: userID, RefreshTokenHash: "other", ExpiresAt: time.Now().Add(time.Hour)})
+	s.Require().NoError(err)
+	s.Require().NoError(s.Repo.RevokeSessionsByUserID(s.Ctx, userID))
+	sessions, err = s.Repo.SessionsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Empty(sessions, "no sessions should be left after logging out everywhere")
+	authorized, err := s.Repo.UserAuthorizationForSession(s.Ctx, otherSessionID, userID)
+	s.Require().NoError(err)
+	s.True(authorized)
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gragorther/epigo/shamir"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+type ReleaseMode string
+
+const (
+	// the message is sent to each recipient as it is
+	ReleaseModeStandard ReleaseMode = "standard"
+	// the message is encrypted on release and its key split between the recipients with Shamir's secret sharing, so
+	// no single recipient can read it alone
+	ReleaseModeShamir ReleaseMode = "shamir"
+)
+
+// the error deliveries of shamir messages fail with when there aren't enough recipients to split the key between
+const errNotEnoughShareRecipients = "not enough recipients to split the message key between"
+
+// the threshold the key of a shamir message is split with when it goes out to recipients recipients. It's lowered to
+// the number of recipients when there are fewer, so the message isn't lost. Below 2, the message can't be sent at all.
+func EffectiveShareThreshold(threshold uint, recipients uint) uint {
+	return min(threshold, recipients)
+}
+
+// encrypts the content of the user's shamir messages with a new key and splits the key between their deliveries. The
+// content itself is removed, so the message can only be read by putting the shares together. The shares are only kept
+// until they're sent.
+//
+// When a message has fewer recipients than its threshold, the threshold is lowered to the number of recipients, so the
+// message isn't lost, but never below 2. Messages with a single recipient fail to deliver instead.
+//
+// Call it after CreateDeliveries. Messages that were already shared are skipped, so it can be retried.
+func (d *DB) ShareLastMessages(ctx context.Context, userID uint) error {
+	rows, err := d.db.Query(ctx, `SELECT id, share_threshold,
+		ARRAY(SELECT deliveries.id FROM deliveries WHERE deliveries.last_message_id = last_messages.id ORDER BY deliveries.id),
+		`+sealedLastMessageColumns+` FROM last_messages WHERE user_id = $1 AND release_mode = $2 AND shared_ciphertext IS NULL`, userID, ReleaseModeShamir)
+	if err != nil {
+		return err
+	}
+	type unsharedLastMessage struct {
+		LastMessage
+		DeliveryIDs []int64
+	}
+	messages, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (unsharedLastMessage, error) {
+		var id uint
+		var shareThreshold null.Int32
+		var deliveryIDs []int64
+		var m sealedLastMessage
+		if err := row.Scan(append([]any{&id, &shareThreshold, &deliveryIDs}, m.scanTargets()...)...); err != nil {
+			return unsharedLastMessage{}, err
+		}
+		lastMessage, err := d.openLastMessage(id, m)
+		lastMessage.ShareThreshold = shareThreshold
+		return unsharedLastMessage{LastMessage: lastMessage, DeliveryIDs: deliveryIDs}, err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		n := len(message.DeliveryIDs)
+		if n == 0 {
+			continue
+		}
+		if n < 2 {
+			if _, err := d.db.Exec(ctx, "UPDATE deliveries SET status = $1, last_error = $2 WHERE id = ANY($3)", DeliveryStatusFailed, errNotEnoughShareRecipients, message.DeliveryIDs); err != nil {
+				return err
+			}
+			continue
+		}
+		threshold := int(EffectiveShareThreshold(uint(message.ShareThreshold.Int32), uint(n)))
+		ciphertext, shares, err := shamir.SealAndSplit([]byte(message.Content.String), n, threshold)
+		if err != nil {
+			return fmt.Errorf("failed to split the key of last message %d: %w", message.ID, err)
+		}
+		// the revisions go too, the server shouldn't be able to read the content anymore
+		if _, err := d.db.Exec(ctx, `WITH m AS (UPDATE last_messages SET shared_ciphertext = $2, share_threshold = $3, share_count = $4,
+			content = NULL, content_ciphertext = NULL WHERE id = $1 AND shared_ciphertext IS NULL RETURNING id),
+			r AS (DELETE FROM last_message_revisions USING m WHERE last_message_revisions.last_message_id = m.id)
+			UPDATE deliveries SET key_share = s.share FROM m, UNNEST($5::bigint[], $6::bytea[]) AS s(id, share)
+			WHERE deliveries.id = s.id AND deliveries.last_message_id = m.id`,
+			message.ID, ciphertext, threshold, n, message.DeliveryIDs, shares); err != nil {
+			return err
+		}
+	}
+	return nil
+}
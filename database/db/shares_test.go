@@ -0,0 +1,70 @@
+package db_test
+
+import (
+	"github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/shamir"
+	"github.com/guregu/null/v6"
+)
+
+func (s *Suite) TestShareLastMessages() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	groupID, err := s.Repo.CreateGroupReturningID(s.Ctx, db.CreateGroup{
+		UserID:          userID,
+		Name:            "heirs",
+		RecipientEmails: []string{"first@google.com", "second@google.com", "third@google.com"},
+	})
+	s.Require().NoError(err, "creating test group shouldn't fail")
+	s.Require().NoError(s.Repo.CreateLastMessage(s.Ctx, db.CreateLastMessage{
+		UserID:         userID,
+		Title:          "wallet",
+		Content:        null.StringFrom("seed words"),
+		GroupIDs:       []uint{groupID},
+		ReleaseMode:    db.ReleaseModeShamir,
+		ShareThreshold: null.Int32From(2),
+	}))
+
+	s.Require().NoError(s.Repo.CreateDeliveries(s.Ctx, userID))
+	s.Require().NoError(s.Repo.ShareLastMessages(s.Ctx, userID))
+	ids, err := s.Repo.QueuedDeliveryIDsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(ids, 3)
+
+	var shares [][]byte
+	for _, id := range ids {
+		delivery, err := s.Repo.DeliveryMessageByID(s.Ctx, id)
+		s.Require().NoError(err)
+		s.Equal(db.ReleaseModeShamir, delivery.ReleaseMode)
+		s.False(delivery.Content.Valid, "the content should only be readable with the shares")
+		s.Equal(null.Int32From(3), delivery.ShareCount)
+		s.Require().NotEmpty(delivery.KeyShare)
+		shares = append(shares, delivery.KeyShare)
+	}
+	first, err := s.Repo.DeliveryMessageByID(s.Ctx, ids[0])
+	s.Require().NoError(err)
+	content, err := shamir.CombineAndOpen(first.SharedCiphertext, shares[1:])
+	s.Require().NoError(err)
+	s.Equal("seed words", string(content))
+
+	s.Require().NoError(s.Repo.ShareLastMessages(s.Ctx, userID), "sharing again shouldn't fail")
+	again, err := s.Repo.DeliveryMessageByID(s.Ctx, ids[0])
+	s.Require().NoError(err)
+	s.Equal(first.KeyShare, again.KeyShare, "sharing again shouldn't split the key again")
+
+	s.Require().NoError(s.Repo.MarkDeliverySent(s.Ctx, ids[0], "<id@example.com>"))
+	sent, err := s.Repo.DeliveryMessageByID(s.Ctx, ids[0])
+	s.Require().NoError(err)
+	s.Nil(sent.KeyShare, "shares shouldn't be kept after they were sent")
+
+	s.Require().NoError(s.Repo.MarkDeliveryAttemptFailed(s.Ctx, ids[1], "mailbox full", false))
+	retried, err := s.Repo.DeliveryMessageByID(s.Ctx, ids[1])
+	s.Require().NoError(err)
+	s.NotEmpty(retried.KeyShare, "shares should be kept for deliveries that are retried")
+	s.Require().NoError(s.Repo.MarkDeliveryAttemptFailed(s.Ctx, ids[1], "no such user", true))
+	failed, err := s.Repo.DeliveryMessageByID(s.Ctx, ids[1])
+	s.Require().NoError(err)
+	s.Nil(failed.KeyShare, "shares shouldn't be kept after the delivery failed for good")
+}
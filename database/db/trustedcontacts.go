@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	_ "embed"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/guregu/null/v6"
+	"github.com/jackc/pgx/v5"
+)
+
+// the user's trusted contacts have been asked to confirm their death. Their last messages are released once a quorum confirms or the confirmation times out.
+const UserStatusAwaitingConfirmation UserStatus = "awaiting_confirmation"
+
+type TrustedContactDecision string
+
+const (
+	TrustedContactDecisionConfirmed TrustedContactDecision = "confirmed"
+	TrustedContactDecisionVetoed    TrustedContactDecision = "vetoed"
+)
+
+type CreateTrustedContact struct {
+	UserID uint
+	Email  string
+	Name   null.String
+}
+
+func (d *DB) CreateTrustedContact(ctx context.Context, contact CreateTrustedContact) error {
+	_, err := d.db.Exec(ctx, "INSERT INTO trusted_contacts (user_id, email, name) VALUES ($1, $2, $3) ON CONFLICT (user_id, email) DO NOTHING", contact.UserID, contact.Email, contact.Name)
+	return err
+}
+
+type TrustedContact struct {
+	ID       uint        `json:"id"`
+	Email    string      `json:"email"`
+	Name     null.String `json:"name"`
+	Decision null.String `json:"decision"`
+}
+
+func (d *DB) TrustedContactsByUserID(ctx context.Context, userID uint) (contacts []TrustedContact, err error) {
+	if err := pgxscan.Select(ctx, d.db, &contacts, "SELECT id, email, name, decision FROM trusted_contacts WHERE user_id = $1 ORDER BY id", userID); err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func (d *DB) UserAuthorizationForTrustedContact(ctx context.Context, contactID uint, userID uint) (authorized bool, err error) {
+	err = d.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM trusted_contacts WHERE id = $1 AND user_id = $2)", contactID, userID).Scan(&authorized)
+	return authorized, err
+}
+
+func (d *DB) DeleteTrustedContactByID(ctx context.Context, id uint) error {
+	_, err := d.db.Exec(ctx, "DELETE FROM trusted_contacts WHERE id = $1", id)
+	return err
+}
+
+type TrustedContactSettings struct {
+	Quorum  uint
+	Timeout time.Duration
+}
+
+func (d *DB) SetUserTrustedContactSettings(ctx context.Context, userID uint, settings TrustedContactSettings) error {
+	_, err := d.db.Exec(ctx, "UPDATE users SET trusted_contact_quorum = $1, trusted_contact_timeout_seconds = $2 WHERE id = $3", settings.Quorum, int64(settings.Timeout.Seconds()), userID)
+	return err
+}
+
+//go:embed queries/start_user_awaiting_confirmation.sql
+var startUserAwaitingConfirmationQuery string
+
+// moves a user whose grace period ran out into the awaiting confirmation state and clears earlier decisions of their trusted contacts.
+// deadline is when the last messages get released if the trusted contacts don't reach a quorum. If the user is already awaiting confirmation,
+// their existing deadline is returned. awaiting is false if the user was neither pending release nor awaiting confirmation.
+func (d *DB) StartUserAwaitingConfirmation(ctx context.Context, userID uint) (deadline time.Time, awaiting bool, err error) {
+	err = d.db.QueryRow(ctx, startUserAwaitingConfirmationQuery, userID).Scan(&deadline)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	return deadline, err == nil, err
+}
+
+//go:embed queries/record_trusted_contact_decision.sql
+var recordTrustedContactDecisionQuery string
+
+var ErrNotAwaitingConfirmation = errors.New("user isn't awaiting confirmation from their trusted contacts")
+
+func (d *DB) RecordTrustedContactDecision(ctx context.Context, contactID uint, userID uint, decision TrustedContactDecision) error {
+	var id uint
+	err := d.db.QueryRow(ctx, recordTrustedContactDecisionQuery, contactID, userID, decision).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotAwaitingConfirmation
+	}
+	return err
+}
+
+//go:embed queries/trusted_contact_confirmations.sql
+var trustedContactConfirmationsQuery string
+
+type TrustedContactConfirmations struct {
+	Confirmed uint
+	Total     uint
+	Quorum    uint
+}
+
+// a quorum can never be larger than the number of trusted contacts
+func (c TrustedContactConfirmations) QuorumReached() bool {
+	return c.Total > 0 && c.Confirmed >= min(c.Quorum, c.Total)
+}
+
+func (d *DB) TrustedContactConfirmationsByUserID(ctx context.Context, userID uint) (confirmations TrustedContactConfirmations, err error) {
+	err = d.db.QueryRow(ctx, trustedContactConfirmationsQuery, userID).Scan(&confirmations.Confirmed, &confirmations.Total, &confirmations.Quorum)
+	return confirmations, err
+}
+
+// a trusted contact vetoed the release, e.g. because the user is only in hospital. The user starts over as if they checked in.
+func (d *DB) VetoUserRelease(ctx context.Context, userID uint) error {
+	_, err := d.db.Exec(ctx, "UPDATE users SET status = $1, sent_emails = 0, release_at = NULL WHERE id = $2 AND status = $3", UserStatusAlive, userID, UserStatusAwaitingConfirmation)
+	return err
+}
@@ -0,0 +1,46 @@
+package db_test
+
+import (
+	"github.com/gragorther/epigo/database/db"
+	"github.com/guregu/null/v6"
+)
+
+func (s *Suite) TestTrustedContactDecisions() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	for _, email := range []string{"first@google.com", "second@google.com", "second@google.com"} {
+		s.Require().NoError(s.Repo.CreateTrustedContact(s.Ctx, db.CreateTrustedContact{UserID: userID, Email: email, Name: null.StringFrom("contact")}))
+	}
+	s.Require().NoError(s.Repo.SetUserTrustedContactSettings(s.Ctx, userID, db.TrustedContactSettings{Quorum: 2}))
+
+	contacts, err := s.Repo.TrustedContactsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().Len(contacts, 2, "duplicate trusted contacts shouldn't be added")
+
+	s.ErrorIs(s.Repo.RecordTrustedContactDecision(s.Ctx, contacts[0].ID, userID, db.TrustedContactDecisionConfirmed), db.ErrNotAwaitingConfirmation,
+		"decisions shouldn't be recorded while the user is alive")
+
+	_, _, err = s.Repo.StartUserPendingRelease(s.Ctx, userID)
+	s.Require().NoError(err)
+	_, awaiting, err := s.Repo.StartUserAwaitingConfirmation(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Require().True(awaiting)
+
+	s.Require().NoError(s.Repo.RecordTrustedContactDecision(s.Ctx, contacts[0].ID, userID, db.TrustedContactDecisionConfirmed))
+	confirmations, err := s.Repo.TrustedContactConfirmationsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.False(confirmations.QuorumReached())
+
+	s.Require().NoError(s.Repo.RecordTrustedContactDecision(s.Ctx, contacts[1].ID, userID, db.TrustedContactDecisionConfirmed))
+	confirmations, err = s.Repo.TrustedContactConfirmationsByUserID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.True(confirmations.QuorumReached())
+
+	s.Require().NoError(s.Repo.VetoUserRelease(s.Ctx, userID))
+	status, err := s.Repo.UserStatusByID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal(db.UserStatusAlive, status, "vetoing should bring the user back to alive")
+}
@@ -3,12 +3,14 @@ package db
 import (
 	"context"
 	"errors"
+	"time"
 
 	_ "embed"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/guregu/null/v6"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func (d *DB) UpdateUserInterval(ctx context.Context, userID uint, cron string) error {
@@ -41,16 +43,19 @@ func (d *DB) GetUserIntervals(ctx context.Context) (userIntervals []UserInterval
 type IntervalAndSentEmails struct {
 	UserSentEmails
 	UserInterval
+	Status UserStatus
+	Locale string
 }
 
+// users whose accounts are about to be deleted are left out, so their messages aren't released in the meantime
 func (d *DB) AllUserIntervalsAndSentEmails(ctx context.Context) (intervals []IntervalAndSentEmails, err error) {
-	rows, err := d.db.Query(ctx, "SELECT sent_emails, max_sent_emails, id, email, cron, name FROM users")
+	rows, err := d.db.Query(ctx, "SELECT sent_emails, max_sent_emails, id, email, cron, COALESCE(name, ''), status, locale FROM users WHERE deletion_scheduled_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
 
 	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (interval IntervalAndSentEmails, err error) {
-		err = row.Scan(&interval.SentEmails, &interval.MaxSentEmails, &interval.ID, &interval.Email, &interval.Cron, &interval.Name)
+		err = row.Scan(&interval.SentEmails, &interval.MaxSentEmails, &interval.ID, &interval.Email, &interval.Cron, &interval.Name, &interval.Status, &interval.Locale)
 		return
 	})
 }
@@ -76,15 +81,17 @@ type CreateUserInput struct {
 	Email        string
 	PasswordHash string
 	Name         null.String
+	// defaults to english if empty
+	Locale string
 }
 
 func (d *DB) CreateUser(ctx context.Context, user CreateUserInput) error {
-	_, err := d.db.Exec(ctx, "INSERT INTO users (username, email, name, password_hash) VALUES ($1, $2, $3, $4)", user.Username, user.Email, user.Name, user.PasswordHash)
+	_, err := d.db.Exec(ctx, "INSERT INTO users (username, email, name, password_hash, locale) VALUES ($1, $2, $3, $4, COALESCE(NULLIF($5, ''), 'en'))", user.Username, user.Email, user.Name, user.PasswordHash, user.Locale)
 	return err
 }
 
 func (d *DB) CreateUserReturningID(ctx context.Context, user CreateUserInput) (userID uint, err error) {
-	err = d.db.QueryRow(ctx, "INSERT INTO users (username, email, name, password_hash) VALUES ($1, $2, $3, $4) RETURNING id", user.Username, user.Email, user.Name, user.PasswordHash).Scan(&userID)
+	err = d.db.QueryRow(ctx, "INSERT INTO users (username, email, name, password_hash, locale) VALUES ($1, $2, $3, $4, COALESCE(NULLIF($5, ''), 'en')) RETURNING id", user.Username, user.Email, user.Name, user.PasswordHash, user.Locale).Scan(&userID)
 	return
 }
 
@@ -107,13 +114,19 @@ type User struct {
 	Username string
 	Name     null.String
 	Email    string
+	Locale   string
 }
 
 func (d *DB) UserByID(ctx context.Context, ID uint) (user User, err error) {
-	err = d.db.QueryRow(ctx, "SELECT username, name, email FROM users WHERE id = $1", ID).Scan(&user.Username, &user.Name, &user.Email)
+	err = d.db.QueryRow(ctx, "SELECT username, name, email, locale FROM users WHERE id = $1", ID).Scan(&user.Username, &user.Name, &user.Email, &user.Locale)
 	return user, err
 }
 
+func (d *DB) SetUserLocale(ctx context.Context, userID uint, locale string) error {
+	_, err := d.db.Exec(ctx, "UPDATE users SET locale = $1 WHERE id = $2", locale, userID)
+	return err
+}
+
 type UserWithCron struct {
 	User
 	Cron null.String
@@ -145,3 +158,84 @@ func (d *DB) IncrementUserSentEmailsCount(ctx context.Context, userID uint) erro
 	_, err := d.db.Exec(ctx, "UPDATE users SET sent_emails = sent_emails + 1 WHERE id = $1", userID)
 	return err
 }
+
+// records that the user is sent a test email now, unless they were already sent one in the last minInterval.
+// claimed is false in that case.
+func (d *DB) ClaimUserTestEmail(ctx context.Context, userID uint, minInterval time.Duration) (claimed bool, err error) {
+	tag, err := d.db.Exec(ctx, `UPDATE users SET last_test_email_at = now() WHERE id = $1
+		AND (last_test_email_at IS NULL OR last_test_email_at <= now() - make_interval(secs => $2))`, userID, minInterval.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+type UserStatus string
+
+const (
+	UserStatusAlive UserStatus = "alive"
+	// the user missed all their life status emails and their last messages will be released once the grace period is over
+	UserStatusPendingRelease UserStatus = "pending_release"
+	// the user's last messages have been sent out
+	UserStatusReleased UserStatus = "released"
+)
+
+func (d *DB) UserStatusByID(ctx context.Context, userID uint) (status UserStatus, err error) {
+	err = d.db.QueryRow(ctx, "SELECT status FROM users WHERE id = $1", userID).Scan(&status)
+	return status, err
+}
+
+//go:embed queries/start_user_pending_release.sql
+var startUserPendingReleaseQuery string
+
+// moves an alive user into the pending release state and returns when their last messages will be released.
+// If the user is already pending release, their existing release time is returned. pending is false if the user has already been released.
+func (d *DB) StartUserPendingRelease(ctx context.Context, userID uint) (releaseAt time.Time, pending bool, err error) {
+	err = d.db.QueryRow(ctx, startUserPendingReleaseQuery, userID).Scan(&releaseAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	return releaseAt, err == nil, err
+}
+
+func (d *DB) MarkUserReleased(ctx context.Context, userID uint) error {
+	_, err := d.db.Exec(ctx, "UPDATE users SET status = $1 WHERE id = $2", UserStatusReleased, userID)
+	return err
+}
+
+func (d *DB) SetUserGracePeriod(ctx context.Context, userID uint, gracePeriod time.Duration) error {
+	_, err := d.db.Exec(ctx, "UPDATE users SET grace_period_seconds = $1 WHERE id = $2", int64(gracePeriod.Seconds()), userID)
+	return err
+}
+
+// the SQLSTATE of unique constraint violations
+const uniqueViolation = "23505"
+
+// someone else registered with the address in the meantime
+var ErrEmailTaken = errors.New("email address is already taken")
+
+// changes the user's address and logs them out everywhere, unless it isn't oldEmail anymore or the old address
+// cancelled the changes asked for until requestedAt. changed is false in that case, which means the change was already
+// confirmed, another change came first or it was cancelled.
+func (d *DB) ChangeUserEmail(ctx context.Context, userID uint, oldEmail string, newEmail string, requestedAt time.Time) (changed bool, err error) {
+	// the issued at claim of tokens only has a precision of seconds, so changes asked for in the second of the
+	// cancellation count as cancelled
+	err = d.db.QueryRow(ctx, `WITH updated AS (
+		UPDATE users SET email = $3, auth_valid_after = now() WHERE id = $1 AND email = $2
+		AND (email_change_cancelled_at IS NULL OR date_trunc('second', email_change_cancelled_at) < $4) RETURNING id
+	), revoked AS (
+		UPDATE sessions SET revoked_at = now() WHERE user_id IN (SELECT id FROM updated) AND revoked_at IS NULL
+	)
+	SELECT EXISTS(SELECT 1 FROM updated)`, userID, oldEmail, newEmail, requestedAt).Scan(&changed)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return false, ErrEmailTaken
+	}
+	return changed, err
+}
+
+// the links of the email changes asked for until now stop working
+func (d *DB) CancelUserEmailChanges(ctx context.Context, userID uint) error {
+	_, err := d.db.Exec(ctx, "UPDATE users SET email_change_cancelled_at = now() WHERE id = $1", userID)
+	return err
+}
@@ -0,0 +1,118 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/gragorther/epigo/database/db"
+)
+
+func (s *Suite) TestStartUserPendingRelease() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	s.Require().NoError(s.Repo.SetUserGracePeriod(s.Ctx, userID, 2*time.Hour))
+
+	releaseAt, pending, err := s.Repo.StartUserPendingRelease(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.True(pending)
+	s.WithinDuration(time.Now().Add(2*time.Hour), releaseAt, time.Minute, "release should happen after the grace period")
+
+	again, pending, err := s.Repo.StartUserPendingRelease(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.True(pending)
+	s.True(releaseAt.Equal(again), "starting the release twice shouldn't move the release time")
+
+	_, err = s.Repo.CheckInUser(s.Ctx, userID, db.CheckInSourceEmail)
+	s.Require().NoError(err)
+	status, err := s.Repo.UserStatusByID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal(db.UserStatusAlive, status, "checking in should cancel the pending release")
+
+	s.Require().NoError(s.Repo.MarkUserReleased(s.Ctx, userID))
+	_, pending, err = s.Repo.StartUserPendingRelease(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.False(pending, "released users can't go back to pending release")
+}
+
+func (s *Suite) TestClaimUserTestEmail() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "testemail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+
+	claimed, err := s.Repo.ClaimUserTestEmail(s.Ctx, userID, time.Hour)
+	s.Require().NoError(err)
+	s.True(claimed)
+	claimed, err = s.Repo.ClaimUserTestEmail(s.Ctx, userID, time.Hour)
+	s.Require().NoError(err)
+	s.False(claimed, "a second test email shouldn't be sent within the interval")
+	claimed, err = s.Repo.ClaimUserTestEmail(s.Ctx, userID, 0)
+	s.Require().NoError(err)
+	s.True(claimed)
+}
+
+func (s *Suite) TestChangeUserEmail() {
+	userID, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "old@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+	_, err = s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "otheruser",
+		Email:    "taken@google.com",
+	})
+	s.Require().NoError(err)
+
+	requestedAt := time.Now().Add(-time.Minute)
+	_, err = s.Repo.ChangeUserEmail(s.Ctx, userID, "old@google.com", "taken@google.com", requestedAt)
+	s.ErrorIs(err, db.ErrEmailTaken)
+
+	sessionID, err := s.Repo.CreateSession(s.Ctx, db.CreateSession{UserID: userID, RefreshTokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)})
+	s.Require().NoError(err)
+	changed, err := s.Repo.ChangeUserEmail(s.Ctx, userID, "old@google.com", "new@google.com", requestedAt)
+	s.Require().NoError(err)
+	s.True(changed)
+	changed, err = s.Repo.ChangeUserEmail(s.Ctx, userID, "old@google.com", "newer@google.com", requestedAt)
+	s.Require().NoError(err)
+	s.False(changed, "the same confirmation shouldn't work twice")
+	active, err := s.Repo.SessionActive(s.Ctx, sessionID, userID, time.Now())
+	s.Require().NoError(err)
+	s.False(active, "the user should be logged out everywhere once their address changed")
+
+	user, err := s.Repo.UserByID(s.Ctx, userID)
+	s.Require().NoError(err)
+	s.Equal("new@google.com", user.Email)
+
+	s.Require().NoError(s.Repo.CancelUserEmailChanges(s.Ctx, userID))
+	changed, err = s.Repo.ChangeUserEmail(s.Ctx, userID, "new@google.com", "newer@google.com", requestedAt)
+	s.Require().NoError(err)
+	s.False(changed, "cancelled changes shouldn't go through")
+	changed, err = s.Repo.ChangeUserEmail(s.Ctx, userID, "new@google.com", "newer@google.com", time.Now().Add(time.Minute))
+	s.Require().NoError(err)
+	s.True(changed, "changes asked for after the cancellation should go through")
+}
+
+func (s *Suite) TestClaimPasswordResetEmail() {
+	_, err := s.Repo.CreateUserReturningID(s.Ctx, db.CreateUserInput{
+		Username: "testusername",
+		Email:    "TestEmail@google.com",
+	})
+	s.Require().NoError(err, "creating test user shouldn't fail")
+
+	claimed, err := s.Repo.ClaimPasswordResetEmail(s.Ctx, "testemail@google.com", time.Hour)
+	s.Require().NoError(err)
+	s.True(claimed, "addresses should be compared case-insensitively")
+	claimed, err = s.Repo.ClaimPasswordResetEmail(s.Ctx, "testemail@google.com", time.Hour)
+	s.Require().NoError(err)
+	s.False(claimed, "a second reset email shouldn't be sent within the interval")
+	claimed, err = s.Repo.ClaimPasswordResetEmail(s.Ctx, "nobody@google.com", 0)
+	s.Require().NoError(err)
+	s.False(claimed, "nobody is registered with the address")
+
+	user, err := s.Repo.PasswordResetUserByEmail(s.Ctx, "testemail@google.com")
+	s.Require().NoError(err)
+	s.Equal("TestEmail@google.com", user.Email)
+}
@@ -1,11 +1,13 @@
 package testhelpers
 
 import (
+	"bytes"
 	"context"
 	"log"
 
 	"github.com/gragorther/epigo/database/db"
 	"github.com/gragorther/epigo/database/initializers"
+	"github.com/gragorther/epigo/envelope"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/suite"
 )
@@ -16,6 +18,8 @@ type DBTestSuite struct {
 	Repo        *db.DB
 	Ctx         context.Context
 	DB          *pgxpool.Pool
+	// the master keys Repo encrypts last messages with
+	Keys *envelope.Keys
 }
 
 func (suite *DBTestSuite) SetupSuite() {
@@ -26,6 +30,8 @@ func (suite *DBTestSuite) SetupSuite() {
 		log.Fatal(err)
 	}
 	suite.PgContainer = pgContainer
+	suite.Keys, err = envelope.NewKeys(map[uint][]byte{1: bytes.Repeat([]byte{1}, envelope.KeySize)})
+	suite.Require().NoError(err)
 
 	// here we connect and then close the DB, just to run the migrations. The connection
 	// *must* be closed, otherwise the snapshot fails because there can't be any active connections.
@@ -40,7 +46,7 @@ func (suite *DBTestSuite) SetupSuite() {
 	suite.DB = conn
 	suite.Require().NoError(err)
 
-	repo := db.NewDB(conn)
+	repo := db.NewDB(conn, suite.Keys)
 	suite.Repo = repo
 }
 
@@ -60,7 +66,7 @@ func setupTest(suite *DBTestSuite) {
 	suite.Require().NoError(err)
 	suite.DB = conn
 
-	suite.Repo = db.NewDB(conn)
+	suite.Repo = db.NewDB(conn, suite.Keys)
 }
 
 func (suite *DBTestSuite) BeforeTest(suiteName, testName string) {
@@ -1,68 +1,140 @@
 package email
 
 import (
+	"bytes"
 	"context"
-	_ "embed"
-	"errors"
 	"fmt"
 	"math/rand"
-	"text/template"
+	"time"
 
 	"github.com/wneessen/go-mail"
 )
 
-type UserDeathEmailAndRecipients struct {
-	Title           string
-	Content         string
-	RecipientEmails []string
+type UserDeathEmail struct {
+	Title          string
+	Content        string
+	RecipientEmail string
+	// the language the recipient gets the email in
+	Locale Locale
+	// set for client side encrypted messages instead of Content. Links to the page that decrypts the message in the
+	// recipient's browser.
+	ViewURL string
+	// set instead of Content when the message key was split between the recipients. ViewURL then links to the page
+	// where they put their shares together.
+	KeyShare       string
+	ShareThreshold uint
+	ShareCount     uint
+	// files sent along with the email
+	Attachments []Attachment
+	// files that were too large to attach, which the recipient downloads instead
+	AttachmentLinks []AttachmentLink
+	// when the links in AttachmentLinks stop working
+	AttachmentLinksExpireAt time.Time
+	// a test copy the user sent to themself, which says so
+	Test bool
 }
 
-//go:embed templates/death.txt
-var deathTemplateString string
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+type AttachmentLink struct {
+	Filename string
+	URL      string
+}
+
+type deathTemplateData struct {
+	Email          string
+	Name           string
+	Message        string
+	ViewURL        string
+	KeyShare       string
+	ShareThreshold uint
+	ShareCount     uint
+	// the names of the attached files
+	AttachedFiles   []string
+	AttachmentLinks []AttachmentLink
+	LinksExpireAt   string
+	Test            bool
+}
+
+// an email as it would be sent, for previews
+type RenderedEmail struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+func (e *EmailService) deathSubject(name string, email UserDeathEmail) string {
+	subject := e.translate(email.Locale, "death.subject", name, email.Title)
+	if email.Test {
+		subject = e.translate(email.Locale, "death.testsubject", subject)
+	}
+	return subject
+}
+
+func deathData(name string, email UserDeathEmail) deathTemplateData {
+	data := deathTemplateData{
+		Email:   email.RecipientEmail,
+		Name:    name,
+		Message: email.Content,
+		ViewURL: email.ViewURL,
 
-// name is the name of the person who died
-func (e *EmailService) SendUserDeathEmails(ctx context.Context, name string, emails []UserDeathEmailAndRecipients) error {
-	if emails == nil {
-		return errors.New("nil emails")
+		KeyShare:       email.KeyShare,
+		ShareThreshold: email.ShareThreshold,
+		ShareCount:     email.ShareCount,
+
+		AttachmentLinks: email.AttachmentLinks,
+		Test:            email.Test,
+	}
+	if len(email.AttachmentLinks) > 0 {
+		data.LinksExpireAt = email.AttachmentLinksExpireAt.UTC().Format(time.RFC1123)
 	}
-	type templateData struct {
-		Email   string
-		Name    string
-		Message string
+	for _, attachment := range email.Attachments {
+		data.AttachedFiles = append(data.AttachedFiles, attachment.Filename)
 	}
-	tpl, err := template.New("deathTemplate").Parse(deathTemplateString)
-	if err != nil {
-		return err
+	return data
+}
+
+// the subject and body SendUserDeathEmail would send. The contents of the attachments aren't needed.
+func (e *EmailService) RenderUserDeathEmail(name string, email UserDeathEmail) (rendered RenderedEmail, err error) {
+	rendered.Subject = e.deathSubject(name, email)
+	rendered.Text, rendered.HTML, err = e.render(tplDeath, email.Locale, deathData(name, email))
+	return rendered, err
+}
+
+// sends one last message to one recipient. name is the name of the person who died.
+//
+// the returned messageID is the Message-ID header of the sent email
+func (e *EmailService) SendUserDeathEmail(ctx context.Context, name string, email UserDeathEmail) (messageID string, err error) {
+	msg := mail.NewMsg()
+	if err := msg.FromFormat(e.fromFormat, e.from); err != nil {
+		return "", err
 	}
 
-	var messages []*mail.Msg
-	for _, email := range emails {
-		for _, recipient := range email.RecipientEmails {
-			msg := mail.NewMsg()
-			if err := msg.FromFormat(e.fromFormat, e.from); err != nil {
-				return err
-			}
-
-			if err := msg.EnvelopeFrom(fmt.Sprintf("%s+%d", e.from, rand.Int31())); err != nil {
-				return err
-			}
-
-			if err := msg.To(recipient); err != nil {
-				return err
-			}
-			msg.SetDate()
-			msg.SetMessageID()
-			msg.Subject(fmt.Sprintf("Message from %s: %s", name, email.Title))
-			if err := msg.SetBodyTextTemplate(tpl, templateData{
-				Email:   recipient,
-				Name:    name,
-				Message: email.Content,
-			}); err != nil {
-				return err
-			}
-			messages = append(messages, msg)
+	if err := msg.EnvelopeFrom(fmt.Sprintf("%s+%d", e.from, rand.Int31())); err != nil {
+		return "", err
+	}
+
+	if err := msg.To(email.RecipientEmail); err != nil {
+		return "", err
+	}
+	msg.SetDate()
+	msg.SetMessageID()
+	msg.Subject(e.deathSubject(name, email))
+	for _, attachment := range email.Attachments {
+		if err := msg.AttachReader(attachment.Filename, bytes.NewReader(attachment.Content), mail.WithFileContentType(mail.ContentType(attachment.ContentType))); err != nil {
+			return "", fmt.Errorf("failed to attach %s: %w", attachment.Filename, err)
 		}
 	}
+	if err := e.setBody(msg, tplDeath, email.Locale, deathData(name, email)); err != nil {
+		return "", err
+	}
 
-	return e.client.DialAndSendWithContext(ctx, messages...)
+	if err := e.client.DialAndSendWithContext(ctx, msg); err != nil {
+		return "", err
+	}
+	return msg.GetMessageID(), nil
 }
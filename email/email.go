@@ -1,18 +1,35 @@
 package email
 
 import (
+	"sync/atomic"
+
 	"github.com/wneessen/go-mail"
 )
 
 type EmailService struct {
-	client     *mail.Client
-	from       string
-	fromFormat string
+	client      *mail.Client
+	from        string
+	fromFormat  string
+	templateDir string
+	catalog     catalog
+	templates   atomic.Pointer[localizedTemplates]
 }
 
-/*from is the address the email service is sending emails from*/
-func NewEmailService(client *mail.Client, from string, fromFormat string) *EmailService {
-	return &EmailService{client: client, from: from, fromFormat: fromFormat}
+/*
+from is the address the email service is sending emails from.
+
+templateDir is an optional directory with templates that override the embedded ones.
+*/
+func NewEmailService(client *mail.Client, from string, fromFormat string, templateDir string) (*EmailService, error) {
+	catalog, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	e := &EmailService{client: client, from: from, fromFormat: fromFormat, templateDir: templateDir, catalog: catalog}
+	if err := e.ReloadTemplates(); err != nil {
+		return nil, err
+	}
+	return e, nil
 }
 
 func NewClient(host string, port int, password string, username string) (*mail.Client, error) {
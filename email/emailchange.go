@@ -0,0 +1,50 @@
+package email
+
+import (
+	"context"
+)
+
+// asks the user to confirm their new address. user.Email is the new address.
+func (e *EmailService) SendEmailChangeEmail(ctx context.Context, user LifeStatusUser, confirmURL string) error {
+	msg, err := e.newMsg(e.translate(user.Locale, "emailchange.subject"), user.Email)
+	if err != nil {
+		return err
+	}
+
+	templateData := struct {
+		UserName   string
+		ConfirmURL string
+	}{
+		UserName:   user.Name,
+		ConfirmURL: confirmURL,
+	}
+
+	if err := e.setBody(msg, tplEmailChange, user.Locale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
+
+// tells the user at their current address that someone asked to change it, with a link that cancels the change in case
+// it wasn't them
+func (e *EmailService) SendEmailChangeNoticeEmail(ctx context.Context, user LifeStatusUser, newEmail string, cancelURL string) error {
+	msg, err := e.newMsg(e.translate(user.Locale, "emailchangenotice.subject"), user.Email)
+	if err != nil {
+		return err
+	}
+
+	templateData := struct {
+		UserName  string
+		NewEmail  string
+		CancelURL string
+	}{
+		UserName:  user.Name,
+		NewEmail:  newEmail,
+		CancelURL: cancelURL,
+	}
+
+	if err := e.setBody(msg, tplEmailChangeNotice, user.Locale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
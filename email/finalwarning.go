@@ -0,0 +1,32 @@
+package email
+
+import (
+	"context"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+// sent when the user's grace period starts. Checking in through verificationURL cancels the release of their last messages.
+func (e *EmailService) SendUserFinalWarningEmail(ctx context.Context, user LifeStatusUser, verificationURL string, releaseAt time.Time) error {
+	msg, err := e.newMsg("FINAL WARNING: your last messages are about to be sent", user.Email)
+	if err != nil {
+		return err
+	}
+	msg.SetImportance(mail.ImportanceUrgent)
+
+	templateData := struct {
+		VerificationURL string
+		UserName        string
+		ReleaseAt       string
+	}{
+		VerificationURL: verificationURL,
+		UserName:        user.Name,
+		ReleaseAt:       releaseAt.UTC().Format(time.RFC1123),
+	}
+
+	if err := e.setBody(msg, tplFinalWarning, user.Locale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
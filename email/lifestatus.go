@@ -2,24 +2,16 @@ package email
 
 import (
 	"context"
-	_ "embed"
-	"text/template"
 )
 
 type LifeStatusUser struct {
-	Name  string
-	Email string
+	Name   string
+	Email  string
+	Locale Locale
 }
 
-//go:embed templates/lifestatus.txt
-var userLifeStatusTpl string
-
 func (e *EmailService) SendUserLifeStatusEmail(ctx context.Context, user LifeStatusUser, verificationURL string) error {
-	msg, err := e.newMsg("verify your life status", user.Email)
-	if err != nil {
-		return err
-	}
-	tpl, err := template.New("lifeStatus").Parse(userLifeStatusTpl)
+	msg, err := e.newMsg(e.translate(user.Locale, "lifestatus.subject"), user.Email)
 	if err != nil {
 		return err
 	}
@@ -34,9 +26,8 @@ func (e *EmailService) SendUserLifeStatusEmail(ctx context.Context, user LifeSta
 		Email:           user.Email,
 	}
 
-	textMsg, err := e.newTextMsg(msg, tpl, templateData)
-	if err != nil {
+	if err := e.setBody(msg, tplLifeStatus, user.Locale, templateData); err != nil {
 		return err
 	}
-	return e.client.DialAndSendWithContext(ctx, textMsg)
+	return e.client.DialAndSendWithContext(ctx, msg)
 }
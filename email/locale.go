@@ -0,0 +1,77 @@
+package email
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"golang.org/x/text/language"
+)
+
+// a BCP 47 language tag like "en" or "de"
+type Locale string
+
+const DefaultLocale Locale = "en"
+
+// the locales we have translations for. The first one is the fallback for MatchLocale.
+var SupportedLocales = []Locale{DefaultLocale, "de", "fr", "es", "it"}
+
+func IsSupportedLocale(locale Locale) bool {
+	return slices.Contains(SupportedLocales, locale)
+}
+
+var localeMatcher = language.NewMatcher(func() []language.Tag {
+	tags := make([]language.Tag, len(SupportedLocales))
+	for i, locale := range SupportedLocales {
+		tags[i] = language.Make(string(locale))
+	}
+	return tags
+}())
+
+// picks the supported locale that best fits an Accept-Language header, or DefaultLocale if none does
+func MatchLocale(acceptLanguage string) Locale {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+	_, index, confidence := localeMatcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+	return SupportedLocales[index]
+}
+
+//go:embed locales
+var embeddedLocales embed.FS
+
+// maps a locale to its translations, which are fmt format strings keyed by message ID
+type catalog map[Locale]map[string]string
+
+func loadCatalog() (catalog, error) {
+	c := make(catalog, len(SupportedLocales))
+	for _, locale := range SupportedLocales {
+		data, err := embeddedLocales.ReadFile(fmt.Sprintf("locales/%s.json", locale))
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("failed to parse %s translations: %w", locale, err)
+		}
+		c[locale] = messages
+	}
+	return c, nil
+}
+
+// translates key into locale, falling back to DefaultLocale and then to the key itself
+func (c catalog) translate(locale Locale, key string, args ...any) string {
+	format, ok := c[locale][key]
+	if !ok {
+		format, ok = c[DefaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+	return fmt.Sprintf(format, args...)
+}
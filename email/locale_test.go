@@ -0,0 +1,49 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogIsComplete(t *testing.T) {
+	c, err := loadCatalog()
+	require.NoError(t, err)
+	for _, locale := range SupportedLocales {
+		for key := range c[DefaultLocale] {
+			assert.Contains(t, c[locale], key, "%s is missing a translation", locale)
+		}
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	table := map[string]struct {
+		AcceptLanguage string
+		Want           Locale
+	}{
+		"exact":       {AcceptLanguage: "de", Want: "de"},
+		"region":      {AcceptLanguage: "fr-CH, fr;q=0.9, en;q=0.8", Want: "fr"},
+		"quality":     {AcceptLanguage: "ja;q=0.5, it;q=0.8", Want: "it"},
+		"unsupported": {AcceptLanguage: "ja", Want: DefaultLocale},
+		"empty":       {AcceptLanguage: "", Want: DefaultLocale},
+		"invalid":     {AcceptLanguage: ";;;", Want: DefaultLocale},
+	}
+	for name, test := range table {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.Want, MatchLocale(test.AcceptLanguage))
+		})
+	}
+}
+
+func TestLocalizedTemplates(t *testing.T) {
+	e, err := NewEmailService(nil, "from@google.com", "Epilogue", "")
+	require.NoError(t, err)
+
+	rendered := renderDeathEmail(t, e, "de", "message")
+	assert.Contains(t, rendered, "Hallo recipient@google.com,")
+	assert.Contains(t, rendered, "Gesendet von Epilogue")
+	assert.Contains(t, rendered, "Content-Language: de")
+
+	assert.Contains(t, renderDeathEmail(t, e, "xx", "message"), "Sent by Epilogue", "unsupported locales should fall back to the default one")
+}
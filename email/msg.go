@@ -1,9 +1,6 @@
 package email
 
 import (
-	"fmt"
-	"text/template"
-
 	"github.com/wneessen/go-mail"
 )
 
@@ -25,10 +22,3 @@ func (e *EmailService) newMsg(subject string, to string, opts ...mail.MsgOption)
 	}
 	return msg, nil
 }
-
-func (e *EmailService) newTextMsg(msg *mail.Msg, template *template.Template, templateData any, opts ...mail.PartOption) (*mail.Msg, error) {
-	if err := msg.SetBodyTextTemplate(template, templateData, opts...); err != nil {
-		return nil, fmt.Errorf("failed to set text template: %w", err)
-	}
-	return msg, nil
-}
@@ -0,0 +1,69 @@
+package email
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wneessen/go-mail"
+)
+
+// sends a notification to one of the channels a user registered. url is an optional link the receiver should open.
+// unsubscribeURL is where the receiver can stop getting the notifications.
+func (e *EmailService) SendNotificationEmail(ctx context.Context, to string, subject string, message string, url string, unsubscribeURL string) error {
+	msg, err := e.newMsg(subject, to)
+	if err != nil {
+		return err
+	}
+	setListUnsubscribe(msg, unsubscribeURL)
+
+	templateData := struct {
+		Message        string
+		URL            string
+		UnsubscribeURL string
+	}{
+		Message:        message,
+		URL:            url,
+		UnsubscribeURL: unsubscribeURL,
+	}
+
+	if err := e.setBody(msg, tplNotification, DefaultLocale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
+
+// asks the address of an email channel of userName to confirm that it wants their notifications
+func (e *EmailService) SendChannelOptInEmail(ctx context.Context, to string, userName string, confirmURL string, unsubscribeURL string) error {
+	msg, err := e.newMsg(fmt.Sprintf("%s wants to send their notifications to you", userName), to)
+	if err != nil {
+		return err
+	}
+	setListUnsubscribe(msg, unsubscribeURL)
+
+	templateData := struct {
+		Email          string
+		UserName       string
+		ConfirmURL     string
+		UnsubscribeURL string
+	}{
+		Email:          to,
+		UserName:       userName,
+		ConfirmURL:     confirmURL,
+		UnsubscribeURL: unsubscribeURL,
+	}
+
+	if err := e.setBody(msg, tplChannelOptIn, DefaultLocale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
+
+// lets mail clients show an unsubscribe button. They unsubscribe with a POST to the URL then (RFC 8058), opening it
+// only shows the unsubscribe page.
+func setListUnsubscribe(msg *mail.Msg, unsubscribeURL string) {
+	if unsubscribeURL == "" {
+		return
+	}
+	msg.SetGenHeader(mail.HeaderListUnsubscribe, fmt.Sprintf("<%s>", unsubscribeURL))
+	msg.SetGenHeader(mail.HeaderListUnsubscribePost, "List-Unsubscribe=One-Click")
+}
@@ -0,0 +1,26 @@
+package email
+
+import (
+	"context"
+)
+
+// sends the user a link from which they can choose a new password
+func (e *EmailService) SendPasswordResetEmail(ctx context.Context, user LifeStatusUser, resetURL string) error {
+	msg, err := e.newMsg(e.translate(user.Locale, "passwordreset.subject"), user.Email)
+	if err != nil {
+		return err
+	}
+
+	templateData := struct {
+		UserName string
+		ResetURL string
+	}{
+		UserName: user.Name,
+		ResetURL: resetURL,
+	}
+
+	if err := e.setBody(msg, tplPasswordReset, user.Locale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
@@ -0,0 +1,32 @@
+package email
+
+import (
+	"context"
+	"fmt"
+)
+
+// lets a recipient know that userName named them, so our death emails don't come out of the blue
+func (e *EmailService) SendRecipientNoticeEmail(ctx context.Context, recipientEmail string, userName string, confirmURL string, unsubscribeURL string) error {
+	msg, err := e.newMsg(fmt.Sprintf("%s named you as a recipient of their last messages", userName), recipientEmail)
+	if err != nil {
+		return err
+	}
+	setListUnsubscribe(msg, unsubscribeURL)
+
+	templateData := struct {
+		Email          string
+		UserName       string
+		ConfirmURL     string
+		UnsubscribeURL string
+	}{
+		Email:          recipientEmail,
+		UserName:       userName,
+		ConfirmURL:     confirmURL,
+		UnsubscribeURL: unsubscribeURL,
+	}
+
+	if err := e.setBody(msg, tplRecipientNotice, DefaultLocale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
@@ -2,18 +2,14 @@ package email
 
 import (
 	"context"
-	_ "embed"
 	"fmt"
-	"text/template"
 
 	"github.com/wneessen/go-mail"
 )
 
-//go:embed templates/verification.txt
-var verificationTemplate string
-
 type User struct {
-	Email string
+	Email  string
+	Locale Locale
 }
 
 // sends the user a verification email with a link from which they can continue registration
@@ -25,7 +21,7 @@ func (e *EmailService) SendVerificationEmail(ctx context.Context, user User, reg
 	if err := message.To(user.Email); err != nil {
 		return fmt.Errorf("failed to set to address: %w", err)
 	}
-	message.Subject("Verify your email address")
+	message.Subject(e.translate(user.Locale, "verification.subject"))
 	if err := message.From(e.from); err != nil {
 		return fmt.Errorf("failed to set message from: %w", err)
 	}
@@ -33,16 +29,12 @@ func (e *EmailService) SendVerificationEmail(ctx context.Context, user User, reg
 		return fmt.Errorf("failed to set envelope from: %w", err)
 	}
 
-	tpl, err := template.New("verification").Parse(verificationTemplate)
-	if err != nil {
-		return fmt.Errorf("failed to parse verification email text template: %w", err)
-	}
 	type templateData struct {
 		Email            string
 		RegistrationLink string
 	}
-	if err := message.SetBodyTextTemplate(tpl, templateData{Email: user.Email, RegistrationLink: registrationLink}); err != nil {
-		return fmt.Errorf("failed to set body text template: %w", err)
+	if err := e.setBody(message, tplVerification, user.Locale, templateData{Email: user.Email, RegistrationLink: registrationLink}); err != nil {
+		return err
 	}
 	return e.client.DialAndSendWithContext(ctx, message)
 }
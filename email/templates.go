@@ -0,0 +1,185 @@
+package email
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/wneessen/go-mail"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// the names of the emails we send. Each one has a <name>.txt text/template and a <name>.html
+// html/template defining the "content" block of layout.html.
+const (
+	tplVerification    = "verification"
+	tplLifeStatus      = "lifestatus"
+	tplFinalWarning    = "finalwarning"
+	tplDeath           = "death"
+	tplTrustedContact  = "trustedcontact"
+	tplRecipientNotice = "recipientnotice"
+	tplNotification    = "notification"
+	tplPasswordReset   = "passwordreset"
+	tplEmailChange     = "emailchange"
+	// sent to the old address when the user asks to change it
+	tplEmailChangeNotice = "emailchangenotice"
+	// asks the address of an email channel to confirm it
+	tplChannelOptIn = "channeloptin"
+)
+
+var templateNames = []string{tplVerification, tplLifeStatus, tplFinalWarning, tplDeath, tplTrustedContact, tplRecipientNotice, tplNotification, tplPasswordReset, tplEmailChange, tplEmailChangeNotice, tplChannelOptIn}
+
+const layoutTemplate = "layout.html"
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+type templates map[string]emailTemplate
+
+// the templates of each supported locale
+type localizedTemplates map[Locale]templates
+
+// overlayFS opens files from override when they exist there and from fallback otherwise,
+// so operators only have to provide the templates they want to change
+type overlayFS struct {
+	override fs.FS
+	fallback fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.override.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.fallback.Open(name)
+}
+
+// returns the templates to parse. If dir isn't empty, templates in it take precedence over the embedded ones.
+func templatesFS(dir string) (fs.FS, error) {
+	embedded, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return embedded, nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to open template directory: %w", err)
+	}
+	return overlayFS{override: os.DirFS(dir), fallback: embedded}, nil
+}
+
+// templates translate strings with {{t "key" args...}}, and {{locale}} returns the locale they're rendered in
+func (e *EmailService) templateFuncs(locale Locale) map[string]any {
+	return map[string]any{
+		"t": func(key string, args ...any) string {
+			return e.catalog.translate(locale, key, args...)
+		},
+		"locale": func() Locale { return locale },
+	}
+}
+
+func (e *EmailService) parseTemplates(fsys fs.FS, locale Locale) (templates, error) {
+	funcs := e.templateFuncs(locale)
+	layout, err := htmltemplate.New(layoutTemplate).Funcs(funcs).ParseFS(fsys, layoutTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email layout: %w", err)
+	}
+
+	parsed := make(templates, len(templateNames))
+	for _, name := range templateNames {
+		text, err := texttemplate.New(name+".txt").Funcs(funcs).ParseFS(fsys, name+".txt")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s text template: %w", name, err)
+		}
+		html, err := layout.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := html.ParseFS(fsys, name+".html"); err != nil {
+			return nil, fmt.Errorf("failed to parse %s html template: %w", name, err)
+		}
+		parsed[name] = emailTemplate{text: text, html: html}
+	}
+	return parsed, nil
+}
+
+// sets the body of msg to the text and html versions of the template called name, in the given locale
+func (e *EmailService) setBody(msg *mail.Msg, name string, locale Locale, data any) error {
+	if !IsSupportedLocale(locale) {
+		locale = DefaultLocale
+	}
+	tpl, ok := (*e.templates.Load())[locale][name]
+	if !ok {
+		return fmt.Errorf("unknown email template %q", name)
+	}
+	if err := msg.SetBodyTextTemplate(tpl.text, data); err != nil {
+		return fmt.Errorf("failed to set text template: %w", err)
+	}
+	if err := msg.AddAlternativeHTMLTemplate(tpl.html, data); err != nil {
+		return fmt.Errorf("failed to set html template: %w", err)
+	}
+	msg.SetGenHeader(mail.HeaderContentLang, string(locale))
+	return nil
+}
+
+// the text and html versions of the template called name, the way setBody puts them in an email
+func (e *EmailService) render(name string, locale Locale, data any) (text string, html string, err error) {
+	if !IsSupportedLocale(locale) {
+		locale = DefaultLocale
+	}
+	tpl, ok := (*e.templates.Load())[locale][name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown email template %q", name)
+	}
+	var buf strings.Builder
+	if err := tpl.text.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("failed to execute text template: %w", err)
+	}
+	text = buf.String()
+	buf.Reset()
+	if err := tpl.html.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("failed to execute html template: %w", err)
+	}
+	return text, buf.String(), nil
+}
+
+func (e *EmailService) translate(locale Locale, key string, args ...any) string {
+	return e.catalog.translate(locale, key, args...)
+}
+
+// translates text that doesn't go out by email, like notifications, with the same translations as the emails
+type TranslateFunc func(locale Locale, key string, args ...any) string
+
+func (e *EmailService) Translate(locale Locale, key string, args ...any) string {
+	return e.catalog.translate(locale, key, args...)
+}
+
+// parses the templates again, picking up changes in the template directory.
+// If parsing fails, the previous templates are kept.
+func (e *EmailService) ReloadTemplates() error {
+	fsys, err := templatesFS(e.templateDir)
+	if err != nil {
+		return err
+	}
+	parsed := make(localizedTemplates, len(SupportedLocales))
+	for _, locale := range SupportedLocales {
+		if parsed[locale], err = e.parseTemplates(fsys, locale); err != nil {
+			return err
+		}
+	}
+	e.templates.Store(&parsed)
+	return nil
+}
@@ -0,0 +1,119 @@
+package email
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wneessen/go-mail"
+)
+
+func renderDeathEmail(t *testing.T, e *EmailService, locale Locale, message string) string {
+	t.Helper()
+	return renderDeathEmailData(t, e, locale, deathTemplateData{Email: "recipient@google.com", Name: "John", Message: message})
+}
+
+func renderDeathEmailData(t *testing.T, e *EmailService, locale Locale, data deathTemplateData) string {
+	t.Helper()
+	msg := mail.NewMsg()
+	require.NoError(t, e.setBody(msg, tplDeath, locale, data))
+	var buf bytes.Buffer
+	_, err := msg.WriteTo(&buf)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestEmbeddedTemplates(t *testing.T) {
+	e, err := NewEmailService(nil, "from@google.com", "Epilogue", "")
+	require.NoError(t, err, "the embedded templates should parse")
+
+	rendered := renderDeathEmail(t, e, DefaultLocale, "<script>alert(1)</script>")
+	assert.Contains(t, rendered, "multipart/alternative")
+	assert.Contains(t, rendered, "text/plain")
+	assert.Contains(t, rendered, "text/html")
+	assert.Contains(t, rendered, "&lt;script&gt;", "the message should be escaped in the html part")
+	assert.Contains(t, rendered, "Sent by Epilogue", "the html part should use the layout")
+}
+
+func TestEncryptedDeathEmail(t *testing.T) {
+	e, err := NewEmailService(nil, "from@google.com", "Epilogue", "")
+	require.NoError(t, err)
+
+	rendered := renderDeathEmailData(t, e, DefaultLocale, deathTemplateData{Email: "recipient@google.com", Name: "John", ViewURL: "https://example.com/v#k"})
+	assert.Contains(t, rendered, "end-to-end encrypted")
+	assert.Contains(t, rendered, "https://example.com/v#k")
+}
+
+func TestSharedDeathEmail(t *testing.T) {
+	e, err := NewEmailService(nil, "from@google.com", "Epilogue", "")
+	require.NoError(t, err)
+
+	rendered := renderDeathEmailData(t, e, DefaultLocale, deathTemplateData{
+		Email: "recipient@google.com", Name: "John", ViewURL: "https://example.com/s", KeyShare: "ABCD-EFGH", ShareThreshold: 2, ShareCount: 3,
+	})
+	assert.Contains(t, rendered, "split between 3 people")
+	assert.Contains(t, rendered, "once 2 of you")
+	assert.Contains(t, rendered, "ABCD-EFGH")
+}
+
+func TestDeathEmailAttachments(t *testing.T) {
+	e, err := NewEmailService(nil, "from@google.com", "Epilogue", "")
+	require.NoError(t, err)
+
+	rendered := renderDeathEmailData(t, e, DefaultLocale, deathTemplateData{
+		Email: "recipient@google.com", Name: "John", Message: "hello",
+		AttachedFiles:   []string{"beach.jpg"},
+		AttachmentLinks: []AttachmentLink{{Filename: "video.mp4", URL: "https://example.com/download?token=t"}},
+		LinksExpireAt:   "Mon, 02 Jan 2006 15:04:05 UTC",
+	})
+	assert.Contains(t, rendered, "attached to this email")
+	assert.Contains(t, rendered, "beach.jpg")
+	assert.Contains(t, rendered, "Download them before Mon, 02 Jan 2006")
+	assert.Contains(t, rendered, "https://example.com/download?token=3Dt", "the link should be in the quoted-printable body")
+}
+
+func TestRenderUserDeathEmail(t *testing.T) {
+	e, err := NewEmailService(nil, "from@google.com", "Epilogue", "")
+	require.NoError(t, err)
+
+	rendered, err := e.RenderUserDeathEmail("John", UserDeathEmail{
+		Title: "goodbye", Content: "<b>hello</b>", RecipientEmail: "recipient@google.com", Locale: DefaultLocale,
+		Attachments: []Attachment{{Filename: "beach.jpg"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Message from John: goodbye", rendered.Subject)
+	assert.Contains(t, rendered.Text, "<b>hello</b>")
+	assert.Contains(t, rendered.Text, "- beach.jpg")
+	assert.Contains(t, rendered.HTML, "&lt;b&gt;hello&lt;/b&gt;")
+	assert.NotContains(t, rendered.Text, "test copy")
+
+	rendered, err = e.RenderUserDeathEmail("John", UserDeathEmail{Title: "goodbye", Content: "hello", Locale: DefaultLocale, Test: true})
+	require.NoError(t, err)
+	assert.Equal(t, "[Test] Message from John: goodbye", rendered.Subject)
+	assert.Contains(t, rendered.Text, "test copy")
+}
+
+func TestTemplateDirOverride(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "death.txt"), []byte("overridden {{.Message}}"), 0o644))
+
+	e, err := NewEmailService(nil, "from@google.com", "Epilogue", dir)
+	require.NoError(t, err)
+	rendered := renderDeathEmail(t, e, DefaultLocale, "message")
+	assert.Contains(t, rendered, "overridden message")
+	assert.Contains(t, rendered, "Sent by Epilogue", "templates that aren't overridden should fall back to the embedded ones")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "death.txt"), []byte("reloaded {{.Message}}"), 0o644))
+	require.NoError(t, e.ReloadTemplates())
+	assert.Contains(t, renderDeathEmail(t, e, DefaultLocale, "message"), "reloaded message")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "death.txt"), []byte("broken {{.Message"), 0o644))
+	assert.Error(t, e.ReloadTemplates())
+	assert.Contains(t, renderDeathEmail(t, e, DefaultLocale, "message"), "reloaded message", "the previous templates should be kept when reloading fails")
+
+	_, err = NewEmailService(nil, "from@google.com", "Epilogue", filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,41 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type TrustedContact struct {
+	Name  string
+	Email string
+}
+
+// asks a trusted contact to confirm or veto the death of userName before their last messages are released
+func (e *EmailService) SendTrustedContactEmail(ctx context.Context, contact TrustedContact, userName string, confirmURL string, vetoURL string, deadline time.Time) error {
+	msg, err := e.newMsg(fmt.Sprintf("Please confirm what happened to %s", userName), contact.Email)
+	if err != nil {
+		return err
+	}
+
+	templateData := struct {
+		ContactName string
+		Email       string
+		UserName    string
+		ConfirmURL  string
+		VetoURL     string
+		Deadline    string
+	}{
+		ContactName: contact.Name,
+		Email:       contact.Email,
+		UserName:    userName,
+		ConfirmURL:  confirmURL,
+		VetoURL:     vetoURL,
+		Deadline:    deadline.UTC().Format(time.RFC1123),
+	}
+
+	if err := e.setBody(msg, tplTrustedContact, DefaultLocale, templateData); err != nil {
+		return err
+	}
+	return e.client.DialAndSendWithContext(ctx, msg)
+}
@@ -1,8 +1,39 @@
 package email
 
-import "net/mail"
+import (
+	"net/mail"
+	"strings"
+)
 
 func Validate(email string) bool {
 	_, err := mail.ParseAddress(email)
 	return err == nil
 }
+
+// parses the address and returns only the address itself, lowercased, so addresses that only differ in case, a display
+// name or surrounding whitespace are equal and can be de-duplicated. ok is false if the address is invalid.
+func Normalize(email string) (normalized string, ok bool) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(addr.Address), true
+}
+
+// validates and normalizes the addresses and removes duplicates. ok is false if any of them is invalid.
+func NormalizeList(emails []string) (normalized []string, ok bool) {
+	normalized = make([]string, 0, len(emails))
+	seen := make(map[string]struct{}, len(emails))
+	for _, email := range emails {
+		email, ok := Normalize(email)
+		if !ok {
+			return nil, false
+		}
+		if _, ok := seen[email]; ok {
+			continue
+		}
+		seen[email] = struct{}{}
+		normalized = append(normalized, email)
+	}
+	return normalized, true
+}
@@ -0,0 +1,43 @@
+package email_test
+
+import (
+	"testing"
+
+	"github.com/gragorther/epigo/email"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeList(t *testing.T) {
+	table := map[string]struct {
+		Input  []string
+		Want   []string
+		WantOK bool
+	}{
+		"duplicates": {
+			Input:  []string{"Test@Google.com", " test@google.com", "other@google.com"},
+			Want:   []string{"test@google.com", "other@google.com"},
+			WantOK: true,
+		},
+		"display names": {
+			Input:  []string{"Test <Test@Google.com>", "test@google.com"},
+			Want:   []string{"test@google.com"},
+			WantOK: true,
+		},
+		"invalid": {
+			Input: []string{"test@google.com", "notanemail"},
+		},
+		"empty": {
+			Input:  nil,
+			Want:   []string{},
+			WantOK: true,
+		},
+	}
+
+	for name, test := range table {
+		t.Run(name, func(t *testing.T) {
+			got, ok := email.NormalizeList(test.Input)
+			assert.Equal(t, test.WantOK, ok)
+			assert.Equal(t, test.Want, got)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Package envelope encrypts data at rest with envelope encryption: every record gets its own AES-256-GCM data key,
+// which is stored next to the record, wrapped by a master key that only lives in the config.
+//
+// Master keys are versioned, so they can be rotated by adding a new one and rewrapping the data keys, without
+// touching the encrypted data.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the length of master and data keys, for AES-256
+const KeySize = 32
+
+var (
+	ErrNoKeys          = errors.New("envelope: no master keys")
+	ErrUnknownVersion  = errors.New("envelope: unknown master key version")
+	ErrInvalidKey      = errors.New("envelope: keys must be 32 bytes long")
+	ErrInvalidKeySpec  = errors.New("envelope: master keys must look like <version>:<base64 key>")
+	ErrDuplicateKey    = errors.New("envelope: duplicate master key version")
+	ErrCiphertextShort = errors.New("envelope: ciphertext too short")
+)
+
+// the master keys, by version. The highest version wraps new data keys, the others are only kept to unwrap old ones.
+type Keys struct {
+	current uint
+	aeads   map[uint]cipher.AEAD
+}
+
+func NewKeys(masterKeys map[uint][]byte) (*Keys, error) {
+	if len(masterKeys) == 0 {
+		return nil, ErrNoKeys
+	}
+	keys := &Keys{aeads: make(map[uint]cipher.AEAD, len(masterKeys))}
+	for version, key := range masterKeys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("master key version %d: %w", version, err)
+		}
+		keys.aeads[version] = aead
+		keys.current = max(keys.current, version)
+	}
+	return keys, nil
+}
+
+// parses master keys in the <version>:<base64 key> format, e.g. from an environment variable
+func ParseKeys(specs []string) (*Keys, error) {
+	masterKeys := make(map[uint][]byte, len(specs))
+	for _, spec := range specs {
+		versionString, encoded, ok := strings.Cut(strings.TrimSpace(spec), ":")
+		if !ok {
+			return nil, ErrInvalidKeySpec
+		}
+		version, err := strconv.ParseUint(versionString, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidKeySpec, err)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidKeySpec, err)
+		}
+		if _, ok := masterKeys[uint(version)]; ok {
+			return nil, ErrDuplicateKey
+		}
+		masterKeys[uint(version)] = key
+	}
+	return NewKeys(masterKeys)
+}
+
+// the version of the master key new data keys are wrapped with
+func (k *Keys) CurrentVersion() uint {
+	return k.current
+}
+
+// creates a data key for a new record. Store the wrapped key and the version with the record.
+func (k *Keys) GenerateDataKey() (dataKey DataKey, wrapped []byte, version uint, err error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return DataKey{}, nil, 0, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return DataKey{}, nil, 0, err
+	}
+	wrapped, err = seal(k.aeads[k.current], key, versionAD(k.current))
+	if err != nil {
+		return DataKey{}, nil, 0, err
+	}
+	return DataKey{aead: aead}, wrapped, k.current, nil
+}
+
+func (k *Keys) UnwrapDataKey(wrapped []byte, version uint) (DataKey, error) {
+	key, err := k.unwrap(wrapped, version)
+	if err != nil {
+		return DataKey{}, err
+	}
+	return NewDataKey(key)
+}
+
+// wraps a data key with the current master key, so the old master key can be dropped. Data encrypted with the data key
+// stays readable.
+func (k *Keys) RewrapDataKey(wrapped []byte, version uint) (rewrapped []byte, newVersion uint, err error) {
+	key, err := k.unwrap(wrapped, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	rewrapped, err = seal(k.aeads[k.current], key, versionAD(k.current))
+	return rewrapped, k.current, err
+}
+
+func (k *Keys) unwrap(wrapped []byte, version uint) ([]byte, error) {
+	aead, ok := k.aeads[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return open(aead, wrapped, versionAD(version))
+}
+
+// the key a single record is encrypted with
+type DataKey struct {
+	aead cipher.AEAD
+}
+
+// a data key from raw key bytes that aren't wrapped by a master key, e.g. because they're split between people instead
+func NewDataKey(key []byte) (DataKey, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return DataKey{}, err
+	}
+	return DataKey{aead: aead}, nil
+}
+
+// encrypts plaintext. additionalData isn't encrypted but has to match when decrypting, so use it to bind the ciphertext
+// to where it's stored, e.g. the column name.
+func (k DataKey) Seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+	return seal(k.aead, plaintext, additionalData)
+}
+
+func (k DataKey) Open(ciphertext []byte, additionalData []byte) ([]byte, error) {
+	return open(k.aead, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// the nonce is random and put in front of the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrCiphertextShort
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// binds a wrapped data key to the version of the master key that wrapped it
+func versionAD(version uint) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(version))
+}
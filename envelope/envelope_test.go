@@ -0,0 +1,81 @@
+package envelope_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/gragorther/epigo/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func masterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, envelope.KeySize)
+}
+
+func TestSealAndOpen(t *testing.T) {
+	keys, err := envelope.NewKeys(map[uint][]byte{1: masterKey(1)})
+	require.NoError(t, err)
+
+	dataKey, wrapped, version, err := keys.GenerateDataKey()
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), version)
+	ciphertext, err := dataKey.Seal([]byte("last words"), []byte("content"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "last words")
+
+	unwrapped, err := keys.UnwrapDataKey(wrapped, version)
+	require.NoError(t, err)
+	plaintext, err := unwrapped.Open(ciphertext, []byte("content"))
+	require.NoError(t, err)
+	assert.Equal(t, "last words", string(plaintext))
+
+	_, err = unwrapped.Open(ciphertext, []byte("title"))
+	assert.Error(t, err, "ciphertexts shouldn't open with different additional data")
+	_, err = keys.UnwrapDataKey(wrapped, 2)
+	assert.ErrorIs(t, err, envelope.ErrUnknownVersion)
+}
+
+func TestRewrapDataKey(t *testing.T) {
+	oldKeys, err := envelope.NewKeys(map[uint][]byte{1: masterKey(1)})
+	require.NoError(t, err)
+	dataKey, wrapped, version, err := oldKeys.GenerateDataKey()
+	require.NoError(t, err)
+	ciphertext, err := dataKey.Seal([]byte("last words"), nil)
+	require.NoError(t, err)
+
+	rotatedKeys, err := envelope.NewKeys(map[uint][]byte{1: masterKey(1), 2: masterKey(2)})
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), rotatedKeys.CurrentVersion(), "the highest version should be the current one")
+	rewrapped, newVersion, err := rotatedKeys.RewrapDataKey(wrapped, version)
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), newVersion)
+
+	newKeys, err := envelope.NewKeys(map[uint][]byte{2: masterKey(2)})
+	require.NoError(t, err)
+	unwrapped, err := newKeys.UnwrapDataKey(rewrapped, newVersion)
+	require.NoError(t, err, "the old master key shouldn't be needed after rewrapping")
+	plaintext, err := unwrapped.Open(ciphertext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "last words", string(plaintext))
+
+	_, err = newKeys.UnwrapDataKey(rewrapped, 1)
+	assert.Error(t, err, "wrapped keys should be bound to their version")
+}
+
+func TestParseKeys(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(masterKey(1))
+	keys, err := envelope.ParseKeys([]string{"1:" + encoded, " 3:" + encoded})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), keys.CurrentVersion())
+
+	_, err = envelope.ParseKeys(nil)
+	assert.ErrorIs(t, err, envelope.ErrNoKeys)
+	_, err = envelope.ParseKeys([]string{encoded})
+	assert.ErrorIs(t, err, envelope.ErrInvalidKeySpec)
+	_, err = envelope.ParseKeys([]string{"1:" + encoded, "1:" + encoded})
+	assert.ErrorIs(t, err, envelope.ErrDuplicateKey)
+	_, err = envelope.ParseKeys([]string{"1:" + base64.StdEncoding.EncodeToString([]byte("short"))})
+	assert.ErrorIs(t, err, envelope.ErrInvalidKey)
+}
@@ -0,0 +1,320 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/handlers/confirmpage"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/notify"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/guregu/null/v6"
+)
+
+var ErrGroupIDsRequired = errors.New("channels that get last messages need the groupIDs whose messages they get")
+
+type AddChannelInput struct {
+	// one of "email", "webhook" or "gotify"
+	Kind notify.Kind `json:"kind" binding:"required"`
+	// the email address for email channels, otherwise the URL
+	Target string `json:"target" binding:"required"`
+	// the signing secret of webhooks or the app token of gotify channels
+	Secret       null.String `json:"secret"`
+	LifeStatus   bool        `json:"lifeStatus"`
+	LastMessages bool        `json:"lastMessages"`
+	// the groups whose last messages the channel gets. Needed for lastMessages.
+	GroupIDs []uint `json:"groupIDs"`
+}
+
+// returns the normalized target, or ok == false if it's not valid for the kind of channel. URLs of hosts that resolve
+// to addresses that aren't public are only refused when they're used (see notify.NewHTTPClient), since what they
+// resolve to can change; the ones that obviously aren't public are refused here already.
+func validateTarget(kind notify.Kind, target string) (normalized string, ok bool) {
+	if kind == notify.KindEmail {
+		return email.Normalize(target)
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "", false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !notify.IsPublicAddr(addr) {
+		return "", false
+	}
+	return u.String(), true
+}
+
+func Add(db interface {
+	UserAuthorizationForGroups(ctx context.Context, groupIDs []uint, userID uint) (bool, error)
+}, queue interface {
+	CreateNotificationChannel(channel dbHandler.CreateNotificationChannel) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input AddChannelInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind add notification channel JSON: %w", err))
+			return
+		}
+		if !slices.Contains(notify.Kinds, input.Kind) {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+		target, ok := validateTarget(input.Kind, input.Target)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+		// gotify rejects messages without an app token
+		if input.Kind == notify.KindGotify && input.Secret.String == "" {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+		if input.LastMessages && len(input.GroupIDs) == 0 {
+			c.AbortWithError(http.StatusUnprocessableEntity, ErrGroupIDsRequired)
+			return
+		}
+		authorized, err := db.UserAuthorizationForGroups(c, input.GroupIDs, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for groups: %w", err))
+			return
+		}
+		if !authorized {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if err := queue.CreateNotificationChannel(dbHandler.CreateNotificationChannel{
+			UserID:       userID,
+			Kind:         string(input.Kind),
+			Target:       target,
+			Secret:       input.Secret,
+			LifeStatus:   input.LifeStatus,
+			LastMessages: input.LastMessages,
+			GroupIDs:     input.GroupIDs,
+		}); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create notification channel: %w", err))
+			return
+		}
+	}
+}
+
+func List(db interface {
+	NotificationChannelsByUserID(ctx context.Context, userID uint) (channels []dbHandler.NotificationChannel, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		channels, err := db.NotificationChannelsByUserID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get notification channels by user ID: %w", err))
+			return
+		}
+		if channels == nil {
+			channels = []dbHandler.NotificationChannel{}
+		}
+		c.JSON(http.StatusOK, channels)
+	}
+}
+
+type channelAuthorizer interface {
+	UserAuthorizationForNotificationChannel(ctx context.Context, channelID uint, userID uint) (authorized bool, err error)
+}
+
+// returns the ID of the channel in the URL if it belongs to the user, otherwise it aborts the request
+func authorizedChannelID(c *gin.Context, db channelAuthorizer) (channelID uint, ok bool) {
+	userID, err := ginctx.GetUserID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return 0, false
+	}
+	channelID, err = ginctx.GetID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return 0, false
+	}
+	authorized, err := db.UserAuthorizationForNotificationChannel(c, channelID, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for notification channel: %w", err))
+		return 0, false
+	}
+	if !authorized {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return 0, false
+	}
+	return channelID, true
+}
+
+func Delete(db channelAuthorizer, queue interface {
+	DeleteNotificationChannelByID(id uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, ok := authorizedChannelID(c, db)
+		if !ok {
+			return
+		}
+		if err := queue.DeleteNotificationChannelByID(channelID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to delete notification channel: %w", err))
+			return
+		}
+	}
+}
+
+var (
+	// the test notifications go to any target the user added, so they could be used to flood it
+	ErrChannelTestedRecently = errors.New("the channel was already sent a test notification or confirmation email recently")
+	ErrChannelUnsubscribed   = errors.New("the address of the channel unsubscribed from your notifications")
+)
+
+// sends a test notification, so the user can check the channel works. Channels get at most one test per
+// minDurationBetweenEmails. Email channels that weren't confirmed yet are sent the confirmation email again instead.
+func Test(db interface {
+	channelAuthorizer
+	NotificationChannelByID(ctx context.Context, id uint) (channel dbHandler.NotificationChannel, err error)
+	ClaimNotificationChannelOptIn(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error)
+	ClaimNotificationChannelTest(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error)
+	UserByID(ctx context.Context, id uint) (dbHandler.User, error)
+}, queue interface {
+	NotifyChannel(channelID uint, notification notify.Notification) error
+	SendChannelOptIn(channelID uint, email string, userName string) error
+}, minDurationBetweenEmails time.Duration,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, ok := authorizedChannelID(c, db)
+		if !ok {
+			return
+		}
+		channel, err := db.NotificationChannelByID(c, channelID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get notification channel by ID: %w", err))
+			return
+		}
+		if channel.UnsubscribedAt.Valid {
+			c.AbortWithError(http.StatusConflict, ErrChannelUnsubscribed)
+			return
+		}
+
+		if !channel.ConfirmedAt.Valid {
+			claimed, err := db.ClaimNotificationChannelOptIn(c, channelID, minDurationBetweenEmails)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to claim notification channel opt-in: %w", err))
+				return
+			}
+			if !claimed {
+				c.AbortWithError(http.StatusTooManyRequests, ErrChannelTestedRecently)
+				return
+			}
+			userID, err := ginctx.GetUserID(c)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			user, err := db.UserByID(c, userID)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get user by ID: %w", err))
+				return
+			}
+			if err := queue.SendChannelOptIn(channelID, channel.Target, user.Name.ValueOr(user.Username)); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to send channel opt-in: %w", err))
+				return
+			}
+			c.Status(http.StatusAccepted)
+			return
+		}
+
+		claimed, err := db.ClaimNotificationChannelTest(c, channelID, minDurationBetweenEmails)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to claim notification channel test: %w", err))
+			return
+		}
+		if !claimed {
+			c.AbortWithError(http.StatusTooManyRequests, ErrChannelTestedRecently)
+			return
+		}
+		if err := queue.NotifyChannel(channelID, notify.Notification{
+			Event:   notify.EventTest,
+			Title:   "Test notification",
+			Message: "This channel will get your notifications from Epilogue.",
+		}); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to send test notification: %w", err))
+			return
+		}
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// the token is bound to the address the confirmation email went to, so it stops working once the target is changed
+var ErrChannelEmailChanged = errors.New("channel doesn't exist anymore or its email changed")
+
+type optInDB interface {
+	ConfirmNotificationChannel(ctx context.Context, id uint, email string) (confirmed bool, err error)
+	UnsubscribeNotificationChannel(ctx context.Context, id uint, email string) (unsubscribed bool, err error)
+}
+
+func optIn(parseChannelOptInToken tokens.ParseChannelOptInFunc, update func(ctx context.Context, id uint, email string) (bool, error), doneText string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		channelID, email, err := parseChannelOptInToken(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse channel opt-in token: %w", err))
+			return
+		}
+		updated, err := update(c, channelID, email)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to update notification channel: %w", err))
+			return
+		}
+		if !updated {
+			c.AbortWithError(http.StatusNotFound, ErrChannelEmailChanged)
+			return
+		}
+		confirmpage.Done(c, "Thank you", doneText)
+	}
+}
+
+// the page the confirm link in the confirmation email opens. Only the form on it confirms.
+func AskConfirm() gin.HandlerFunc {
+	return confirmpage.Ask("Receive notifications", "Confirm below that you want to receive their notifications.", "Confirm")
+}
+
+// the page the unsubscribe links in the emails to the channel open. Only the form on it unsubscribes.
+func AskUnsubscribe() gin.HandlerFunc {
+	return confirmpage.Ask("Unsubscribe", "If you unsubscribe, you won't get any of their notifications, including their last messages.", "Unsubscribe")
+}
+
+// confirms the email channel. Takes a `token` query parameter, which is the channel opt-in JWT token
+func Confirm(db optInDB, parseChannelOptInToken tokens.ParseChannelOptInFunc) gin.HandlerFunc {
+	return optIn(parseChannelOptInToken, db.ConfirmNotificationChannel, "You'll receive their notifications.")
+}
+
+// unsubscribes the email channel. It never gets any notifications or confirmation emails again.
+func Unsubscribe(db optInDB, parseChannelOptInToken tokens.ParseChannelOptInFunc) gin.HandlerFunc {
+	return optIn(parseChannelOptInToken, db.UnsubscribeNotificationChannel, "You were unsubscribed and won't get any of their notifications.")
+}
@@ -0,0 +1,53 @@
+// Package confirmpage renders the pages the links in emails open. Opening a link only shows a page with a button, which
+// posts back to the same URL, token included, to do what the link is for. Mail scanners and link previews open links
+// too, so a link alone must never change anything.
+package confirmpage
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed page.html
+var pageHTML string
+
+var pageTemplate = template.Must(template.New("page").Parse(pageHTML))
+
+type page struct {
+	Title  string
+	Text   string
+	Button string
+}
+
+func render(c *gin.Context, status int, p page) {
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	_ = pageTemplate.Execute(c.Writer, p)
+}
+
+// asks to confirm the action with a button that posts to the same URL. It needs a `token` query parameter, so broken
+// links don't get a button that can only fail.
+func Ask(title string, text string, button string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("token") == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		Prompt(c, title, text, button)
+	}
+}
+
+// renders the page Ask does, for handlers whose texts depend on the request, e.g. on the locale of the user the link is for
+func Prompt(c *gin.Context, title string, text string, button string) {
+	render(c, http.StatusOK, page{Title: title, Text: text, Button: button})
+}
+
+// tells the user that the action went through
+func Done(c *gin.Context, title string, text string) {
+	render(c, http.StatusOK, page{Title: title, Text: text})
+}
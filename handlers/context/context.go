@@ -34,8 +34,17 @@ func GetUserID(c *gin.Context) (uint, error) {
 	return currentUser, err
 }
 
+// the ID of the session the request was authenticated with
+func GetSessionID(c *gin.Context) (uint, error) {
+	return Get[uint](middlewares.CurrentSession, c)
+}
+
 func GetID(c *gin.Context) (uint, error) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, strconv.IntSize)
+	return GetUintParam(c, "id")
+}
+
+func GetUintParam(c *gin.Context, key string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(key), 10, strconv.IntSize)
 	if err != nil {
 		return uint(0), fmt.Errorf("failed to parse uint: %w", err)
 	}
@@ -46,3 +55,18 @@ func GetID(c *gin.Context) (uint, error) {
 func SetUserID(c *gin.Context, id uint) {
 	c.Set(middlewares.CurrentUser, id)
 }
+
+// aborts the request with err and leaves the response to middlewares.ErrorHandler, which picks the status from err
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// the header paginated lists put the cursor of their next page in. It's left out on the last page.
+const NextCursorHeader = "X-Next-Cursor"
+
+func SetNextCursor(c *gin.Context, cursor string) {
+	if cursor != "" {
+		c.Header(NextCursorHeader, cursor)
+	}
+}
@@ -2,14 +2,17 @@ package groups
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
 	ginctx "github.com/gragorther/epigo/handlers/context"
 	"github.com/guregu/null/v6"
+	"github.com/samber/lo"
 )
 
 type Recipient struct {
@@ -19,8 +22,10 @@ type Recipient struct {
 type AddGroupInput struct {
 	Name           string      `json:"name" binding:"required"`
 	Description    null.String `json:"description"`
-	Recipients     []Recipient
-	LastMessageIDs []uint `json:"lastMessageIDs"`
+	Recipients     []Recipient `json:"recipients"`
+	LastMessageIDs []uint      `json:"lastMessageIDs"`
+	// the locale recipients get their emails in. Defaults to the user's locale.
+	Locale null.String `json:"locale"`
 }
 
 func Add(queue interface {
@@ -45,7 +50,18 @@ func Add(queue interface {
 			return
 		}
 
-		err = queue.CreateGroup(dbHandler.CreateGroup{UserID: userID, Name: input.Name, Description: input.Description, LastMessageIDs: input.LastMessageIDs})
+		if input.Locale.Valid && !email.IsSupportedLocale(email.Locale(input.Locale.String)) {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		recipientEmails, ok := email.NormalizeList(lo.Map(input.Recipients, func(item Recipient, _ int) string { return item.Email }))
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		err = queue.CreateGroup(dbHandler.CreateGroup{UserID: userID, Name: input.Name, Description: input.Description, LastMessageIDs: input.LastMessageIDs, RecipientEmails: recipientEmails, Locale: input.Locale})
 		if err != nil {
 
 			c.AbortWithError(http.StatusInternalServerError, err)
@@ -92,8 +108,17 @@ func Delete(db interface {
 	}
 }
 
+type ListGroupsInput struct {
+	Limit uint `form:"limit" binding:"max=100"`
+	// from the X-Next-Cursor header of the previous page
+	Cursor string `form:"cursor"`
+	// only groups whose name contains it
+	Search string `form:"search" binding:"max=200"`
+	Sort   string `form:"sort" binding:"omitempty,oneof=id -id name -name"`
+}
+
 func List(db interface {
-	GroupsByUserID(ctx context.Context, userID uint) (groups []dbHandler.Group, err error)
+	GroupsPageByUserID(ctx context.Context, userID uint, opts dbHandler.ListOptions) (groups []dbHandler.Group, nextCursor string, err error)
 },
 ) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -102,26 +127,69 @@ func List(db interface {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
+		var input ListGroupsInput
+		if err := c.ShouldBindQuery(&input); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind list groups query: %w", err))
+			return
+		}
+
+		groups, nextCursor, err := db.GroupsPageByUserID(c, userID, dbHandler.ListOptions{Limit: input.Limit, Cursor: input.Cursor, Search: input.Search, Sort: input.Sort})
+		if errors.Is(err, dbHandler.ErrInvalidCursor) {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to find groups by user ID during ListGroups: %w", err))
+			return
+		}
+		if groups == nil {
+			groups = []dbHandler.Group{}
+		}
+
+		ginctx.SetNextCursor(c, nextCursor)
+		c.JSON(http.StatusOK, groups)
+	}
+}
 
-		groups, err := db.GroupsByUserID(c, userID)
+// the group with its last message IDs and recipients
+func Get(db interface {
+	GroupByID(ctx context.Context, id uint) (group dbHandler.GroupByID, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to find groups and recipients by user ID during ListGroups: %w", err))
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		id, err := ginctx.GetID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
 			return
 		}
 
-		if len(groups) == 0 {
+		group, err := db.GroupByID(c, id)
+		if err != nil {
+			ginctx.Abort(c, fmt.Errorf("failed to get group by ID: %w", err))
+			return
+		}
+		if group.UserID != userID {
+			// other users' groups look like they don't exist, so their IDs can't be probed
 			c.AbortWithStatus(http.StatusNotFound)
 			return
 		}
 
-		c.JSON(http.StatusOK, groups)
+		c.JSON(http.StatusOK, group)
 	}
 }
 
 type EditGroupInput struct {
-	Name           null.String `json:"name"`
-	Description    null.String `json:"description"`
-	LastMessageIDs []uint      `json:"lastMessageIDs"`
+	Name        null.String `json:"name"`
+	Description null.String `json:"description"`
+	// only confirmed recipients get the group's last messages
+	RequireRecipientOptIn null.Bool   `json:"requireRecipientOptIn"`
+	Locale                null.String `json:"locale"`
+	LastMessageIDs        []uint      `json:"lastMessageIDs"`
 }
 
 func Edit(db interface {
@@ -142,6 +210,10 @@ func Edit(db interface {
 			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind edit group json: %w", err))
 			return
 		}
+		if input.Locale.Valid && !email.IsSupportedLocale(email.Locale(input.Locale.String)) {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
 		id, err := ginctx.GetID(c)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get ID from context while editing group: %w", err))
@@ -157,7 +229,7 @@ func Edit(db interface {
 			return
 		}
 
-		err = queue.UpdateGroup(id, dbHandler.UpdateGroup{Name: input.Name, Description: input.Description})
+		err = queue.UpdateGroup(id, dbHandler.UpdateGroup{Name: input.Name, Description: input.Description, RequireRecipientOptIn: input.RequireRecipientOptIn, Locale: input.Locale})
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
@@ -0,0 +1,20 @@
+package jwks
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gragorther/epigo/tokens"
+)
+
+// serves the public keys tokens are signed with as a JSON Web Key Set, so other services can verify them
+func JWKS(keys interface {
+	JWKS() tokens.JWKS
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// keys are rotated rarely, and verifiers fetch the set again when they see an unknown key ID
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.JSON(http.StatusOK, keys.JWKS())
+	}
+}
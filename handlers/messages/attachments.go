@@ -0,0 +1,331 @@
+package messages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gragorther/epigo/blob"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrAttachmentTooLarge = errors.New("the attachment is too large")
+	ErrInvalidFilename    = errors.New("the attachment needs a file name of at most 255 characters")
+)
+
+// room for the rest of the multipart body around the file
+const multipartOverhead = 1 << 20
+
+type lastMessageAuthorizationDB interface {
+	UserAuthorizationForLastMessage(ctx context.Context, messageID uint, userID uint) (bool, error)
+}
+
+// gets the last message ID from the `id` path param and checks that the message belongs to the current user. ok is false if the request was aborted.
+func authorizedLastMessageID(c *gin.Context, db lastMessageAuthorizationDB) (userID uint, lastMessageID uint, ok bool) {
+	userID, err := ginctx.GetUserID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return 0, 0, false
+	}
+	lastMessageID, err = ginctx.GetID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return 0, 0, false
+	}
+	authorized, err := db.UserAuthorizationForLastMessage(c, lastMessageID, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for last message: %w", err))
+		return 0, 0, false
+	}
+	if !authorized {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	return userID, lastMessageID, true
+}
+
+// gets the attachment ID from the `attachmentID` path param and checks that it belongs to the current user's last message. ok is false if the request was aborted.
+func authorizedAttachmentID(c *gin.Context, db interface {
+	UserAuthorizationForAttachment(ctx context.Context, attachmentID uint, lastMessageID uint, userID uint) (authorized bool, err error)
+},
+) (lastMessageID uint, attachmentID uint, ok bool) {
+	userID, err := ginctx.GetUserID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return 0, 0, false
+	}
+	lastMessageID, err = ginctx.GetID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return 0, 0, false
+	}
+	attachmentID, err = ginctx.GetUintParam(c, "attachmentID")
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return 0, 0, false
+	}
+	authorized, err := db.UserAuthorizationForAttachment(c, attachmentID, lastMessageID, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for attachment: %w", err))
+		return 0, 0, false
+	}
+	if !authorized {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	return lastMessageID, attachmentID, true
+}
+
+type UploadAttachmentOutput struct {
+	ID uint `json:"id"`
+}
+
+// takes the file in the `file` field of a multipart form. Files larger than maxSize, or that would take the user over
+// their quota, are rejected with 413.
+//
+// The file is encrypted with the key of its message before it goes into the blob store. Messages in the shamir release
+// mode and end-to-end encrypted messages can't have attachments.
+func UploadAttachment(db interface {
+	lastMessageAuthorizationDB
+	LastMessageSettingsByID(ctx context.Context, id uint) (settings dbHandler.LastMessageSettings, err error)
+	CreateAttachment(ctx context.Context, attachment dbHandler.CreateAttachment, quota int64) (id uint, err error)
+	SealAttachment(ctx context.Context, lastMessageID uint, blobKey string, content []byte) (ciphertext []byte, err error)
+	DeleteAttachmentByID(ctx context.Context, id uint) error
+}, blobs blob.BlobStore, maxSize int64, quota int64,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, lastMessageID, ok := authorizedLastMessageID(c, db)
+		if !ok {
+			return
+		}
+		settings, err := db.LastMessageSettingsByID(c, lastMessageID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get last message settings: %w", err))
+			return
+		}
+		if settings.ReleaseMode == dbHandler.ReleaseModeShamir {
+			c.AbortWithError(http.StatusUnprocessableEntity, ErrShamirAttachments)
+			return
+		}
+		if settings.Encrypted {
+			c.AbortWithError(http.StatusUnprocessableEntity, ErrEncryptedAttachments)
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+		fileHeader, err := c.FormFile("file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, ErrAttachmentTooLarge)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to get the attachment from the form: %w", err))
+			return
+		}
+		if fileHeader.Size > maxSize {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, ErrAttachmentTooLarge)
+			return
+		}
+		filename := filepath.Base(strings.ReplaceAll(fileHeader.Filename, "\\", "/"))
+		if filename == "." || filename == "/" || !utf8.ValidString(filename) || utf8.RuneCountInString(filename) > 255 {
+			c.AbortWithError(http.StatusUnprocessableEntity, ErrInvalidFilename)
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to open the uploaded attachment: %w", err))
+			return
+		}
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to read the uploaded attachment: %w", err))
+			return
+		}
+		contentType := fileHeader.Header.Get("Content-Type")
+		if _, _, err := mime.ParseMediaType(contentType); err != nil || contentType == "application/octet-stream" {
+			contentType = http.DetectContentType(content)
+		}
+
+		blobKey, err := blob.NewKey()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		// the attachment is recorded first, which reserves its space in the quota
+		id, err := db.CreateAttachment(c, dbHandler.CreateAttachment{
+			UserID:        userID,
+			LastMessageID: lastMessageID,
+			Filename:      filename,
+			ContentType:   contentType,
+			Size:          int64(len(content)),
+			BlobKey:       blobKey,
+		}, quota)
+		if errors.Is(err, dbHandler.ErrAttachmentQuotaExceeded) {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create attachment: %w", err))
+			return
+		}
+
+		ciphertext, err := db.SealAttachment(c, lastMessageID, blobKey, content)
+		if err == nil {
+			err = blobs.Put(c, blobKey, bytes.NewReader(ciphertext), int64(len(ciphertext)), "application/octet-stream")
+		}
+		if err != nil {
+			if deleteErr := db.DeleteAttachmentByID(c, id); deleteErr != nil {
+				err = errors.Join(err, deleteErr)
+			}
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to store attachment: %w", err))
+			return
+		}
+		c.JSON(http.StatusCreated, UploadAttachmentOutput{ID: id})
+	}
+}
+
+func ListAttachments(db interface {
+	lastMessageAuthorizationDB
+	AttachmentsByLastMessageID(ctx context.Context, lastMessageID uint) (attachments []dbHandler.Attachment, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, lastMessageID, ok := authorizedLastMessageID(c, db)
+		if !ok {
+			return
+		}
+		attachments, err := db.AttachmentsByLastMessageID(c, lastMessageID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get attachments: %w", err))
+			return
+		}
+		if attachments == nil {
+			attachments = []dbHandler.Attachment{}
+		}
+		c.JSON(http.StatusOK, attachments)
+	}
+}
+
+type attachmentOpener interface {
+	OpenAttachment(ctx context.Context, lastMessageID uint, blobKey string, ciphertext []byte) (content []byte, err error)
+}
+
+// decrypts the attachment and sends it as a download, so browsers don't render it on our origin
+func serveAttachment(c *gin.Context, db attachmentOpener, blobs blob.BlobStore, attachment dbHandler.Attachment) {
+	r, err := blobs.Get(c, attachment.BlobKey)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get the blob of attachment %d: %w", attachment.ID, err))
+		return
+	}
+	ciphertext, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to read the blob of attachment %d: %w", attachment.ID, err))
+		return
+	}
+	content, err := db.OpenAttachment(c, attachment.LastMessageID, attachment.BlobKey, ciphertext)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the message was deleted in the meantime
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "sandbox")
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, attachment.ContentType, content)
+}
+
+// lets the owner download one of their attachments
+func DownloadAttachment(db interface {
+	UserAuthorizationForAttachment(ctx context.Context, attachmentID uint, lastMessageID uint, userID uint) (authorized bool, err error)
+	AttachmentsByLastMessageID(ctx context.Context, lastMessageID uint) (attachments []dbHandler.Attachment, err error)
+	attachmentOpener
+}, blobs blob.BlobStore,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lastMessageID, attachmentID, ok := authorizedAttachmentID(c, db)
+		if !ok {
+			return
+		}
+		attachments, err := db.AttachmentsByLastMessageID(c, lastMessageID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get attachments: %w", err))
+			return
+		}
+		for _, attachment := range attachments {
+			if attachment.ID == attachmentID {
+				serveAttachment(c, db, blobs, attachment)
+				return
+			}
+		}
+		c.AbortWithStatus(http.StatusNotFound)
+	}
+}
+
+func DeleteAttachment(db interface {
+	UserAuthorizationForAttachment(ctx context.Context, attachmentID uint, lastMessageID uint, userID uint) (authorized bool, err error)
+	DeleteAttachmentByID(ctx context.Context, id uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, attachmentID, ok := authorizedAttachmentID(c, db)
+		if !ok {
+			return
+		}
+		if err := db.DeleteAttachmentByID(c, attachmentID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to delete attachment: %w", err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// the download links in death emails whose attachments were too large to send along. It takes a `token` query
+// parameter, which is the attachment access token from the link.
+func DownloadDeliveryAttachment(db interface {
+	DeliveryAttachment(ctx context.Context, deliveryID uint, attachmentID uint) (attachment dbHandler.Attachment, err error)
+	attachmentOpener
+}, blobs blob.BlobStore, parseAttachmentAccess tokens.ParseAttachmentAccessFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		deliveryID, attachmentID, err := parseAttachmentAccess(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse attachment access token: %w", err))
+			return
+		}
+		attachment, err := db.DeliveryAttachment(c, deliveryID, attachmentID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get attachment: %w", err))
+			return
+		}
+		serveAttachment(c, db, blobs, attachment)
+	}
+}
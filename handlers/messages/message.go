@@ -1,7 +1,10 @@
 package messages
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -11,10 +14,113 @@ import (
 	"github.com/guregu/null/v6"
 )
 
+var (
+	ErrContentAndEncryptedContent = errors.New("only one of content and encryptedContent can be set")
+	ErrShareThresholdRequired     = errors.New("the shamir release mode needs a shareThreshold")
+	ErrShamirEncryptedContent     = errors.New("end-to-end encrypted messages can't use the shamir release mode")
+	ErrReleaseDelayRequired       = errors.New("the after_death release rule needs releaseDelayDays")
+	ErrReleaseAtRequired          = errors.New("the on_date and yearly release rules need releaseAt")
+	ErrShamirYearly               = errors.New("messages in the shamir release mode can't be sent yearly")
+	// shamir messages are only sent as shares of their key, their attachments couldn't be unlocked with it
+	ErrShamirAttachments = errors.New("messages in the shamir release mode can't have attachments")
+	// attachments are only encrypted with the server's key, they would leak what an end-to-end encrypted message is about
+	ErrEncryptedAttachments = errors.New("end-to-end encrypted messages can't have attachments")
+	ErrRawKeyFragment       = errors.New("keyFragment has to be a key wrapped with a passphrase, described by metadata.wrap")
+)
+
+// a message body encrypted by the client. The only algorithm is AES-256-GCM, since that's what the decryption page
+// recipients get linked to supports.
+type EncryptedContentInput struct {
+	Ciphertext string `json:"ciphertext" binding:"required,base64"`
+	Algorithm  string `json:"algorithm" binding:"required,oneof=A256GCM"`
+	// the IV, and how to unwrap the key in the key fragment
+	Metadata json.RawMessage `json:"metadata"`
+	// goes after the # of the link in the death email. It has to be wrapped with a passphrase, so it needs metadata.wrap;
+	// the server must never have the key itself. Leave it empty to hand out the key some other way.
+	KeyFragment string `json:"keyFragment" binding:"omitempty,max=1024,base64rawurl"`
+}
+
+func (i *EncryptedContentInput) validate() error {
+	if i == nil || i.KeyFragment == "" {
+		return nil
+	}
+	if !i.encryptedContent().KeyWrapped() {
+		return ErrRawKeyFragment
+	}
+	return nil
+}
+
+func (i *EncryptedContentInput) encryptedContent() *dbHandler.EncryptedContent {
+	if i == nil {
+		return nil
+	}
+	return &dbHandler.EncryptedContent{
+		Ciphertext:  i.Ciphertext,
+		Algorithm:   i.Algorithm,
+		Metadata:    i.Metadata,
+		KeyFragment: null.NewString(i.KeyFragment, i.KeyFragment != ""),
+	}
+}
+
 type AddMessageInput struct {
-	Title    string      `json:"title" binding:"required"`
-	Content  null.String `json:"content"`
-	GroupIDs []uint      `json:"groupIDs"`
+	Title   string      `json:"title" binding:"required,max=200"`
+	Content null.String `json:"content"`
+	// set instead of Content for end-to-end encrypted messages
+	EncryptedContent *EncryptedContentInput `json:"encryptedContent"`
+	GroupIDs         []uint                 `json:"groupIDs"`
+	// in the shamir release mode, the key of the message is split between its recipients on release, and
+	// ShareThreshold of them have to put their shares together to read it
+	ReleaseMode    dbHandler.ReleaseMode `json:"releaseMode" binding:"omitempty,oneof=standard shamir"`
+	ShareThreshold uint                  `json:"shareThreshold" binding:"omitempty,min=2,max=255"`
+	ReleaseRuleInput
+}
+
+// when the message goes out once the user died. Without a rule it goes out right away.
+type ReleaseRuleInput struct {
+	ReleaseRule dbHandler.ReleaseRule `json:"releaseRule" binding:"omitempty,oneof=on_death after_death on_date yearly"`
+	// for the after_death rule
+	ReleaseDelayDays uint `json:"releaseDelayDays" binding:"omitempty,min=1,max=36500"`
+	// for the on_date rule, and the date whose anniversary the yearly rule sends the message on
+	ReleaseAt null.Time `json:"releaseAt"`
+}
+
+func (i ReleaseRuleInput) validate(releaseMode dbHandler.ReleaseMode) error {
+	switch i.ReleaseRule {
+	case dbHandler.ReleaseRuleAfterDeath:
+		if i.ReleaseDelayDays == 0 {
+			return ErrReleaseDelayRequired
+		}
+	case dbHandler.ReleaseRuleOnDate, dbHandler.ReleaseRuleYearly:
+		if !i.ReleaseAt.Valid {
+			return ErrReleaseAtRequired
+		}
+	}
+	if i.ReleaseRule == dbHandler.ReleaseRuleYearly && releaseMode == dbHandler.ReleaseModeShamir {
+		return ErrShamirYearly
+	}
+	return nil
+}
+
+// the settings that go with the rule; the others are cleared
+func (i ReleaseRuleInput) releaseDelayDays() null.Int32 {
+	return null.NewInt32(int32(i.ReleaseDelayDays), i.ReleaseRule == dbHandler.ReleaseRuleAfterDeath)
+}
+
+func (i ReleaseRuleInput) releaseAt() null.Time {
+	return null.NewTime(i.ReleaseAt.Time, i.ReleaseAt.Valid && (i.ReleaseRule == dbHandler.ReleaseRuleOnDate || i.ReleaseRule == dbHandler.ReleaseRuleYearly))
+}
+
+func validateReleaseMode(releaseMode dbHandler.ReleaseMode, shareThreshold uint, encryptedContent *EncryptedContentInput) error {
+	if releaseMode != dbHandler.ReleaseModeShamir {
+		return nil
+	}
+	if shareThreshold == 0 {
+		return ErrShareThresholdRequired
+	}
+	if encryptedContent != nil {
+		return ErrShamirEncryptedContent
+	}
+	return nil
 }
 
 func Add(db interface {
@@ -36,6 +142,22 @@ func Add(db interface {
 			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind json while creating last message: %w", err))
 			return
 		}
+		if input.Content.Valid && input.EncryptedContent != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, ErrContentAndEncryptedContent)
+			return
+		}
+		if err := input.EncryptedContent.validate(); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err := validateReleaseMode(input.ReleaseMode, input.ShareThreshold, input.EncryptedContent); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err := input.ReleaseRuleInput.validate(input.ReleaseMode); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
 		authorized, err := db.UserAuthorizationForGroups(c, input.GroupIDs, userID)
 		if err != nil {
 			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("check if user is authorized for groups: %w", err))
@@ -47,10 +169,16 @@ func Add(db interface {
 		}
 
 		err = queue.CreateLastMessage(dbHandler.CreateLastMessage{
-			UserID:   userID,
-			Title:    input.Title,
-			Content:  input.Content,
-			GroupIDs: input.GroupIDs,
+			UserID:           userID,
+			Title:            input.Title,
+			Content:          input.Content,
+			EncryptedContent: input.EncryptedContent.encryptedContent(),
+			GroupIDs:         input.GroupIDs,
+			ReleaseMode:      input.ReleaseMode,
+			ShareThreshold:   null.NewInt32(int32(input.ShareThreshold), input.ShareThreshold != 0),
+			ReleaseRule:      input.ReleaseRule,
+			ReleaseDelayDays: input.releaseDelayDays(),
+			ReleaseAt:        input.releaseAt(),
 		})
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create last message: %w", err))
@@ -60,8 +188,17 @@ func Add(db interface {
 	}
 }
 
+type ListMessagesInput struct {
+	Limit uint `form:"limit" binding:"max=100"`
+	// from the X-Next-Cursor header of the previous page
+	Cursor string `form:"cursor"`
+	// only messages whose title contains it
+	Search string `form:"search" binding:"max=200"`
+	Sort   string `form:"sort" binding:"omitempty,oneof=id -id title -title"`
+}
+
 func List(db interface {
-	LastMessagesByUserID(ctx context.Context, userID uint) (lastMessages []dbHandler.LastMessage, err error)
+	LastMessagesPageByUserID(ctx context.Context, userID uint, opts dbHandler.ListOptions) (lastMessages []dbHandler.LastMessage, nextCursor string, err error)
 },
 ) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -70,25 +207,98 @@ func List(db interface {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
+		var input ListMessagesInput
+		if err := c.ShouldBindQuery(&input); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind list last messages query: %w", err))
+			return
+		}
 
-		lastMessages, err := db.LastMessagesByUserID(c, userID)
+		lastMessages, nextCursor, err := db.LastMessagesPageByUserID(c, userID, dbHandler.ListOptions{Limit: input.Limit, Cursor: input.Cursor, Search: input.Search, Sort: input.Sort})
+		if errors.Is(err, dbHandler.ErrInvalidCursor) {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to find last message: %w", err))
 			return
 		}
+		if lastMessages == nil {
+			lastMessages = []dbHandler.LastMessage{}
+		}
 
+		ginctx.SetNextCursor(c, nextCursor)
 		c.JSON(http.StatusOK, lastMessages)
 	}
 }
 
+// the last message with its group IDs and recipients
+func Get(db interface {
+	LastMessageByID(ctx context.Context, id uint) (lastMessage dbHandler.LastMessageByID, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		id, err := ginctx.GetID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		lastMessage, err := db.LastMessageByID(c, id)
+		if err != nil {
+			ginctx.Abort(c, fmt.Errorf("failed to get last message by ID: %w", err))
+			return
+		}
+		if lastMessage.UserID != userID {
+			// other users' messages look like they don't exist, so their IDs can't be probed
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.JSON(http.StatusOK, lastMessage)
+	}
+}
+
 type EditMessageInput struct {
-	Title    null.String `json:"title"`
-	Content  null.String `json:"content"`
-	GroupIDs []uint      `json:"groupIDs"`
+	Title   null.String `json:"title"`
+	Content null.String `json:"content"`
+	// replaces the content. Setting Content instead turns an encrypted message back into a plain one.
+	EncryptedContent *EncryptedContentInput `json:"encryptedContent"`
+	GroupIDs         []uint                 `json:"groupIDs"`
+	ReleaseMode      dbHandler.ReleaseMode  `json:"releaseMode" binding:"omitempty,oneof=standard shamir"`
+	ShareThreshold   uint                   `json:"shareThreshold" binding:"omitempty,min=2,max=255"`
+	// replaces the release rule and its settings when releaseRule is set
+	ReleaseRuleInput
+}
+
+// checks the edit against what the message already is. The body alone isn't enough: setting the shamir release mode
+// on an end-to-end encrypted or yearly message is as invalid as setting encrypted content or the yearly release rule on
+// a shamir message, and so is setting the shamir release mode or encrypted content on a message with attachments.
+func (i EditMessageInput) validateAgainst(stored dbHandler.LastMessageSettings) error {
+	releaseMode := cmp.Or(i.ReleaseMode, stored.ReleaseMode)
+	encrypted := i.EncryptedContent != nil || (stored.Encrypted && !i.Content.Valid)
+	if releaseMode == dbHandler.ReleaseModeShamir && encrypted {
+		return ErrShamirEncryptedContent
+	}
+	if releaseMode == dbHandler.ReleaseModeShamir && cmp.Or(i.ReleaseRule, stored.ReleaseRule) == dbHandler.ReleaseRuleYearly {
+		return ErrShamirYearly
+	}
+	if releaseMode == dbHandler.ReleaseModeShamir && stored.HasAttachments {
+		return ErrShamirAttachments
+	}
+	if encrypted && stored.HasAttachments {
+		return ErrEncryptedAttachments
+	}
+	return nil
 }
 
 func Edit(db interface {
 	CanUserEditLastmessage(ctx context.Context, userID uint, messageID uint, groupIDs []uint) (authorized bool, err error)
+	LastMessageSettingsByID(ctx context.Context, id uint) (settings dbHandler.LastMessageSettings, err error)
 }, queue interface {
 	UpdateLastMessage(id uint, m dbHandler.UpdateLastMessage) error
 },
@@ -111,6 +321,22 @@ func Edit(db interface {
 			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind edit last message json: %w", err))
 			return
 		}
+		if input.Content.Valid && input.EncryptedContent != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, ErrContentAndEncryptedContent)
+			return
+		}
+		if err := input.EncryptedContent.validate(); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err := validateReleaseMode(input.ReleaseMode, input.ShareThreshold, input.EncryptedContent); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err := input.ReleaseRuleInput.validate(input.ReleaseMode); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
 		authorizedToEdit, err := db.CanUserEditLastmessage(c, userID, messageID, input.GroupIDs)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
@@ -120,11 +346,27 @@ func Edit(db interface {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		stored, err := db.LastMessageSettingsByID(c, messageID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get last message settings: %w", err))
+			return
+		}
+		// the update is applied by a task, which can't tell the user it failed
+		if err := input.validateAgainst(stored); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
 
 		err = queue.UpdateLastMessage(messageID, dbHandler.UpdateLastMessage{
-			Title:    input.Title,
-			GroupIDs: input.GroupIDs,
-			Content:  input.Content,
+			Title:            input.Title,
+			GroupIDs:         input.GroupIDs,
+			Content:          input.Content,
+			EncryptedContent: input.EncryptedContent.encryptedContent(),
+			ReleaseMode:      input.ReleaseMode,
+			ShareThreshold:   null.NewInt32(int32(input.ShareThreshold), input.ShareThreshold != 0),
+			ReleaseRule:      input.ReleaseRule,
+			ReleaseDelayDays: input.releaseDelayDays(),
+			ReleaseAt:        input.releaseAt(),
 		})
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to update last message: %w", err))
@@ -169,3 +411,28 @@ func Delete(db interface {
 		}
 	}
 }
+
+// lists what happened to each last message sent to each recipient
+func ListDeliveries(db interface {
+	DeliveriesByUserID(ctx context.Context, userID uint) (deliveries []dbHandler.Delivery, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		deliveries, err := db.DeliveriesByUserID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get deliveries by user ID: %w", err))
+			return
+		}
+		if deliveries == nil {
+			deliveries = []dbHandler.Delivery{}
+		}
+
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
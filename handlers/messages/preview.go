@@ -0,0 +1,67 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gragorther/epigo/asynq/tasks"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+)
+
+// test emails go to the owner's own inbox, but they could still be used to flood it
+var ErrTestEmailTooSoon = errors.New("a test email was already sent recently")
+
+type PreviewInput struct {
+	// also sends the owner a test copy of the email
+	SendTest bool `form:"sendTest"`
+}
+
+// renders the death email each recipient would get for the last message. Links to the message and its attachments
+// don't work in previews, since they need a delivery. Test emails are sent at most once per minDurationBetweenEmails,
+// more are rejected with 429.
+func Preview(db interface {
+	lastMessageAuthorizationDB
+	ClaimUserTestEmail(ctx context.Context, userID uint, minInterval time.Duration) (claimed bool, err error)
+}, previewLastMessage tasks.PreviewLastMessageFunc, queue interface {
+	SendLastMessagePreview(lastMessageID uint) error
+}, minDurationBetweenEmails time.Duration,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, lastMessageID, ok := authorizedLastMessageID(c, db)
+		if !ok {
+			return
+		}
+		var input PreviewInput
+		if err := c.ShouldBindQuery(&input); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind preview query: %w", err))
+			return
+		}
+
+		previews, err := previewLastMessage(c, lastMessageID)
+		if err != nil {
+			// not found if the message was deleted in the meantime
+			ginctx.Abort(c, fmt.Errorf("failed to preview last message: %w", err))
+			return
+		}
+		if input.SendTest {
+			claimed, err := db.ClaimUserTestEmail(c, userID, minDurationBetweenEmails)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to claim user test email: %w", err))
+				return
+			}
+			if !claimed {
+				c.AbortWithError(http.StatusTooManyRequests, ErrTestEmailTooSoon)
+				return
+			}
+			if err := queue.SendLastMessagePreview(lastMessageID); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to enqueue last message test email: %w", err))
+				return
+			}
+		}
+		c.JSON(http.StatusOK, previews)
+	}
+}
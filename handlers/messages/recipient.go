@@ -0,0 +1,197 @@
+package messages
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/shamir"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed view
+var viewFiles embed.FS
+
+// the pages recipients get linked to: view.html decrypts an end-to-end encrypted message in the browser with the key from
+// the link's fragment, so the key never reaches the server. shared.html lets recipients put their key shares together.
+func View(file string) gin.HandlerFunc {
+	contentType := "text/html; charset=utf-8"
+	if strings.HasSuffix(file, ".js") {
+		contentType = "text/javascript; charset=utf-8"
+	}
+	return func(c *gin.Context) {
+		content, err := viewFiles.ReadFile("view/" + file)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to read embedded view file: %w", err))
+			return
+		}
+		c.Header("Content-Security-Policy", "default-src 'none'; script-src 'self'; connect-src 'self'; style-src 'unsafe-inline'")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Data(http.StatusOK, contentType, content)
+	}
+}
+
+type EncryptedMessageOutput struct {
+	Title string `json:"title"`
+	// the name of the user who left the message
+	From       string          `json:"from"`
+	Ciphertext string          `json:"ciphertext"`
+	Algorithm  string          `json:"algorithm"`
+	Metadata   json.RawMessage `json:"metadata"`
+}
+
+// gives the recipient of a sent delivery the ciphertext of the message. It takes a `token` query parameter, which is
+// the message access token from the death email.
+func EncryptedMessage(db interface {
+	DeliveryMessageByID(ctx context.Context, id uint) (delivery dbHandler.DeliveryMessage, err error)
+}, parseMessageAccess tokens.ParseMessageAccessFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		deliveryID, err := parseMessageAccess(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse message access token: %w", err))
+			return
+		}
+
+		delivery, err := db.DeliveryMessageByID(c, deliveryID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get delivery: %w", err))
+			return
+		}
+		// the message may have been turned into a plain one after it was sent, or the delivery never went out
+		if !delivery.Status.MaybeSent() || delivery.EncryptedContent == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, EncryptedMessageOutput{
+			Title:      delivery.Title,
+			From:       delivery.UserName,
+			Ciphertext: delivery.EncryptedContent.Ciphertext,
+			Algorithm:  delivery.EncryptedContent.Algorithm,
+			Metadata:   delivery.EncryptedContent.Metadata,
+		})
+	}
+}
+
+type deliveryMessageDB interface {
+	DeliveryMessageByID(ctx context.Context, id uint) (delivery dbHandler.DeliveryMessage, err error)
+}
+
+// the sent delivery of a message whose key was split, from the token in the `token` query parameter
+func sharedDelivery(c *gin.Context, db deliveryMessageDB, parseMessageAccess tokens.ParseMessageAccessFunc) (delivery dbHandler.DeliveryMessage, ok bool) {
+	token := c.Query("token")
+	if token == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return dbHandler.DeliveryMessage{}, false
+	}
+	deliveryID, err := parseMessageAccess(token)
+	if err != nil {
+		c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse message access token: %w", err))
+		return dbHandler.DeliveryMessage{}, false
+	}
+
+	delivery, err = db.DeliveryMessageByID(c, deliveryID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return dbHandler.DeliveryMessage{}, false
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get delivery: %w", err))
+		return dbHandler.DeliveryMessage{}, false
+	}
+	if !delivery.Status.MaybeSent() || delivery.ReleaseMode != dbHandler.ReleaseModeShamir || delivery.SharedCiphertext == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return dbHandler.DeliveryMessage{}, false
+	}
+	return delivery, true
+}
+
+type SharedMessageOutput struct {
+	Title string `json:"title"`
+	// the name of the user who left the message
+	From string `json:"from"`
+	// how many shares it takes to read the message
+	ShareThreshold uint `json:"shareThreshold"`
+	ShareCount     uint `json:"shareCount"`
+}
+
+// tells the recipient of a message whose key was split how many shares it takes to read it
+func SharedMessage(db deliveryMessageDB, parseMessageAccess tokens.ParseMessageAccessFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		delivery, ok := sharedDelivery(c, db, parseMessageAccess)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, SharedMessageOutput{
+			Title:          delivery.Title,
+			From:           delivery.UserName,
+			ShareThreshold: uint(delivery.ShareThreshold.Int32),
+			ShareCount:     uint(delivery.ShareCount.Int32),
+		})
+	}
+}
+
+type UnlockSharedMessageInput struct {
+	Shares []string `json:"shares" binding:"required,min=2,max=255"`
+}
+
+type UnlockSharedMessageOutput struct {
+	Title   string `json:"title"`
+	From    string `json:"from"`
+	Content string `json:"content"`
+}
+
+// puts the key shares recipients pooled together and decrypts the message. The key and the shares are only held in
+// memory for this request.
+func UnlockSharedMessage(db deliveryMessageDB, parseMessageAccess tokens.ParseMessageAccessFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		delivery, ok := sharedDelivery(c, db, parseMessageAccess)
+		if !ok {
+			return
+		}
+		var input UnlockSharedMessageInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind unlock shared message json: %w", err))
+			return
+		}
+		shares := make([][]byte, len(input.Shares))
+		for i, encoded := range input.Shares {
+			share, err := shamir.DecodeShare(encoded)
+			if err != nil {
+				c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to decode share %d: %w", i+1, err))
+				return
+			}
+			shares[i] = share
+		}
+
+		content, err := shamir.CombineAndOpen(delivery.SharedCiphertext, shares)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, UnlockSharedMessageOutput{
+			Title:   delivery.Title,
+			From:    delivery.UserName,
+			Content: string(content),
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/middlewares"
+)
+
+// lists the earlier versions of a last message's title and content, newest first
+func ListRevisions(db interface {
+	lastMessageAuthorizationDB
+	LastMessageRevisions(ctx context.Context, lastMessageID uint) (revisions []dbHandler.LastMessageRevision, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, lastMessageID, ok := authorizedLastMessageID(c, db)
+		if !ok {
+			return
+		}
+		revisions, err := db.LastMessageRevisions(c, lastMessageID)
+		if err != nil {
+			// not found if the message was deleted in the meantime
+			ginctx.Abort(c, fmt.Errorf("failed to get last message revisions: %w", err))
+			return
+		}
+		c.JSON(http.StatusOK, revisions)
+	}
+}
+
+// sets the title and content of a last message back to those of one of its revisions. The restore is a revision of its
+// own, so it can be undone.
+func RestoreRevision(db interface {
+	lastMessageAuthorizationDB
+	RestoreLastMessageRevision(ctx context.Context, lastMessageID uint, revisionID uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, lastMessageID, ok := authorizedLastMessageID(c, db)
+		if !ok {
+			return
+		}
+		revisionID, err := ginctx.GetUintParam(c, "revisionID")
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		err = db.RestoreLastMessageRevision(c, lastMessageID, revisionID)
+		if errors.Is(err, dbHandler.ErrRevisionNotRestorable) {
+			err = &middlewares.HTTPError{Status: http.StatusConflict, Err: err}
+		}
+		if err != nil {
+			// not found if the message has no such revision
+			ginctx.Abort(c, fmt.Errorf("failed to restore last message revision: %w", err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,58 @@
+// Lets the recipients of a message whose key was split put their parts together. The part of the recipient who opened
+// the link is after the #, which browsers never send to the server. The parts are only sent when the form is submitted.
+(function () {
+  "use strict";
+
+  const el = (id) => document.getElementById(id);
+  const token = new URLSearchParams(location.search).get("token");
+
+  function fail(text) {
+    el("status").textContent = text;
+    el("status").className = "error";
+  }
+
+  async function unlock(event) {
+    event.preventDefault();
+    const shares = el("shares").value.split("\n").map((s) => s.trim()).filter((s) => s !== "");
+    const res = await fetch("shared/unlock?token=" + encodeURIComponent(token), {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ shares: shares }),
+      referrerPolicy: "no-referrer",
+    });
+    if (!res.ok) {
+      fail("The parts don't unlock the message. Check that you have enough of them and that each is complete.");
+      return;
+    }
+    const message = await res.json();
+    el("message").textContent = message.content;
+    el("message").className = "";
+    el("unlock").className = "hidden";
+    el("status").className = "hidden";
+  }
+
+  async function main() {
+    if (!token) {
+      fail("This link is incomplete. Make sure you opened the whole link from the email.");
+      return;
+    }
+    const res = await fetch("shared/info?token=" + encodeURIComponent(token), { referrerPolicy: "no-referrer" });
+    if (!res.ok) {
+      fail("The message couldn't be loaded. The link may be broken or the message may have been deleted.");
+      return;
+    }
+    const message = await res.json();
+    el("title").textContent = message.title;
+    el("from").textContent = "From " + message.from;
+    el("from").className = "";
+    el("status").textContent = "The key to this message was split between " + message.shareCount + " people. Collect the parts of "
+      + message.shareThreshold + " of you, including yours, to read it.";
+    el("shares").value = decodeURIComponent(location.hash.slice(1));
+    el("unlock").className = "";
+    el("unlock").addEventListener("submit", (event) => {
+      unlock(event).catch(() => fail("The message couldn't be unlocked. Try again later."));
+    });
+  }
+
+  main().catch(() => fail("The message couldn't be loaded. Try again later."));
+})();
@@ -0,0 +1,91 @@
+// Decrypts an end-to-end encrypted last message in the browser. The key comes from the part of the link after the #,
+// which browsers never send to the server.
+//
+// The message metadata holds the base64url encoded "iv". If it also has "wrap", the key in the link is an AES-KW
+// wrapped key, and the key to unwrap it is derived from a passphrase the recipient got from the sender:
+//   "wrap": {"kdf": "PBKDF2", "hash": "SHA-256", "salt": "<base64url>", "iterations": 600000}
+// Otherwise the recipient is asked to paste the raw AES-256-GCM key, which the server never gets, so it's never in the
+// link either.
+(function () {
+  "use strict";
+
+  const el = (id) => document.getElementById(id);
+
+  function decodeBase64(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    while (s.length % 4 !== 0) {
+      s += "=";
+    }
+    return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+  }
+
+  function fail(text) {
+    el("status").textContent = text;
+    el("status").className = "error";
+  }
+
+  async function deriveWrappingKey(passphrase, wrap) {
+    if (wrap.kdf !== "PBKDF2") {
+      throw new Error("unsupported key derivation function " + wrap.kdf);
+    }
+    const material = await crypto.subtle.importKey("raw", new TextEncoder().encode(passphrase), "PBKDF2", false, ["deriveKey"]);
+    return crypto.subtle.deriveKey(
+      { name: "PBKDF2", hash: wrap.hash || "SHA-256", salt: decodeBase64(wrap.salt), iterations: wrap.iterations },
+      material,
+      { name: "AES-KW", length: 256 },
+      false,
+      ["unwrapKey"],
+    );
+  }
+
+  async function messageKey(metadata, keyFragment, secret) {
+    if (metadata.wrap) {
+      const wrappingKey = await deriveWrappingKey(secret, metadata.wrap);
+      return crypto.subtle.unwrapKey("raw", decodeBase64(keyFragment), wrappingKey, "AES-KW", "AES-GCM", false, ["decrypt"]);
+    }
+    return crypto.subtle.importKey("raw", decodeBase64(secret), "AES-GCM", false, ["decrypt"]);
+  }
+
+  async function decrypt(message, keyFragment, secret) {
+    const metadata = message.metadata || {};
+    const key = await messageKey(metadata, keyFragment, secret);
+    const plaintext = await crypto.subtle.decrypt({ name: "AES-GCM", iv: decodeBase64(metadata.iv) }, key, decodeBase64(message.ciphertext));
+    el("message").textContent = new TextDecoder().decode(plaintext);
+    el("message").className = "";
+    el("unlock").className = "hidden";
+    el("status").className = "hidden";
+  }
+
+  async function main() {
+    const token = new URLSearchParams(location.search).get("token");
+    const keyFragment = location.hash.slice(1);
+    if (!token) {
+      fail("This link is incomplete. Make sure you opened the whole link from the email.");
+      return;
+    }
+    const res = await fetch("encrypted?token=" + encodeURIComponent(token), { referrerPolicy: "no-referrer" });
+    if (!res.ok) {
+      fail("The message couldn't be loaded. The link may be broken or the message may have been deleted.");
+      return;
+    }
+    const message = await res.json();
+    el("title").textContent = message.title;
+    el("from").textContent = "From " + message.from;
+    el("from").className = "";
+    if (message.algorithm !== "A256GCM") {
+      fail("This message was encrypted with " + message.algorithm + ", which this page can't decrypt.");
+      return;
+    }
+
+    const metadata = message.metadata || {};
+    el("unlock-label").textContent = metadata.wrap ? "Passphrase from the sender: " : "Key from the sender: ";
+    el("status").textContent = "This message is protected. Enter what the sender gave you to read it.";
+    el("unlock").className = "";
+    el("unlock").addEventListener("submit", (event) => {
+      event.preventDefault();
+      decrypt(message, keyFragment, el("secret").value).catch(() => fail("That didn't work. Check what you entered and try again."));
+    });
+  }
+
+  main().catch(() => fail("The message couldn't be decrypted. Make sure you opened the whole link from the email."));
+})();
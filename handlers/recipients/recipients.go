@@ -0,0 +1,292 @@
+package recipients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/handlers/confirmpage"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/samber/lo"
+)
+
+type groupAuthorizationDB interface {
+	UserAuthorizationForGroups(ctx context.Context, groupIDs []uint, userID uint) (bool, error)
+}
+
+// gets the group ID from the `id` path param and checks that the group belongs to the current user. ok is false if the request was aborted.
+func authorizedGroupID(c *gin.Context, db groupAuthorizationDB) (groupID uint, ok bool) {
+	userID, err := ginctx.GetUserID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return 0, false
+	}
+	groupID, err = ginctx.GetID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return 0, false
+	}
+	authorized, err := db.UserAuthorizationForGroups(c, []uint{groupID}, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for group: %w", err))
+		return 0, false
+	}
+	if !authorized {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return 0, false
+	}
+	return groupID, true
+}
+
+// gets the recipient ID from the `recipientID` path param and checks that it belongs to the current user's group. ok is false if the request was aborted.
+func authorizedRecipientID(c *gin.Context, db interface {
+	UserAuthorizationForRecipient(ctx context.Context, recipientID uint, groupID uint, userID uint) (authorized bool, err error)
+},
+) (recipientID uint, ok bool) {
+	userID, err := ginctx.GetUserID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return 0, false
+	}
+	groupID, err := ginctx.GetID(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return 0, false
+	}
+	recipientID, err = ginctx.GetUintParam(c, "recipientID")
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return 0, false
+	}
+	authorized, err := db.UserAuthorizationForRecipient(c, recipientID, groupID, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for recipient: %w", err))
+		return 0, false
+	}
+	if !authorized {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return 0, false
+	}
+	return recipientID, true
+}
+
+type Recipient struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type AddRecipientsInput struct {
+	Recipients []Recipient `json:"recipients" binding:"required,min=1,dive"`
+}
+
+func Add(db groupAuthorizationDB, queue interface {
+	CreateRecipients(groupID uint, emails []string) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID, ok := authorizedGroupID(c, db)
+		if !ok {
+			return
+		}
+		var input AddRecipientsInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind add recipients JSON: %w", err))
+			return
+		}
+		emails, ok := email.NormalizeList(lo.Map(input.Recipients, func(item Recipient, _ int) string { return item.Email }))
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := queue.CreateRecipients(groupID, emails); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create recipients: %w", err))
+			return
+		}
+	}
+}
+
+func List(db interface {
+	groupAuthorizationDB
+	RecipientsByGroupID(ctx context.Context, groupID uint) (recipients []dbHandler.GroupRecipient, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID, ok := authorizedGroupID(c, db)
+		if !ok {
+			return
+		}
+		recipients, err := db.RecipientsByGroupID(c, groupID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get recipients by group ID: %w", err))
+			return
+		}
+		if recipients == nil {
+			recipients = []dbHandler.GroupRecipient{}
+		}
+		c.JSON(http.StatusOK, recipients)
+	}
+}
+
+func Edit(db interface {
+	UserAuthorizationForRecipient(ctx context.Context, recipientID uint, groupID uint, userID uint) (authorized bool, err error)
+}, queue interface {
+	UpdateRecipientEmail(id uint, email string) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recipientID, ok := authorizedRecipientID(c, db)
+		if !ok {
+			return
+		}
+		var input Recipient
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind edit recipient JSON: %w", err))
+			return
+		}
+		newEmail, ok := email.Normalize(input.Email)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := queue.UpdateRecipientEmail(recipientID, newEmail); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to update recipient: %w", err))
+			return
+		}
+	}
+}
+
+func Delete(db interface {
+	UserAuthorizationForRecipient(ctx context.Context, recipientID uint, groupID uint, userID uint) (authorized bool, err error)
+}, queue interface {
+	DeleteRecipientByID(id uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recipientID, ok := authorizedRecipientID(c, db)
+		if !ok {
+			return
+		}
+		if err := queue.DeleteRecipientByID(recipientID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to delete recipient: %w", err))
+			return
+		}
+	}
+}
+
+// the notices name the owner, so they could be used to flood the recipients' inboxes
+var ErrRecipientsNotifiedRecently = errors.New("the recipients who haven't responded yet were already sent a notice recently")
+
+// emails a notice to every recipient of the group who hasn't responded yet. Recipients get at most one notice per
+// minDurationBetweenEmails; if there's nobody left to send one to, it responds with 429.
+func Notify(db interface {
+	groupAuthorizationDB
+	ClaimRecipientNotices(ctx context.Context, groupID uint, minInterval time.Duration) (recipients []dbHandler.GroupRecipient, err error)
+	RecipientsByGroupID(ctx context.Context, groupID uint) (recipients []dbHandler.GroupRecipient, err error)
+	UserByID(ctx context.Context, id uint) (dbHandler.User, error)
+}, queue interface {
+	SendRecipientNotice(recipientID uint, email string, userName string) error
+}, minDurationBetweenEmails time.Duration,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID, ok := authorizedGroupID(c, db)
+		if !ok {
+			return
+		}
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		user, err := db.UserByID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get user by ID: %w", err))
+			return
+		}
+		userName := user.Name.ValueOr(user.Username)
+
+		recipients, err := db.ClaimRecipientNotices(c, groupID, minDurationBetweenEmails)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to claim recipient notices: %w", err))
+			return
+		}
+		if len(recipients) == 0 {
+			all, err := db.RecipientsByGroupID(c, groupID)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get recipients by group ID: %w", err))
+				return
+			}
+			anyPending := lo.ContainsBy(all, func(recipient dbHandler.GroupRecipient) bool {
+				return recipient.Status == dbHandler.RecipientStatusPending
+			})
+			if anyPending {
+				c.AbortWithError(http.StatusTooManyRequests, ErrRecipientsNotifiedRecently)
+				return
+			}
+		}
+		for _, recipient := range recipients {
+			if err := queue.SendRecipientNotice(recipient.ID, recipient.Email, userName); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to send recipient notice: %w", err))
+				return
+			}
+		}
+	}
+}
+
+// the token is bound to the address the notice went to, so a notice to an old address can't confirm the new one
+var ErrRecipientEmailChanged = errors.New("recipient doesn't exist anymore or their email changed")
+
+type optInDB interface {
+	SetRecipientStatusByEmail(ctx context.Context, id uint, email string, status dbHandler.RecipientStatus) (updated bool, err error)
+}
+
+func optIn(db optInDB, parseRecipientOptInToken tokens.ParseRecipientOptInFunc, status dbHandler.RecipientStatus, doneText string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		recipientID, email, err := parseRecipientOptInToken(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse recipient opt-in token: %w", err))
+			return
+		}
+		updated, err := db.SetRecipientStatusByEmail(c, recipientID, email, status)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to set recipient status: %w", err))
+			return
+		}
+		if !updated {
+			c.AbortWithError(http.StatusNotFound, ErrRecipientEmailChanged)
+			return
+		}
+		confirmpage.Done(c, "Thank you", doneText)
+	}
+}
+
+// the page the confirm link in the recipient notice opens. Only the form on it confirms.
+func AskConfirm() gin.HandlerFunc {
+	return confirmpage.Ask("Receive last messages", "Confirm below that you want to receive their last messages.", "Confirm")
+}
+
+// the page the unsubscribe link in the recipient notice opens. Only the form on it unsubscribes.
+func AskUnsubscribe() gin.HandlerFunc {
+	return confirmpage.Ask("Unsubscribe", "If you unsubscribe, you won't get any of their last messages.", "Unsubscribe")
+}
+
+// confirms the recipient. Takes a `token` query parameter, which is the recipient opt-in JWT token
+func Confirm(db optInDB, parseRecipientOptInToken tokens.ParseRecipientOptInFunc) gin.HandlerFunc {
+	return optIn(db, parseRecipientOptInToken, dbHandler.RecipientStatusConfirmed, "You'll receive their last messages.")
+}
+
+// unsubscribes the recipient. Declined recipients never get any last messages.
+func Unsubscribe(db optInDB, parseRecipientOptInToken tokens.ParseRecipientOptInFunc) gin.HandlerFunc {
+	return optIn(db, parseRecipientOptInToken, dbHandler.RecipientStatusDeclined, "You were unsubscribed and won't get any of their last messages.")
+}
@@ -0,0 +1,100 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+)
+
+type SessionOutput struct {
+	dbHandler.Session
+	// whether the request was made with this session
+	Current bool `json:"current"`
+}
+
+// lists the devices the user is logged in on
+func List(db interface {
+	SessionsByUserID(ctx context.Context, userID uint) (sessions []dbHandler.Session, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		currentSessionID, err := ginctx.GetSessionID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		sessions, err := db.SessionsByUserID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get sessions by user ID: %w", err))
+			return
+		}
+		output := make([]SessionOutput, 0, len(sessions))
+		for _, session := range sessions {
+			output = append(output, SessionOutput{Session: session, Current: session.ID == currentSessionID})
+		}
+		c.JSON(http.StatusOK, output)
+	}
+}
+
+// logs the user out on one device, which may be the one making the request
+func Delete(db interface {
+	UserAuthorizationForSession(ctx context.Context, sessionID uint, userID uint) (authorized bool, err error)
+	RevokeSessionByID(ctx context.Context, id uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		sessionID, err := ginctx.GetID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		authorized, err := db.UserAuthorizationForSession(c, sessionID, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for session: %w", err))
+			return
+		}
+		if !authorized {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		// revoked right away rather than through the queue, so a stolen device is cut off immediately
+		if err := db.RevokeSessionByID(c, sessionID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to revoke session: %w", err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// logs the user out everywhere, including the device making the request
+func DeleteAll(db interface {
+	RevokeSessionsByUserID(ctx context.Context, userID uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if err := db.RevokeSessionsByUserID(c, userID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to revoke sessions: %w", err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,280 @@
+package trustedcontacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gragorther/epigo/asynq/tasks"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/handlers/confirmpage"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/guregu/null/v6"
+)
+
+type AddTrustedContactInput struct {
+	Email string      `json:"email" binding:"required,email"`
+	Name  null.String `json:"name"`
+}
+
+func Add(queue interface {
+	CreateTrustedContact(contact dbHandler.CreateTrustedContact) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input AddTrustedContactInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind add trusted contact JSON: %w", err))
+			return
+		}
+
+		if err := queue.CreateTrustedContact(dbHandler.CreateTrustedContact{UserID: userID, Email: input.Email, Name: input.Name}); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create trusted contact: %w", err))
+			return
+		}
+	}
+}
+
+func List(db interface {
+	TrustedContactsByUserID(ctx context.Context, userID uint) (contacts []dbHandler.TrustedContact, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		contacts, err := db.TrustedContactsByUserID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get trusted contacts by user ID: %w", err))
+			return
+		}
+		if contacts == nil {
+			contacts = []dbHandler.TrustedContact{}
+		}
+		c.JSON(http.StatusOK, contacts)
+	}
+}
+
+func Delete(db interface {
+	UserAuthorizationForTrustedContact(ctx context.Context, contactID uint, userID uint) (authorized bool, err error)
+}, queue interface {
+	DeleteTrustedContactByID(id uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		contactID, err := ginctx.GetID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		authorized, err := db.UserAuthorizationForTrustedContact(c, contactID, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check user authorization for trusted contact: %w", err))
+			return
+		}
+		if !authorized {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if err := queue.DeleteTrustedContactByID(contactID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to delete trusted contact: %w", err))
+			return
+		}
+	}
+}
+
+type SetSettingsInput struct {
+	// how many trusted contacts have to confirm the death before the last messages are released
+	Quorum uint `json:"quorum" binding:"required,min=1"`
+	// a duration string like "168h". If the trusted contacts don't reach a quorum in this time, the last messages are released anyway.
+	Timeout string `json:"timeout" binding:"required"`
+}
+
+const (
+	MinTimeout = time.Hour
+	MaxTimeout = 90 * 24 * time.Hour
+)
+
+func SetSettings(queue interface {
+	SetUserTrustedContactSettings(userID uint, settings dbHandler.TrustedContactSettings) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input SetSettingsInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind trusted contact settings JSON: %w", err))
+			return
+		}
+		timeout, err := time.ParseDuration(input.Timeout)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if timeout < MinTimeout || timeout > MaxTimeout {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := queue.SetUserTrustedContactSettings(userID, dbHandler.TrustedContactSettings{Quorum: input.Quorum, Timeout: timeout}); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to set trusted contact settings: %w", err))
+			return
+		}
+	}
+}
+
+type decisionDB interface {
+	RecordTrustedContactDecision(ctx context.Context, contactID uint, userID uint, decision dbHandler.TrustedContactDecision) error
+	localeDB
+}
+
+type localeDB interface {
+	UserByID(ctx context.Context, id uint) (dbHandler.User, error)
+}
+
+// parses the `token` query parameter. ok is false if the request was aborted.
+func parseDecisionToken(c *gin.Context, parseToken tokens.ParseTrustedContactDecisionFunc) (contactID uint, userID uint, ok bool) {
+	token := c.Query("token")
+	if token == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return 0, 0, false
+	}
+	contactID, userID, err := parseToken(token)
+	if err != nil {
+		c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse trusted contact decision token: %w", err))
+		return 0, 0, false
+	}
+	return contactID, userID, true
+}
+
+// the pages trusted contacts see are in the locale of the user they're a trusted contact of, like the emails about them.
+// ok is false if the request was aborted.
+func userLocale(c *gin.Context, db localeDB, userID uint) (locale email.Locale, ok bool) {
+	user, err := db.UserByID(c, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get user by ID: %w", err))
+		return "", false
+	}
+	return email.Locale(user.Locale), true
+}
+
+// parses the `token` query parameter and records the trusted contact's decision. ok is false if the request was aborted.
+func recordDecision(c *gin.Context, db decisionDB, parseToken tokens.ParseTrustedContactDecisionFunc, decision dbHandler.TrustedContactDecision) (userID uint, locale email.Locale, ok bool) {
+	contactID, userID, ok := parseDecisionToken(c, parseToken)
+	if !ok {
+		return 0, "", false
+	}
+
+	err := db.RecordTrustedContactDecision(c, contactID, userID, decision)
+	if errors.Is(err, dbHandler.ErrNotAwaitingConfirmation) {
+		// the user checked in, was vetoed or their messages were already released
+		c.AbortWithError(http.StatusConflict, err)
+		return 0, "", false
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to record trusted contact decision: %w", err))
+		return 0, "", false
+	}
+	locale, ok = userLocale(c, db, userID)
+	return userID, locale, ok
+}
+
+// a trusted contact confirms the user's death. Once a quorum of them confirms, the last messages are released.
+func Confirm(db interface {
+	decisionDB
+	TrustedContactConfirmationsByUserID(ctx context.Context, userID uint) (confirmations dbHandler.TrustedContactConfirmations, err error)
+}, parseToken tokens.ParseTrustedContactDecisionFunc, runUserDeath tasks.RunUserDeathFunc, translate email.TranslateFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, locale, ok := recordDecision(c, db, parseToken, dbHandler.TrustedContactDecisionConfirmed)
+		if !ok {
+			return
+		}
+
+		confirmations, err := db.TrustedContactConfirmationsByUserID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get trusted contact confirmations: %w", err))
+			return
+		}
+		if confirmations.QuorumReached() {
+			// ErrNoUserDeathTask means another confirmation that reached the quorum at the same time already released them
+			if err := runUserDeath(userID); err != nil && !errors.Is(err, tasks.ErrNoUserDeathTask) {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to run user death task: %w", err))
+				return
+			}
+		}
+		confirmpage.Done(c, translate(locale, "trustedcontact.done.title"), translate(locale, "trustedcontact.confirm.done"))
+	}
+}
+
+// renders the page one of the links in the email opens, in the locale of the user the token is for
+func ask(db localeDB, parseToken tokens.ParseTrustedContactDecisionFunc, translate email.TranslateFunc, page string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, userID, ok := parseDecisionToken(c, parseToken)
+		if !ok {
+			return
+		}
+		locale, ok := userLocale(c, db, userID)
+		if !ok {
+			return
+		}
+		confirmpage.Prompt(c, translate(locale, "trustedcontact."+page+".title"), translate(locale, "trustedcontact."+page+".text"),
+			translate(locale, "trustedcontact."+page+".button"))
+	}
+}
+
+// the page the confirm link in the email opens. Only the form on it confirms the death.
+func AskConfirm(db localeDB, parseToken tokens.ParseTrustedContactDecisionFunc, translate email.TranslateFunc) gin.HandlerFunc {
+	return ask(db, parseToken, translate, "confirm")
+}
+
+// the page the veto link in the email opens. Only the form on it vetoes the release.
+func AskVeto(db localeDB, parseToken tokens.ParseTrustedContactDecisionFunc, translate email.TranslateFunc) gin.HandlerFunc {
+	return ask(db, parseToken, translate, "veto")
+}
+
+// a trusted contact vetoes the release, e.g. because the user is only in hospital
+func Veto(db interface {
+	decisionDB
+	VetoUserRelease(ctx context.Context, userID uint) error
+}, parseToken tokens.ParseTrustedContactDecisionFunc, cancelUserDeath tasks.CancelUserDeathFunc, translate email.TranslateFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, locale, ok := recordDecision(c, db, parseToken, dbHandler.TrustedContactDecisionVetoed)
+		if !ok {
+			return
+		}
+
+		if err := db.VetoUserRelease(c, userID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to veto user release: %w", err))
+			return
+		}
+		if err := cancelUserDeath(userID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to cancel user death task: %w", err))
+			return
+		}
+		confirmpage.Done(c, translate(locale, "trustedcontact.done.title"), translate(locale, "trustedcontact.veto.done"))
+	}
+}
@@ -0,0 +1,119 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gragorther/epigo/asynq/tasks"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/handlers/confirmpage"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/tokens"
+)
+
+type checkInDB interface {
+	CheckInUser(ctx context.Context, userID uint, source dbHandler.CheckInSource) (checkedInAt time.Time, err error)
+}
+
+type CheckInOutput struct {
+	CheckedInAt time.Time `json:"checkedInAt"`
+}
+
+// resets the user's dead man's switch and stops their pending death task, if there is one. ok is false if the request was aborted.
+func checkIn(c *gin.Context, db checkInDB, cancelUserDeath tasks.CancelUserDeathFunc, userID uint, source dbHandler.CheckInSource) (checkedInAt time.Time, ok bool) {
+	checkedInAt, err := db.CheckInUser(c, userID, source)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check in user: %w", err))
+		return time.Time{}, false
+	}
+	if err := cancelUserDeath(userID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to cancel user death task: %w", err))
+		return time.Time{}, false
+	}
+	return checkedInAt, true
+}
+
+// the page the links in the life status and final warning emails open. Only the form on it checks the user in.
+func AskVerifyLifeStatus() gin.HandlerFunc {
+	return confirmpage.Ask("Still alive?", "Confirm below that you're alive, so your last messages aren't sent out.", "I'm alive")
+}
+
+// checks the user in. It takes a `token` query parameter, which is the user life status JWT token
+func VerifyLifeStatus(db checkInDB, parseUserLifeStatusToken tokens.ParseUserLifeStatusFunc, cancelUserDeath tasks.CancelUserDeathFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		userID, err := parseUserLifeStatusToken(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse user life status token: %w", err))
+			return
+		}
+
+		if _, ok := checkIn(c, db, cancelUserDeath, userID, dbHandler.CheckInSourceEmail); !ok {
+			return
+		}
+		confirmpage.Done(c, "Thank you", "You're checked in. Your last messages won't be sent out.")
+	}
+}
+
+// lets a logged in user check in from the app
+func CheckIn(db checkInDB, cancelUserDeath tasks.CancelUserDeathFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		checkedInAt, ok := checkIn(c, db, cancelUserDeath, userID, dbHandler.CheckInSourceApp)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, CheckInOutput{CheckedInAt: checkedInAt})
+	}
+}
+
+type ListCheckInsInput struct {
+	Limit  uint `form:"limit" binding:"max=100"`
+	Offset uint `form:"offset"`
+}
+
+const defaultCheckInsLimit = 20
+
+func ListCheckIns(db interface {
+	CheckInsByUserID(ctx context.Context, userID uint, limit uint, offset uint) (checkIns []dbHandler.CheckIn, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input ListCheckInsInput
+		if err := c.ShouldBindQuery(&input); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind list check-ins query: %w", err))
+			return
+		}
+		if input.Limit == 0 {
+			input.Limit = defaultCheckInsLimit
+		}
+
+		checkIns, err := db.CheckInsByUserID(c, userID, input.Limit, input.Offset)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get check-ins by user ID: %w", err))
+			return
+		}
+		if checkIns == nil {
+			checkIns = []dbHandler.CheckIn{}
+		}
+
+		c.JSON(http.StatusOK, checkIns)
+	}
+}
@@ -0,0 +1,128 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gragorther/epigo/asynq/tasks"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+)
+
+type deleteAccountInput struct {
+	Password string `json:"password" binding:"required"`
+	// needed if the user has two-factor authentication enabled
+	secondFactorInput
+}
+
+type DeleteAccountOutput struct {
+	DeletionScheduledAt time.Time `json:"deletionScheduledAt"`
+}
+
+// deletes the account and everything that belongs to it. If coolingOff isn't 0, the account is only deleted
+// once it's over, and the user can cancel the deletion until then. The user's last messages aren't released while
+// the account is about to be deleted.
+func DeleteAccount(db interface {
+	reauthenticationDB
+	DeleteUser(ctx context.Context, ID uint) error
+	ScheduleUserDeletion(ctx context.Context, userID uint, deleteAt time.Time) error
+}, queue interface {
+	DeleteUserAt(userID uint, deleteAt time.Time) error
+}, cancelUserDeath tasks.CancelUserDeathFunc, comparePasswordAndHash func(password string, hash string) (match bool, err error), coolingOff time.Duration,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input deleteAccountInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		if !reauthenticate(c, db, comparePasswordAndHash, userID, input.Password, input.secondFactorInput) {
+			return
+		}
+
+		if coolingOff == 0 {
+			if err := db.DeleteUser(c, userID); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to delete user: %w", err))
+				return
+			}
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		deleteAt := time.Now().Add(coolingOff)
+		if err := db.ScheduleUserDeletion(c, userID, deleteAt); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to schedule user deletion: %w", err))
+			return
+		}
+		if err := cancelUserDeath(userID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to cancel user death: %w", err))
+			return
+		}
+		if err := queue.DeleteUserAt(userID, deleteAt); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to enqueue user deletion: %w", err))
+			return
+		}
+		c.JSON(http.StatusAccepted, DeleteAccountOutput{DeletionScheduledAt: deleteAt})
+	}
+}
+
+// keeps the account during the cooling-off period. If the user's last messages were about to be released, their
+// release is scheduled again.
+func CancelDeletion(db interface {
+	CancelUserDeletion(ctx context.Context, userID uint) (user dbHandler.KeptUser, cancelled bool, err error)
+}, queue interface {
+	RearmUserDeath(user dbHandler.KeptUser) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		user, cancelled, err := db.CancelUserDeletion(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to cancel user deletion: %w", err))
+			return
+		}
+		if !cancelled {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err := queue.RearmUserDeath(user); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to schedule user death: %w", err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// returns everything stored about the user as a JSON file
+func Export(db interface {
+	ExportUserData(ctx context.Context, userID uint) (export dbHandler.UserExport, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		export, err := db.ExportUserData(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to export user data: %w", err))
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="epilogue-export.json"`)
+		c.JSON(http.StatusOK, export)
+	}
+}
@@ -0,0 +1,145 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/handlers/confirmpage"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/tokens"
+)
+
+type changeEmailInput struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	// needed if the user has two-factor authentication enabled
+	secondFactorInput
+}
+
+// sends a confirmation link to the new address and warns the current one, which can cancel the change. The address only
+// changes once it's confirmed, since life status emails that go to a wrong address would release the user's messages.
+// Whoever controls the address can reset the password, so the change needs the password and the second factor.
+func ChangeEmail(db interface {
+	reauthenticationDB
+	CheckIfUserExistsByEmail(ctx context.Context, email string) (bool, error)
+}, queue interface {
+	SendEmailChange(userID uint, newEmail string) error
+	SendEmailChangeNotice(userID uint, newEmail string) error
+}, comparePasswordAndHash func(password string, hash string) (match bool, err error),
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input changeEmailInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		newEmail, ok := email.Normalize(input.Email)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+		if !reauthenticate(c, db, comparePasswordAndHash, userID, input.Password, input.secondFactorInput) {
+			return
+		}
+
+		exists, err := db.CheckIfUserExistsByEmail(c, newEmail)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check if user exists by email: %w", err))
+			return
+		}
+		if exists {
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+
+		if err := queue.SendEmailChangeNotice(userID, newEmail); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to enqueue email change notice: %w", err))
+			return
+		}
+		if err := queue.SendEmailChange(userID, newEmail); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to enqueue email change confirmation: %w", err))
+			return
+		}
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// the page the link in the confirmation email sent to the new address opens. Only the form on it changes the address.
+func AskConfirmEmailChange() gin.HandlerFunc {
+	return confirmpage.Ask("Confirm your new address", "Confirm below to use this address for your account. You'll be logged out everywhere.", "Confirm")
+}
+
+// changes the address and logs the user out everywhere. Takes a `token` query parameter, the email change JWT token
+func ConfirmEmailChange(db interface {
+	ChangeUserEmail(ctx context.Context, userID uint, oldEmail string, newEmail string, requestedAt time.Time) (changed bool, err error)
+}, parseEmailChange tokens.ParseEmailChangeFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		userID, oldEmail, newEmail, requestedAt, err := parseEmailChange(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse email change token: %w", err))
+			return
+		}
+
+		changed, err := db.ChangeUserEmail(c, userID, oldEmail, newEmail, requestedAt)
+		if errors.Is(err, dbHandler.ErrEmailTaken) {
+			c.AbortWithError(http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to change user email: %w", err))
+			return
+		}
+		if !changed {
+			// the address already changed since the link was sent, or the change was cancelled
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+		confirmpage.Done(c, "Address changed", "Your account now uses this address. Log in again to continue.")
+	}
+}
+
+// the page the cancel link in the notice sent to the old address opens. Only the form on it cancels.
+func AskCancelEmailChange() gin.HandlerFunc {
+	return confirmpage.Ask("Cancel the address change", "If you didn't ask to change your address, cancel the change below.", "Cancel the change")
+}
+
+// cancels the email changes asked for until now. Takes a `token` query parameter, the email change cancel JWT token
+func CancelEmailChange(db interface {
+	CancelUserEmailChanges(ctx context.Context, userID uint) error
+}, parseEmailChangeCancel tokens.ParseEmailChangeCancelFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		userID, err := parseEmailChangeCancel(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse email change cancel token: %w", err))
+			return
+		}
+		if err := db.CancelUserEmailChanges(c, userID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to cancel email changes: %w", err))
+			return
+		}
+		confirmpage.Done(c, "Change cancelled", "Your address stays the same. If you didn't ask for the change, reset your password.")
+	}
+}
@@ -0,0 +1,267 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/gragorther/epigo/totp"
+)
+
+// the issuer shown in authenticator apps
+const totpIssuer = "Epilogue"
+
+const (
+	// wrong codes in a row after which codes are refused for totpLockout. Recovery codes still work.
+	maxTOTPAttempts = 5
+	totpLockout     = 15 * time.Minute
+)
+
+type MFARequiredResponse struct {
+	MFARequired bool `json:"mfaRequired"`
+	// exchanged for a session at /user/login/mfa together with a code
+	MFAToken string `json:"mfaToken"`
+}
+
+type secondFactorInput struct {
+	// the code from the authenticator app
+	Code string `json:"code"`
+	// one of the recovery codes, if the user can't use their authenticator app
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type secondFactorDB interface {
+	TOTPByUserID(ctx context.Context, userID uint) (totp dbHandler.TOTP, err error)
+	UseTOTPStep(ctx context.Context, userID uint, step int64) (used bool, err error)
+	RecordTOTPFailure(ctx context.Context, userID uint, maxAttempts uint, lockout time.Duration) error
+	UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (used bool, err error)
+}
+
+// checks the TOTP or recovery code of a user who has two-factor authentication enabled, otherwise it aborts the request.
+// Each code can only be used once.
+func verifySecondFactor(c *gin.Context, db secondFactorDB, userID uint, input secondFactorInput) (ok bool) {
+	userTOTP, err := db.TOTPByUserID(c, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get totp by user ID: %w", err))
+		return false
+	}
+	if !userTOTP.Enabled {
+		c.AbortWithStatus(http.StatusConflict)
+		return false
+	}
+
+	if input.RecoveryCode != "" {
+		used, err := db.UseRecoveryCode(c, userID, totp.HashRecoveryCode(input.RecoveryCode))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to use recovery code: %w", err))
+			return false
+		}
+		if !used {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	if userTOTP.LockedUntil.Valid && userTOTP.LockedUntil.Time.After(time.Now()) {
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return false
+	}
+	step, valid, err := totp.Validate(userTOTP.Secret.String, input.Code, time.Now())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to validate totp code: %w", err))
+		return false
+	}
+	if !valid {
+		if err := db.RecordTOTPFailure(c, userID, maxTOTPAttempts, totpLockout); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to record totp failure: %w", err))
+			return false
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return false
+	}
+	used, err := db.UseTOTPStep(c, userID, step)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to use totp step: %w", err))
+		return false
+	}
+	if !used {
+		// the code was already used
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+type loginMFAInput struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	secondFactorInput
+}
+
+// the second step of logging in for users with two-factor authentication
+func LoginMFA(db interface {
+	secondFactorDB
+	CreateSession(ctx context.Context, session dbHandler.CreateSession) (id uint, err error)
+}, parseMFAPending tokens.ParseMFAPendingFunc, createUserAuthToken tokens.CreateUserAuthFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input loginMFAInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		userID, err := parseMFAPending(input.MFAToken)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse mfa pending token: %w", err))
+			return
+		}
+		if !verifySecondFactor(c, db, userID, input.secondFactorInput) {
+			return
+		}
+
+		response, err := startSession(c, db, userID, createUserAuthToken)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to start session: %w", err))
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+type EnrollTOTPOutput struct {
+	Secret string `json:"secret"`
+	// otpauth URI for showing as a QR code
+	URI string `json:"uri"`
+}
+
+// starts enrolling the user in TOTP. It isn't enabled until the first code is verified at /user/mfa/totp/verify.
+func EnrollTOTP(db interface {
+	UserByID(ctx context.Context, id uint) (user dbHandler.User, err error)
+	SetPendingTOTPSecret(ctx context.Context, userID uint, secret string) (set bool, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		user, err := db.UserByID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get user by ID: %w", err))
+			return
+		}
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to generate totp secret: %w", err))
+			return
+		}
+		set, err := db.SetPendingTOTPSecret(c, userID, secret)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to set pending totp secret: %w", err))
+			return
+		}
+		if !set {
+			// already enabled
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+		c.JSON(http.StatusOK, EnrollTOTPOutput{Secret: secret, URI: totp.URI(totpIssuer, user.Username, secret)})
+	}
+}
+
+type verifyTOTPInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type VerifyTOTPOutput struct {
+	// only shown once, the server just keeps their hashes
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// enables TOTP once the user has entered the first code from their authenticator app
+func VerifyTOTP(db interface {
+	TOTPByUserID(ctx context.Context, userID uint) (totp dbHandler.TOTP, err error)
+	EnableTOTP(ctx context.Context, userID uint, step int64, recoveryCodeHashes []string) (enabled bool, err error)
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input verifyTOTPInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		userTOTP, err := db.TOTPByUserID(c, userID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get totp by user ID: %w", err))
+			return
+		}
+		if userTOTP.Enabled || !userTOTP.Secret.Valid {
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+		step, valid, err := totp.Validate(userTOTP.Secret.String, input.Code, time.Now())
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to validate totp code: %w", err))
+			return
+		}
+		if !valid {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		codes, hashes, err := totp.GenerateRecoveryCodes()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to generate recovery codes: %w", err))
+			return
+		}
+		enabled, err := db.EnableTOTP(c, userID, step, hashes)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to enable totp: %w", err))
+			return
+		}
+		if !enabled {
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+		c.JSON(http.StatusOK, VerifyTOTPOutput{RecoveryCodes: codes})
+	}
+}
+
+// turns two-factor authentication off. It takes a current code, so a stolen session alone can't turn it off.
+func DisableTOTP(db interface {
+	secondFactorDB
+	DisableTOTP(ctx context.Context, userID uint) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input secondFactorInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		if !verifySecondFactor(c, db, userID, input) {
+			return
+		}
+		if err := db.DisableTOTP(c, userID); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to disable totp: %w", err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,133 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gragorther/epigo/email"
+	argon2id "github.com/gragorther/epigo/hash"
+	"github.com/gragorther/epigo/tokens"
+)
+
+type reauthenticationDB interface {
+	secondFactorDB
+	PasswordHashByUserID(ctx context.Context, userID uint) (passwordHash string, err error)
+}
+
+// checks the password of a logged in user, and their TOTP or recovery code if they have two-factor authentication
+// enabled, before they do something a stolen session mustn't be enough for. Otherwise it aborts the request.
+func reauthenticate(c *gin.Context, db reauthenticationDB, comparePasswordAndHash func(password string, hash string) (match bool, err error),
+	userID uint, password string, secondFactor secondFactorInput,
+) (ok bool) {
+	passwordHash, err := db.PasswordHashByUserID(c, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get password hash by user ID: %w", err))
+		return false
+	}
+	match, err := comparePasswordAndHash(password, passwordHash)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to compare password and hash: %w", err))
+		return false
+	}
+	if !match {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return false
+	}
+	userTOTP, err := db.TOTPByUserID(c, userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get totp by user ID: %w", err))
+		return false
+	}
+	return !userTOTP.Enabled || verifySecondFactor(c, db, userID, secondFactor)
+}
+
+type forgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// sends a password reset link to the email, at most once per minDurationBetweenEmails. It responds the same way
+// whether or not someone is registered with the address and whether or not the email is sent, so it can't be used
+// to find out who has an account.
+func ForgotPassword(db interface {
+	ClaimPasswordResetEmail(ctx context.Context, email string, minInterval time.Duration) (claimed bool, err error)
+}, queue interface {
+	SendPasswordResetEmail(email string) error
+}, minDurationBetweenEmails time.Duration,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input forgotPasswordInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		userEmail, ok := email.Normalize(input.Email)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+		claimed, err := db.ClaimPasswordResetEmail(c, userEmail, minDurationBetweenEmails)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to claim password reset email: %w", err))
+			return
+		}
+		if !claimed {
+			c.Status(http.StatusAccepted)
+			return
+		}
+		if err := queue.SendPasswordResetEmail(userEmail); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to enqueue password reset email: %w", err))
+			return
+		}
+		c.Status(http.StatusAccepted)
+	}
+}
+
+type resetPasswordInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// sets a new password using the token from the password reset email and logs the user out everywhere
+func ResetPassword(db interface {
+	PasswordHashByUserID(ctx context.Context, userID uint) (passwordHash string, err error)
+	ResetUserPassword(ctx context.Context, userID uint, oldPasswordHash string, newPasswordHash string) (reset bool, err error)
+}, createHash func(string, *argon2id.Params) (string, error), parsePasswordReset tokens.ParsePasswordResetFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input resetPasswordInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		var oldPasswordHash string
+		userID, err := parsePasswordReset(input.Token, func(userID uint) (passwordHash string, err error) {
+			oldPasswordHash, err = db.PasswordHashByUserID(c, userID)
+			return oldPasswordHash, err
+		})
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse password reset token: %w", err))
+			return
+		}
+
+		newPasswordHash, err := createHash(input.Password, argon2id.DefaultParams)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to hash password: %w", err))
+			return
+		}
+		reset, err := db.ResetUserPassword(c, userID, oldPasswordHash, newPasswordHash)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to reset password: %w", err))
+			return
+		}
+		if !reset {
+			// the password changed between parsing the token and updating it, so the token was used concurrently
+			c.AbortWithError(http.StatusUnauthorized, tokens.ErrPasswordChanged)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
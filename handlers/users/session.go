@@ -0,0 +1,86 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbHandler "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/jackc/pgx/v5"
+)
+
+// creates a session for the device the request came from and returns its tokens
+func startSession(c *gin.Context, db interface {
+	CreateSession(ctx context.Context, session dbHandler.CreateSession) (id uint, err error)
+}, userID uint, createUserAuthToken tokens.CreateUserAuthFunc,
+) (LoginResponse, error) {
+	refreshToken, refreshTokenHash, err := tokens.NewRefreshToken()
+	if err != nil {
+		return LoginResponse{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	sessionID, err := db.CreateSession(c, dbHandler.CreateSession{
+		UserID:           userID,
+		RefreshTokenHash: refreshTokenHash,
+		UserAgent:        truncate(c.Request.UserAgent(), 512),
+		IPAddress:        c.ClientIP(),
+		ExpiresAt:        time.Now().Add(tokens.RefreshTokenExpiry),
+	})
+	if err != nil {
+		return LoginResponse{}, fmt.Errorf("failed to create session: %w", err)
+	}
+	token, err := createUserAuthToken(userID, sessionID)
+	if err != nil {
+		return LoginResponse{}, fmt.Errorf("failed to generate JWT token: %w", err)
+	}
+	return LoginResponse{Token: token, RefreshToken: refreshToken, ExpiresAt: time.Now().Add(tokens.UserAuthExpiry)}, nil
+}
+
+func truncate(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen]
+}
+
+type refreshInput struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// exchanges a refresh token for a new access token and a new refresh token. Using a refresh token
+// that was already exchanged revokes the session, since that means someone else has a copy of it.
+func Refresh(db interface {
+	RotateSessionRefreshToken(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, expiresAt time.Time) (session dbHandler.SessionUser, err error)
+}, createUserAuthToken tokens.CreateUserAuthFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input refreshInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		refreshToken, refreshTokenHash, err := tokens.NewRefreshToken()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to generate refresh token: %w", err))
+			return
+		}
+		session, err := db.RotateSessionRefreshToken(c, tokens.HashRefreshToken(input.RefreshToken), refreshTokenHash, time.Now().Add(tokens.RefreshTokenExpiry))
+		if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, dbHandler.ErrRefreshTokenReused) {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to refresh session: %w", err))
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to rotate refresh token: %w", err))
+			return
+		}
+		token, err := createUserAuthToken(session.UserID, session.ID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to generate JWT token: %w", err))
+			return
+		}
+		c.JSON(http.StatusOK, LoginResponse{Token: token, RefreshToken: refreshToken, ExpiresAt: time.Now().Add(tokens.UserAuthExpiry)})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/gragorther/epigo/cron"
 	"github.com/gragorther/epigo/database/db"
 	dbHandlers "github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
 	ginctx "github.com/gragorther/epigo/handlers/context"
 	argon2id "github.com/gragorther/epigo/hash"
 	"github.com/gragorther/epigo/tokens"
@@ -21,6 +22,8 @@ type RegistrationInput struct {
 	Name     null.String `json:"name"`
 	Password string      `json:"password" binding:"required"`
 	Token    string      `json:"token" binding:"required"`
+	// if empty, it's picked from the Accept-Language header
+	Locale string `json:"locale"`
 }
 type LoginInput struct {
 	Username string `json:"username" binding:"required"`
@@ -43,6 +46,11 @@ func Register(db interface {
 			c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("failed to bind register user JSON: %w", err))
 			return
 		}
+		locale, ok := requestLocale(c, authInput.Locale)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
 		userEmail, err := parseEmailVerificationToken(authInput.Token)
 		if err != nil {
 			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse user email verification token, which was acquired from the `token` query param: %w", err))
@@ -64,7 +72,7 @@ func Register(db interface {
 			return
 		}
 
-		if err := queue.CreateUser(dbHandlers.CreateUserInput{Username: authInput.Username, Email: userEmail, Name: authInput.Name, PasswordHash: passwordHash}); err != nil {
+		if err := queue.CreateUser(dbHandlers.CreateUserInput{Username: authInput.Username, Email: userEmail, Name: authInput.Name, PasswordHash: passwordHash, Locale: string(locale)}); err != nil {
 			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create user: %w", err))
 			return
 		}
@@ -73,13 +81,20 @@ func Register(db interface {
 }
 
 type LoginResponse struct {
+	// the short-lived access token
 	Token string `json:"token"`
+	// exchanged for a new access token at /user/token/refresh. It's replaced every time it's used.
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
 }
 
 func Login(db interface {
 	UserIDAndPasswordHashByUsername(ctx context.Context, username string) (user db.UserIDAndPasswordHash, err error)
 	CheckIfUserExistsByUsername(ctx context.Context, username string) (bool, error)
+	CreateSession(ctx context.Context, session db.CreateSession) (id uint, err error)
+	TOTPByUserID(ctx context.Context, userID uint) (totp db.TOTP, err error)
 }, comparePasswordAndHash func(password string, hash string) (match bool, err error), createUserAuthToken tokens.CreateUserAuthFunc,
+	createMFAPending tokens.CreateMFAPendingFunc,
 ) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var authInput LoginInput
@@ -113,15 +128,28 @@ func Login(db interface {
 			return
 		}
 
-		token, err := createUserAuthToken(userFound.ID)
+		userTOTP, err := db.TOTPByUserID(c, userFound.ID)
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to generate JWT token: %w", err))
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get totp by user ID: %w", err))
+			return
+		}
+		if userTOTP.Enabled {
+			mfaToken, err := createMFAPending(userFound.ID)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to generate mfa pending token: %w", err))
+				return
+			}
+			c.JSON(http.StatusOK, MFARequiredResponse{MFARequired: true, MFAToken: mfaToken})
 			return
 		}
 
-		c.JSON(http.StatusOK, LoginResponse{
-			Token: token,
-		})
+		response, err := startSession(c, db, userFound.ID, createUserAuthToken)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to start session: %w", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
 	}
 }
 
@@ -156,6 +184,7 @@ type GetUserDataOutput struct {
 	Username string `json:"username,omitzero"`
 	Name     string `json:"name,omitzero"`
 	Email    string `json:"email,omitzero"`
+	Locale   string `json:"locale,omitzero"`
 }
 
 func GetData(db interface {
@@ -179,6 +208,7 @@ func GetData(db interface {
 			Username: user.Username,
 			Name:     user.Name.String,
 			Email:    user.Email,
+			Locale:   user.Locale,
 		})
 	}
 }
@@ -221,11 +251,13 @@ func SetEmailInterval(queue interface {
 
 type EmailVerificationInput struct {
 	Email string `json:"email" binding:"required,email"`
+	// the language of the verification email. If empty, it's picked from the Accept-Language header
+	Locale string `json:"locale"`
 }
 
 // here, the user enters their email, gets sent a registration link to their email, and continues registration from there
 func VerifyEmail(queue interface {
-	SendVerificationEmail(email string) error
+	SendVerificationEmail(email string, locale string) error
 }, db interface {
 	CheckIfUserExistsByEmail(ctx context.Context, email string) (bool, error)
 },
@@ -236,6 +268,11 @@ func VerifyEmail(queue interface {
 			c.AbortWithError(http.StatusUnprocessableEntity, err)
 			return
 		}
+		locale, ok := requestLocale(c, input.Locale)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
 
 		exists, err := db.CheckIfUserExistsByEmail(c, input.Email)
 		if err != nil {
@@ -246,9 +283,94 @@ func VerifyEmail(queue interface {
 			c.AbortWithStatus(http.StatusConflict)
 			return
 		}
-		if err := queue.SendVerificationEmail(input.Email); err != nil {
+		if err := queue.SendVerificationEmail(input.Email, string(locale)); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+}
+
+type setGracePeriodInput struct {
+	// a duration string like "72h"
+	GracePeriod string `json:"gracePeriod" binding:"required"`
+}
+
+const (
+	MinGracePeriod = time.Hour
+	MaxGracePeriod = 30 * 24 * time.Hour
+)
+
+// sets how long the user has to check in after missing all their life status emails before their last messages are released
+func SetGracePeriod(queue interface {
+	SetUserGracePeriod(userID uint, gracePeriod time.Duration) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var input setGracePeriodInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind json while setting user grace period: %w", err))
+			return
+		}
+		gracePeriod, err := time.ParseDuration(input.GracePeriod)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if gracePeriod < MinGracePeriod || gracePeriod > MaxGracePeriod {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := queue.SetUserGracePeriod(userID, gracePeriod); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to set user grace period: %w", err))
+			return
+		}
+	}
+}
+
+// returns the requested locale, or the best match for the Accept-Language header if none was requested.
+// ok is false if the requested locale isn't supported.
+func requestLocale(c *gin.Context, requested string) (locale email.Locale, ok bool) {
+	if requested == "" {
+		return email.MatchLocale(c.GetHeader("Accept-Language")), true
+	}
+	locale = email.Locale(requested)
+	return locale, email.IsSupportedLocale(locale)
+}
+
+type setLocaleInput struct {
+	Locale string `json:"locale" binding:"required"`
+}
+
+// sets the language of the user's emails
+func SetLocale(queue interface {
+	SetUserLocale(userID uint, locale string) error
+},
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := ginctx.GetUserID(c)
+		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
+		var input setLocaleInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind json while setting user locale: %w", err))
+			return
+		}
+		if !email.IsSupportedLocale(email.Locale(input.Locale)) {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := queue.SetUserLocale(userID, input.Locale); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to set user locale: %w", err))
+			return
+		}
 	}
 }
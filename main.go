@@ -15,10 +15,12 @@ import (
 	"github.com/gragorther/epigo/asynq/scheduler"
 	"github.com/gragorther/epigo/asynq/tasks"
 	"github.com/gragorther/epigo/asynq/workers"
+	"github.com/gragorther/epigo/blob"
 	"github.com/gragorther/epigo/config"
 	"github.com/gragorther/epigo/database/db"
 	"github.com/gragorther/epigo/database/initializers"
 	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/envelope"
 	"github.com/gragorther/epigo/logger"
 	"github.com/gragorther/epigo/router"
 	"github.com/gragorther/epigo/tokens"
@@ -27,10 +29,23 @@ import (
 
 func main() {
 	config, err := config.Get()
-	jwtSecret := []byte(config.JWTSecret)
 	if err != nil {
 		os.Exit(1)
 	}
+	keys, err := tokens.LoadKeyring([]byte(config.JWTSecret), config.JWTSigningKeyFile, config.JWTVerificationKeyFiles)
+	if err != nil {
+		log.Fatalf("failed to load JWT keys: %v", err)
+	}
+
+	messageKeys, err := envelope.ParseKeys(config.MessageEncryptionKeys)
+	if err != nil {
+		log.Fatalf("failed to load message encryption keys: %v", err)
+	}
+
+	blobs, err := newBlobStore(config.Blob)
+	if err != nil {
+		log.Fatalf("failed to set up the blob store: %v", err)
+	}
 
 	_ = logger.Configure(config.Production, os.Stdout)
 
@@ -52,7 +67,7 @@ func main() {
 		log.Fatalf("failed to migrate db: %v", err)
 	}
 
-	dbHandler := db.NewDB(dbconn)
+	dbHandler := db.NewDB(dbconn, messageKeys)
 	emailClient, err := email.NewClient(config.Email.Host, config.Email.Port, config.Email.Password, config.Email.Username)
 	if err != nil {
 		log.Fatalf("failed to run email client: %v", err)
@@ -63,15 +78,51 @@ func main() {
 		}
 	}()
 
-	createEmailVerificationToken := tokens.CreateEmailVerification(jwtSecret, config.BaseURL, config.BaseURL)
-	createUserLifeStatusToken := tokens.CreateUserLifeStatus(jwtSecret, []string{config.BaseURL}, config.BaseURL)
-	emailService := email.NewEmailService(emailClient, config.Email.From, config.Email.FromFormat)
+	createEmailVerificationToken := tokens.CreateEmailVerification(keys, config.BaseURL, config.BaseURL)
+	createUserLifeStatusToken := tokens.CreateUserLifeStatus(keys, []string{config.BaseURL}, config.BaseURL)
+	createTrustedContactDecisionToken := tokens.CreateTrustedContactDecision(keys, []string{config.BaseURL}, config.BaseURL)
+	createRecipientOptInToken := tokens.CreateRecipientOptIn(keys, []string{config.BaseURL}, config.BaseURL)
+	createChannelOptInToken := tokens.CreateChannelOptIn(keys, []string{config.BaseURL}, config.BaseURL)
+	createPasswordResetToken := tokens.CreatePasswordReset(keys, []string{config.BaseURL}, config.BaseURL)
+	createEmailChangeToken := tokens.CreateEmailChange(keys, []string{config.BaseURL}, config.BaseURL)
+	createEmailChangeCancelToken := tokens.CreateEmailChangeCancel(keys, []string{config.BaseURL}, config.BaseURL)
+	createMessageAccessToken := tokens.CreateMessageAccess(keys, []string{config.BaseURL}, config.BaseURL)
+	createAttachmentAccessToken := tokens.CreateAttachmentAccess(keys, []string{config.BaseURL}, config.BaseURL)
+	emailService, err := email.NewEmailService(emailClient, config.Email.From, config.Email.FromFormat, config.Email.TemplateDir)
+	if err != nil {
+		log.Fatalf("failed to load email templates: %v", err)
+	}
+	reloadTemplates := make(chan os.Signal, 1)
+	signal.Notify(reloadTemplates, syscall.SIGHUP)
+	go func() {
+		for range reloadTemplates {
+			if err := emailService.ReloadTemplates(); err != nil {
+				log.Printf("failed to reload email templates: %v", err)
+				continue
+			}
+			log.Println("reloaded email templates")
+		}
+	}()
+	messageViewURL := fmt.Sprintf("%s/messages/view", config.BaseURL)
+	sharedMessageURL := fmt.Sprintf("%s/messages/shared", config.BaseURL)
+	attachmentDownloadURL := fmt.Sprintf("%s/messages/attachments/download", config.BaseURL)
 	redisClientOpt := asynq.RedisClientOpt{Addr: config.Redis.Address, Username: config.Redis.Address, Password: config.Redis.Password, DB: config.Redis.DB}
-	go workers.Run(ctx, redisClientOpt, dbHandler, jwtSecret, emailService, fmt.Sprintf("%v/user/register", config.BaseURL), createEmailVerificationToken, createUserLifeStatusToken, fmt.Sprintf("%s/user/life/verify", config.BaseURL))
+	go workers.Run(ctx, redisClientOpt, dbHandler, emailService, fmt.Sprintf("%v/user/register", config.BaseURL), createEmailVerificationToken, createUserLifeStatusToken, fmt.Sprintf("%s/user/life/verify", config.BaseURL),
+		createTrustedContactDecisionToken, fmt.Sprintf("%s/trusted-contacts", config.BaseURL),
+		createRecipientOptInToken, fmt.Sprintf("%s/recipients", config.BaseURL),
+		createChannelOptInToken, fmt.Sprintf("%s/channels", config.BaseURL), config.MinDurationBetweenEmails,
+		createPasswordResetToken, fmt.Sprintf("%s/user/password/reset", config.BaseURL),
+		createEmailChangeToken, fmt.Sprintf("%s/user/email/confirm", config.BaseURL), createEmailChangeCancelToken, fmt.Sprintf("%s/user/email/cancel", config.BaseURL),
+		createMessageAccessToken, messageViewURL, sharedMessageURL,
+		blobs, createAttachmentAccessToken, attachmentDownloadURL, config.Attachments.MailLimit, config.Attachments.LinkExpiry)
 	go scheduler.Run(dbHandler, redisClientOpt)
 	asynqClient := asynq.NewClient(redisClientOpt)
+	asynqInspector := asynq.NewInspector(redisClientOpt)
 
-	r := router.Setup(dbHandler, tasks.NewQueue(tasks.EnqueueTask(asynqClient), sonic.Marshal), config.JWTSecret, tasks.EnqueueTask(asynqClient), config.BaseURL, config.MinDurationBetweenEmails)
+	r := router.Setup(dbHandler, tasks.NewQueue(tasks.EnqueueTask(asynqClient), sonic.Marshal), keys, tasks.EnqueueTask(asynqClient), config.BaseURL, config.MinDurationBetweenEmails, tasks.CancelUserDeath(asynqInspector), tasks.RunUserDeath(asynqInspector), config.AccountDeletionCoolingOff,
+		blobs, config.Attachments.MaxSize, config.Attachments.Quota,
+		tasks.PreviewLastMessage(dbHandler, emailService, messageViewURL, sharedMessageURL, attachmentDownloadURL, config.Attachments.MailLimit, config.Attachments.LinkExpiry),
+		emailService.Translate)
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -100,3 +151,21 @@ func main() {
 
 	log.Println("Server exiting")
 }
+
+func newBlobStore(conf config.BlobConfig) (blob.BlobStore, error) {
+	switch conf.Store {
+	case "local":
+		return blob.NewLocalStore(conf.LocalDir)
+	case "s3":
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:        conf.S3Endpoint,
+			Region:          conf.S3Region,
+			Bucket:          conf.S3Bucket,
+			AccessKeyID:     conf.S3AccessKeyID,
+			SecretAccessKey: conf.S3SecretAccessKey,
+			PathStyle:       conf.S3PathStyle,
+		}, &http.Client{Timeout: 5 * time.Minute})
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", conf.Store)
+	}
+}
@@ -1,18 +1,28 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gragorther/epigo/tokens"
 
 	"github.com/gin-gonic/gin"
 )
 
-const CurrentUser = "currentUser"
+const (
+	CurrentUser    = "currentUser"
+	CurrentSession = "currentSession"
+)
 
-func CheckAuth(parseUserAuthToken tokens.ParseUserAuthFunc) gin.HandlerFunc {
+// db.SessionActive reports whether the session of the token was revoked or has expired, or whether the user's
+// auth tokens were invalidated after the token was issued.
+func CheckAuth(parseUserAuthToken tokens.ParseUserAuthFunc, db interface {
+	SessionActive(ctx context.Context, sessionID uint, userID uint, issuedAt time.Time) (active bool, err error)
+},
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 
@@ -28,7 +38,7 @@ func CheckAuth(parseUserAuthToken tokens.ParseUserAuthFunc) gin.HandlerFunc {
 		}
 
 		tokenString := authToken[1]
-		userID, err := parseUserAuthToken(tokenString)
+		userID, sessionID, issuedAt, err := parseUserAuthToken(tokenString)
 		if err != nil {
 			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to parse user auth token: %w", err))
 			return
@@ -39,7 +49,18 @@ func CheckAuth(parseUserAuthToken tokens.ParseUserAuthFunc) gin.HandlerFunc {
 			return
 		}
 
+		active, err := db.SessionActive(c, sessionID, userID, issuedAt)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check if the session is active: %w", err))
+			return
+		}
+		if !active {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
 		c.Set(CurrentUser, userID)
+		c.Set(CurrentSession, sessionID)
 
 		c.Next()
 	}
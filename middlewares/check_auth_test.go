@@ -1,9 +1,12 @@
 package middlewares_test
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	ginctx "github.com/gragorther/epigo/handlers/context"
@@ -15,15 +18,38 @@ import (
 
 var (
 	JWT_SECRET         = []byte("very sercure")
-	parseUserAuthToken = tokens.ParseUserAuth(JWT_SECRET, []string{testIssuer}, testIssuer)
+	keys               = newKeyring(JWT_SECRET)
+	parseUserAuthToken = tokens.ParseUserAuth(keys, []string{testIssuer}, testIssuer)
 )
 
 const (
-	testUserID = 1
-	testIssuer = "https://issuer.com"
+	testUserID    = 1
+	testSessionID = 4
+	testIssuer    = "https://issuer.com"
 )
 
-var createUserAuth = tokens.CreateUserAuth(JWT_SECRET, []string{testIssuer}, testIssuer)
+var createUserAuth = tokens.CreateUserAuth(keys, []string{testIssuer}, testIssuer)
+
+func newKeyring(secret []byte) *tokens.Keyring {
+	key, err := tokens.NewHMACKey(secret)
+	if err != nil {
+		panic(err)
+	}
+	keyring, err := tokens.NewKeyring(key)
+	if err != nil {
+		panic(err)
+	}
+	return keyring
+}
+
+type authDB struct {
+	revoked bool
+	err     error
+}
+
+func (a authDB) SessionActive(ctx context.Context, sessionID uint, userID uint, issuedAt time.Time) (active bool, err error) {
+	return !a.revoked && sessionID == testSessionID, a.err
+}
 
 func TestCheckAuth(t *testing.T) {
 	type want struct {
@@ -33,6 +59,7 @@ func TestCheckAuth(t *testing.T) {
 	table := []struct {
 		Name   string
 		Header http.Header
+		DB     authDB
 		Want   want
 	}{
 		{Name: "valid", Want: want{
@@ -42,14 +69,23 @@ func TestCheckAuth(t *testing.T) {
 		{Name: "invalid token", Want: want{
 			Status: http.StatusUnauthorized,
 		}},
+		{Name: "revoked session", DB: authDB{revoked: true}, Want: want{
+			Status: http.StatusUnauthorized,
+		}},
+		{Name: "db error", DB: authDB{err: errors.New("connection refused")}, Want: want{
+			Status: http.StatusInternalServerError,
+		}},
 	}
 
 	{
 		require := require.New(t)
-		token, err := createUserAuth(testUserID)
+		token, err := createUserAuth(testUserID, testSessionID)
 		require.NoError(err, "creating user auth token shouldn't fail")
 
 		middlewares.SetHttpAuthHeaderToken(&table[0].Header, token)
+		for i := 2; i < len(table); i++ {
+			middlewares.SetHttpAuthHeaderToken(&table[i].Header, token)
+		}
 	}
 	{
 		table[1].Header = make(http.Header)
@@ -62,7 +98,7 @@ func TestCheckAuth(t *testing.T) {
 			gin.SetMode(gin.TestMode)
 
 			r := gin.New()
-			r.Use(middlewares.CheckAuth(parseUserAuthToken))
+			r.Use(middlewares.CheckAuth(parseUserAuthToken, test.DB))
 
 			userIDs := make(chan uint, 1)
 
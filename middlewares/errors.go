@@ -1,11 +1,46 @@
 package middlewares
 
 import (
+	"errors"
 	"log"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 )
 
+// an error that ErrorHandler responds to with Status
+type HTTPError struct {
+	Status int
+	Err    error
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status)
+	}
+	return e.Err.Error()
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// the status to respond to err with: the one of an HTTPError, 404 for rows that weren't found and 500 for everything else
+func statusOf(err error) int {
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Status
+	case errors.Is(err, pgx.ErrNoRows):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// logs the errors of the request. Handlers that abort with an error but leave the response alone (see ginctx.Abort)
+// get the status statusOf picks for the last error.
 func ErrorHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next() // Step1: Process the request first.
@@ -15,6 +50,9 @@ func ErrorHandler() gin.HandlerFunc {
 			for _, err := range c.Errors {
 				log.Print(err)
 			}
+			if !c.Writer.Written() {
+				c.AbortWithStatus(statusOf(c.Errors.Last().Err))
+			}
 		}
 
 		// Any other steps if no errors are found
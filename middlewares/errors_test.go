@@ -0,0 +1,52 @@
+package middlewares_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	ginctx "github.com/gragorther/epigo/handlers/context"
+	"github.com/gragorther/epigo/middlewares"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorHandler(t *testing.T) {
+	table := []struct {
+		Name    string
+		Handler gin.HandlerFunc
+		Want    int
+	}{
+		{Name: "no rows", Handler: func(c *gin.Context) {
+			ginctx.Abort(c, fmt.Errorf("failed to get group: %w", pgx.ErrNoRows))
+		}, Want: http.StatusNotFound},
+		{Name: "http error", Handler: func(c *gin.Context) {
+			ginctx.Abort(c, &middlewares.HTTPError{Status: http.StatusConflict, Err: errors.New("conflict")})
+		}, Want: http.StatusConflict},
+		{Name: "other error", Handler: func(c *gin.Context) {
+			ginctx.Abort(c, errors.New("connection refused"))
+		}, Want: http.StatusInternalServerError},
+		{Name: "status set by the handler", Handler: func(c *gin.Context) {
+			c.AbortWithError(http.StatusBadRequest, pgx.ErrNoRows)
+		}, Want: http.StatusBadRequest},
+		{Name: "no error", Handler: func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		}, Want: http.StatusNoContent},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(middlewares.ErrorHandler())
+			r.GET("/", test.Handler)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, test.Want, w.Code)
+		})
+	}
+}
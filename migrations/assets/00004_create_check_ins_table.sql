@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE check_ins(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+user_id integer NOT NULL references users ON DELETE CASCADE,
+source VARCHAR(20) NOT NULL,
+created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_check_ins_user_id_created_at ON check_ins(user_id, created_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_check_ins_user_id_created_at;
+DROP TABLE IF EXISTS check_ins;
+-- +goose StatementEnd
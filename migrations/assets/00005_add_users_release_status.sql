@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'alive',
+    ADD COLUMN grace_period_seconds INTEGER NOT NULL DEFAULT 259200,
+    ADD COLUMN release_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN IF EXISTS release_at,
+    DROP COLUMN IF EXISTS grace_period_seconds,
+    DROP COLUMN IF EXISTS status;
+-- +goose StatementEnd
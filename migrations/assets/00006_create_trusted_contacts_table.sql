@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE trusted_contacts(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+user_id integer NOT NULL references users ON DELETE CASCADE,
+email VARCHAR(319) NOT NULL,
+name VARCHAR(70),
+decision VARCHAR(20),
+decided_at TIMESTAMP WITH TIME ZONE,
+UNIQUE (user_id, email)
+);
+
+ALTER TABLE users
+    ADD COLUMN trusted_contact_quorum SMALLINT NOT NULL DEFAULT 1,
+    ADD COLUMN trusted_contact_timeout_seconds INTEGER NOT NULL DEFAULT 604800;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN IF EXISTS trusted_contact_timeout_seconds,
+    DROP COLUMN IF EXISTS trusted_contact_quorum;
+DROP TABLE IF EXISTS trusted_contacts;
+-- +goose StatementEnd
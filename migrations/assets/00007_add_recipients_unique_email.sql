@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+DELETE FROM recipients WHERE email IS NULL OR group_id IS NULL;
+UPDATE recipients SET email = lower(trim(email));
+DELETE FROM recipients a USING recipients b WHERE a.id > b.id AND a.group_id = b.group_id AND a.email = b.email;
+
+ALTER TABLE recipients
+    ALTER COLUMN email SET NOT NULL,
+    ALTER COLUMN group_id SET NOT NULL,
+    ADD CONSTRAINT recipients_group_id_email_key UNIQUE (group_id, email);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE recipients
+    DROP CONSTRAINT IF EXISTS recipients_group_id_email_key,
+    ALTER COLUMN group_id DROP NOT NULL,
+    ALTER COLUMN email DROP NOT NULL;
+-- +goose StatementEnd
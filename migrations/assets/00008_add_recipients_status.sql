@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE recipients ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'pending';
+ALTER TABLE groups ADD COLUMN require_recipient_opt_in BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE groups DROP COLUMN IF EXISTS require_recipient_opt_in;
+ALTER TABLE recipients DROP COLUMN IF EXISTS status;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE deliveries(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+user_id integer NOT NULL references users ON DELETE CASCADE,
+last_message_id integer NOT NULL references last_messages ON DELETE CASCADE,
+recipient_email VARCHAR(319) NOT NULL,
+status VARCHAR(20) NOT NULL DEFAULT 'queued',
+attempts SMALLINT NOT NULL DEFAULT 0,
+last_error text,
+smtp_message_id VARCHAR(255),
+created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+UNIQUE (last_message_id, recipient_email)
+);
+CREATE INDEX idx_deliveries_user_id ON deliveries(user_id);
+
+CREATE TRIGGER deliveries_set_updated_at
+BEFORE UPDATE ON deliveries
+FOR EACH ROW
+EXECUTE FUNCTION set_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS deliveries_set_updated_at ON deliveries;
+DROP INDEX IF EXISTS idx_deliveries_user_id;
+DROP TABLE IF EXISTS deliveries;
+-- +goose StatementEnd
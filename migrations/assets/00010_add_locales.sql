@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN locale VARCHAR(10) NOT NULL DEFAULT 'en';
+-- recipients of a group get their emails in the group's locale, or in the user's locale if it's NULL
+ALTER TABLE groups ADD COLUMN locale VARCHAR(10);
+ALTER TABLE deliveries ADD COLUMN locale VARCHAR(10) NOT NULL DEFAULT 'en';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE deliveries DROP COLUMN IF EXISTS locale;
+ALTER TABLE groups DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE notification_channels(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+user_id integer NOT NULL references users ON DELETE CASCADE,
+kind VARCHAR(20) NOT NULL,
+-- an email address or URL, depending on the kind
+target VARCHAR(2048) NOT NULL,
+secret VARCHAR(255),
+life_status BOOLEAN NOT NULL DEFAULT TRUE,
+last_messages BOOLEAN NOT NULL DEFAULT FALSE,
+created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_notification_channels_user_id ON notification_channels(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_notification_channels_user_id;
+DROP TABLE IF EXISTS notification_channels;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- auth tokens issued before this are rejected, e.g. after a password reset
+ALTER TABLE users ADD COLUMN auth_valid_after TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS auth_valid_after;
+-- +goose StatementEnd
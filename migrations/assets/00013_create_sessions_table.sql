@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE sessions(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+user_id integer NOT NULL references users ON DELETE CASCADE,
+-- sha256 of the refresh token, which is replaced every time it's used
+refresh_token_hash VARCHAR(64) NOT NULL UNIQUE,
+-- the refresh token that was last replaced. If it's used again, it has been stolen.
+previous_refresh_token_hash VARCHAR(64),
+user_agent VARCHAR(512) NOT NULL DEFAULT '',
+ip_address VARCHAR(45) NOT NULL DEFAULT '',
+created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+last_used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_previous_refresh_token_hash ON sessions(previous_refresh_token_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_sessions_previous_refresh_token_hash;
+DROP INDEX IF EXISTS idx_sessions_user_id;
+DROP TABLE IF EXISTS sessions;
+-- +goose StatementEnd
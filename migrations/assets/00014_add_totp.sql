@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the secret is set when the user starts enrolling, and totp_enabled once they've verified their first code
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+-- the time step of the last code that was used, so codes can't be used twice
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN totp_failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN totp_locked_until TIMESTAMP WITH TIME ZONE;
+CREATE TABLE recovery_codes(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+user_id integer NOT NULL references users ON DELETE CASCADE,
+code_hash VARCHAR(64) NOT NULL,
+used_at TIMESTAMP WITH TIME ZONE,
+UNIQUE (user_id, code_hash)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_failed_attempts;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
+-- +goose StatementEnd
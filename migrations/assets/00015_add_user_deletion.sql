@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+-- deleting a user deletes everything they own
+ALTER TABLE last_messages DROP CONSTRAINT last_messages_user_id_fkey,
+    ADD CONSTRAINT last_messages_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+ALTER TABLE groups DROP CONSTRAINT groups_user_id_fkey,
+    ADD CONSTRAINT groups_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+ALTER TABLE group_last_messages DROP CONSTRAINT group_last_messages_group_id_fkey,
+    ADD CONSTRAINT group_last_messages_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups ON DELETE CASCADE;
+ALTER TABLE group_last_messages DROP CONSTRAINT group_last_messages_last_message_id_fkey,
+    ADD CONSTRAINT group_last_messages_last_message_id_fkey FOREIGN KEY (last_message_id) REFERENCES last_messages ON DELETE CASCADE;
+ALTER TABLE recipients DROP CONSTRAINT recipients_group_id_fkey,
+    ADD CONSTRAINT recipients_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups ON DELETE CASCADE;
+-- set while the account is waiting out the cooling-off period before it's deleted
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+ALTER TABLE recipients DROP CONSTRAINT recipients_group_id_fkey,
+    ADD CONSTRAINT recipients_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups;
+ALTER TABLE group_last_messages DROP CONSTRAINT group_last_messages_last_message_id_fkey,
+    ADD CONSTRAINT group_last_messages_last_message_id_fkey FOREIGN KEY (last_message_id) REFERENCES last_messages;
+ALTER TABLE group_last_messages DROP CONSTRAINT group_last_messages_group_id_fkey,
+    ADD CONSTRAINT group_last_messages_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups;
+ALTER TABLE groups DROP CONSTRAINT groups_user_id_fkey,
+    ADD CONSTRAINT groups_user_id_fkey FOREIGN KEY (user_id) REFERENCES users;
+ALTER TABLE last_messages DROP CONSTRAINT last_messages_user_id_fkey,
+    ADD CONSTRAINT last_messages_user_id_fkey FOREIGN KEY (user_id) REFERENCES users;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- set instead of content when the message was encrypted on the client. Holds the ciphertext, the algorithm and whatever
+-- the client needs to unwrap the key, none of which the server can read.
+ALTER TABLE last_messages ADD COLUMN encrypted_content JSONB,
+    ADD CONSTRAINT last_messages_content_or_encrypted_content CHECK (content IS NULL OR encrypted_content IS NULL);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE last_messages DROP COLUMN IF EXISTS encrypted_content;
+-- +goose StatementEnd
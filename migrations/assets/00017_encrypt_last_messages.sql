@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- last messages are encrypted with a data key per row, which is wrapped by the master key with version key_version.
+-- Rows from before this have no key version and keep their plaintext until the re-encrypt command encrypts them.
+ALTER TABLE last_messages ALTER COLUMN title DROP NOT NULL,
+    ADD COLUMN title_ciphertext BYTEA,
+    ADD COLUMN content_ciphertext BYTEA,
+    ADD COLUMN encrypted_content_ciphertext BYTEA,
+    ADD COLUMN data_key BYTEA,
+    ADD COLUMN key_version INTEGER,
+    ADD CONSTRAINT last_messages_data_key_version CHECK ((data_key IS NULL) = (key_version IS NULL)),
+    ADD CONSTRAINT last_messages_encrypted_without_plaintext CHECK (key_version IS NULL
+        OR (title IS NULL AND content IS NULL AND encrypted_content IS NULL AND title_ciphertext IS NOT NULL)),
+    ADD CONSTRAINT last_messages_content_or_encrypted_content_ciphertext CHECK (content_ciphertext IS NULL OR encrypted_content_ciphertext IS NULL);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- encrypted rows can't be decrypted here, and dropping the columns would lose them
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM last_messages WHERE key_version IS NOT NULL) THEN
+        RAISE EXCEPTION 'last messages are encrypted, migrating down would lose them';
+    END IF;
+END $$;
+ALTER TABLE last_messages DROP COLUMN IF EXISTS title_ciphertext,
+    DROP COLUMN IF EXISTS content_ciphertext,
+    DROP COLUMN IF EXISTS encrypted_content_ciphertext,
+    DROP COLUMN IF EXISTS data_key,
+    DROP COLUMN IF EXISTS key_version,
+    ALTER COLUMN title SET NOT NULL;
+-- +goose StatementEnd
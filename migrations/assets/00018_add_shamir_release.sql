@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- in the shamir release mode, the message is encrypted with a new key when it's released and the key is split between
+-- its recipients, share_threshold of whom have to put their shares together to read it
+ALTER TABLE last_messages ADD COLUMN release_mode VARCHAR(20) NOT NULL DEFAULT 'standard' CHECK (release_mode IN ('standard', 'shamir')),
+    ADD COLUMN share_threshold INTEGER CHECK (share_threshold BETWEEN 2 AND 255),
+    -- set on release, the content encrypted with the split key. share_count is how many shares there are.
+    ADD COLUMN shared_ciphertext BYTEA,
+    ADD COLUMN share_count INTEGER,
+    ADD CONSTRAINT last_messages_shamir_threshold CHECK (release_mode = 'standard' OR share_threshold IS NOT NULL),
+    ADD CONSTRAINT last_messages_shamir_plain_content CHECK (release_mode = 'standard'
+        OR (encrypted_content IS NULL AND encrypted_content_ciphertext IS NULL));
+-- only kept until the share has been sent to its recipient
+ALTER TABLE deliveries ADD COLUMN key_share BYTEA;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE deliveries DROP COLUMN IF EXISTS key_share;
+ALTER TABLE last_messages DROP COLUMN IF EXISTS release_mode,
+    DROP COLUMN IF EXISTS share_threshold,
+    DROP COLUMN IF EXISTS shared_ciphertext,
+    DROP COLUMN IF EXISTS share_count;
+-- +goose StatementEnd
@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+-- files left with last messages. The files themselves are in the blob store under blob_key, encrypted with the data
+-- key of their last message.
+CREATE TABLE attachments(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+user_id integer NOT NULL references users ON DELETE CASCADE,
+last_message_id integer NOT NULL references last_messages ON DELETE CASCADE,
+filename VARCHAR(255) NOT NULL,
+content_type VARCHAR(255) NOT NULL,
+-- of the file before it was encrypted
+size BIGINT NOT NULL CHECK (size >= 0),
+blob_key VARCHAR(64) UNIQUE NOT NULL,
+created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_attachments_user_id ON attachments(user_id);
+CREATE INDEX idx_attachments_last_message_id ON attachments(last_message_id);
+-- the total size of the user's attachments, kept on the user so the quota can be checked and reserved in one update
+ALTER TABLE users ADD COLUMN attachments_size BIGINT NOT NULL DEFAULT 0;
+
+-- the blobs of deleted attachments, until they're deleted from the blob store. Attachments are also deleted by cascades
+-- from their message or user, so a trigger keeps track of them and gives the space back to the user.
+CREATE TABLE deleted_blobs(
+blob_key VARCHAR(64) PRIMARY KEY,
+deleted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE FUNCTION release_deleted_attachment() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO deleted_blobs (blob_key) VALUES (OLD.blob_key) ON CONFLICT DO NOTHING;
+  UPDATE users SET attachments_size = attachments_size - OLD.size WHERE id = OLD.user_id;
+  RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER attachments_release_deleted
+AFTER DELETE ON attachments
+FOR EACH ROW
+EXECUTE FUNCTION release_deleted_attachment();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS attachments_release_deleted ON attachments;
+DROP FUNCTION IF EXISTS release_deleted_attachment;
+DROP TABLE IF EXISTS deleted_blobs;
+ALTER TABLE users DROP COLUMN IF EXISTS attachments_size;
+DROP INDEX IF EXISTS idx_attachments_last_message_id;
+DROP INDEX IF EXISTS idx_attachments_user_id;
+DROP TABLE IF EXISTS attachments;
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+-- when a last message goes out once the user died: right away (on_death), release_delay_days later (after_death), at
+-- release_at (on_date), or every year on the anniversary of release_at (yearly)
+ALTER TABLE last_messages ADD COLUMN release_rule VARCHAR(20) NOT NULL DEFAULT 'on_death' CHECK (release_rule IN ('on_death', 'after_death', 'on_date', 'yearly')),
+    ADD COLUMN release_delay_days INTEGER CHECK (release_delay_days BETWEEN 1 AND 36500),
+    ADD COLUMN release_at TIMESTAMP WITH TIME ZONE,
+    ADD CONSTRAINT last_messages_release_delay CHECK (release_rule <> 'after_death' OR release_delay_days IS NOT NULL),
+    ADD CONSTRAINT last_messages_release_at CHECK (release_rule NOT IN ('on_date', 'yearly') OR release_at IS NOT NULL),
+    -- the key shares are only handed out once
+    ADD CONSTRAINT last_messages_shamir_yearly CHECK (release_mode = 'standard' OR release_rule <> 'yearly');
+
+-- yearly messages get a delivery per recipient and year, numbered by occurrence
+ALTER TABLE deliveries ADD COLUMN occurrence INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN send_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    DROP CONSTRAINT deliveries_last_message_id_recipient_email_key,
+    ADD CONSTRAINT deliveries_last_message_id_recipient_email_occurrence_key UNIQUE (last_message_id, recipient_email, occurrence);
+
+-- the first anniversary of anniversary that isn't before after. February 29th falls on February 28th in other years.
+CREATE FUNCTION next_anniversary(anniversary TIMESTAMP WITH TIME ZONE, after TIMESTAMP WITH TIME ZONE) RETURNS TIMESTAMP WITH TIME ZONE AS $$
+DECLARE
+  years INTEGER := GREATEST(0, EXTRACT(YEAR FROM age(after, anniversary))::INTEGER);
+BEGIN
+  WHILE anniversary + make_interval(years => years) < after LOOP
+    years := years + 1;
+  END LOOP;
+  RETURN anniversary + make_interval(years => years);
+END;
+$$ LANGUAGE plpgsql STABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP FUNCTION IF EXISTS next_anniversary;
+DELETE FROM deliveries WHERE occurrence > 0;
+ALTER TABLE deliveries DROP CONSTRAINT IF EXISTS deliveries_last_message_id_recipient_email_occurrence_key,
+    ADD CONSTRAINT deliveries_last_message_id_recipient_email_key UNIQUE (last_message_id, recipient_email),
+    DROP COLUMN IF EXISTS occurrence,
+    DROP COLUMN IF EXISTS send_at;
+ALTER TABLE last_messages DROP COLUMN IF EXISTS release_rule,
+    DROP COLUMN IF EXISTS release_delay_days,
+    DROP COLUMN IF EXISTS release_at;
+-- +goose StatementEnd
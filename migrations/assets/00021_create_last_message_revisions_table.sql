@@ -0,0 +1,63 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every version of the title and content of a last message, so users can go back to an earlier one. The ciphertexts are
+-- copies of the message's, encrypted with its data key, so restoring a revision copies them back without decrypting.
+CREATE TABLE last_message_revisions(
+id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+last_message_id integer NOT NULL references last_messages ON DELETE CASCADE,
+title_ciphertext BYTEA NOT NULL,
+content_ciphertext BYTEA,
+encrypted_content_ciphertext BYTEA,
+created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_last_message_revisions_last_message_id ON last_message_revisions(last_message_id);
+
+-- messages from before encryption get their first revision once they're encrypted, and released shamir messages don't
+-- get any, their content is only readable with the key shares
+CREATE FUNCTION record_last_message_revision() RETURNS trigger AS $$
+BEGIN
+  IF NEW.key_version IS NULL OR NEW.shared_ciphertext IS NOT NULL THEN
+    RETURN NULL;
+  END IF;
+  IF TG_OP = 'UPDATE' AND OLD.title_ciphertext IS NOT DISTINCT FROM NEW.title_ciphertext
+    AND OLD.content_ciphertext IS NOT DISTINCT FROM NEW.content_ciphertext
+    AND OLD.encrypted_content_ciphertext IS NOT DISTINCT FROM NEW.encrypted_content_ciphertext THEN
+    RETURN NULL;
+  END IF;
+  INSERT INTO last_message_revisions (last_message_id, title_ciphertext, content_ciphertext, encrypted_content_ciphertext)
+  VALUES (NEW.id, NEW.title_ciphertext, NEW.content_ciphertext, NEW.encrypted_content_ciphertext);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER last_messages_record_revision
+AFTER INSERT OR UPDATE OF title_ciphertext, content_ciphertext, encrypted_content_ciphertext ON last_messages
+FOR EACH ROW
+EXECUTE FUNCTION record_last_message_revision();
+
+-- revisions can only be added and deleted
+CREATE FUNCTION forbid_last_message_revision_update() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'last message revisions are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER last_message_revisions_immutable
+BEFORE UPDATE ON last_message_revisions
+FOR EACH ROW
+EXECUTE FUNCTION forbid_last_message_revision_update();
+
+INSERT INTO last_message_revisions (last_message_id, title_ciphertext, content_ciphertext, encrypted_content_ciphertext)
+SELECT id, title_ciphertext, content_ciphertext, encrypted_content_ciphertext FROM last_messages
+WHERE key_version IS NOT NULL AND shared_ciphertext IS NULL ORDER BY id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS last_message_revisions_immutable ON last_message_revisions;
+DROP FUNCTION IF EXISTS forbid_last_message_revision_update;
+DROP TRIGGER IF EXISTS last_messages_record_revision ON last_messages;
+DROP FUNCTION IF EXISTS record_last_message_revision;
+DROP INDEX IF EXISTS idx_last_message_revisions_last_message_id;
+DROP TABLE IF EXISTS last_message_revisions;
+-- +goose StatementEnd
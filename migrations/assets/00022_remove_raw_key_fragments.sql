@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the key fragment of an end-to-end encrypted message has to be wrapped with a passphrase, as described by the "wrap"
+-- of its metadata. Ones that aren't are the message key itself, which the server must never have. This removes them from
+-- messages stored before encryption was added; the reencrypt command removes them from the encrypted ones.
+UPDATE last_messages SET encrypted_content = encrypted_content - 'keyFragment'
+WHERE encrypted_content ? 'keyFragment' AND jsonb_typeof(encrypted_content #> '{metadata,wrap}') IS DISTINCT FROM 'object';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- the removed key fragments can't be brought back
+SELECT 1;
+-- +goose StatementEnd
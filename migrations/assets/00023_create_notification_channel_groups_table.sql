@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the groups whose last messages a channel gets once they're released. Channels only get the messages of their groups,
+-- like recipients. Channels created before this got every message, which isn't what users meant for channels shared
+-- with only some of their recipients, so they don't get any until groups are picked for them.
+CREATE TABLE notification_channel_groups(
+notification_channel_id integer NOT NULL references notification_channels ON DELETE CASCADE,
+group_id integer NOT NULL references groups ON DELETE CASCADE,
+PRIMARY KEY (notification_channel_id, group_id)
+);
+CREATE INDEX idx_notification_channel_groups_group_id ON notification_channel_groups(group_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_notification_channel_groups_group_id;
+DROP TABLE IF EXISTS notification_channel_groups;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- email change links sent before this don't work anymore, set when the old address cancels a change
+ALTER TABLE users ADD COLUMN email_change_cancelled_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS email_change_cancelled_at;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- when the user was last sent a test copy of a death email, so they can't be sent too often
+ALTER TABLE users ADD COLUMN last_test_email_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS last_test_email_at;
+-- +goose StatementEnd
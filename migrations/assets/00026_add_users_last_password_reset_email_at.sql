@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- when the user was last sent a password reset email, so anyone who knows their address can't flood their inbox
+ALTER TABLE users ADD COLUMN last_password_reset_email_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS last_password_reset_email_at;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- when the recipient was last sent a notice, so the owner can't send them one too often
+ALTER TABLE recipients ADD COLUMN notified_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE recipients DROP COLUMN IF EXISTS notified_at;
+-- +goose StatementEnd
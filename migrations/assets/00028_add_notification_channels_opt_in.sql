@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- email channels only get notifications once whoever reads the address confirmed it, like recipients. Other kinds of
+-- channels are confirmed when they're created. unsubscribed_at is set once the address unsubscribed, after which it
+-- never gets anything again, not even another confirmation email.
+ALTER TABLE notification_channels
+    ADD COLUMN confirmed_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN unsubscribed_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN opt_in_sent_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN last_tested_at TIMESTAMP WITH TIME ZONE;
+UPDATE notification_channels SET confirmed_at = created_at WHERE kind <> 'email';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE notification_channels
+    DROP COLUMN IF EXISTS confirmed_at,
+    DROP COLUMN IF EXISTS unsubscribed_at,
+    DROP COLUMN IF EXISTS opt_in_sent_at,
+    DROP COLUMN IF EXISTS last_tested_at;
+-- +goose StatementEnd
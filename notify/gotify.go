@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// sends push notifications through a Gotify server, or anything that speaks its message API
+type Gotify struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+// url is the base URL of the server and token is the token of the app the messages are sent as
+func NewGotify(client *http.Client, url string, token string) *Gotify {
+	return &Gotify{client: client, url: strings.TrimSuffix(url, "/"), token: token}
+}
+
+type gotifyMessage struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+// life status pings get a high priority so they aren't silenced on the user's phone
+func gotifyPriority(event Event) int {
+	if event == EventLifeStatus {
+		return 8
+	}
+	return 5
+}
+
+func (g *Gotify) Notify(ctx context.Context, notification Notification) error {
+	message := gotifyMessage{
+		Title:    notification.Title,
+		Message:  notification.Message,
+		Priority: gotifyPriority(notification.Event),
+	}
+	if notification.URL != "" {
+		message.Message += "\n\n" + notification.URL
+		message.Extras = map[string]any{
+			"client::notification": map[string]any{"click": map[string]string{"url": notification.URL}},
+		}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.token)
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return checkStatus(res)
+}
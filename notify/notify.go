@@ -0,0 +1,82 @@
+// Package notify sends notifications to the channels users register, like extra email addresses or webhooks.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type Event string
+
+const (
+	// asks the user to let us know they're alive
+	EventLifeStatus Event = "lifeStatus"
+	// one of the user's last messages after they've been released
+	EventLastMessage Event = "lastMessage"
+	// sent when the user tests a channel
+	EventTest Event = "test"
+)
+
+type Notification struct {
+	Event   Event  `json:"event"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	// a link the receiver should open, e.g. to let us know they're alive
+	URL string `json:"url,omitempty"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// the kind of a channel decides which Notifier is used for it
+type Kind string
+
+const (
+	KindEmail   Kind = "email"
+	KindWebhook Kind = "webhook"
+	KindGotify  Kind = "gotify"
+)
+
+var Kinds = []Kind{KindEmail, KindWebhook, KindGotify}
+
+var ErrUnknownKind = errors.New("unknown notification channel kind")
+
+// returns the Notifier for a channel. target is the email address or URL of the channel,
+// secret is the webhook signing secret or the gotify app token.
+func New(kind Kind, target string, secret string, unsubscribeURL string, emailSender EmailSender, client *http.Client) (Notifier, error) {
+	switch kind {
+	case KindEmail:
+		return NewSMTP(emailSender, target, unsubscribeURL), nil
+	case KindWebhook:
+		return NewWebhook(client, target, secret), nil
+	case KindGotify:
+		return NewGotify(client, target, secret), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKind, kind)
+	}
+}
+
+// returned when an HTTP channel responds with a non-2xx status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("notification channel responded with status %d", e.StatusCode)
+}
+
+// client errors won't go away by retrying, except for rate limiting and timeouts. Neither will redirects, which
+// aren't followed.
+func (e *StatusError) Permanent() bool {
+	return e.StatusCode >= 300 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests && e.StatusCode != http.StatusRequestTimeout
+}
+
+func checkStatus(res *http.Response) error {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &StatusError{StatusCode: res.StatusCode}
+	}
+	return nil
+}
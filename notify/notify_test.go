@@ -0,0 +1,154 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gragorther/epigo/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var notification = notify.Notification{
+	Event:   notify.EventLifeStatus,
+	Title:   "Still alive?",
+	Message: "Let us know you're alive.",
+	URL:     "https://example.com/user/life/verify?token=token",
+}
+
+func TestWebhook(t *testing.T) {
+	var gotBody []byte
+	var gotSignature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(notify.SignatureHeader)
+	}))
+	defer srv.Close()
+
+	webhook := notify.NewWebhook(srv.Client(), srv.URL, "secret")
+	require.NoError(t, webhook.Notify(context.Background(), notification))
+
+	var got notify.Notification
+	require.NoError(t, json.Unmarshal(gotBody, &got))
+	assert.Equal(t, notification, got)
+	assert.Equal(t, "sha256="+notify.Sign("secret", gotBody), gotSignature)
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get(notify.SignatureHeader), "webhooks without a secret shouldn't be signed")
+	}))
+	defer srv.Close()
+
+	require.NoError(t, notify.NewWebhook(srv.Client(), srv.URL, "").Notify(context.Background(), notification))
+}
+
+func TestWebhookStatus(t *testing.T) {
+	table := map[string]struct {
+		Status        int
+		WantPermanent bool
+	}{
+		"redirect":          {Status: http.StatusFound, WantPermanent: true},
+		"not found":         {Status: http.StatusNotFound, WantPermanent: true},
+		"too many requests": {Status: http.StatusTooManyRequests, WantPermanent: false},
+		"server error":      {Status: http.StatusBadGateway, WantPermanent: false},
+	}
+	for name, test := range table {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.Status)
+			}))
+			defer srv.Close()
+
+			err := notify.NewWebhook(srv.Client(), srv.URL, "").Notify(context.Background(), notification)
+			var statusErr *notify.StatusError
+			require.ErrorAs(t, err, &statusErr)
+			assert.Equal(t, test.Status, statusErr.StatusCode)
+			assert.Equal(t, test.WantPermanent, statusErr.Permanent())
+		})
+	}
+}
+
+func TestGotify(t *testing.T) {
+	var got struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/message", r.URL.Path)
+		assert.Equal(t, "apptoken", r.Header.Get("X-Gotify-Key"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer srv.Close()
+
+	gotify := notify.NewGotify(srv.Client(), srv.URL+"/", "apptoken")
+	require.NoError(t, gotify.Notify(context.Background(), notification))
+	assert.Equal(t, notification.Title, got.Title)
+	assert.Contains(t, got.Message, notification.URL, "the link should be in the message for clients that don't support extras")
+	assert.Equal(t, 8, got.Priority)
+}
+
+type emailSender struct {
+	to             string
+	subject        string
+	unsubscribeURL string
+}
+
+func (e *emailSender) SendNotificationEmail(ctx context.Context, to string, subject string, message string, url string, unsubscribeURL string) error {
+	e.to = to
+	e.subject = subject
+	e.unsubscribeURL = unsubscribeURL
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	sender := &emailSender{}
+	notifier, err := notify.New(notify.KindEmail, "test@google.com", "", "https://example.com/channels/unsubscribe?token=token", sender, http.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, notifier.Notify(context.Background(), notification))
+	assert.Equal(t, "test@google.com", sender.to)
+	assert.Equal(t, notification.Title, sender.subject)
+	assert.Equal(t, "https://example.com/channels/unsubscribe?token=token", sender.unsubscribeURL)
+
+	_, err = notify.New("carrier pigeon", "", "", "", sender, http.DefaultClient)
+	assert.ErrorIs(t, err, notify.ErrUnknownKind)
+}
+
+func TestHTTPClientRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	err := notify.NewWebhook(notify.NewHTTPClient(time.Second), srv.URL, "").Notify(context.Background(), notification)
+	assert.ErrorIs(t, err, notify.ErrForbiddenAddress)
+	assert.False(t, called, "the client shouldn't connect to loopback addresses")
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	table := map[string]bool{
+		"1.1.1.1":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"192.168.0.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+	}
+	for addr, want := range table {
+		assert.Equal(t, want, notify.IsPublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
@@ -0,0 +1,23 @@
+package notify
+
+import "context"
+
+type EmailSender interface {
+	SendNotificationEmail(ctx context.Context, to string, subject string, message string, url string, unsubscribeURL string) error
+}
+
+// sends notifications to an email address
+type SMTP struct {
+	sender EmailSender
+	to     string
+	// where the address can stop getting the notifications
+	unsubscribeURL string
+}
+
+func NewSMTP(sender EmailSender, to string, unsubscribeURL string) *SMTP {
+	return &SMTP{sender: sender, to: to, unsubscribeURL: unsubscribeURL}
+}
+
+func (s *SMTP) Notify(ctx context.Context, notification Notification) error {
+	return s.sender.SendNotificationEmail(ctx, s.to, notification.Title, notification.Message, notification.URL, s.unsubscribeURL)
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// the header with the HMAC-SHA256 of the request body, so receivers can check the webhook came from us
+const SignatureHeader = "X-Epilogue-Signature"
+
+// POSTs notifications as JSON to a URL
+type Webhook struct {
+	client *http.Client
+	url    string
+	secret string
+}
+
+// if secret isn't empty, requests are signed with it
+func NewWebhook(client *http.Client, url string, secret string) *Webhook {
+	return &Webhook{client: client, url: url, secret: secret}
+}
+
+func (w *Webhook) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, body))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return checkStatus(res)
+}
+
+// returns the hex encoded HMAC-SHA256 of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -6,12 +6,20 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gragorther/epigo/asynq/tasks"
+	"github.com/gragorther/epigo/blob"
 	"github.com/gragorther/epigo/database/db"
+	"github.com/gragorther/epigo/email"
+	"github.com/gragorther/epigo/handlers/channels"
 	"github.com/gragorther/epigo/handlers/groups"
+	"github.com/gragorther/epigo/handlers/jwks"
 	"github.com/gragorther/epigo/handlers/messages"
+	"github.com/gragorther/epigo/handlers/recipients"
+	"github.com/gragorther/epigo/handlers/sessions"
+	"github.com/gragorther/epigo/handlers/trustedcontacts"
 	"github.com/gragorther/epigo/handlers/users"
 	argon2id "github.com/gragorther/epigo/hash"
 	"github.com/gragorther/epigo/middlewares"
+	"github.com/gragorther/epigo/notify"
 	"github.com/gragorther/epigo/tokens"
 )
 
@@ -26,18 +34,74 @@ func Setup(db interface {
 	DeleteLastMessageByID(ctx context.Context, id uint) error
 	CanUserEditLastmessage(ctx context.Context, userID uint, messageID uint, groupIDs []uint) (authorized bool, err error)
 	UpdateLastMessage(ctx context.Context, id uint, m db.UpdateLastMessage) error
-	LastMessagesByUserID(ctx context.Context, userID uint) (lastMessages []db.LastMessage, err error)
+	LastMessagesPageByUserID(ctx context.Context, userID uint, opts db.ListOptions) (lastMessages []db.LastMessage, nextCursor string, err error)
 	CreateLastMessage(ctx context.Context, message db.CreateLastMessage) error
 	CanUserEditGroup(ctx context.Context, userID uint, groupID uint, lastMessageIDs []uint) (authorized bool, err error)
 	UpdateGroup(ctx context.Context, id uint, group db.UpdateGroup) error
-	GroupsByUserID(ctx context.Context, userID uint) (groups []db.Group, err error)
+	GroupsPageByUserID(ctx context.Context, userID uint, opts db.ListOptions) (groups []db.Group, nextCursor string, err error)
 	DeleteGroupByID(ctx context.Context, id uint) error
 	UserAuthorizationForGroups(ctx context.Context, groupIDs []uint, userID uint) (bool, error)
 	CreateGroup(context.Context, db.CreateGroup) error
 	CheckIfUserExistsByUsernameAndEmail(ctx context.Context, username string, email string) (bool, error)
+	CheckInUser(ctx context.Context, userID uint, source db.CheckInSource) (checkedInAt time.Time, err error)
+	CheckInsByUserID(ctx context.Context, userID uint, limit uint, offset uint) (checkIns []db.CheckIn, err error)
+	TrustedContactsByUserID(ctx context.Context, userID uint) (contacts []db.TrustedContact, err error)
+	UserAuthorizationForTrustedContact(ctx context.Context, contactID uint, userID uint) (authorized bool, err error)
+	RecordTrustedContactDecision(ctx context.Context, contactID uint, userID uint, decision db.TrustedContactDecision) error
+	TrustedContactConfirmationsByUserID(ctx context.Context, userID uint) (confirmations db.TrustedContactConfirmations, err error)
+	VetoUserRelease(ctx context.Context, userID uint) error
+	RecipientsByGroupID(ctx context.Context, groupID uint) (recipients []db.GroupRecipient, err error)
+	ClaimRecipientNotices(ctx context.Context, groupID uint, minInterval time.Duration) (recipients []db.GroupRecipient, err error)
+	UserAuthorizationForRecipient(ctx context.Context, recipientID uint, groupID uint, userID uint) (authorized bool, err error)
+	SetRecipientStatusByEmail(ctx context.Context, id uint, email string, status db.RecipientStatus) (updated bool, err error)
+	DeliveriesByUserID(ctx context.Context, userID uint) (deliveries []db.Delivery, err error)
+	DeliveryMessageByID(ctx context.Context, id uint) (delivery db.DeliveryMessage, err error)
+	NotificationChannelsByUserID(ctx context.Context, userID uint) (channels []db.NotificationChannel, err error)
+	UserAuthorizationForNotificationChannel(ctx context.Context, channelID uint, userID uint) (authorized bool, err error)
+	NotificationChannelByID(ctx context.Context, id uint) (channel db.NotificationChannel, err error)
+	ClaimNotificationChannelOptIn(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error)
+	ClaimNotificationChannelTest(ctx context.Context, id uint, minInterval time.Duration) (claimed bool, err error)
+	ConfirmNotificationChannel(ctx context.Context, id uint, email string) (confirmed bool, err error)
+	UnsubscribeNotificationChannel(ctx context.Context, id uint, email string) (unsubscribed bool, err error)
+	SessionActive(ctx context.Context, sessionID uint, userID uint, issuedAt time.Time) (active bool, err error)
+	CreateSession(ctx context.Context, session db.CreateSession) (id uint, err error)
+	RotateSessionRefreshToken(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, expiresAt time.Time) (session db.SessionUser, err error)
+	SessionsByUserID(ctx context.Context, userID uint) (sessions []db.Session, err error)
+	UserAuthorizationForSession(ctx context.Context, sessionID uint, userID uint) (authorized bool, err error)
+	RevokeSessionByID(ctx context.Context, id uint) error
+	RevokeSessionsByUserID(ctx context.Context, userID uint) error
+	TOTPByUserID(ctx context.Context, userID uint) (totp db.TOTP, err error)
+	SetPendingTOTPSecret(ctx context.Context, userID uint, secret string) (set bool, err error)
+	EnableTOTP(ctx context.Context, userID uint, step int64, recoveryCodeHashes []string) (enabled bool, err error)
+	DisableTOTP(ctx context.Context, userID uint) error
+	UseTOTPStep(ctx context.Context, userID uint, step int64) (used bool, err error)
+	RecordTOTPFailure(ctx context.Context, userID uint, maxAttempts uint, lockout time.Duration) error
+	UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (used bool, err error)
+	ChangeUserEmail(ctx context.Context, userID uint, oldEmail string, newEmail string, requestedAt time.Time) (changed bool, err error)
+	CancelUserEmailChanges(ctx context.Context, userID uint) error
+	ClaimUserTestEmail(ctx context.Context, userID uint, minInterval time.Duration) (claimed bool, err error)
+	DeleteUser(ctx context.Context, ID uint) error
+	ScheduleUserDeletion(ctx context.Context, userID uint, deleteAt time.Time) error
+	ClaimPasswordResetEmail(ctx context.Context, email string, minInterval time.Duration) (claimed bool, err error)
+	CancelUserDeletion(ctx context.Context, userID uint) (user db.KeptUser, cancelled bool, err error)
+	ExportUserData(ctx context.Context, userID uint) (export db.UserExport, err error)
+	PasswordHashByUserID(ctx context.Context, userID uint) (passwordHash string, err error)
+	ResetUserPassword(ctx context.Context, userID uint, oldPasswordHash string, newPasswordHash string) (reset bool, err error)
+	CreateAttachment(ctx context.Context, attachment db.CreateAttachment, quota int64) (id uint, err error)
+	SealAttachment(ctx context.Context, lastMessageID uint, blobKey string, content []byte) (ciphertext []byte, err error)
+	OpenAttachment(ctx context.Context, lastMessageID uint, blobKey string, ciphertext []byte) (content []byte, err error)
+	AttachmentsByLastMessageID(ctx context.Context, lastMessageID uint) (attachments []db.Attachment, err error)
+	UserAuthorizationForAttachment(ctx context.Context, attachmentID uint, lastMessageID uint, userID uint) (authorized bool, err error)
+	DeleteAttachmentByID(ctx context.Context, id uint) error
+	DeliveryAttachment(ctx context.Context, deliveryID uint, attachmentID uint) (attachment db.Attachment, err error)
+	LastMessageRevisions(ctx context.Context, lastMessageID uint) (revisions []db.LastMessageRevision, err error)
+	RestoreLastMessageRevision(ctx context.Context, lastMessageID uint, revisionID uint) error
+	GroupByID(ctx context.Context, id uint) (group db.GroupByID, err error)
+	LastMessageByID(ctx context.Context, id uint) (lastMessage db.LastMessageByID, err error)
+	LastMessageSettingsByID(ctx context.Context, id uint) (settings db.LastMessageSettings, err error)
 }, queue interface {
 	UpdateLastMessage(id uint, m db.UpdateLastMessage) error
-	SendVerificationEmail(email string) error
+	SendVerificationEmail(email string, locale string) error
 	UpdateUserInterval(uint, string) error
 	CreateGroup(db.CreateGroup) error
 	DeleteGroupByID(id uint) error
@@ -45,39 +109,168 @@ func Setup(db interface {
 	CreateLastMessage(message db.CreateLastMessage) error
 	DeleteLastMessageByID(id uint) error
 	CreateUser(db.CreateUserInput) error
-}, jwtSecret string, enqueueTask tasks.TaskEnqueueFunc, baseURL string, minDurationBetweenEmail time.Duration,
+	SetUserGracePeriod(userID uint, gracePeriod time.Duration) error
+	SetUserLocale(userID uint, locale string) error
+	CreateTrustedContact(contact db.CreateTrustedContact) error
+	DeleteTrustedContactByID(id uint) error
+	SetUserTrustedContactSettings(userID uint, settings db.TrustedContactSettings) error
+	CreateRecipients(groupID uint, emails []string) error
+	UpdateRecipientEmail(id uint, email string) error
+	DeleteRecipientByID(id uint) error
+	SendRecipientNotice(recipientID uint, email string, userName string) error
+	CreateNotificationChannel(channel db.CreateNotificationChannel) error
+	DeleteNotificationChannelByID(id uint) error
+	NotifyChannel(channelID uint, notification notify.Notification) error
+	SendChannelOptIn(channelID uint, email string, userName string) error
+	SendPasswordResetEmail(email string) error
+	SendEmailChange(userID uint, newEmail string) error
+	SendEmailChangeNotice(userID uint, newEmail string) error
+	DeleteUserAt(userID uint, deleteAt time.Time) error
+	RearmUserDeath(user db.KeptUser) error
+	SendLastMessagePreview(lastMessageID uint) error
+}, keys *tokens.Keyring, enqueueTask tasks.TaskEnqueueFunc, baseURL string, minDurationBetweenEmail time.Duration,
+	cancelUserDeath tasks.CancelUserDeathFunc, runUserDeath tasks.RunUserDeathFunc, accountDeletionCoolingOff time.Duration,
+	blobs blob.BlobStore, attachmentMaxSize int64, attachmentQuota int64, previewLastMessage tasks.PreviewLastMessageFunc,
+	translate email.TranslateFunc,
 ) *gin.Engine {
 	r := gin.Default()
 	r.Use(middlewares.ErrorHandler())
 
-	jwtSecretBytes := []byte(jwtSecret)
 	audience := []string{baseURL}
-	parseUserAuthToken := tokens.ParseUserAuth(jwtSecretBytes, audience, baseURL)
-	checkAuth := middlewares.CheckAuth(parseUserAuthToken)
-	parseEmailVerificationToken := tokens.ParseEmailVerification(jwtSecretBytes, baseURL, baseURL)
-	createUserAuthToken := tokens.CreateUserAuth(jwtSecretBytes, audience, baseURL)
+	parseUserAuthToken := tokens.ParseUserAuth(keys, audience, baseURL)
+	checkAuth := middlewares.CheckAuth(parseUserAuthToken, db)
+	parseEmailVerificationToken := tokens.ParseEmailVerification(keys, baseURL, baseURL)
+	createUserAuthToken := tokens.CreateUserAuth(keys, audience, baseURL)
+	parseUserLifeStatusToken := tokens.ParseUserLifeStatus(keys, audience, baseURL)
+	parseTrustedContactDecisionToken := tokens.ParseTrustedContactDecision(keys, audience, baseURL)
+	parseRecipientOptInToken := tokens.ParseRecipientOptIn(keys, audience, baseURL)
+	parseChannelOptInToken := tokens.ParseChannelOptIn(keys, audience, baseURL)
+	parsePasswordResetToken := tokens.ParsePasswordReset(keys, audience, baseURL)
+	createMFAPendingToken := tokens.CreateMFAPending(keys, audience, baseURL)
+	parseMFAPendingToken := tokens.ParseMFAPending(keys, audience, baseURL)
+	parseEmailChangeToken := tokens.ParseEmailChange(keys, audience, baseURL)
+	parseEmailChangeCancelToken := tokens.ParseEmailChangeCancel(keys, audience, baseURL)
+	parseMessageAccessToken := tokens.ParseMessageAccess(keys, audience, baseURL)
+	parseAttachmentAccessToken := tokens.ParseAttachmentAccess(keys, audience, baseURL)
+
+	// the public keys tokens are signed with, for services that verify them
+	r.GET("/.well-known/jwks.json", jwks.JWKS(keys))
 
 	// user stuff
 	{
 		user := r.Group("/user")
 		user.POST("/register", users.Register(db, queue, argon2id.CreateHash, parseEmailVerificationToken))
 		user.POST("/verify-email", users.VerifyEmail(queue, db))
-		user.POST("/login", users.Login(db, argon2id.ComparePasswordAndHash, createUserAuthToken))
+		user.POST("/login", users.Login(db, argon2id.ComparePasswordAndHash, createUserAuthToken, createMFAPendingToken))
+		user.POST("/login/mfa", users.LoginMFA(db, parseMFAPendingToken, createUserAuthToken))
+		user.POST("/mfa/totp", checkAuth, users.EnrollTOTP(db))
+		user.POST("/mfa/totp/verify", checkAuth, users.VerifyTOTP(db))
+		user.DELETE("/mfa/totp", checkAuth, users.DisableTOTP(db))
+		user.POST("/token/refresh", users.Refresh(db, createUserAuthToken))
+		user.GET("/sessions", checkAuth, sessions.List(db))
+		user.DELETE("/sessions", checkAuth, sessions.DeleteAll(db)) // log out everywhere
+		user.DELETE("/sessions/:id", checkAuth, sessions.Delete(db))
+		user.POST("/password/forgot", users.ForgotPassword(db, queue, minDurationBetweenEmail))
+		user.POST("/password/reset", users.ResetPassword(db, argon2id.CreateHash, parsePasswordResetToken))
 		user.GET("/profile", checkAuth, users.GetData(db))
+		user.DELETE("", checkAuth, users.DeleteAccount(db, queue, cancelUserDeath, argon2id.ComparePasswordAndHash, accountDeletionCoolingOff))
+		user.DELETE("/deletion", checkAuth, users.CancelDeletion(db, queue))
+		user.GET("/export", checkAuth, users.Export(db))
 		user.PUT("/set-email-interval", checkAuth, users.SetEmailInterval(queue, minDurationBetweenEmail))
+		user.PUT("/grace-period", checkAuth, users.SetGracePeriod(queue))
+		user.PUT("/locale", checkAuth, users.SetLocale(queue))
+		user.PUT("/email", checkAuth, users.ChangeEmail(db, queue, argon2id.ComparePasswordAndHash))
+		user.GET("/email/confirm", users.AskConfirmEmailChange())
+		user.POST("/email/confirm", users.ConfirmEmailChange(db, parseEmailChangeToken))
+		user.GET("/email/cancel", users.AskCancelEmailChange())
+		user.POST("/email/cancel", users.CancelEmailChange(db, parseEmailChangeCancelToken))
+		user.GET("/life/verify", users.AskVerifyLifeStatus())
+		user.POST("/life/verify", users.VerifyLifeStatus(db, parseUserLifeStatusToken, cancelUserDeath))
+		user.POST("/check-in", checkAuth, users.CheckIn(db, cancelUserDeath))
+		user.GET("/check-ins", checkAuth, users.ListCheckIns(db))
 
 		// groups
 		user.DELETE("/groups/:id", checkAuth, groups.Delete(db, queue))
 		user.POST("/groups", checkAuth, groups.Add(queue))
 		user.GET("/groups", checkAuth, groups.List(db)) // list groups
 		user.PATCH("/groups/:id", checkAuth, groups.Edit(db, queue))
-		user.GET("/groups/:id", checkAuth)
+		user.GET("/groups/:id", checkAuth, groups.Get(db))
+
+		// recipients
+		user.POST("/groups/:id/recipients", checkAuth, recipients.Add(db, queue))
+		user.GET("/groups/:id/recipients", checkAuth, recipients.List(db))
+		user.PUT("/groups/:id/recipients/:recipientID", checkAuth, recipients.Edit(db, queue))
+		user.DELETE("/groups/:id/recipients/:recipientID", checkAuth, recipients.Delete(db, queue))
+		user.POST("/groups/:id/recipients/notify", checkAuth, recipients.Notify(db, queue, minDurationBetweenEmail))
 
 		// lastMessages
 		user.POST("/last-messages", checkAuth, messages.Add(db, queue))
 		user.GET("/last-messages", checkAuth, messages.List(db))
+		user.GET("/last-messages/:id", checkAuth, messages.Get(db))
 		user.PATCH("/last-messages/:id", checkAuth, messages.Edit(db, queue))
 		user.DELETE("/last-messages/:id", checkAuth, messages.Delete(db, queue))
+		user.POST("/last-messages/:id/attachments", checkAuth, messages.UploadAttachment(db, blobs, attachmentMaxSize, attachmentQuota))
+		user.GET("/last-messages/:id/attachments", checkAuth, messages.ListAttachments(db))
+		user.GET("/last-messages/:id/attachments/:attachmentID", checkAuth, messages.DownloadAttachment(db, blobs))
+		user.DELETE("/last-messages/:id/attachments/:attachmentID", checkAuth, messages.DeleteAttachment(db))
+		user.POST("/last-messages/:id/preview", checkAuth, messages.Preview(db, previewLastMessage, queue, minDurationBetweenEmail))
+		user.GET("/last-messages/:id/revisions", checkAuth, messages.ListRevisions(db))
+		user.POST("/last-messages/:id/revisions/:revisionID/restore", checkAuth, messages.RestoreRevision(db))
+		user.GET("/deliveries", checkAuth, messages.ListDeliveries(db))
+
+		// trusted contacts
+		user.POST("/trusted-contacts", checkAuth, trustedcontacts.Add(queue))
+		user.GET("/trusted-contacts", checkAuth, trustedcontacts.List(db))
+		user.DELETE("/trusted-contacts/:id", checkAuth, trustedcontacts.Delete(db, queue))
+		user.PUT("/trusted-contacts/settings", checkAuth, trustedcontacts.SetSettings(queue))
+
+		// notification channels
+		user.POST("/channels", checkAuth, channels.Add(db, queue))
+		user.GET("/channels", checkAuth, channels.List(db))
+		user.DELETE("/channels/:id", checkAuth, channels.Delete(db, queue))
+		user.POST("/channels/:id/test", checkAuth, channels.Test(db, queue, minDurationBetweenEmail))
+	}
+
+	// the links in the emails sent to trusted contacts
+	{
+		trustedContacts := r.Group("/trusted-contacts")
+		trustedContacts.GET("/confirm", trustedcontacts.AskConfirm(db, parseTrustedContactDecisionToken, translate))
+		trustedContacts.POST("/confirm", trustedcontacts.Confirm(db, parseTrustedContactDecisionToken, runUserDeath, translate))
+		trustedContacts.GET("/veto", trustedcontacts.AskVeto(db, parseTrustedContactDecisionToken, translate))
+		trustedContacts.POST("/veto", trustedcontacts.Veto(db, parseTrustedContactDecisionToken, cancelUserDeath, translate))
+	}
+
+	// the links in the notices sent to recipients
+	{
+		recipientOptIn := r.Group("/recipients")
+		recipientOptIn.GET("/confirm", recipients.AskConfirm())
+		recipientOptIn.POST("/confirm", recipients.Confirm(db, parseRecipientOptInToken))
+		recipientOptIn.GET("/unsubscribe", recipients.AskUnsubscribe())
+		// also the target of one-click unsubscribes from the List-Unsubscribe header
+		recipientOptIn.POST("/unsubscribe", recipients.Unsubscribe(db, parseRecipientOptInToken))
+	}
+
+	// the links in the emails sent to email notification channels
+	{
+		channelOptIn := r.Group("/channels")
+		channelOptIn.GET("/confirm", channels.AskConfirm())
+		channelOptIn.POST("/confirm", channels.Confirm(db, parseChannelOptInToken))
+		channelOptIn.GET("/unsubscribe", channels.AskUnsubscribe())
+		// also the target of one-click unsubscribes from the List-Unsubscribe header
+		channelOptIn.POST("/unsubscribe", channels.Unsubscribe(db, parseChannelOptInToken))
+	}
+
+	// the links in the death emails of encrypted messages and messages whose key was split
+	{
+		lastMessages := r.Group("/messages")
+		lastMessages.GET("/view", messages.View("view.html"))
+		lastMessages.GET("/view.js", messages.View("view.js"))
+		lastMessages.GET("/encrypted", messages.EncryptedMessage(db, parseMessageAccessToken))
+		lastMessages.GET("/shared", messages.View("shared.html"))
+		lastMessages.GET("/shared.js", messages.View("shared.js"))
+		lastMessages.GET("/shared/info", messages.SharedMessage(db, parseMessageAccessToken))
+		lastMessages.POST("/shared/unlock", messages.UnlockSharedMessage(db, parseMessageAccessToken))
+		lastMessages.GET("/attachments/download", messages.DownloadDeliveryAttachment(db, blobs, parseAttachmentAccessToken))
 	}
 	return r
 }
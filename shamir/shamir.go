@@ -0,0 +1,190 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8): a secret is split into n shares, any threshold of
+// which recover it, while fewer reveal nothing about it.
+package shamir
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"github.com/gragorther/epigo/envelope"
+)
+
+// the x coordinates are the non-zero field elements
+const MaxShares = 255
+
+var (
+	ErrEmptySecret      = errors.New("shamir: empty secret")
+	ErrInvalidThreshold = errors.New("shamir: the threshold must be between 2 and the number of shares")
+	ErrTooManyShares    = errors.New("shamir: at most 255 shares are supported")
+	ErrInvalidShares    = errors.New("shamir: shares must be at least 2 of the same length with different x coordinates")
+	ErrNotEnoughShares  = errors.New("shamir: the shares don't recover the key")
+)
+
+// splits secret into n shares. Each share is one byte longer than the secret, the last byte is its x coordinate.
+func Split(secret []byte, n int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	if n > MaxShares {
+		return nil, ErrTooManyShares
+	}
+	if threshold < 2 || threshold > n {
+		return nil, ErrInvalidThreshold
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+	// a random polynomial of degree threshold-1 per byte, with the secret byte as the constant term
+	coefficients := make([]byte, threshold)
+	for b, secretByte := range secret {
+		coefficients[0] = secretByte
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			share[b] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+// recovers the secret from at least threshold shares. With fewer shares, or shares of different secrets, the result is
+// garbage rather than an error, so check it some other way, like with an AEAD.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+	length := len(shares[0])
+	if length < 2 {
+		return nil, ErrInvalidShares
+	}
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != length {
+			return nil, ErrInvalidShares
+		}
+		x := share[length-1]
+		if x == 0 || seen[x] {
+			return nil, ErrInvalidShares
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, length-1)
+	for i, share := range shares {
+		// the Lagrange basis polynomial of this share at x = 0. Subtraction is xor in GF(2^8).
+		basis := byte(1)
+		for j, x := range xs {
+			if i != j {
+				basis = mul(basis, div(x, x^xs[i]))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= mul(share[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// Horner's method
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+// log and exp tables of GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1 and generator 3
+var logTable, expTable = func() (logTable [256]byte, expTable [255]byte) {
+	x := byte(1)
+	for i := range expTable {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		// multiply by 3: x*2 xor x, reducing by the polynomial when x*2 overflows
+		double := x << 1
+		if x&0x80 != 0 {
+			double ^= 0x1b
+		}
+		x ^= double
+	}
+	return logTable, expTable
+}()
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// formats a share for people to copy, in groups of 4 characters
+func EncodeShare(share []byte) string {
+	encoded := encoding.EncodeToString(share)
+	var groups []string
+	for len(encoded) > 4 {
+		groups = append(groups, encoded[:4])
+		encoded = encoded[4:]
+	}
+	return strings.Join(append(groups, encoded), "-")
+}
+
+// parses a share formatted by EncodeShare. Dashes, spaces and case are ignored, since people may type shares in by hand.
+func DecodeShare(s string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "", "\n", "", "\r", "", "\t", "").Replace(s))
+	return encoding.DecodeString(normalized)
+}
+
+// encrypts plaintext with a new AES-256-GCM key and splits the key into n shares, so the plaintext can only be read once
+// threshold of them are put together again. The key itself isn't returned.
+func SealAndSplit(plaintext []byte, n int, threshold int) (ciphertext []byte, shares [][]byte, err error) {
+	key := make([]byte, envelope.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := envelope.NewDataKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ciphertext, err = dataKey.Seal(plaintext, nil); err != nil {
+		return nil, nil, err
+	}
+	if shares, err = Split(key, n, threshold); err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, shares, nil
+}
+
+// puts the shares from SealAndSplit together and decrypts the ciphertext. Fails with ErrNotEnoughShares when the shares
+// don't recover the key, e.g. because there are too few of them or one is wrong.
+func CombineAndOpen(ciphertext []byte, shares [][]byte) (plaintext []byte, err error) {
+	key, err := Combine(shares)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := envelope.NewDataKey(key)
+	if err != nil {
+		return nil, ErrInvalidShares
+	}
+	plaintext, err = dataKey.Open(ciphertext, nil)
+	if err != nil {
+		return nil, ErrNotEnoughShares
+	}
+	return plaintext, nil
+}
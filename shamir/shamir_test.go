@@ -0,0 +1,79 @@
+package shamir_test
+
+import (
+	"testing"
+
+	"github.com/gragorther/epigo/shamir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitAndCombine(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := shamir.Split(secret, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		picked := make([][]byte, len(subset))
+		for i, s := range subset {
+			picked[i] = shares[s]
+		}
+		combined, err := shamir.Combine(picked)
+		require.NoError(t, err)
+		assert.Equal(t, secret, combined, "shares %v should recover the secret", subset)
+	}
+
+	combined, err := shamir.Combine(shares[:2])
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, combined, "fewer shares than the threshold shouldn't recover the secret")
+}
+
+func TestSplitErrors(t *testing.T) {
+	_, err := shamir.Split(nil, 3, 2)
+	assert.ErrorIs(t, err, shamir.ErrEmptySecret)
+	_, err = shamir.Split([]byte("secret"), 3, 4)
+	assert.ErrorIs(t, err, shamir.ErrInvalidThreshold)
+	_, err = shamir.Split([]byte("secret"), 3, 1)
+	assert.ErrorIs(t, err, shamir.ErrInvalidThreshold, "a threshold of 1 would give every share the whole secret")
+	_, err = shamir.Split([]byte("secret"), 256, 2)
+	assert.ErrorIs(t, err, shamir.ErrTooManyShares)
+}
+
+func TestCombineErrors(t *testing.T) {
+	shares, err := shamir.Split([]byte("secret"), 3, 2)
+	require.NoError(t, err)
+
+	_, err = shamir.Combine(shares[:1])
+	assert.ErrorIs(t, err, shamir.ErrInvalidShares)
+	_, err = shamir.Combine([][]byte{shares[0], shares[0]})
+	assert.ErrorIs(t, err, shamir.ErrInvalidShares, "the same share twice shouldn't count as two")
+	_, err = shamir.Combine([][]byte{shares[0], shares[1][1:]})
+	assert.ErrorIs(t, err, shamir.ErrInvalidShares)
+}
+
+func TestEncodeShare(t *testing.T) {
+	shares, err := shamir.Split([]byte("secret"), 2, 2)
+	require.NoError(t, err)
+
+	encoded := shamir.EncodeShare(shares[0])
+	assert.Contains(t, encoded, "-")
+	decoded, err := shamir.DecodeShare(" " + encoded + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, shares[0], decoded)
+}
+
+func TestSealAndSplit(t *testing.T) {
+	ciphertext, shares, err := shamir.SealAndSplit([]byte("wallet seed"), 3, 2)
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "wallet seed")
+
+	plaintext, err := shamir.CombineAndOpen(ciphertext, [][]byte{shares[2], shares[0]})
+	require.NoError(t, err)
+	assert.Equal(t, "wallet seed", string(plaintext))
+
+	other, _, err := shamir.SealAndSplit([]byte("other"), 3, 2)
+	require.NoError(t, err)
+	_, err = shamir.CombineAndOpen(other, shares[:2])
+	assert.ErrorIs(t, err, shamir.ErrNotEnoughShares, "shares of another message shouldn't open it")
+}
@@ -25,18 +25,18 @@ func NewUserLifeStatusClaims(userID uint, audience []string, issuer string, expi
 type CreateUserLifeStatusFunc func(userID uint, expiresAt time.Time) (token string, err error)
 
 // token for verifying that the user is still alive (or something similar, i.e. not kidnapped)
-func CreateUserLifeStatus(jwtSecret []byte, audience []string, issuer string) CreateUserLifeStatusFunc {
+func CreateUserLifeStatus(keys *Keyring, audience []string, issuer string) CreateUserLifeStatusFunc {
 	return func(userID uint, expiresAt time.Time) (token string, err error) {
-		return createToken(jwtSecret, NewUserLifeStatusClaims(userID, audience, issuer, expiresAt))
+		return createToken(keys, NewUserLifeStatusClaims(userID, audience, issuer, expiresAt))
 	}
 }
 
 type ParseUserLifeStatusFunc func(tokenString string) (userID uint, err error)
 
-func ParseUserLifeStatus(jwtSecret []byte, audience []string, issuer string) ParseUserLifeStatusFunc {
+func ParseUserLifeStatus(keys *Keyring, audience []string, issuer string) ParseUserLifeStatusFunc {
 	return func(tokenString string) (userID uint, err error) {
 		var claims UserLifeStatusClaims
-		if err := parseToken(jwtSecret, tokenString, TypeUserLifeStatus, audience, issuer, "", &claims); err != nil {
+		if err := parseToken(keys, tokenString, TypeUserLifeStatus, audience, issuer, "", &claims); err != nil {
 			return 0, fmt.Errorf("failed to parse token: %w", err)
 		}
 		return claims.UserID, nil
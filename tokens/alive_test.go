@@ -0,0 +1,48 @@
+package tokens_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createUserLifeStatus = tokens.CreateUserLifeStatus(keys, []string{audience}, issuer)
+	parseUserLifeStatus  = tokens.ParseUserLifeStatus(keys, []string{audience}, issuer)
+)
+
+func TestParseUserLifeStatus(t *testing.T) {
+	const userID = 12
+	table := map[string]struct {
+		ExpiresAt time.Time
+		WantError bool
+	}{
+		"valid":   {ExpiresAt: time.Now().Add(time.Hour)},
+		"expired": {ExpiresAt: time.Now().Add(-time.Hour), WantError: true},
+	}
+
+	for name, test := range table {
+		t.Run(name, func(t *testing.T) {
+			token, err := createUserLifeStatus(userID, test.ExpiresAt)
+			require.NoError(t, err, "creating user life status token shouldn't fail")
+
+			got, err := parseUserLifeStatus(token)
+			if test.WantError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, uint(userID), got)
+		})
+	}
+
+	t.Run("wrong token type", func(t *testing.T) {
+		token, err := createUserAuth(userID, 1)
+		require.NoError(t, err)
+		_, err = parseUserLifeStatus(token)
+		assert.Error(t, err, "user auth tokens shouldn't be accepted as life status tokens")
+	})
+}
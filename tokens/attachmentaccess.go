@@ -0,0 +1,45 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypeAttachmentAccess = "attachmentAccess"
+
+type AttachmentAccessClaims struct {
+	Claims
+	DeliveryID   uint `json:"deliveryID,omitzero"`
+	AttachmentID uint `json:"attachmentID,omitzero"`
+}
+
+type CreateAttachmentAccessFunc func(deliveryID uint, attachmentID uint, expiresAt time.Time) (token string, err error)
+
+// token in the download links of attachments that were too large to go into the death email
+func CreateAttachmentAccess(keys *Keyring, audience []string, issuer string) CreateAttachmentAccessFunc {
+	return func(deliveryID uint, attachmentID uint, expiresAt time.Time) (token string, err error) {
+		return createToken(keys, AttachmentAccessClaims{
+			Claims:       NewClaims(TypeAttachmentAccess, audience, issuer, jwt.NewNumericDate(expiresAt), nil, strconv.FormatUint(uint64(deliveryID), 10)),
+			DeliveryID:   deliveryID,
+			AttachmentID: attachmentID,
+		})
+	}
+}
+
+type ParseAttachmentAccessFunc func(tokenString string) (deliveryID uint, attachmentID uint, err error)
+
+func ParseAttachmentAccess(keys *Keyring, audience []string, issuer string) ParseAttachmentAccessFunc {
+	return func(tokenString string) (deliveryID uint, attachmentID uint, err error) {
+		var claims AttachmentAccessClaims
+		if err := parseToken(keys, tokenString, TypeAttachmentAccess, audience, issuer, "", &claims); err != nil {
+			return 0, 0, fmt.Errorf("failed to parse token: %w", err)
+		}
+		if claims.DeliveryID == 0 || claims.AttachmentID == 0 {
+			return 0, 0, ErrInvalidToken
+		}
+		return claims.DeliveryID, claims.AttachmentID, nil
+	}
+}
@@ -0,0 +1,35 @@
+package tokens_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createAttachmentAccess = tokens.CreateAttachmentAccess(keys, []string{audience}, issuer)
+	parseAttachmentAccess  = tokens.ParseAttachmentAccess(keys, []string{audience}, issuer)
+)
+
+func TestParseAttachmentAccess(t *testing.T) {
+	token, err := createAttachmentAccess(12, 3, time.Now().Add(time.Hour))
+	require.NoError(t, err, "creating attachment access token shouldn't fail")
+
+	deliveryID, attachmentID, err := parseAttachmentAccess(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(12), deliveryID)
+	assert.Equal(t, uint(3), attachmentID)
+
+	expired, err := createAttachmentAccess(12, 3, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	_, _, err = parseAttachmentAccess(expired)
+	assert.Error(t, err, "download links should expire")
+
+	messageAccessToken, err := createMessageAccess(12)
+	require.NoError(t, err)
+	_, _, err = parseAttachmentAccess(messageAccessToken)
+	assert.Error(t, err, "message access tokens shouldn't give access to attachments")
+}
@@ -0,0 +1,49 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypeChannelOptIn = "channelOptIn"
+
+type ChannelOptInClaims struct {
+	Claims
+	ChannelID uint `json:"channelID,omitzero"`
+	// the address of the email channel. The token stops working once the channel's target is changed.
+	Email string `json:"email,omitzero"`
+}
+
+type CreateChannelOptInFunc func(channelID uint, email string) (token string, err error)
+
+// the unsubscribe link goes along with every notification, so it has to keep working for as long as they're sent
+const channelOptInExpiry = 365 * 24 * time.Hour
+
+// token that lets the address of an email channel confirm that it wants the user's notifications, or unsubscribe
+func CreateChannelOptIn(keys *Keyring, audience []string, issuer string) CreateChannelOptInFunc {
+	return func(channelID uint, email string) (token string, err error) {
+		return createToken(keys, ChannelOptInClaims{
+			Claims:    NewClaims(TypeChannelOptIn, audience, issuer, jwt.NewNumericDate(time.Now().Add(channelOptInExpiry)), nil, strconv.FormatUint(uint64(channelID), 10)),
+			ChannelID: channelID,
+			Email:     email,
+		})
+	}
+}
+
+type ParseChannelOptInFunc func(tokenString string) (channelID uint, email string, err error)
+
+func ParseChannelOptIn(keys *Keyring, audience []string, issuer string) ParseChannelOptInFunc {
+	return func(tokenString string) (channelID uint, email string, err error) {
+		var claims ChannelOptInClaims
+		if err := parseToken(keys, tokenString, TypeChannelOptIn, audience, issuer, "", &claims); err != nil {
+			return 0, "", fmt.Errorf("failed to parse token: %w", err)
+		}
+		if claims.Email == "" {
+			return 0, "", ErrEmptyEmailClaim
+		}
+		return claims.ChannelID, claims.Email, nil
+	}
+}
@@ -0,0 +1,36 @@
+package tokens_test
+
+import (
+	"testing"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createChannelOptIn = tokens.CreateChannelOptIn(keys, []string{audience}, issuer)
+	parseChannelOptIn  = tokens.ParseChannelOptIn(keys, []string{audience}, issuer)
+)
+
+func TestParseChannelOptIn(t *testing.T) {
+	const channelID = 7
+	const email = "backup@testing.com"
+	token, err := createChannelOptIn(channelID, email)
+	require.NoError(t, err, "creating channel opt-in token shouldn't fail")
+
+	gotID, gotEmail, err := parseChannelOptIn(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(channelID), gotID)
+	assert.Equal(t, email, gotEmail)
+
+	token, err = createChannelOptIn(channelID, "")
+	require.NoError(t, err)
+	_, _, err = parseChannelOptIn(token)
+	assert.ErrorIs(t, err, tokens.ErrEmptyEmailClaim, "tokens that aren't bound to an email shouldn't be accepted")
+
+	recipientToken, err := createRecipientOptIn(channelID, email)
+	require.NoError(t, err)
+	_, _, err = parseChannelOptIn(recipientToken)
+	assert.Error(t, err, "recipient opt-in tokens shouldn't be accepted")
+}
@@ -15,9 +15,9 @@ type EmailClaims struct {
 }
 type CreateEmailVerificationFunc func(userEmail string) (token string, err error)
 
-func CreateEmailVerification(jwtSecret []byte, audience string, issuer string) CreateEmailVerificationFunc {
+func CreateEmailVerification(keys *Keyring, audience string, issuer string) CreateEmailVerificationFunc {
 	return func(userEmail string) (token string, err error) {
-		return createToken(jwtSecret, EmailClaims{
+		return createToken(keys, EmailClaims{
 			Email: userEmail,
 			Claims: Claims{RegisteredClaims: jwt.RegisteredClaims{
 				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 2)),
@@ -37,10 +37,10 @@ var (
 
 type ParseEmailVerificationFunc func(tokenString string) (userEmail string, err error)
 
-func ParseEmailVerification(jwtSecret []byte, audience string, issuer string) ParseEmailVerificationFunc {
+func ParseEmailVerification(keys *Keyring, audience string, issuer string) ParseEmailVerificationFunc {
 	return func(tokenString string) (userEmail string, err error) {
 		var claims EmailClaims
-		if err := parseToken(jwtSecret, tokenString, TypeEmailVerification, []string{audience}, issuer, "", &claims); err != nil {
+		if err := parseToken(keys, tokenString, TypeEmailVerification, []string{audience}, issuer, "", &claims); err != nil {
 			return "", err
 		}
 
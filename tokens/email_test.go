@@ -17,7 +17,7 @@ const (
 	issuer   = "https://testserver.com"
 )
 
-var parseEmailVerificationToken = tokens.ParseEmailVerification(jwtSecret, audience, issuer)
+var parseEmailVerificationToken = tokens.ParseEmailVerification(keys, audience, issuer)
 
 func TestCreateEmailVerification(t *testing.T) {
 	table := map[string]struct {
@@ -31,7 +31,7 @@ func TestCreateEmailVerification(t *testing.T) {
 	for name, test := range table {
 		t.Run(name, func(t *testing.T) {
 			require := require.New(t)
-			gotToken, err := tokens.CreateEmailVerification(jwtSecret, audience, issuer)(test.Email)
+			gotToken, err := tokens.CreateEmailVerification(keys, audience, issuer)(test.Email)
 			require.NoError(err, "creating email verification token shouldn't fail")
 			userEmail, err := parseEmailVerificationToken(gotToken)
 			require.NoError(err, "parsing token shouldn't fail")
@@ -59,7 +59,7 @@ func TestParseEmailVerification(t *testing.T) {
 
 	{
 		var err error
-		table[0].Token, err = tokens.CreateEmailVerification(jwtSecret, audience, issuer)(testEmail)
+		table[0].Token, err = tokens.CreateEmailVerification(keys, audience, issuer)(testEmail)
 		if err != nil {
 			t.Fatalf("failed to create token: %v", err)
 		}
@@ -0,0 +1,85 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypeEmailChange = "emailChange"
+
+type EmailChangeClaims struct {
+	Claims
+	UserID uint `json:"userID,omitzero"`
+	// the address the user had when they asked for the change. The token stops working once it changes.
+	OldEmail string `json:"oldEmail,omitempty"`
+	NewEmail string `json:"newEmail,omitempty"`
+}
+
+const EmailChangeExpiry = 24 * time.Hour
+
+type CreateEmailChangeFunc func(userID uint, oldEmail string, newEmail string) (token string, err error)
+
+// token sent to the new address, proving the user can receive email there
+func CreateEmailChange(keys *Keyring, audience []string, issuer string) CreateEmailChangeFunc {
+	return func(userID uint, oldEmail string, newEmail string) (token string, err error) {
+		return createToken(keys, EmailChangeClaims{
+			Claims:   NewClaims(TypeEmailChange, audience, issuer, jwt.NewNumericDate(time.Now().Add(EmailChangeExpiry)), nil, strconv.FormatUint(uint64(userID), 10)),
+			UserID:   userID,
+			OldEmail: oldEmail,
+			NewEmail: newEmail,
+		})
+	}
+}
+
+// issuedAt is when the change was asked for, changes that were cancelled since don't go through
+type ParseEmailChangeFunc func(tokenString string) (userID uint, oldEmail string, newEmail string, issuedAt time.Time, err error)
+
+func ParseEmailChange(keys *Keyring, audience []string, issuer string) ParseEmailChangeFunc {
+	return func(tokenString string) (userID uint, oldEmail string, newEmail string, issuedAt time.Time, err error) {
+		var claims EmailChangeClaims
+		if err := parseToken(keys, tokenString, TypeEmailChange, audience, issuer, "", &claims); err != nil {
+			return 0, "", "", time.Time{}, fmt.Errorf("failed to parse token: %w", err)
+		}
+		if claims.UserID == 0 || claims.NewEmail == "" || claims.IssuedAt == nil {
+			return 0, "", "", time.Time{}, ErrInvalidToken
+		}
+		return claims.UserID, claims.OldEmail, claims.NewEmail, claims.IssuedAt.Time, nil
+	}
+}
+
+const TypeEmailChangeCancel = "emailChangeCancel"
+
+type EmailChangeCancelClaims struct {
+	Claims
+	UserID uint `json:"userID,omitzero"`
+}
+
+type CreateEmailChangeCancelFunc func(userID uint) (token string, err error)
+
+// token sent to the old address, which cancels the changes asked for until then. It lasts as long as the change links.
+func CreateEmailChangeCancel(keys *Keyring, audience []string, issuer string) CreateEmailChangeCancelFunc {
+	return func(userID uint) (token string, err error) {
+		return createToken(keys, EmailChangeCancelClaims{
+			Claims: NewClaims(TypeEmailChangeCancel, audience, issuer, jwt.NewNumericDate(time.Now().Add(EmailChangeExpiry)), nil, strconv.FormatUint(uint64(userID), 10)),
+			UserID: userID,
+		})
+	}
+}
+
+type ParseEmailChangeCancelFunc func(tokenString string) (userID uint, err error)
+
+func ParseEmailChangeCancel(keys *Keyring, audience []string, issuer string) ParseEmailChangeCancelFunc {
+	return func(tokenString string) (userID uint, err error) {
+		var claims EmailChangeCancelClaims
+		if err := parseToken(keys, tokenString, TypeEmailChangeCancel, audience, issuer, "", &claims); err != nil {
+			return 0, fmt.Errorf("failed to parse token: %w", err)
+		}
+		if claims.UserID == 0 {
+			return 0, ErrInvalidToken
+		}
+		return claims.UserID, nil
+	}
+}
@@ -0,0 +1,53 @@
+package tokens_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createEmailChange = tokens.CreateEmailChange(keys, []string{audience}, issuer)
+	parseEmailChange  = tokens.ParseEmailChange(keys, []string{audience}, issuer)
+)
+
+var (
+	createEmailChangeCancel = tokens.CreateEmailChangeCancel(keys, []string{audience}, issuer)
+	parseEmailChangeCancel  = tokens.ParseEmailChangeCancel(keys, []string{audience}, issuer)
+)
+
+func TestParseEmailChange(t *testing.T) {
+	token, err := createEmailChange(4, "old@google.com", "new@google.com")
+	require.NoError(t, err, "creating email change token shouldn't fail")
+
+	userID, oldEmail, newEmail, issuedAt, err := parseEmailChange(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(4), userID)
+	assert.Equal(t, "old@google.com", oldEmail)
+	assert.Equal(t, "new@google.com", newEmail)
+	assert.WithinDuration(t, time.Now(), issuedAt, 2*time.Second)
+
+	verificationToken, err := tokens.CreateEmailVerification(keys, audience, issuer)("new@google.com")
+	require.NoError(t, err)
+	_, _, _, _, err = parseEmailChange(verificationToken)
+	assert.Error(t, err, "registration tokens shouldn't be able to change addresses")
+}
+
+func TestParseEmailChangeCancel(t *testing.T) {
+	token, err := createEmailChangeCancel(4)
+	require.NoError(t, err, "creating email change cancel token shouldn't fail")
+
+	userID, err := parseEmailChangeCancel(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(4), userID)
+
+	changeToken, err := createEmailChange(4, "old@google.com", "new@google.com")
+	require.NoError(t, err)
+	_, err = parseEmailChangeCancel(changeToken)
+	assert.Error(t, err, "email change tokens shouldn't cancel changes")
+	_, _, _, _, err = parseEmailChange(token)
+	assert.Error(t, err, "cancel tokens shouldn't change addresses")
+}
@@ -0,0 +1,279 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey        = errors.New("unknown signing key")
+	ErrUnsupportedKey    = errors.New("unsupported key type, only Ed25519 and P-256 ECDSA keys are supported")
+	ErrNotPrivateKey     = errors.New("the signing key must be a private key")
+	ErrDuplicateKeyID    = errors.New("duplicate key ID")
+	ErrNoPEMBlock        = errors.New("no PEM block found")
+	ErrTooManyLegacyKeys = errors.New("only one key can verify tokens without a key ID")
+	ErrEmptyHMACSecret   = errors.New("empty HMAC secret")
+)
+
+type Key struct {
+	// sent in the kid header of tokens, so the key that signed a token can be found when it's parsed
+	ID     string
+	Method jwt.SigningMethod
+	// nil for keys that only verify tokens
+	signKey   any
+	verifyKey any
+	// whether the key verifies tokens without a kid header, which were issued before there were key IDs
+	legacy bool
+}
+
+// a key made from the JWT secret all tokens used to be signed with. It also verifies the tokens
+// that were signed before tokens had key IDs.
+func NewHMACKey(secret []byte) (Key, error) {
+	if len(secret) == 0 {
+		return Key{}, ErrEmptyHMACSecret
+	}
+	sum := sha256.Sum256(secret)
+	return Key{
+		// derived from the secret, so it stays the same across restarts without revealing the secret
+		ID:        "hs256-" + hex.EncodeToString(sum[:4]),
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+		legacy:    true,
+	}, nil
+}
+
+// parses an Ed25519 or P-256 ECDSA key. Private keys (PKCS #8 or SEC 1) can sign tokens, public keys (PKIX)
+// can only verify them, e.g. the key that signed tokens before the last rotation.
+// The key ID is the RFC 7638 thumbprint of the public key.
+func ParsePEMKey(pemBytes []byte) (Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return Key{}, ErrNoPEMBlock
+	}
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	var key Key
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key = Key{Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}
+	case ed25519.PublicKey:
+		key = Key{Method: jwt.SigningMethodEdDSA, verifyKey: k}
+	case *ecdsa.PrivateKey:
+		key = Key{Method: jwt.SigningMethodES256, signKey: k, verifyKey: &k.PublicKey}
+	case *ecdsa.PublicKey:
+		key = Key{Method: jwt.SigningMethodES256, verifyKey: k}
+	default:
+		return Key{}, ErrUnsupportedKey
+	}
+	if k, ok := key.verifyKey.(*ecdsa.PublicKey); ok && k.Curve != elliptic.P256() {
+		return Key{}, ErrUnsupportedKey
+	}
+
+	jwk, err := publicJWK(key)
+	if err != nil {
+		return Key{}, err
+	}
+	key.ID, err = jwk.thumbprint()
+	return key, err
+}
+
+func LoadPEMKeyFile(path string) (Key, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := ParsePEMKey(pemBytes)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// the keys tokens are signed and verified with. Tokens are signed with one key, and verified with
+// whichever key their kid header names, so keys can be rotated without invalidating the tokens that
+// are still out there.
+type Keyring struct {
+	signing Key
+	keys    map[string]Key
+	legacy  *Key
+}
+
+// verificationKeys are keys that aren't used for signing new tokens anymore, but whose tokens are still accepted
+func NewKeyring(signing Key, verificationKeys ...Key) (*Keyring, error) {
+	if signing.signKey == nil {
+		return nil, ErrNotPrivateKey
+	}
+	k := &Keyring{signing: signing, keys: make(map[string]Key)}
+	for _, key := range append([]Key{signing}, verificationKeys...) {
+		if _, exists := k.keys[key.ID]; exists {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateKeyID, key.ID)
+		}
+		k.keys[key.ID] = key
+		if key.legacy {
+			if k.legacy != nil {
+				return nil, ErrTooManyLegacyKeys
+			}
+			k.legacy = &key
+		}
+	}
+	return k, nil
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (tokenString string, err error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.signKey)
+}
+
+// the jwt.Keyfunc used when parsing tokens
+func (k *Keyring) verificationKey(token *jwt.Token) (any, error) {
+	var key Key
+	switch kid := token.Header["kid"].(type) {
+	case nil:
+		if k.legacy == nil {
+			return nil, ErrUnknownKey
+		}
+		key = *k.legacy
+	case string:
+		var ok bool
+		if key, ok = k.keys[kid]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+		}
+	default:
+		return nil, ErrUnknownKey
+	}
+	// otherwise a token could pick the algorithm its key is used with
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+func (k *Keyring) algorithms() []string {
+	var algs []string
+	for _, key := range k.keys {
+		if !slices.Contains(algs, key.Method.Alg()) {
+			algs = append(algs, key.Method.Alg())
+		}
+	}
+	return algs
+}
+
+// a public key in the JSON Web Key format, RFC 7517
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y,omitempty"`
+	KeyID   string `json:"kid,omitempty"`
+	Alg     string `json:"alg,omitempty"`
+	Use     string `json:"use,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func publicJWK(key Key) (JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch k := key.verifyKey.(type) {
+	case ed25519.PublicKey:
+		return JWK{KeyType: "OKP", Curve: "Ed25519", X: encode(k), KeyID: key.ID, Alg: key.Method.Alg(), Use: "sig"}, nil
+	case *ecdsa.PublicKey:
+		// the coordinates are padded to the size of the curve, RFC 7518 section 6.2.1.2
+		x, y := make([]byte, 32), make([]byte, 32)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return JWK{KeyType: "EC", Curve: "P-256", X: encode(x), Y: encode(y), KeyID: key.ID, Alg: key.Method.Alg(), Use: "sig"}, nil
+	}
+	return JWK{}, ErrUnsupportedKey
+}
+
+// RFC 7638: the hash of the required members in lexicographic order
+func (j JWK) thumbprint() (string, error) {
+	members := map[string]string{"crv": j.Curve, "kty": j.KeyType, "x": j.X}
+	if j.Y != "" {
+		members["y"] = j.Y
+	}
+	// encoding/json sorts map keys
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// the public keys other services can verify our tokens with. HMAC keys are secret, so they aren't included.
+func (k *Keyring) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		jwk, err := publicJWK(key)
+		if err != nil {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	slices.SortFunc(jwks.Keys, func(a, b JWK) int { return strings.Compare(a.KeyID, b.KeyID) })
+	return jwks
+}
+
+// builds the keyring from the config. New tokens are signed with the key in signingKeyFile, or with the HMAC
+// secret if there is none. The HMAC secret keeps verifying the tokens it signed after switching to a key file.
+func LoadKeyring(hmacSecret []byte, signingKeyFile string, verificationKeyFiles []string) (*Keyring, error) {
+	var keys []Key
+	if len(hmacSecret) != 0 {
+		key, err := NewHMACKey(hmacSecret)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if signingKeyFile != "" {
+		key, err := LoadPEMKeyFile(signingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append([]Key{key}, keys...)
+	}
+	for _, path := range verificationKeyFiles {
+		key, err := LoadPEMKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// a private key can be listed too, but it won't sign anything
+		key.signKey = nil
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNotPrivateKey
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
@@ -0,0 +1,123 @@
+package tokens_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var keys = func() *tokens.Keyring {
+	key, err := tokens.NewHMACKey(jwtSecret)
+	if err != nil {
+		panic(err)
+	}
+	keyring, err := tokens.NewKeyring(key)
+	if err != nil {
+		panic(err)
+	}
+	return keyring
+}()
+
+func pemEncode(t *testing.T, blockType string, der []byte) []byte {
+	t.Helper()
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func newEd25519Key(t *testing.T) (private tokens.Key, public tokens.Key) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	private, err = tokens.ParsePEMKey(pemEncode(t, "PRIVATE KEY", privDER))
+	require.NoError(t, err)
+	public, err = tokens.ParsePEMKey(pemEncode(t, "PUBLIC KEY", pubDER))
+	require.NoError(t, err)
+	return private, public
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, oldPublicKey := newEd25519Key(t)
+	assert.Equal(t, oldKey.ID, oldPublicKey.ID, "the key ID should only depend on the public key")
+
+	oldKeyring, err := tokens.NewKeyring(oldKey)
+	require.NoError(t, err)
+	oldToken, err := tokens.CreateUserAuth(oldKeyring, []string{audience}, issuer)(1, 1)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	newKey, err := tokens.ParsePEMKey(pemEncode(t, "EC PRIVATE KEY", ecDER))
+	require.NoError(t, err)
+	assert.Equal(t, jwt.SigningMethodES256, newKey.Method)
+
+	legacyKey, err := tokens.NewHMACKey(jwtSecret)
+	require.NoError(t, err)
+	keyring, err := tokens.NewKeyring(newKey, oldPublicKey, legacyKey)
+	require.NoError(t, err)
+	parse := tokens.ParseUserAuth(keyring, []string{audience}, issuer)
+
+	_, _, _, err = parse(oldToken)
+	assert.NoError(t, err, "tokens signed with the previous key should still be accepted")
+
+	newToken, err := tokens.CreateUserAuth(keyring, []string{audience}, issuer)(1, 1)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, newKey.ID, parsed.Header["kid"])
+	_, _, _, err = parse(newToken)
+	assert.NoError(t, err)
+
+	// tokens from before there were key IDs
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ": tokens.TypeUserAuth, "id": 1, "sid": 1, "iss": issuer, "aud": audience,
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+	}).SignedString(jwtSecret)
+	require.NoError(t, err)
+	_, _, _, err = parse(legacyToken)
+	assert.NoError(t, err, "tokens without a key ID should be verified with the HMAC key")
+
+	_, _, _, err = tokens.ParseUserAuth(oldKeyring, []string{audience}, issuer)(newToken)
+	assert.Error(t, err, "tokens signed with keys the keyring doesn't know shouldn't be accepted")
+
+	_, err = tokens.NewKeyring(oldPublicKey)
+	assert.ErrorIs(t, err, tokens.ErrNotPrivateKey)
+	_, err = tokens.NewKeyring(newKey, newKey)
+	assert.ErrorIs(t, err, tokens.ErrDuplicateKeyID)
+}
+
+func TestJWKS(t *testing.T) {
+	signingKey, _ := newEd25519Key(t)
+	legacyKey, err := tokens.NewHMACKey(jwtSecret)
+	require.NoError(t, err)
+	keyring, err := tokens.NewKeyring(signingKey, legacyKey)
+	require.NoError(t, err)
+
+	jwks := keyring.JWKS()
+	require.Len(t, jwks.Keys, 1, "HMAC keys are secret and shouldn't be published")
+	assert.Equal(t, tokens.JWK{KeyType: "OKP", Curve: "Ed25519", X: jwks.Keys[0].X, KeyID: signingKey.ID, Alg: "EdDSA", Use: "sig"}, jwks.Keys[0])
+}
+
+func TestParsePEMKeyRejectsOtherCurves(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	require.NoError(t, err)
+	_, err = tokens.ParsePEMKey(pemEncode(t, "PRIVATE KEY", der))
+	assert.ErrorIs(t, err, tokens.ErrUnsupportedKey)
+}
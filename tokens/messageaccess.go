@@ -0,0 +1,46 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypeMessageAccess = "messageAccess"
+
+type MessageAccessClaims struct {
+	Claims
+	DeliveryID uint `json:"deliveryID,omitzero"`
+}
+
+// recipients may keep the death email for years before they open the message
+const MessageAccessExpiry = 10 * 365 * 24 * time.Hour
+
+type CreateMessageAccessFunc func(deliveryID uint) (token string, err error)
+
+// token in the link of a death email that lets the recipient fetch an encrypted last message
+func CreateMessageAccess(keys *Keyring, audience []string, issuer string) CreateMessageAccessFunc {
+	return func(deliveryID uint) (token string, err error) {
+		return createToken(keys, MessageAccessClaims{
+			Claims:     NewClaims(TypeMessageAccess, audience, issuer, jwt.NewNumericDate(time.Now().Add(MessageAccessExpiry)), nil, strconv.FormatUint(uint64(deliveryID), 10)),
+			DeliveryID: deliveryID,
+		})
+	}
+}
+
+type ParseMessageAccessFunc func(tokenString string) (deliveryID uint, err error)
+
+func ParseMessageAccess(keys *Keyring, audience []string, issuer string) ParseMessageAccessFunc {
+	return func(tokenString string) (deliveryID uint, err error) {
+		var claims MessageAccessClaims
+		if err := parseToken(keys, tokenString, TypeMessageAccess, audience, issuer, "", &claims); err != nil {
+			return 0, fmt.Errorf("failed to parse token: %w", err)
+		}
+		if claims.DeliveryID == 0 {
+			return 0, ErrInvalidToken
+		}
+		return claims.DeliveryID, nil
+	}
+}
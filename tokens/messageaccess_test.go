@@ -0,0 +1,29 @@
+package tokens_test
+
+import (
+	"testing"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createMessageAccess = tokens.CreateMessageAccess(keys, []string{audience}, issuer)
+	parseMessageAccess  = tokens.ParseMessageAccess(keys, []string{audience}, issuer)
+)
+
+func TestParseMessageAccess(t *testing.T) {
+	const deliveryID = 12
+	token, err := createMessageAccess(deliveryID)
+	require.NoError(t, err, "creating message access token shouldn't fail")
+
+	got, err := parseMessageAccess(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(deliveryID), got)
+
+	optInToken, err := createRecipientOptIn(deliveryID, testEmail)
+	require.NoError(t, err)
+	_, err = parseMessageAccess(optInToken)
+	assert.Error(t, err, "recipient opt-in tokens shouldn't give access to messages")
+}
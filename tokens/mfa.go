@@ -0,0 +1,44 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypeMFAPending = "mfaPending"
+
+type MFAPendingClaims struct {
+	Claims
+	UserID uint `json:"userID,omitzero"`
+}
+
+// how long the user has to enter their code after entering their password
+const MFAPendingExpiry = 5 * time.Minute
+
+type CreateMFAPendingFunc func(userID uint) (token string, err error)
+
+// token that shows the user has entered the right password, but not yet their second factor.
+// It can only be exchanged for a userAuth token at /user/login/mfa.
+func CreateMFAPending(keys *Keyring, audience []string, issuer string) CreateMFAPendingFunc {
+	return func(userID uint) (token string, err error) {
+		return createToken(keys, MFAPendingClaims{
+			Claims: NewClaims(TypeMFAPending, audience, issuer, jwt.NewNumericDate(time.Now().Add(MFAPendingExpiry)), nil, strconv.FormatUint(uint64(userID), 10)),
+			UserID: userID,
+		})
+	}
+}
+
+type ParseMFAPendingFunc func(tokenString string) (userID uint, err error)
+
+func ParseMFAPending(keys *Keyring, audience []string, issuer string) ParseMFAPendingFunc {
+	return func(tokenString string) (userID uint, err error) {
+		var claims MFAPendingClaims
+		if err := parseToken(keys, tokenString, TypeMFAPending, audience, issuer, "", &claims); err != nil {
+			return 0, fmt.Errorf("failed to parse token: %w", err)
+		}
+		return claims.UserID, nil
+	}
+}
@@ -0,0 +1,27 @@
+package tokens_test
+
+import (
+	"testing"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createMFAPending = tokens.CreateMFAPending(keys, []string{audience}, issuer)
+	parseMFAPending  = tokens.ParseMFAPending(keys, []string{audience}, issuer)
+)
+
+func TestParseMFAPending(t *testing.T) {
+	const userID = 9
+	token, err := createMFAPending(userID)
+	require.NoError(t, err, "creating mfa pending token shouldn't fail")
+
+	got, err := parseMFAPending(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(userID), got)
+
+	_, _, _, err = parseUserAuth(token)
+	assert.Error(t, err, "mfa pending tokens shouldn't be usable as auth tokens")
+}
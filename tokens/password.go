@@ -0,0 +1,66 @@
+package tokens
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypePasswordReset = "passwordReset"
+
+type PasswordResetClaims struct {
+	Claims
+	UserID uint `json:"userID,omitzero"`
+	// derived from the password hash the token was issued for. Once the password changes, the token can't be used anymore.
+	PasswordFingerprint string `json:"pwf,omitempty"`
+}
+
+const PasswordResetExpiry = time.Hour
+
+var ErrPasswordChanged = errors.New("the password has changed since the token was issued")
+
+// identifies a password hash without revealing it. Tokens are readable by anyone who has them,
+// so the hash itself isn't put into them.
+func PasswordFingerprint(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+type CreatePasswordResetFunc func(userID uint, passwordHash string) (token string, err error)
+
+// token that lets the user set a new password. It's bound to their current password hash, so it can only be used once.
+func CreatePasswordReset(keys *Keyring, audience []string, issuer string) CreatePasswordResetFunc {
+	return func(userID uint, passwordHash string) (token string, err error) {
+		return createToken(keys, PasswordResetClaims{
+			Claims:              NewClaims(TypePasswordReset, audience, issuer, jwt.NewNumericDate(time.Now().Add(PasswordResetExpiry)), nil, strconv.FormatUint(uint64(userID), 10)),
+			UserID:              userID,
+			PasswordFingerprint: PasswordFingerprint(passwordHash),
+		})
+	}
+}
+
+// currentPasswordHash returns the user's password hash, so the token can be checked against it
+type ParsePasswordResetFunc func(tokenString string, currentPasswordHash func(userID uint) (string, error)) (userID uint, err error)
+
+func ParsePasswordReset(keys *Keyring, audience []string, issuer string) ParsePasswordResetFunc {
+	return func(tokenString string, currentPasswordHash func(userID uint) (string, error)) (userID uint, err error) {
+		var claims PasswordResetClaims
+		if err := parseToken(keys, tokenString, TypePasswordReset, audience, issuer, "", &claims); err != nil {
+			return 0, fmt.Errorf("failed to parse token: %w", err)
+		}
+		passwordHash, err := currentPasswordHash(claims.UserID)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(PasswordFingerprint(passwordHash)), []byte(claims.PasswordFingerprint)) != 1 {
+			return 0, ErrPasswordChanged
+		}
+		return claims.UserID, nil
+	}
+}
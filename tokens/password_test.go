@@ -0,0 +1,44 @@
+package tokens_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createPasswordReset = tokens.CreatePasswordReset(keys, []string{audience}, issuer)
+	parsePasswordReset  = tokens.ParsePasswordReset(keys, []string{audience}, issuer)
+)
+
+func TestParsePasswordReset(t *testing.T) {
+	const userID = 3
+	passwordHash := "$argon2id$v=19$m=65536,t=1,p=2$c2FsdA$aGFzaA"
+	currentPasswordHash := func(id uint) (string, error) {
+		assert.Equal(t, uint(userID), id)
+		return passwordHash, nil
+	}
+
+	token, err := createPasswordReset(userID, passwordHash)
+	require.NoError(t, err, "creating password reset token shouldn't fail")
+
+	got, err := parsePasswordReset(token, currentPasswordHash)
+	require.NoError(t, err)
+	assert.Equal(t, uint(userID), got)
+
+	passwordHash = "$argon2id$v=19$m=65536,t=1,p=2$c2FsdA$bmV3"
+	_, err = parsePasswordReset(token, currentPasswordHash)
+	assert.ErrorIs(t, err, tokens.ErrPasswordChanged, "the token shouldn't be usable after the password changed")
+
+	lookupErr := errors.New("user not found")
+	_, err = parsePasswordReset(token, func(uint) (string, error) { return "", lookupErr })
+	assert.ErrorIs(t, err, lookupErr)
+
+	userAuthToken, err := createUserAuth(userID, 1)
+	require.NoError(t, err)
+	_, err = parsePasswordReset(userAuthToken, currentPasswordHash)
+	assert.Error(t, err, "other token types shouldn't be accepted")
+}
@@ -0,0 +1,49 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypeRecipientOptIn = "recipientOptIn"
+
+type RecipientOptInClaims struct {
+	Claims
+	RecipientID uint `json:"recipientID,omitzero"`
+	// the address the notice went to. The token stops working once the recipient's email is changed.
+	Email string `json:"email,omitzero"`
+}
+
+type CreateRecipientOptInFunc func(recipientID uint, email string) (token string, err error)
+
+// the unsubscribe link has to keep working for a long time, because recipients may only read the notice much later
+const recipientOptInExpiry = 365 * 24 * time.Hour
+
+// token that lets a recipient confirm that they want to receive last messages, or unsubscribe
+func CreateRecipientOptIn(keys *Keyring, audience []string, issuer string) CreateRecipientOptInFunc {
+	return func(recipientID uint, email string) (token string, err error) {
+		return createToken(keys, RecipientOptInClaims{
+			Claims:      NewClaims(TypeRecipientOptIn, audience, issuer, jwt.NewNumericDate(time.Now().Add(recipientOptInExpiry)), nil, strconv.FormatUint(uint64(recipientID), 10)),
+			RecipientID: recipientID,
+			Email:       email,
+		})
+	}
+}
+
+type ParseRecipientOptInFunc func(tokenString string) (recipientID uint, email string, err error)
+
+func ParseRecipientOptIn(keys *Keyring, audience []string, issuer string) ParseRecipientOptInFunc {
+	return func(tokenString string) (recipientID uint, email string, err error) {
+		var claims RecipientOptInClaims
+		if err := parseToken(keys, tokenString, TypeRecipientOptIn, audience, issuer, "", &claims); err != nil {
+			return 0, "", fmt.Errorf("failed to parse token: %w", err)
+		}
+		if claims.Email == "" {
+			return 0, "", ErrEmptyEmailClaim
+		}
+		return claims.RecipientID, claims.Email, nil
+	}
+}
@@ -0,0 +1,36 @@
+package tokens_test
+
+import (
+	"testing"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createRecipientOptIn = tokens.CreateRecipientOptIn(keys, []string{audience}, issuer)
+	parseRecipientOptIn  = tokens.ParseRecipientOptIn(keys, []string{audience}, issuer)
+)
+
+func TestParseRecipientOptIn(t *testing.T) {
+	const recipientID = 7
+	const email = "recipient@testing.com"
+	token, err := createRecipientOptIn(recipientID, email)
+	require.NoError(t, err, "creating recipient opt-in token shouldn't fail")
+
+	gotID, gotEmail, err := parseRecipientOptIn(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(recipientID), gotID)
+	assert.Equal(t, email, gotEmail)
+
+	token, err = createRecipientOptIn(recipientID, "")
+	require.NoError(t, err)
+	_, _, err = parseRecipientOptIn(token)
+	assert.ErrorIs(t, err, tokens.ErrEmptyEmailClaim, "tokens that aren't bound to an email shouldn't be accepted")
+
+	userAuthToken, err := createUserAuth(recipientID, 1)
+	require.NoError(t, err)
+	_, _, err = parseRecipientOptIn(userAuthToken)
+	assert.Error(t, err, "other token types shouldn't be accepted")
+}
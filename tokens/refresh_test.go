@@ -0,0 +1,20 @@
+package tokens_test
+
+import (
+	"testing"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := tokens.NewRefreshToken()
+	require.NoError(t, err)
+	assert.Equal(t, tokens.HashRefreshToken(token), hash)
+	assert.Len(t, hash, 64, "the hash should fit into the refresh_token_hash column")
+
+	other, _, err := tokens.NewRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
@@ -14,8 +14,6 @@ type properTypeClaims interface {
 	CheckType(expected string) (match bool)
 }
 
-var signingMethod = jwt.SigningMethodHS256
-
 var (
 	ErrInvalidExpirationDate = errors.New("invalid expiration date")
 	ErrTokenIsNil            = errors.New("token is nil")
@@ -24,7 +22,7 @@ var (
 )
 
 // claims MUST be a pointer so that it can be populated
-func parseToken(jwtSecret []byte, tokenString string, expectedType string, audience []string, issuer string, subject string, claims properTypeClaims) error {
+func parseToken(keys *Keyring, tokenString string, expectedType string, audience []string, issuer string, subject string, claims properTypeClaims) error {
 	val := reflect.ValueOf(claims)
 	if val.Kind() != reflect.Pointer || val.IsNil() {
 		return fmt.Errorf("claims must be a non-nil pointer")
@@ -34,19 +32,12 @@ func parseToken(jwtSecret []byte, tokenString string, expectedType string, audie
 		jwt.WithIssuer(issuer),
 		jwt.WithExpirationRequired(),
 		jwt.WithAudience(audience...),
+		jwt.WithValidMethods(keys.algorithms()),
 	}
 	if subject != "" {
 		tokenConstraints = append(tokenConstraints, jwt.WithSubject(subject))
 	}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		if token.Method != signingMethod {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	}, tokenConstraints...)
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.verificationKey, tokenConstraints...)
 	if err != nil {
 		return err
 	}
@@ -75,7 +66,6 @@ func parseToken(jwtSecret []byte, tokenString string, expectedType string, audie
 	return nil
 }
 
-func createToken(jwtSecret []byte, claims jwt.Claims) (tokenString string, err error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+func createToken(keys *Keyring, claims jwt.Claims) (tokenString string, err error) {
+	return keys.sign(claims)
 }
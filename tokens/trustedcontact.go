@@ -0,0 +1,42 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const TypeTrustedContactDecision = "trustedContactDecision"
+
+type TrustedContactDecisionClaims struct {
+	Claims
+	ContactID uint `json:"contactID,omitzero"`
+	UserID    uint `json:"userID,omitzero"`
+}
+
+type CreateTrustedContactDecisionFunc func(contactID uint, userID uint, expiresAt time.Time) (token string, err error)
+
+// token that lets a trusted contact confirm or veto the release of a user's last messages
+func CreateTrustedContactDecision(keys *Keyring, audience []string, issuer string) CreateTrustedContactDecisionFunc {
+	return func(contactID uint, userID uint, expiresAt time.Time) (token string, err error) {
+		return createToken(keys, TrustedContactDecisionClaims{
+			Claims:    NewClaims(TypeTrustedContactDecision, audience, issuer, jwt.NewNumericDate(expiresAt), nil, strconv.FormatUint(uint64(contactID), 10)),
+			ContactID: contactID,
+			UserID:    userID,
+		})
+	}
+}
+
+type ParseTrustedContactDecisionFunc func(tokenString string) (contactID uint, userID uint, err error)
+
+func ParseTrustedContactDecision(keys *Keyring, audience []string, issuer string) ParseTrustedContactDecisionFunc {
+	return func(tokenString string) (contactID uint, userID uint, err error) {
+		var claims TrustedContactDecisionClaims
+		if err := parseToken(keys, tokenString, TypeTrustedContactDecision, audience, issuer, "", &claims); err != nil {
+			return 0, 0, fmt.Errorf("failed to parse token: %w", err)
+		}
+		return claims.ContactID, claims.UserID, nil
+	}
+}
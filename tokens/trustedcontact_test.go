@@ -0,0 +1,36 @@
+package tokens_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gragorther/epigo/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createTrustedContactDecision = tokens.CreateTrustedContactDecision(keys, []string{audience}, issuer)
+	parseTrustedContactDecision  = tokens.ParseTrustedContactDecision(keys, []string{audience}, issuer)
+)
+
+func TestParseTrustedContactDecision(t *testing.T) {
+	const (
+		contactID = 3
+		userID    = 12
+	)
+	token, err := createTrustedContactDecision(contactID, userID, time.Now().Add(time.Hour))
+	require.NoError(t, err, "creating trusted contact decision token shouldn't fail")
+
+	gotContactID, gotUserID, err := parseTrustedContactDecision(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(contactID), gotContactID)
+	assert.Equal(t, uint(userID), gotUserID)
+
+	t.Run("life status token", func(t *testing.T) {
+		token, err := createUserLifeStatus(userID, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		_, _, err = parseTrustedContactDecision(token)
+		assert.Error(t, err, "other token types shouldn't be accepted")
+	})
+}
@@ -14,23 +14,28 @@ var ErrExpiredToken = errors.New("expired token")
 
 const TypeUserAuth = "userAuth"
 
+// access tokens are short-lived; clients get new ones with the refresh token of their session
+const UserAuthExpiry = 15 * time.Minute
+
 type userAuthClaims struct {
 	Claims
-	UserID uint `json:"id,omitzero"`
+	UserID    uint `json:"id,omitzero"`
+	SessionID uint `json:"sid,omitzero"`
 }
 
-type CreateUserAuthFunc func(userID uint) (token string, err error)
+type CreateUserAuthFunc func(userID uint, sessionID uint) (token string, err error)
 
-func CreateUserAuth(jwtSecret []byte, audience []string, issuer string) CreateUserAuthFunc {
-	return func(userID uint) (token string, err error) {
-		return createToken(jwtSecret, userAuthClaims{
-			UserID: userID,
+func CreateUserAuth(keys *Keyring, audience []string, issuer string) CreateUserAuthFunc {
+	return func(userID uint, sessionID uint) (token string, err error) {
+		return createToken(keys, userAuthClaims{
+			UserID:    userID,
+			SessionID: sessionID,
 			Claims: Claims{
 				Type: TypeUserAuth,
 				RegisteredClaims: jwt.RegisteredClaims{
 					Issuer:    issuer,
 					Audience:  audience,
-					ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(UserAuthExpiry)),
 					Subject:   strconv.FormatUint(uint64(userID), 10),
 					IssuedAt:  jwt.NewNumericDate(time.Now()),
 				},
@@ -41,15 +46,20 @@ func CreateUserAuth(jwtSecret []byte, audience []string, issuer string) CreateUs
 
 var ErrInvalidTokenType error = errors.New("invalid token type")
 
-type ParseUserAuthFunc func(tokenString string) (userID uint, err error)
+// issuedAt is used to reject tokens issued before the user's sessions were invalidated, e.g. by a password reset
+type ParseUserAuthFunc func(tokenString string) (userID uint, sessionID uint, issuedAt time.Time, err error)
 
-func ParseUserAuth(jwtSecret []byte, audience []string, issuer string) ParseUserAuthFunc {
-	return func(tokenString string) (userID uint, err error) {
+func ParseUserAuth(keys *Keyring, audience []string, issuer string) ParseUserAuthFunc {
+	return func(tokenString string) (userID uint, sessionID uint, issuedAt time.Time, err error) {
 		var claims userAuthClaims
-		if err := parseToken(jwtSecret, tokenString, TypeUserAuth, audience, issuer, "", &claims); err != nil {
-			return 0, err
+		if err := parseToken(keys, tokenString, TypeUserAuth, audience, issuer, "", &claims); err != nil {
+			return 0, 0, time.Time{}, err
+		}
+		// tokens without a session can't be revoked
+		if claims.IssuedAt == nil || claims.SessionID == 0 {
+			return 0, 0, time.Time{}, ErrInvalidToken
 		}
 
-		return claims.UserID, nil
+		return claims.UserID, claims.SessionID, claims.IssuedAt.Time, nil
 	}
 }
@@ -2,6 +2,7 @@ package tokens_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/gragorther/epigo/tokens"
 	"github.com/stretchr/testify/assert"
@@ -9,28 +10,32 @@ import (
 )
 
 var (
-	createUserAuth = tokens.CreateUserAuth(jwtSecret, []string{audience}, issuer)
-	parseUserAuth  = tokens.ParseUserAuth(jwtSecret, []string{audience}, issuer)
+	createUserAuth = tokens.CreateUserAuth(keys, []string{audience}, issuer)
+	parseUserAuth  = tokens.ParseUserAuth(keys, []string{audience}, issuer)
 )
 
 func TestCreateUserAuth(t *testing.T) {
 	table := map[string]struct {
-		UserID uint
+		UserID    uint
+		SessionID uint
 	}{
 		"valid input": {
-			UserID: 12,
+			UserID:    12,
+			SessionID: 3,
 		},
 	}
 
 	for name, test := range table {
 		t.Run(name, func(t *testing.T) {
 			require := require.New(t)
-			gotToken, err := createUserAuth(test.UserID)
+			gotToken, err := createUserAuth(test.UserID, test.SessionID)
 			require.NoError(err, "creating email verification token shouldn't fail")
-			userID, err := parseUserAuth(gotToken)
+			userID, sessionID, issuedAt, err := parseUserAuth(gotToken)
 			require.NoError(err, "parsing token shouldn't fail")
 
 			assert.Equal(t, test.UserID, userID)
+			assert.Equal(t, test.SessionID, sessionID)
+			assert.WithinDuration(t, time.Now(), issuedAt, time.Minute)
 		})
 	}
 }
@@ -50,7 +55,7 @@ func TestParseUserAuth(t *testing.T) {
 
 	{
 		var err error
-		table[0].Token, err = createUserAuth(userID)
+		table[0].Token, err = createUserAuth(userID, 1)
 		if err != nil {
 			panic(err)
 		}
@@ -58,7 +63,7 @@ func TestParseUserAuth(t *testing.T) {
 
 	for _, test := range table {
 		t.Run(test.Name, func(t *testing.T) {
-			userID, err := parseUserAuth(test.Token)
+			userID, _, _, err := parseUserAuth(test.Token)
 			require.NoError(t, err)
 			assert.Equal(t, test.Want.UserID, userID)
 		})
@@ -0,0 +1,35 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// how many recovery codes users get when they enable two-factor authentication
+const RecoveryCodeCount = 10
+
+// generates single-use codes that can be used instead of a TOTP code, e.g. when the user lost their phone.
+// Only the hashes are stored.
+func GenerateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for range RecoveryCodeCount {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		encoded := encoding.EncodeToString(b)
+		code := strings.Join([]string{encoded[0:4], encoded[4:8], encoded[8:12], encoded[12:16]}, "-")
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// the codes have 80 bits of entropy, so a plain hash is enough to keep them from being guessed.
+// Dashes, spaces and case are ignored, since users type them in by hand.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gragorther/epigo/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the SHA1 test vectors from RFC 6238 appendix B, truncated to 6 digits
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	table := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range table {
+		code, err := totp.Code(secret, totp.Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "code at %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	now := time.Now()
+
+	code, err := totp.Code(secret, totp.Step(now.Add(-totp.Period)))
+	require.NoError(t, err)
+	step, ok, err := totp.Validate(secret, code, now)
+	require.NoError(t, err)
+	assert.True(t, ok, "codes from the previous step should be accepted")
+	assert.Equal(t, totp.Step(now)-1, step)
+
+	code, err = totp.Code(secret, totp.Step(now.Add(-5*totp.Period)))
+	require.NoError(t, err)
+	_, ok, err = totp.Validate(secret, code, now)
+	require.NoError(t, err)
+	assert.False(t, ok, "old codes shouldn't be accepted")
+
+	_, ok, err = totp.Validate(secret, "12345", now)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := totp.URI("Epilogue", "john", "ABC")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Epilogue:john?"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "issuer=Epilogue")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := totp.GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, totp.RecoveryCodeCount)
+	assert.Equal(t, hashes[0], totp.HashRecoveryCode(strings.ToLower(strings.ReplaceAll(codes[0], "-", ""))), "recovery codes should be accepted without dashes and in lowercase")
+	assert.NotEqual(t, codes[0], codes[1])
+}